@@ -0,0 +1,96 @@
+// Package access provides authorization checks for reading data of other users.
+//
+// Roles of requester are resolved from its ctxtg.Claims. Claims issued by auth service
+// carry only UserID, so admins are listed by user id in config/admin/users
+// and team leads and members are stored locally by team RPCs.
+package access
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+// TeamStorage is required dependency for Checker
+type TeamStorage interface {
+	TeamRole(context.Context, entities.TeamID, ctxtg.UserID) (entities.TeamRole, error)
+	IsTeamLead(context.Context, ctxtg.UserID, ctxtg.UserID) (bool, error)
+}
+
+// NewChecker returns Checker for given admins and teams
+func NewChecker(admins []ctxtg.UserID, ts TeamStorage) *Checker {
+	c := &Checker{
+		admins:      make(map[ctxtg.UserID]bool),
+		teamStorage: ts,
+	}
+	for _, uid := range admins {
+		c.admins[uid] = true
+	}
+	return c
+}
+
+// Checker is single place for all checks of access to data of other users
+type Checker struct {
+	admins      map[ctxtg.UserID]bool
+	teamStorage TeamStorage
+}
+
+// Admin returns entities.ErrAccessDenied if actor isn't admin
+func (c *Checker) Admin(_ context.Context, actor ctxtg.Claims) error {
+	if !c.isAdmin(actor) {
+		return entities.ErrAccessDenied
+	}
+	return nil
+}
+
+// View returns entities.ErrAccessDenied if actor isn't allowed to read data of user uid.
+// Users may read own data, admins may read data of everyone,
+// team leads may read data of members of their teams.
+func (c *Checker) View(ctx context.Context, actor ctxtg.Claims, uid ctxtg.UserID) error {
+	if actor.UserID == uid || c.isAdmin(actor) {
+		return nil
+	}
+	ok, err := c.teamStorage.IsTeamLead(ctx, actor.UserID, uid)
+	if err != nil {
+		return errors.Wrap(err, "failed to check team lead")
+	}
+	if !ok {
+		return entities.ErrAccessDenied
+	}
+	return nil
+}
+
+// Approve returns entities.ErrAccessDenied if actor isn't allowed to approve timesheets of user uid.
+// Admins may approve timesheets of everyone, team leads may approve timesheets of members of their teams
+// but not their own ones.
+func (c *Checker) Approve(ctx context.Context, actor ctxtg.Claims, uid ctxtg.UserID) error {
+	if c.isAdmin(actor) {
+		return nil
+	}
+	if actor.UserID == uid {
+		return entities.ErrAccessDenied
+	}
+	return c.View(ctx, actor, uid)
+}
+
+// ViewTeam returns entities.ErrAccessDenied if actor isn't admin or lead of team tid
+func (c *Checker) ViewTeam(ctx context.Context, actor ctxtg.Claims, tid entities.TeamID) error {
+	if c.isAdmin(actor) {
+		return nil
+	}
+	role, err := c.teamStorage.TeamRole(ctx, tid, actor.UserID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check team role")
+	}
+	if role != entities.Lead {
+		return entities.ErrAccessDenied
+	}
+	return nil
+}
+
+// isAdmin returns true if user of claims is listed in config/admin/users
+func (c *Checker) isAdmin(actor ctxtg.Claims) bool {
+	return c.admins[actor.UserID]
+}
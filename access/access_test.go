@@ -0,0 +1,126 @@
+package access
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+var ctx = context.Background()
+
+const (
+	admin  ctxtg.UserID    = 1
+	lead   ctxtg.UserID    = 2
+	member ctxtg.UserID    = 3
+	other  ctxtg.UserID    = 4
+	team   entities.TeamID = 10
+)
+
+type testTeamStorage struct {
+	err     error
+	members map[ctxtg.UserID]entities.TeamRole
+}
+
+func newTeamStorage() *testTeamStorage {
+	return &testTeamStorage{
+		members: map[ctxtg.UserID]entities.TeamRole{
+			lead:   entities.Lead,
+			member: entities.Member,
+		},
+	}
+}
+
+func (s *testTeamStorage) TeamRole(_ context.Context, tid entities.TeamID, uid ctxtg.UserID) (entities.TeamRole, error) {
+	if tid != team {
+		return "", s.err
+	}
+	return s.members[uid], s.err
+}
+
+func (s *testTeamStorage) IsTeamLead(_ context.Context, l, m ctxtg.UserID) (bool, error) {
+	return s.members[l] == entities.Lead && s.members[m] != "", s.err
+}
+
+func TestAdmin(t *testing.T) {
+	c := NewChecker([]ctxtg.UserID{admin}, newTeamStorage())
+	if err := c.Admin(ctx, ctxtg.Claims{UserID: admin}); err != nil {
+		t.Error("Unexpected err", err)
+	}
+	if err := c.Admin(ctx, ctxtg.Claims{UserID: lead}); err != entities.ErrAccessDenied {
+		t.Error("Invalid err", err)
+	}
+}
+
+func TestView(t *testing.T) {
+	c := NewChecker([]ctxtg.UserID{admin}, newTeamStorage())
+	for _, tc := range []struct {
+		actor, uid ctxtg.UserID
+		err        error
+	}{
+		{other, other, nil},
+		{admin, other, nil},
+		{lead, member, nil},
+		{lead, lead, nil},
+		{lead, other, entities.ErrAccessDenied},
+		{member, lead, entities.ErrAccessDenied},
+		{other, member, entities.ErrAccessDenied},
+	} {
+		if err := c.View(ctx, ctxtg.Claims{UserID: tc.actor}, tc.uid); err != tc.err {
+			t.Errorf("Invalid err %v for %d viewing %d", err, tc.actor, tc.uid)
+		}
+	}
+}
+
+func TestApprove(t *testing.T) {
+	c := NewChecker([]ctxtg.UserID{admin}, newTeamStorage())
+	for _, tc := range []struct {
+		actor, uid ctxtg.UserID
+		err        error
+	}{
+		{admin, admin, nil},
+		{admin, other, nil},
+		{lead, member, nil},
+		{lead, lead, entities.ErrAccessDenied},
+		{lead, other, entities.ErrAccessDenied},
+		{member, member, entities.ErrAccessDenied},
+	} {
+		if err := c.Approve(ctx, ctxtg.Claims{UserID: tc.actor}, tc.uid); err != tc.err {
+			t.Errorf("Invalid err %v for %d approving %d", err, tc.actor, tc.uid)
+		}
+	}
+}
+
+func TestViewTeam(t *testing.T) {
+	c := NewChecker([]ctxtg.UserID{admin}, newTeamStorage())
+	for _, tc := range []struct {
+		actor ctxtg.UserID
+		tid   entities.TeamID
+		err   error
+	}{
+		{admin, team, nil},
+		{admin, team + 1, nil},
+		{lead, team, nil},
+		{lead, team + 1, entities.ErrAccessDenied},
+		{member, team, entities.ErrAccessDenied},
+		{other, team, entities.ErrAccessDenied},
+	} {
+		if err := c.ViewTeam(ctx, ctxtg.Claims{UserID: tc.actor}, tc.tid); err != tc.err {
+			t.Errorf("Invalid err %v for %d viewing team %d", err, tc.actor, tc.tid)
+		}
+	}
+}
+
+func TestStorageErr(t *testing.T) {
+	ts := newTeamStorage()
+	ts.err = errors.New("storage err")
+	c := NewChecker(nil, ts)
+	if err := c.View(ctx, ctxtg.Claims{UserID: lead}, member); errors.Cause(err) != ts.err {
+		t.Error("Invalid err", err)
+	}
+	if err := c.ViewTeam(ctx, ctxtg.Claims{UserID: lead}, team); errors.Cause(err) != ts.err {
+		t.Error("Invalid err", err)
+	}
+}
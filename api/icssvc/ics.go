@@ -0,0 +1,104 @@
+package icssvc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/qarea/planningms/entities"
+)
+
+const (
+	icsTimeFormat  = "20060102T150405Z"
+	icsLineLength  = 75
+	icsLineEnd     = "\r\n"
+	icsProductID   = "-//QArea//planningms//EN"
+	icsUIDDomain   = "planningms"
+	icsDescription = "Spent %d min (%s)"
+)
+
+var icsEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`;`, `\;`,
+	`,`, `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+// writeCalendar renders work sessions as VEVENTs of single VCALENDAR (RFC 5545)
+func writeCalendar(w io.Writer, now int64, ws []entities.WorkSession) error {
+	cw := &calendarWriter{w: bufio.NewWriter(w)}
+	cw.line("BEGIN:VCALENDAR")
+	cw.line("VERSION:2.0")
+	cw.line("PRODID:" + icsProductID)
+	cw.line("CALSCALE:GREGORIAN")
+	cw.line("METHOD:PUBLISH")
+	cw.line("X-WR-CALNAME:Work sessions")
+	for _, s := range ws {
+		cw.line("BEGIN:VEVENT")
+		cw.line("UID:" + sessionUID(s))
+		cw.line("DTSTAMP:" + icsTime(now))
+		cw.line("DTSTART:" + icsTime(s.StartedAt))
+		cw.line("DTEND:" + icsTime(s.EndedAt))
+		cw.line("SUMMARY:" + icsText(s.IssueTitle))
+		if s.IssueURL != "" {
+			cw.line("URL:" + s.IssueURL)
+		}
+		cw.line("DESCRIPTION:" + icsText(fmt.Sprintf(icsDescription, s.Spent/60, s.Status)))
+		cw.line("TRANSP:TRANSPARENT")
+		cw.line("END:VEVENT")
+	}
+	cw.line("END:VCALENDAR")
+	return cw.flush()
+}
+
+// sessionUID is unique because SpentTimeHistory is unique by planning, start and status
+func sessionUID(s entities.WorkSession) string {
+	return fmt.Sprintf("%d-%d-%s@%s", s.PlanningID, s.StartedAt, strings.ToLower(string(s.Status)), icsUIDDomain)
+}
+
+func icsTime(t int64) string {
+	return time.Unix(t, 0).UTC().Format(icsTimeFormat)
+}
+
+func icsText(s string) string {
+	return icsEscaper.Replace(s)
+}
+
+// calendarWriter writes content lines folded to 75 octets and remembers first error
+type calendarWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (c *calendarWriter) line(l string) {
+	if c.err != nil {
+		return
+	}
+	for len(l) > icsLineLength {
+		n := icsLineLength
+		for n > 0 && !utf8.RuneStart(l[n]) {
+			n--
+		}
+		c.write(l[:n] + icsLineEnd)
+		// Continuation line begins with single space which counts to its length.
+		l = " " + l[n:]
+	}
+	c.write(l + icsLineEnd)
+}
+
+func (c *calendarWriter) write(s string) {
+	if c.err == nil {
+		_, c.err = c.w.WriteString(s)
+	}
+}
+
+func (c *calendarWriter) flush() error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.w.Flush()
+}
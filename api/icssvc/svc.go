@@ -0,0 +1,95 @@
+// Package icssvc provides iCalendar feed of recorded work sessions.
+package icssvc
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/powerman/narada-go/narada"
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/cfg"
+	"github.com/qarea/planningms/entities"
+)
+
+var log = narada.NewLog("icssvc: ")
+
+var timeNowFunc = func() int64 {
+	return time.Now().Unix()
+}
+
+const (
+	feedPath      = "/ics/"
+	feedExtension = ".ics"
+)
+
+// Init setups and registers iCalendar feed handler
+func Init(c ICSConfig) {
+	prefix := cfg.HTTP.BasePath + feedPath
+	http.Handle(prefix, http.StripPrefix(prefix, newFeedHandler(c)))
+}
+
+// ICSConfig is dependencies configuration for icssvc
+type ICSConfig struct {
+	FeedStorage FeedStorage
+	Period      time.Duration
+}
+
+// FeedStorage is required dependency for feed handler
+type FeedStorage interface {
+	FeedUserID(context.Context, string) (ctxtg.UserID, error)
+	WorkSessions(context.Context, ctxtg.UserID, int64, int64) ([]entities.WorkSession, error)
+}
+
+func newFeedHandler(c ICSConfig) *feedHandler {
+	return &feedHandler{
+		feedStorage: c.FeedStorage,
+		period:      c.Period,
+	}
+}
+
+// feedHandler serves /ics/<token>.ics with work sessions of token owner
+type feedHandler struct {
+	feedStorage FeedStorage
+	period      time.Duration
+}
+
+func (h *feedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasSuffix(r.URL.Path, feedExtension) {
+		http.NotFound(w, r)
+		return
+	}
+	token := strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, feedExtension), "/")
+	if token == "" || strings.Contains(token, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := r.Context()
+	uid, err := h.feedStorage.FeedUserID(ctx, token)
+	if errors.Cause(err) == entities.ErrInvalidFeedToken {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.ERR("failed to load feed owner: %+v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	now := timeNowFunc()
+	ws, err := h.feedStorage.WorkSessions(ctx, uid, now-int64(h.period.Seconds()), now)
+	if err != nil {
+		log.ERR("failed to load work sessions for user %d: %+v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	if err := writeCalendar(w, now, ws); err != nil {
+		log.ERR("failed to write feed for user %d: %+v", uid, err)
+	}
+}
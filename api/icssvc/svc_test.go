@@ -0,0 +1,174 @@
+package icssvc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+const testToken = "abcdef"
+
+func TestFeedInvalidToken(t *testing.T) {
+	fs := &testFeedStorage{}
+	rec := serveFeed(fs, "/unknown.ics")
+	if rec.Code != http.StatusNotFound {
+		t.Error("Invalid status", rec.Code)
+	}
+}
+
+func TestFeedInvalidPath(t *testing.T) {
+	fs := &testFeedStorage{uid: 1}
+	for _, path := range []string{"/" + testToken, "/.ics", "/a/" + testToken + ".ics"} {
+		rec := serveFeed(fs, path)
+		if rec.Code != http.StatusNotFound {
+			t.Error("Invalid status", path, rec.Code)
+		}
+	}
+}
+
+func TestFeedMethodNotAllowed(t *testing.T) {
+	h := newFeedHandler(ICSConfig{FeedStorage: &testFeedStorage{uid: 1}})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/"+testToken+".ics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Error("Invalid status", rec.Code)
+	}
+}
+
+func TestFeedStorageErr(t *testing.T) {
+	fs := &testFeedStorage{
+		uid: 1,
+		err: errors.New("storage err"),
+	}
+	rec := serveFeed(fs, "/"+testToken+".ics")
+	if rec.Code != http.StatusInternalServerError {
+		t.Error("Invalid status", rec.Code)
+	}
+}
+
+func TestFeed(t *testing.T) {
+	var now int64 = 1500000000
+	defer mockTimeNow(now)()
+	fs := &testFeedStorage{
+		uid: 5,
+		sessions: []entities.WorkSession{
+			{
+				SpentTimeHistory: entities.SpentTimeHistory{
+					PlanningID: 7,
+					Spent:      600,
+					StartedAt:  now - 1200,
+					EndedAt:    now - 600,
+					Status:     entities.Online,
+				},
+				IssueTitle: "Fix login, again",
+				IssueURL:   "https://tracker/issues/1",
+			},
+		},
+	}
+	h := newFeedHandler(ICSConfig{
+		FeedStorage: fs,
+		Period:      time.Hour,
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+testToken+".ics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatal("Invalid status", rec.Code)
+	}
+	if fs.token != testToken {
+		t.Error("Invalid token passed", fs.token)
+	}
+	if fs.userID != 5 || fs.from != now-3600 || fs.to != now {
+		t.Errorf("Invalid sessions range %+v", fs)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") {
+		t.Error("Invalid content type", ct)
+	}
+	body := rec.Body.String()
+	for _, l := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"BEGIN:VEVENT\r\n",
+		"UID:7-1499998800-online@planningms\r\n",
+		"DTSTART:20170714T022000Z\r\n",
+		"DTEND:20170714T023000Z\r\n",
+		"SUMMARY:Fix login\\, again\r\n",
+		"URL:https://tracker/issues/1\r\n",
+		"DESCRIPTION:Spent 10 min (ONLINE)\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(body, l) {
+			t.Errorf("Missing %q in %q", l, body)
+		}
+	}
+}
+
+func TestWriteCalendarFoldsLongLines(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeCalendar(&buf, 0, []entities.WorkSession{{
+		IssueTitle: strings.Repeat("ї", 100),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range strings.Split(buf.String(), "\r\n") {
+		if len(l) > icsLineLength {
+			t.Errorf("Line too long %d %q", len(l), l)
+		}
+	}
+	unfolded := strings.Replace(buf.String(), "\r\n ", "", -1)
+	if !strings.Contains(unfolded, "SUMMARY:"+strings.Repeat("ї", 100)+"\r\n") {
+		t.Error("Invalid folding", unfolded)
+	}
+}
+
+func serveFeed(fs *testFeedStorage, path string) *httptest.ResponseRecorder {
+	h := newFeedHandler(ICSConfig{
+		FeedStorage: fs,
+		Period:      time.Hour,
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func mockTimeNow(timeToReturn int64) func() {
+	timeNowFunc = func() int64 { return timeToReturn }
+	return func() {
+		timeNowFunc = func() int64 {
+			return time.Now().Unix()
+		}
+	}
+}
+
+type testFeedStorage struct {
+	token    string
+	userID   ctxtg.UserID
+	from     int64
+	to       int64
+	uid      ctxtg.UserID
+	sessions []entities.WorkSession
+
+	err error
+}
+
+func (t *testFeedStorage) FeedUserID(_ context.Context, token string) (ctxtg.UserID, error) {
+	t.token = token
+	if t.uid == 0 {
+		return 0, entities.ErrInvalidFeedToken
+	}
+	return t.uid, nil
+}
+
+func (t *testFeedStorage) WorkSessions(_ context.Context, uid ctxtg.UserID, from, to int64) ([]entities.WorkSession, error) {
+	t.userID = uid
+	t.from = from
+	t.to = to
+	return t.sessions, t.err
+}
@@ -0,0 +1,169 @@
+package rpcsvc
+
+import (
+	"context"
+
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+// BillingStorage is required dependency for API
+type BillingStorage interface {
+	SetProjectBilling(context.Context, entities.ProjectBilling) error
+	SetPlanningBillable(context.Context, entities.PlanningID, bool) error
+	AddRate(context.Context, entities.Rate) (entities.RateID, error)
+	DeleteRate(context.Context, entities.RateID) error
+	Rates(context.Context) ([]entities.Rate, error)
+}
+
+// SetProjectBillingReq is input parameter to SetProjectBilling
+type SetProjectBillingReq struct {
+	Context   ctxtg.Context
+	ProjectID entities.ProjectID
+	Billable  bool
+}
+
+// SetProjectBilling sets billable rule for new plannings of project, admin only
+func (p *API) SetProjectBilling(req *SetProjectBillingReq, _ *struct{}) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		return p.billingStorage.SetProjectBilling(ctx, entities.ProjectBilling{
+			ProjectID: req.ProjectID,
+			Billable:  req.Billable,
+		})
+	})
+	return errWithLog(req.Context, "failed to SetProjectBilling", err)
+}
+
+// SetPlanningBillableReq is input parameter to SetPlanningBillable
+type SetPlanningBillableReq struct {
+	Context    ctxtg.Context
+	PlanningID entities.PlanningID
+	Billable   bool
+}
+
+// SetPlanningBillable changes billable flag of planning, admin only
+func (p *API) SetPlanningBillable(req *SetPlanningBillableReq, _ *struct{}) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		return p.billingStorage.SetPlanningBillable(ctx, req.PlanningID, req.Billable)
+	})
+	return errWithLog(req.Context, "failed to SetPlanningBillable", err)
+}
+
+// AddRateReq is input parameter to AddRate
+type AddRateReq struct {
+	Context       ctxtg.Context
+	UserID        ctxtg.UserID
+	ProjectID     entities.ProjectID
+	ActivityID    entities.ActivityID
+	Rate          int64
+	EffectiveFrom int64
+}
+
+// AddRateResp is output from AddRate
+type AddRateResp struct {
+	RateID entities.RateID
+}
+
+// AddRate adds hourly rate, zero UserID, ProjectID or ActivityID matches any value, admin only
+func (p *API) AddRate(req *AddRateReq, resp *AddRateResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		id, err := p.billingStorage.AddRate(ctx, entities.Rate{
+			UserID:        req.UserID,
+			ProjectID:     req.ProjectID,
+			ActivityID:    req.ActivityID,
+			Rate:          req.Rate,
+			EffectiveFrom: req.EffectiveFrom,
+		})
+		*resp = AddRateResp{
+			RateID: id,
+		}
+		return err
+	})
+	return errWithLog(req.Context, "failed to AddRate", err)
+}
+
+// DeleteRateReq is input parameter to DeleteRate
+type DeleteRateReq struct {
+	Context ctxtg.Context
+	RateID  entities.RateID
+}
+
+// DeleteRate removes hourly rate, admin only
+func (p *API) DeleteRate(req *DeleteRateReq, _ *struct{}) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		return p.billingStorage.DeleteRate(ctx, req.RateID)
+	})
+	return errWithLog(req.Context, "failed to DeleteRate", err)
+}
+
+// GetRatesReq is input parameter to GetRates
+type GetRatesReq struct {
+	Context ctxtg.Context
+}
+
+// GetRatesResp is output from GetRates
+type GetRatesResp struct {
+	Rates []entities.Rate
+}
+
+// GetRates returns all hourly rates, admin only
+func (p *API) GetRates(req *GetRatesReq, resp *GetRatesResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		rates, err := p.billingStorage.Rates(ctx)
+		*resp = GetRatesResp{
+			Rates: rates,
+		}
+		return err
+	})
+	return errWithLog(req.Context, "failed to GetRates", err)
+}
+
+// GetBillingReportReq is input parameter to GetBillingReport,
+// zero UserID or ProjectID means all users or projects
+type GetBillingReportReq struct {
+	Context   ctxtg.Context
+	UserID    ctxtg.UserID
+	ProjectID entities.ProjectID
+	From      int64
+	To        int64
+}
+
+// GetBillingReportResp is output from GetBillingReport
+type GetBillingReportResp struct {
+	Report *entities.BillingReport
+}
+
+// GetBillingReport returns billable spent time and amounts for period, admin only
+func (p *API) GetBillingReport(req *GetBillingReportReq, resp *GetBillingReportResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		report, err := p.planningService.BillingReport(ctx, entities.BillingQuery{
+			UserID:    req.UserID,
+			ProjectID: req.ProjectID,
+			From:      req.From,
+			To:        req.To,
+		})
+		*resp = GetBillingReportResp{
+			Report: report,
+		}
+		return err
+	})
+	return errWithLog(req.Context, "failed to GetBillingReport", err)
+}
@@ -0,0 +1,124 @@
+package rpcsvc
+
+import (
+	"context"
+
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+// PrivacyStorage is required dependency for API
+type PrivacyStorage interface {
+	ExportUserData(context.Context, entities.PrivacyRequest) (*entities.UserData, error)
+	EraseUserData(context.Context, entities.PrivacyRequest) error
+	PrivacyRequests(context.Context, ctxtg.UserID) ([]entities.PrivacyRequest, error)
+}
+
+// SpentTimeCache is required dependency for API, it holds running timers of users
+type SpentTimeCache interface {
+	SpentTime(context.Context, ctxtg.UserID) (*entities.SpentTime, error)
+	Erase(context.Context, ctxtg.UserID) error
+	Backup(context.Context) error
+}
+
+// ExportUserDataReq is input parameter to ExportUserData
+type ExportUserDataReq struct {
+	Context ctxtg.Context
+	UserID  ctxtg.UserID
+}
+
+// ExportUserDataResp is output from ExportUserData
+type ExportUserDataResp struct {
+	Data *entities.UserData
+}
+
+// ExportUserData returns everything stored about user including running timer,
+// export is recorded in audit of privacy requests, admin only
+func (p *API) ExportUserData(req *ExportUserDataReq, resp *ExportUserDataResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		if req.UserID == 0 {
+			return entities.ErrInvalidUserID
+		}
+		st, err := p.spentTimeCache.SpentTime(ctx, req.UserID)
+		if err != nil {
+			return err
+		}
+		d, err := p.privacyStorage.ExportUserData(ctx, entities.PrivacyRequest{
+			UserID:  req.UserID,
+			AdminID: c.UserID,
+		})
+		if err != nil {
+			return err
+		}
+		d.SpentTime = st
+		*resp = ExportUserDataResp{
+			Data: d,
+		}
+		return nil
+	})
+	return errWithLog(req.Context, "failed to ExportUserData", err)
+}
+
+// EraseUserDataReq is input parameter to EraseUserData
+type EraseUserDataReq struct {
+	Context      ctxtg.Context
+	UserID       ctxtg.UserID
+	Pseudonymise bool
+}
+
+// EraseUserData deletes user's running timer and all stored data or, if Pseudonymise is set,
+// keeps data needed for reports under pseudonym. Erasure is recorded in audit of privacy requests
+// and may be safely repeated if it failed, admin only
+func (p *API) EraseUserData(req *EraseUserDataReq, _ *struct{}) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		if req.UserID == 0 {
+			return entities.ErrInvalidUserID
+		}
+		// Timer is erased first, it would add spent time to erased plannings otherwise
+		if err := p.spentTimeCache.Erase(ctx, req.UserID); err != nil {
+			return err
+		}
+		action := entities.Erase
+		if req.Pseudonymise {
+			action = entities.Pseudonymise
+		}
+		return p.privacyStorage.EraseUserData(ctx, entities.PrivacyRequest{
+			UserID:  req.UserID,
+			AdminID: c.UserID,
+			Action:  action,
+		})
+	})
+	return errWithLog(req.Context, "failed to EraseUserData", err)
+}
+
+// GetPrivacyRequestsReq is input parameter to GetPrivacyRequests
+type GetPrivacyRequestsReq struct {
+	Context ctxtg.Context
+	UserID  ctxtg.UserID
+}
+
+// GetPrivacyRequestsResp is output from GetPrivacyRequests
+type GetPrivacyRequestsResp struct {
+	Requests []entities.PrivacyRequest
+}
+
+// GetPrivacyRequests returns audit of exports and erasures of user's data, admin only
+func (p *API) GetPrivacyRequests(req *GetPrivacyRequestsReq, resp *GetPrivacyRequestsResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		rs, err := p.privacyStorage.PrivacyRequests(ctx, req.UserID)
+		*resp = GetPrivacyRequestsResp{
+			Requests: rs,
+		}
+		return err
+	})
+	return errWithLog(req.Context, "failed to GetPrivacyRequests", err)
+}
@@ -27,24 +27,48 @@ func Init(c RPCConfig) {
 
 // RPCConfig is dependencies configuration for rpcsvc
 type RPCConfig struct {
-	TokenParser     ctxtg.TokenParser
-	PlanningService PlanningService
-	PlanningStorage PlanningStorage
+	TokenParser      ctxtg.TokenParser
+	PlanningService  PlanningService
+	PlanningStorage  PlanningStorage
+	FeedStorage      FeedStorage
+	TeamStorage      TeamStorage
+	TimesheetStorage TimesheetStorage
+	BillingStorage   BillingStorage
+	PrivacyStorage   PrivacyStorage
+	SpentTimeCache   SpentTimeCache
+	TimerService     TimerService
+	Access           Access
 }
 
 func newPlanningServiceRPC(c RPCConfig) *API {
 	return &API{
-		tokenParser:     c.TokenParser,
-		planningService: c.PlanningService,
-		planningStorage: c.PlanningStorage,
+		tokenParser:      c.TokenParser,
+		planningService:  c.PlanningService,
+		planningStorage:  c.PlanningStorage,
+		feedStorage:      c.FeedStorage,
+		teamStorage:      c.TeamStorage,
+		timesheetStorage: c.TimesheetStorage,
+		billingStorage:   c.BillingStorage,
+		privacyStorage:   c.PrivacyStorage,
+		spentTimeCache:   c.SpentTimeCache,
+		timerService:     c.TimerService,
+		access:           c.Access,
 	}
 }
 
 // API struct for JSON-RPC 2.0
 type API struct {
-	tokenParser     ctxtg.TokenParser
-	planningService PlanningService
-	planningStorage PlanningStorage
+	tokenParser      ctxtg.TokenParser
+	planningService  PlanningService
+	planningStorage  PlanningStorage
+	feedStorage      FeedStorage
+	teamStorage      TeamStorage
+	timesheetStorage TimesheetStorage
+	billingStorage   BillingStorage
+	privacyStorage   PrivacyStorage
+	spentTimeCache   SpentTimeCache
+	timerService     TimerService
+	access           Access
 }
 
 // PlanningService is required dependency for API
@@ -54,6 +78,9 @@ type PlanningService interface {
 	AddSpentTime(context.Context, entities.SpentTimeReport) error
 	OpenedPlannings(context.Context, ctxtg.UserID) ([]entities.ExtendedPlanning, error)
 	SpentTime(context.Context, ctxtg.UserID, int64, int64) (int, error)
+	Burndown(context.Context, entities.BurndownQuery) ([]entities.BurndownPoint, error)
+	SubmitTimesheet(context.Context, entities.Timesheet) (entities.TimesheetID, error)
+	BillingReport(context.Context, entities.BillingQuery) (*entities.BillingReport, error)
 }
 
 // PlanningStorage is required dependency for API
@@ -62,6 +89,22 @@ type PlanningStorage interface {
 	AddExtraTime(context.Context, ctxtg.UserID, entities.PlannedTime) error
 }
 
+// FeedStorage is required dependency for API
+type FeedStorage interface {
+	FeedToken(context.Context, ctxtg.UserID) (string, error)
+	ResetFeedToken(context.Context, ctxtg.UserID) (string, error)
+}
+
+// Access is required dependency for API, it checks access of requester with given claims
+// to data of other users. Admins are users listed in config/admin/users,
+// team leads are managed by team methods.
+type Access interface {
+	Admin(context.Context, ctxtg.Claims) error
+	View(context.Context, ctxtg.Claims, ctxtg.UserID) error
+	Approve(context.Context, ctxtg.Claims, ctxtg.UserID) error
+	ViewTeam(context.Context, ctxtg.Claims, entities.TeamID) error
+}
+
 // Version returns current project narada version
 func (*API) Version(args *struct{}, res *string) error {
 	log.DEBUG("RPC: VERSION")
@@ -82,6 +125,7 @@ type CreatePlanningReq struct {
 	IssueDone       int
 	ActivityID      entities.ActivityID
 	Estimation      int64
+	Billable        *bool
 }
 
 // CreatePlanningResp is response from CreatePlanning
@@ -104,6 +148,7 @@ func (p *API) CreatePlanning(req *CreatePlanningReq, resp *CreatePlanningResp) e
 			IssueDone:       req.IssueDone,
 			ActivityID:      req.ActivityID,
 			Estimation:      req.Estimation,
+			Billable:        req.Billable,
 		})
 		*resp = CreatePlanningResp{
 			PlanningID: id,
@@ -116,6 +161,7 @@ func (p *API) CreatePlanning(req *CreatePlanningReq, resp *CreatePlanningResp) e
 // GetOpenPlanningsReg is input parameter to GetOpenPlannings
 type GetOpenPlanningsReg struct {
 	Context ctxtg.Context
+	UserID  ctxtg.UserID
 }
 
 // GetOpenPlanningsResp is output from GetOpenPlannings
@@ -123,10 +169,15 @@ type GetOpenPlanningsResp struct {
 	Plannings []entities.ExtendedPlanning
 }
 
-// GetOpenPlannings returns all open plannings for user
+// GetOpenPlannings returns all open plannings for user,
+// plannings of UserID are returned if it's set and caller is allowed to view them
 func (p *API) GetOpenPlannings(req *GetOpenPlanningsReg, resp *GetOpenPlanningsResp) error {
 	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
-		ps, err := p.planningService.OpenedPlannings(ctx, c.UserID)
+		uid, err := p.viewedUserID(ctx, c, req.UserID)
+		if err != nil {
+			return err
+		}
+		ps, err := p.planningService.OpenedPlannings(ctx, uid)
 		*resp = GetOpenPlanningsResp{
 			Plannings: ps,
 		}
@@ -218,6 +269,7 @@ func (p *API) ClosePlanning(req *ClosePlanningReq, _ *struct{}) error {
 // SpentTimeReq is input parameter to SpentTime
 type SpentTimeReq struct {
 	Context ctxtg.Context
+	UserID  ctxtg.UserID
 	From    int64
 	To      int64
 }
@@ -227,12 +279,16 @@ type SpentTimeResp struct {
 	Spent int
 }
 
-// SpentTime returns user's spent time for period
+// SpentTime returns user's spent time for period,
+// spent time of UserID is returned if it's set and caller is allowed to view it
 func (p *API) SpentTime(req *SpentTimeReq, resp *SpentTimeResp) error {
 	var spent int
 	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
-		var err error
-		spent, err = p.planningService.SpentTime(ctx, c.UserID, req.From, req.To)
+		uid, err := p.viewedUserID(ctx, c, req.UserID)
+		if err != nil {
+			return err
+		}
+		spent, err = p.planningService.SpentTime(ctx, uid, req.From, req.To)
 		return err
 	})
 	*resp = SpentTimeResp{
@@ -242,6 +298,94 @@ func (p *API) SpentTime(req *SpentTimeReq, resp *SpentTimeResp) error {
 
 }
 
+// GetBurndownReq is input parameter to GetBurndown
+type GetBurndownReq struct {
+	Context     ctxtg.Context
+	UserID      ctxtg.UserID
+	PlanningIDs []entities.PlanningID
+	From        int64
+	To          int64
+	Step        int64
+}
+
+// GetBurndownResp is output from GetBurndown
+type GetBurndownResp struct {
+	Points []entities.BurndownPoint
+}
+
+// GetBurndown returns burndown and cumulative flow points for user's plannings,
+// all opened plannings are used if PlanningIDs is empty.
+// Plannings of UserID are used if it's set and caller is allowed to view them
+func (p *API) GetBurndown(req *GetBurndownReq, resp *GetBurndownResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		uid, err := p.viewedUserID(ctx, c, req.UserID)
+		if err != nil {
+			return err
+		}
+		points, err := p.planningService.Burndown(ctx, entities.BurndownQuery{
+			UserID:      uid,
+			PlanningIDs: req.PlanningIDs,
+			From:        req.From,
+			To:          req.To,
+			Step:        req.Step,
+		})
+		*resp = GetBurndownResp{
+			Points: points,
+		}
+		return err
+	})
+	return errWithLog(req.Context, "failed to GetBurndown", err)
+}
+
+// FeedTokenReq is input parameter to GetFeedToken and ResetFeedToken
+type FeedTokenReq struct {
+	Context ctxtg.Context
+}
+
+// FeedTokenResp is output from GetFeedToken and ResetFeedToken
+type FeedTokenResp struct {
+	Token string
+	Path  string
+}
+
+// GetFeedToken returns user's token for iCalendar feed of work sessions
+func (p *API) GetFeedToken(req *FeedTokenReq, resp *FeedTokenResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		token, err := p.feedStorage.FeedToken(ctx, c.UserID)
+		*resp = newFeedTokenResp(token)
+		return err
+	})
+	return errWithLog(req.Context, "failed to GetFeedToken", err)
+}
+
+// ResetFeedToken invalidates user's iCalendar feed token and returns new one
+func (p *API) ResetFeedToken(req *FeedTokenReq, resp *FeedTokenResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		token, err := p.feedStorage.ResetFeedToken(ctx, c.UserID)
+		*resp = newFeedTokenResp(token)
+		return err
+	})
+	return errWithLog(req.Context, "failed to ResetFeedToken", err)
+}
+
+func newFeedTokenResp(token string) FeedTokenResp {
+	if token == "" {
+		return FeedTokenResp{}
+	}
+	return FeedTokenResp{
+		Token: token,
+		Path:  cfg.HTTP.BasePath + "/ics/" + token + ".ics",
+	}
+}
+
+// viewedUserID returns uid if caller is allowed to view its data or caller's id if uid isn't set
+func (p *API) viewedUserID(ctx context.Context, c ctxtg.Claims, uid ctxtg.UserID) (ctxtg.UserID, error) {
+	if uid == 0 || uid == c.UserID {
+		return c.UserID, nil
+	}
+	return uid, p.access.View(ctx, c, uid)
+}
+
 func errWithLog(ctx ctxtg.Context, prefix string, err error) error {
 	if err == nil {
 		return nil
@@ -8,6 +8,7 @@ import (
 	"reflect"
 	"testing"
 
+	pkgerrors "github.com/pkg/errors"
 	"github.com/powerman/narada-go/narada"
 	"github.com/qarea/ctxtg"
 	"github.com/qarea/ctxtg/ctxtgtest"
@@ -34,6 +35,13 @@ func TestErrTimeout(t *testing.T) {
 	}
 }
 
+func TestErrPlanningConflict(t *testing.T) {
+	err := errWithLog(ctxtg.Context{}, "pre", pkgerrors.Wrap(entities.ErrPlanningConflict, "failed to update planning"))
+	if err != entities.ErrPlanningConflict {
+		t.Error("Unexpeceted error", err)
+	}
+}
+
 func TestCreatePlanningTokenErr(t *testing.T) {
 	ctx := testContext()
 	p := &ctxtgtest.Parser{
@@ -93,6 +101,7 @@ func TestCreatePlanning(t *testing.T) {
 		PlanningStorage: ps,
 		TokenParser:     p,
 	})
+	billable := false
 	expectedNewPlanning := entities.NewPlanning{
 		UserID:          userID,
 		ProjectID:       entities.ProjectID(rand.Int63()),
@@ -104,6 +113,7 @@ func TestCreatePlanning(t *testing.T) {
 		IssueEstimation: rand.Int63(),
 		ActivityID:      entities.ActivityID(rand.Int63()),
 		Estimation:      rand.Int63(),
+		Billable:        &billable,
 	}
 	var resp CreatePlanningResp
 	err := api.CreatePlanning(&CreatePlanningReq{
@@ -117,11 +127,12 @@ func TestCreatePlanning(t *testing.T) {
 		Estimation:      expectedNewPlanning.Estimation,
 		IssueEstimation: expectedNewPlanning.IssueEstimation,
 		IssueDueDate:    expectedNewPlanning.IssueDueDate,
+		Billable:        expectedNewPlanning.Billable,
 	}, &resp)
 	if err != ps.err {
 		t.Error("Service error expected", err)
 	}
-	if ps.newPlanning != expectedNewPlanning {
+	if !reflect.DeepEqual(ps.newPlanning, expectedNewPlanning) {
 		t.Errorf("Invalid new planning passed %+v", ps.newPlanning)
 	}
 	if err := p.Error(); err != nil {
@@ -206,6 +217,70 @@ func TestGetPlannings(t *testing.T) {
 	}
 }
 
+func TestGetPlanningsOfTeamMember(t *testing.T) {
+	claims := testClaims()
+	ctx := testContext()
+	uid := ctxtg.UserID(rand.Int63())
+	ps := &testPlanningService{
+		plannings: randPlannings(),
+	}
+	a := &testAccess{}
+	p := &ctxtgtest.Parser{
+		Claims:        claims,
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		PlanningService: ps,
+		TokenParser:     p,
+		Access:          a,
+	})
+	var resp GetOpenPlanningsResp
+	err := api.GetOpenPlannings(&GetOpenPlanningsReg{
+		Context: ctx,
+		UserID:  uid,
+	}, &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.actor != claims.UserID || a.userID != uid {
+		t.Errorf("Invalid access check %+v", a)
+	}
+	if ps.userID != uid {
+		t.Error("Invalid user ID", ps.userID)
+	}
+	if !reflect.DeepEqual(ps.plannings, resp.Plannings) {
+		t.Error("Invalid response")
+	}
+}
+
+func TestGetPlanningsAccessDenied(t *testing.T) {
+	ctx := testContext()
+	ps := &testPlanningService{}
+	a := &testAccess{
+		err: entities.ErrAccessDenied,
+	}
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		PlanningService: ps,
+		TokenParser:     p,
+		Access:          a,
+	})
+	var resp GetOpenPlanningsResp
+	err := api.GetOpenPlannings(&GetOpenPlanningsReg{
+		Context: ctx,
+		UserID:  ctxtg.UserID(rand.Int63()),
+	}, &resp)
+	if err != entities.ErrAccessDenied {
+		t.Error("Access error expected", err)
+	}
+	if ps.userID != 0 {
+		t.Error("Service shouldn't be called")
+	}
+}
+
 func TestSetExtraTokenErr(t *testing.T) {
 	ctx := testContext()
 	p := &ctxtgtest.Parser{
@@ -601,81 +676,1039 @@ func TestSpentTime(t *testing.T) {
 	}
 }
 
-func randPlannings() []entities.ExtendedPlanning {
-	var ps []entities.ExtendedPlanning
-	for i := 0; i < rand.Intn(10); i++ {
-		ps = append(ps, randPlanning())
+func TestGetBurndownTokenErr(t *testing.T) {
+	ctx := testContext()
+	p := &ctxtgtest.Parser{
+		TokenExpected: ctx.Token,
+		Err:           errors.New("parser err"),
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser: p,
+	})
+	err := api.GetBurndown(&GetBurndownReq{
+		Context: ctx,
+	}, &GetBurndownResp{})
+	if err != p.Err {
+		t.Error("Parser error expected", err)
+	}
+	if err := p.Error(); err != nil {
+		t.Error("Unexpected parser error", err)
 	}
-	return ps
 }
 
-func randPlanning() entities.ExtendedPlanning {
-	return entities.ExtendedPlanning{
-		Estimation: rand.Int63(),
-		Planning: entities.Planning{
-			ID:         entities.PlanningID(rand.Int63()),
-			IssueTitle: randomString(),
-		},
+func TestGetBurndownServiceErr(t *testing.T) {
+	ctx := testContext()
+	ps := &testPlanningService{
+		err: errors.New("Service err"),
+	}
+	p := &ctxtgtest.Parser{
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		PlanningService: ps,
+		TokenParser:     p,
+	})
+	err := api.GetBurndown(&GetBurndownReq{
+		Context: ctx,
+	}, &GetBurndownResp{})
+	if err != ps.err {
+		t.Error("Service error expected", err)
+	}
+	if err := p.Error(); err != nil {
+		t.Error("Unexpected parser error", err)
 	}
 }
 
-func testClaims() ctxtg.Claims {
-	return ctxtg.Claims{
-		UserID: ctxtg.UserID(rand.Int63()),
+func TestGetBurndown(t *testing.T) {
+	claims := testClaims()
+	ctx := testContext()
+	ps := &testPlanningService{
+		points: []entities.BurndownPoint{
+			{Time: rand.Int63(), Remaining: rand.Int63()},
+			{Time: rand.Int63(), Remaining: rand.Int63()},
+		},
+	}
+	p := &ctxtgtest.Parser{
+		Claims:        claims,
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		PlanningService: ps,
+		TokenParser:     p,
+	})
+	req := &GetBurndownReq{
+		Context:     ctx,
+		PlanningIDs: []entities.PlanningID{entities.PlanningID(rand.Int63())},
+		From:        rand.Int63(),
+		To:          rand.Int63(),
+		Step:        rand.Int63(),
+	}
+	resp := &GetBurndownResp{}
+	err := api.GetBurndown(req, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedQuery := entities.BurndownQuery{
+		UserID:      claims.UserID,
+		PlanningIDs: req.PlanningIDs,
+		From:        req.From,
+		To:          req.To,
+		Step:        req.Step,
+	}
+	if !reflect.DeepEqual(ps.burndown, expectedQuery) {
+		t.Errorf("Invalid query passed %+v", ps.burndown)
+	}
+	if !reflect.DeepEqual(resp.Points, ps.points) {
+		t.Errorf("Invalid result %+v", resp.Points)
+	}
+	if err := p.Error(); err != nil {
+		t.Error("Unexpected parser error", err)
 	}
 }
 
-func testContext() ctxtg.Context {
-	return ctxtg.Context{
-		Token: randomToken(),
+func TestGetBurndownOfTeamMember(t *testing.T) {
+	claims := testClaims()
+	ctx := testContext()
+	uid := ctxtg.UserID(rand.Int63())
+	ps := &testPlanningService{}
+	a := &testAccess{}
+	p := &ctxtgtest.Parser{
+		Claims:        claims,
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		PlanningService: ps,
+		TokenParser:     p,
+		Access:          a,
+	})
+	err := api.GetBurndown(&GetBurndownReq{
+		Context: ctx,
+		UserID:  uid,
+	}, &GetBurndownResp{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.actor != claims.UserID || a.userID != uid {
+		t.Errorf("Invalid access check %+v", a)
+	}
+	if ps.burndown.UserID != uid {
+		t.Error("Invalid user ID", ps.burndown.UserID)
 	}
 }
 
-func randomToken() ctxtg.Token {
-	return ctxtg.Token(randomString())
+func TestGetFeedTokenTokenErr(t *testing.T) {
+	ctx := testContext()
+	p := &ctxtgtest.Parser{
+		TokenExpected: ctx.Token,
+		Err:           errors.New("parser err"),
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser: p,
+	})
+	err := api.GetFeedToken(&FeedTokenReq{
+		Context: ctx,
+	}, &FeedTokenResp{})
+	if err != p.Err {
+		t.Error("Parser error expected", err)
+	}
+	if err := p.Error(); err != nil {
+		t.Error("Unexpected parser error", err)
+	}
 }
 
-func randomString() string {
-	return fmt.Sprint(rand.Int63())
+func TestGetFeedTokenStorageErr(t *testing.T) {
+	ctx := testContext()
+	fs := &testFeedStorage{
+		err: errors.New("Storage err"),
+	}
+	p := &ctxtgtest.Parser{
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		FeedStorage: fs,
+		TokenParser: p,
+	})
+	resp := &FeedTokenResp{}
+	err := api.GetFeedToken(&FeedTokenReq{
+		Context: ctx,
+	}, resp)
+	if err != fs.err {
+		t.Error("Storage error expected", err)
+	}
+	if resp.Path != "" {
+		t.Error("Path should be empty", resp.Path)
+	}
 }
 
-type testPlanningService struct {
-	userID     ctxtg.UserID
-	planningID entities.PlanningID
-	extraTime  entities.PlannedTime
-	report     entities.PlanningReport
-	plannings  []entities.ExtendedPlanning
-	spentTime  entities.SpentTimeReport
-	time       int64
-	from       int64
-	to         int64
-	spent      int
-
-	err error
+func TestGetFeedToken(t *testing.T) {
+	claims := testClaims()
+	ctx := testContext()
+	fs := &testFeedStorage{
+		token: randomString(),
+	}
+	p := &ctxtgtest.Parser{
+		Claims:        claims,
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		FeedStorage: fs,
+		TokenParser: p,
+	})
+	resp := &FeedTokenResp{}
+	err := api.GetFeedToken(&FeedTokenReq{
+		Context: ctx,
+	}, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.userID != claims.UserID {
+		t.Error("Invalid user id passed", fs.userID)
+	}
+	if fs.reset {
+		t.Error("Token shouldn't be reset")
+	}
+	if resp.Token != fs.token || resp.Path != "/ics/"+fs.token+".ics" {
+		t.Errorf("Invalid response %+v", resp)
+	}
+	if err := p.Error(); err != nil {
+		t.Error("Unexpected parser error", err)
+	}
 }
 
-func (t *testPlanningService) CreatePlanningNewIssue(_ context.Context) error {
-	return t.err
+func TestResetFeedToken(t *testing.T) {
+	claims := testClaims()
+	ctx := testContext()
+	fs := &testFeedStorage{
+		token: randomString(),
+	}
+	p := &ctxtgtest.Parser{
+		Claims:        claims,
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		FeedStorage: fs,
+		TokenParser: p,
+	})
+	resp := &FeedTokenResp{}
+	err := api.ResetFeedToken(&FeedTokenReq{
+		Context: ctx,
+	}, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.userID != claims.UserID {
+		t.Error("Invalid user id passed", fs.userID)
+	}
+	if !fs.reset {
+		t.Error("Token should be reset")
+	}
+	if resp.Token != fs.token {
+		t.Errorf("Invalid response %+v", resp)
+	}
+	if err := p.Error(); err != nil {
+		t.Error("Unexpected parser error", err)
+	}
 }
 
-func (t *testPlanningService) SetActive(_ context.Context, a entities.NewActivePlanning) error {
-	t.userID = a.UserID
-	t.planningID = a.PlanningID
-	t.time = a.Time
-	return t.err
+func TestCreateTeamNotAdmin(t *testing.T) {
+	ctx := testContext()
+	ts := &testTeamStorage{}
+	a := &testAccess{
+		err: entities.ErrAccessDenied,
+	}
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser: p,
+		TeamStorage: ts,
+		Access:      a,
+	})
+	err := api.CreateTeam(&CreateTeamReq{
+		Context: ctx,
+		Name:    randomString(),
+	}, &CreateTeamResp{})
+	if err != entities.ErrAccessDenied {
+		t.Error("Access error expected", err)
+	}
+	if ts.name != "" {
+		t.Error("Team shouldn't be created")
+	}
 }
 
-func (t *testPlanningService) AddSpentTime(_ context.Context, time entities.SpentTimeReport) error {
-	t.spentTime = time
-	return t.err
+func TestCreateTeam(t *testing.T) {
+	claims := testClaims()
+	ctx := testContext()
+	ts := &testTeamStorage{
+		teamID: entities.TeamID(rand.Int63()),
+	}
+	a := &testAccess{}
+	p := &ctxtgtest.Parser{
+		Claims:        claims,
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser: p,
+		TeamStorage: ts,
+		Access:      a,
+	})
+	name := randomString()
+	var resp CreateTeamResp
+	err := api.CreateTeam(&CreateTeamReq{
+		Context: ctx,
+		Name:    name,
+	}, &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.admin != claims.UserID {
+		t.Error("Admin check expected")
+	}
+	if ts.name != name || resp.TeamID != ts.teamID {
+		t.Errorf("Invalid team %q %+v", ts.name, resp)
+	}
 }
 
-func (t *testPlanningService) ClosePlanning(_ context.Context, userID ctxtg.UserID, r entities.PlanningReport) error {
-	t.report = r
-	t.userID = userID
-	return t.err
-}
-func (t *testPlanningService) OpenedPlannings(_ context.Context, uid ctxtg.UserID) ([]entities.ExtendedPlanning, error) {
+func TestSetTeamMember(t *testing.T) {
+	ctx := testContext()
+	ts := &testTeamStorage{}
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser: p,
+		TeamStorage: ts,
+		Access:      &testAccess{},
+	})
+	expected := entities.TeamMember{
+		TeamID: entities.TeamID(rand.Int63()),
+		UserID: ctxtg.UserID(rand.Int63()),
+		Role:   entities.Lead,
+	}
+	err := api.SetTeamMember(&SetTeamMemberReq{
+		Context: ctx,
+		TeamID:  expected.TeamID,
+		UserID:  expected.UserID,
+		Role:    expected.Role,
+	}, &struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.member != expected {
+		t.Errorf("Invalid member %+v", ts.member)
+	}
+}
+
+func TestGetTeamOverviewAccessDenied(t *testing.T) {
+	ctx := testContext()
+	ts := &testTeamStorage{}
+	a := &testAccess{
+		err: entities.ErrAccessDenied,
+	}
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser: p,
+		TeamStorage: ts,
+		Access:      a,
+	})
+	err := api.GetTeamOverview(&TeamReq{
+		Context: ctx,
+		TeamID:  entities.TeamID(rand.Int63()),
+	}, &GetTeamOverviewResp{})
+	if err != entities.ErrAccessDenied {
+		t.Error("Access error expected", err)
+	}
+	if ts.teamID != 0 {
+		t.Error("Members shouldn't be loaded")
+	}
+}
+
+func TestGetTeamOverview(t *testing.T) {
+	claims := testClaims()
+	ctx := testContext()
+	tid := entities.TeamID(rand.Int63())
+	ts := &testTeamStorage{
+		members: []entities.TeamMember{
+			{TeamID: tid, UserID: ctxtg.UserID(rand.Int63()), Role: entities.Lead},
+			{TeamID: tid, UserID: ctxtg.UserID(rand.Int63()), Role: entities.Member},
+		},
+	}
+	ps := &testPlanningService{
+		plannings: randPlannings(),
+	}
+	a := &testAccess{}
+	p := &ctxtgtest.Parser{
+		Claims:        claims,
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		PlanningService: ps,
+		TokenParser:     p,
+		TeamStorage:     ts,
+		Access:          a,
+	})
+	var resp GetTeamOverviewResp
+	err := api.GetTeamOverview(&TeamReq{
+		Context: ctx,
+		TeamID:  tid,
+	}, &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.actor != claims.UserID || a.teamID != tid || ts.teamID != tid {
+		t.Errorf("Invalid team checked %+v", a)
+	}
+	expected := []entities.MemberOverview{
+		{UserID: ts.members[0].UserID, Role: entities.Lead, Plannings: ps.plannings},
+		{UserID: ts.members[1].UserID, Role: entities.Member, Plannings: ps.plannings},
+	}
+	if !reflect.DeepEqual(resp.Members, expected) {
+		t.Errorf("Invalid overview %+v", resp.Members)
+	}
+}
+
+func TestSubmitTimesheet(t *testing.T) {
+	claims := testClaims()
+	ctx := testContext()
+	ps := &testPlanningService{
+		id: entities.TimesheetID(rand.Int63()),
+	}
+	p := &ctxtgtest.Parser{
+		Claims:        claims,
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		PlanningService: ps,
+		TokenParser:     p,
+	})
+	req := &SubmitTimesheetReq{
+		Context: ctx,
+		From:    rand.Int63(),
+		To:      rand.Int63(),
+	}
+	var resp SubmitTimesheetResp
+	err := api.SubmitTimesheet(req, &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := entities.Timesheet{
+		UserID: claims.UserID,
+		From:   req.From,
+		To:     req.To,
+	}
+	if ps.timesheet != expected {
+		t.Errorf("Invalid timesheet %+v", ps.timesheet)
+	}
+	if resp.TimesheetID != ps.id {
+		t.Error("Invalid response", resp.TimesheetID)
+	}
+}
+
+func TestGetTimesheets(t *testing.T) {
+	claims := testClaims()
+	ctx := testContext()
+	ts := &testTimesheetStorage{
+		timesheets: []entities.Timesheet{
+			{ID: entities.TimesheetID(rand.Int63()), Status: entities.Approved},
+		},
+	}
+	p := &ctxtgtest.Parser{
+		Claims:        claims,
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser:      p,
+		TimesheetStorage: ts,
+	})
+	req := &GetTimesheetsReq{
+		Context: ctx,
+		From:    rand.Int63(),
+		To:      rand.Int63(),
+	}
+	var resp GetTimesheetsResp
+	err := api.GetTimesheets(req, &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.userID != claims.UserID || ts.from != req.From || ts.to != req.To {
+		t.Error("Invalid args passed")
+	}
+	if !reflect.DeepEqual(resp.Timesheets, ts.timesheets) {
+		t.Errorf("Invalid response %+v", resp.Timesheets)
+	}
+}
+
+func TestApproveTimesheetInvalidID(t *testing.T) {
+	ctx := testContext()
+	ts := &testTimesheetStorage{}
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser:      p,
+		TimesheetStorage: ts,
+		Access:           &testAccess{},
+	})
+	err := api.ApproveTimesheet(&ReviewTimesheetReq{
+		Context:     ctx,
+		TimesheetID: entities.TimesheetID(rand.Int63()),
+	}, &struct{}{})
+	if err != entities.ErrInvalidTimesheet {
+		t.Error("Invalid err", err)
+	}
+}
+
+func TestApproveTimesheetAccessDenied(t *testing.T) {
+	ctx := testContext()
+	ts := &testTimesheetStorage{
+		timesheet: &entities.Timesheet{UserID: ctxtg.UserID(rand.Int63())},
+	}
+	a := &testAccess{
+		err: entities.ErrAccessDenied,
+	}
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser:      p,
+		TimesheetStorage: ts,
+		Access:           a,
+	})
+	err := api.ApproveTimesheet(&ReviewTimesheetReq{
+		Context:     ctx,
+		TimesheetID: entities.TimesheetID(rand.Int63()),
+	}, &struct{}{})
+	if err != entities.ErrAccessDenied {
+		t.Error("Access error expected", err)
+	}
+	if ts.review.ID != 0 {
+		t.Error("Timesheet shouldn't be reviewed")
+	}
+}
+
+func TestReviewTimesheet(t *testing.T) {
+	for status, review := range map[entities.TimesheetStatus]func(*API, *ReviewTimesheetReq, *struct{}) error{
+		entities.Approved: (*API).ApproveTimesheet,
+		entities.Rejected: (*API).RejectTimesheet,
+		entities.Unlocked: (*API).UnlockTimesheet,
+	} {
+		claims := testClaims()
+		ctx := testContext()
+		ts := &testTimesheetStorage{
+			timesheet: &entities.Timesheet{UserID: ctxtg.UserID(rand.Int63())},
+		}
+		a := &testAccess{}
+		p := &ctxtgtest.Parser{
+			Claims:        claims,
+			TokenExpected: ctx.Token,
+		}
+		api := newPlanningServiceRPC(RPCConfig{
+			TokenParser:      p,
+			TimesheetStorage: ts,
+			Access:           a,
+		})
+		req := &ReviewTimesheetReq{
+			Context:     ctx,
+			TimesheetID: entities.TimesheetID(rand.Int63()),
+			Comment:     randomString(),
+		}
+		if err := review(api, req, &struct{}{}); err != nil {
+			t.Fatal(err)
+		}
+		if a.actor != claims.UserID || a.userID != ts.timesheet.UserID {
+			t.Errorf("Invalid access check %+v", a)
+		}
+		expected := entities.TimesheetReview{
+			ID:         req.TimesheetID,
+			ApproverID: claims.UserID,
+			Status:     status,
+			Comment:    req.Comment,
+		}
+		if ts.id != req.TimesheetID || ts.review != expected {
+			t.Errorf("Invalid review %+v", ts.review)
+		}
+	}
+}
+
+func TestAddRate(t *testing.T) {
+	claims := testClaims()
+	ctx := testContext()
+	bs := &testBillingStorage{
+		id: entities.RateID(rand.Int63()),
+	}
+	a := &testAccess{}
+	p := &ctxtgtest.Parser{
+		Claims:        claims,
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser:    p,
+		BillingStorage: bs,
+		Access:         a,
+	})
+	req := &AddRateReq{
+		Context:       ctx,
+		UserID:        ctxtg.UserID(rand.Int63()),
+		ProjectID:     entities.ProjectID(rand.Int63()),
+		ActivityID:    entities.ActivityID(rand.Int63()),
+		Rate:          rand.Int63(),
+		EffectiveFrom: rand.Int63(),
+	}
+	var resp AddRateResp
+	if err := api.AddRate(req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if a.admin != claims.UserID {
+		t.Error("Admin check expected")
+	}
+	expected := entities.Rate{
+		UserID:        req.UserID,
+		ProjectID:     req.ProjectID,
+		ActivityID:    req.ActivityID,
+		Rate:          req.Rate,
+		EffectiveFrom: req.EffectiveFrom,
+	}
+	if bs.rate != expected || resp.RateID != bs.id {
+		t.Errorf("Invalid rate %+v %+v", bs.rate, resp)
+	}
+}
+
+func TestSetPlanningBillableNotAdmin(t *testing.T) {
+	ctx := testContext()
+	bs := &testBillingStorage{}
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser:    p,
+		BillingStorage: bs,
+		Access:         &testAccess{err: entities.ErrAccessDenied},
+	})
+	err := api.SetPlanningBillable(&SetPlanningBillableReq{
+		Context:    ctx,
+		PlanningID: entities.PlanningID(rand.Int63()),
+		Billable:   true,
+	}, &struct{}{})
+	if err != entities.ErrAccessDenied {
+		t.Error("Access error expected", err)
+	}
+	if bs.planningID != 0 {
+		t.Error("Planning shouldn't be changed")
+	}
+}
+
+func TestGetBillingReport(t *testing.T) {
+	ctx := testContext()
+	ps := &testPlanningService{
+		billingReport: &entities.BillingReport{Spent: rand.Int63(), Amount: rand.Int63()},
+	}
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		PlanningService: ps,
+		TokenParser:     p,
+		Access:          &testAccess{},
+	})
+	req := &GetBillingReportReq{
+		Context:   ctx,
+		UserID:    ctxtg.UserID(rand.Int63()),
+		ProjectID: entities.ProjectID(rand.Int63()),
+		From:      rand.Int63(),
+		To:        rand.Int63(),
+	}
+	var resp GetBillingReportResp
+	if err := api.GetBillingReport(req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	expected := entities.BillingQuery{
+		UserID:    req.UserID,
+		ProjectID: req.ProjectID,
+		From:      req.From,
+		To:        req.To,
+	}
+	if ps.billing != expected {
+		t.Errorf("Invalid query %+v", ps.billing)
+	}
+	if resp.Report != ps.billingReport {
+		t.Errorf("Invalid report %+v", resp.Report)
+	}
+}
+
+func TestExportUserData(t *testing.T) {
+	claims := testClaims()
+	ctx := testContext()
+	st := &entities.SpentTime{PlanningID: entities.PlanningID(rand.Int63())}
+	prs := &testPrivacyStorage{
+		data: &entities.UserData{LastActivity: rand.Int63()},
+	}
+	sc := &testSpentTimeCache{spentTime: st}
+	a := &testAccess{}
+	p := &ctxtgtest.Parser{
+		Claims:        claims,
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser:    p,
+		PrivacyStorage: prs,
+		SpentTimeCache: sc,
+		Access:         a,
+	})
+	req := &ExportUserDataReq{
+		Context: ctx,
+		UserID:  ctxtg.UserID(rand.Int63()),
+	}
+	var resp ExportUserDataResp
+	if err := api.ExportUserData(req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if a.admin != claims.UserID {
+		t.Error("Admin check expected")
+	}
+	expected := entities.PrivacyRequest{
+		UserID:  req.UserID,
+		AdminID: claims.UserID,
+	}
+	if prs.request != expected || sc.userID != req.UserID {
+		t.Errorf("Invalid request %+v %v", prs.request, sc.userID)
+	}
+	if resp.Data != prs.data || resp.Data.SpentTime != st {
+		t.Errorf("Invalid data %+v", resp.Data)
+	}
+}
+
+func TestEraseUserData(t *testing.T) {
+	for _, pseudonymise := range []bool{false, true} {
+		claims := testClaims()
+		ctx := testContext()
+		prs := &testPrivacyStorage{}
+		sc := &testSpentTimeCache{}
+		p := &ctxtgtest.Parser{
+			Claims:        claims,
+			TokenExpected: ctx.Token,
+		}
+		api := newPlanningServiceRPC(RPCConfig{
+			TokenParser:    p,
+			PrivacyStorage: prs,
+			SpentTimeCache: sc,
+			Access:         &testAccess{},
+		})
+		req := &EraseUserDataReq{
+			Context:      ctx,
+			UserID:       ctxtg.UserID(rand.Int63()),
+			Pseudonymise: pseudonymise,
+		}
+		if err := api.EraseUserData(req, &struct{}{}); err != nil {
+			t.Fatal(err)
+		}
+		expected := entities.PrivacyRequest{
+			UserID:  req.UserID,
+			AdminID: claims.UserID,
+			Action:  entities.Erase,
+		}
+		if pseudonymise {
+			expected.Action = entities.Pseudonymise
+		}
+		if prs.request != expected {
+			t.Errorf("Invalid request %+v", prs.request)
+		}
+		if sc.userID != req.UserID {
+			t.Error("Spent time should be erased")
+		}
+	}
+}
+
+func TestEraseUserDataCacheFailed(t *testing.T) {
+	ctx := testContext()
+	prs := &testPrivacyStorage{}
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser:    p,
+		PrivacyStorage: prs,
+		SpentTimeCache: &testSpentTimeCache{err: entities.ErrMaintenance},
+		Access:         &testAccess{},
+	})
+	err := api.EraseUserData(&EraseUserDataReq{
+		Context: ctx,
+		UserID:  ctxtg.UserID(rand.Int63()),
+	}, &struct{}{})
+	if err != entities.ErrMaintenance {
+		t.Error("Cache error expected", err)
+	}
+	if prs.request.UserID != 0 {
+		t.Error("Data shouldn't be erased while timer is running")
+	}
+}
+
+func TestEraseUserDataNotAdmin(t *testing.T) {
+	ctx := testContext()
+	prs := &testPrivacyStorage{}
+	sc := &testSpentTimeCache{}
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser:    p,
+		PrivacyStorage: prs,
+		SpentTimeCache: sc,
+		Access:         &testAccess{err: entities.ErrAccessDenied},
+	})
+	err := api.EraseUserData(&EraseUserDataReq{
+		Context: ctx,
+		UserID:  ctxtg.UserID(rand.Int63()),
+	}, &struct{}{})
+	if err != entities.ErrAccessDenied {
+		t.Error("Access error expected", err)
+	}
+	if prs.request.UserID != 0 || sc.userID != 0 {
+		t.Error("Data shouldn't be erased")
+	}
+}
+
+func TestEraseUserDataInvalidUserID(t *testing.T) {
+	ctx := testContext()
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser:    p,
+		PrivacyStorage: &testPrivacyStorage{},
+		SpentTimeCache: &testSpentTimeCache{},
+		Access:         &testAccess{},
+	})
+	err := api.EraseUserData(&EraseUserDataReq{Context: ctx}, &struct{}{})
+	if err != entities.ErrInvalidUserID {
+		t.Error("Invalid user id error expected", err)
+	}
+}
+
+func TestGetTimers(t *testing.T) {
+	claims := testClaims()
+	ctx := testContext()
+	ts := &testTimerService{
+		timers: []entities.Timer{{SpentTime: entities.SpentTime{UserID: ctxtg.UserID(rand.Int63())}, Stale: 10}},
+	}
+	a := &testAccess{}
+	p := &ctxtgtest.Parser{
+		Claims:        claims,
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser:  p,
+		TimerService: ts,
+		Access:       a,
+	})
+	var resp GetTimersResp
+	if err := api.GetTimers(&GetTimersReq{Context: ctx}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if a.admin != claims.UserID {
+		t.Error("Admin check expected")
+	}
+	if !reflect.DeepEqual(resp.Timers, ts.timers) {
+		t.Errorf("Invalid timers %+v", resp.Timers)
+	}
+}
+
+func TestFlushTimer(t *testing.T) {
+	ctx := testContext()
+	ts := &testTimerService{flushed: true}
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser:  p,
+		TimerService: ts,
+		Access:       &testAccess{},
+	})
+	req := &FlushTimerReq{
+		Context: ctx,
+		UserID:  ctxtg.UserID(rand.Int63()),
+	}
+	var resp FlushTimerResp
+	if err := api.FlushTimer(req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if ts.userID != req.UserID || !resp.Flushed {
+		t.Errorf("Invalid flush of %v: %+v", ts.userID, resp)
+	}
+}
+
+func TestFlushTimerNotAdmin(t *testing.T) {
+	ctx := testContext()
+	ts := &testTimerService{}
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser:  p,
+		TimerService: ts,
+		Access:       &testAccess{err: entities.ErrAccessDenied},
+	})
+	err := api.FlushTimer(&FlushTimerReq{
+		Context: ctx,
+		UserID:  ctxtg.UserID(rand.Int63()),
+	}, &FlushTimerResp{})
+	if err != entities.ErrAccessDenied {
+		t.Error("Access error expected", err)
+	}
+	if ts.userID != 0 {
+		t.Error("Timer shouldn't be flushed")
+	}
+}
+
+func TestDiscardTimer(t *testing.T) {
+	ctx := testContext()
+	ts := &testTimerService{
+		spentTime: &entities.SpentTime{PlanningID: entities.PlanningID(rand.Int63())},
+	}
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser:  p,
+		TimerService: ts,
+		Access:       &testAccess{},
+	})
+	req := &DiscardTimerReq{
+		Context: ctx,
+		UserID:  ctxtg.UserID(rand.Int63()),
+	}
+	var resp DiscardTimerResp
+	if err := api.DiscardTimer(req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if ts.userID != req.UserID || resp.SpentTime != ts.spentTime {
+		t.Errorf("Invalid discard of %v: %+v", ts.userID, resp)
+	}
+	err := api.DiscardTimer(&DiscardTimerReq{Context: ctx}, &resp)
+	if err != entities.ErrInvalidUserID {
+		t.Error("Invalid user id error expected", err)
+	}
+}
+
+func TestBackupTimers(t *testing.T) {
+	ctx := testContext()
+	sc := &testSpentTimeCache{}
+	p := &ctxtgtest.Parser{
+		Claims:        testClaims(),
+		TokenExpected: ctx.Token,
+	}
+	api := newPlanningServiceRPC(RPCConfig{
+		TokenParser:    p,
+		SpentTimeCache: sc,
+		Access:         &testAccess{},
+	})
+	if err := api.BackupTimers(&BackupTimersReq{Context: ctx}, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if sc.backups != 1 {
+		t.Error("Backup expected")
+	}
+	sc.err = entities.ErrMaintenance
+	if err := api.BackupTimers(&BackupTimersReq{Context: ctx}, &struct{}{}); err != entities.ErrMaintenance {
+		t.Error("Backup error expected", err)
+	}
+}
+
+func randPlannings() []entities.ExtendedPlanning {
+	var ps []entities.ExtendedPlanning
+	for i := 0; i < rand.Intn(10); i++ {
+		ps = append(ps, randPlanning())
+	}
+	return ps
+}
+
+func randPlanning() entities.ExtendedPlanning {
+	return entities.ExtendedPlanning{
+		Estimation: rand.Int63(),
+		Planning: entities.Planning{
+			ID:         entities.PlanningID(rand.Int63()),
+			IssueTitle: randomString(),
+		},
+	}
+}
+
+func testClaims() ctxtg.Claims {
+	return ctxtg.Claims{
+		UserID: ctxtg.UserID(rand.Int63()),
+	}
+}
+
+func testContext() ctxtg.Context {
+	return ctxtg.Context{
+		Token: randomToken(),
+	}
+}
+
+func randomToken() ctxtg.Token {
+	return ctxtg.Token(randomString())
+}
+
+func randomString() string {
+	return fmt.Sprint(rand.Int63())
+}
+
+type testPlanningService struct {
+	userID        ctxtg.UserID
+	planningID    entities.PlanningID
+	extraTime     entities.PlannedTime
+	report        entities.PlanningReport
+	plannings     []entities.ExtendedPlanning
+	spentTime     entities.SpentTimeReport
+	time          int64
+	from          int64
+	to            int64
+	spent         int
+	burndown      entities.BurndownQuery
+	points        []entities.BurndownPoint
+	timesheet     entities.Timesheet
+	id            entities.TimesheetID
+	billing       entities.BillingQuery
+	billingReport *entities.BillingReport
+
+	err error
+}
+
+func (t *testPlanningService) CreatePlanningNewIssue(_ context.Context) error {
+	return t.err
+}
+
+func (t *testPlanningService) SetActive(_ context.Context, a entities.NewActivePlanning) error {
+	t.userID = a.UserID
+	t.planningID = a.PlanningID
+	t.time = a.Time
+	return t.err
+}
+
+func (t *testPlanningService) AddSpentTime(_ context.Context, time entities.SpentTimeReport) error {
+	t.spentTime = time
+	return t.err
+}
+
+func (t *testPlanningService) ClosePlanning(_ context.Context, userID ctxtg.UserID, r entities.PlanningReport) error {
+	t.report = r
+	t.userID = userID
+	return t.err
+}
+func (t *testPlanningService) OpenedPlannings(_ context.Context, uid ctxtg.UserID) ([]entities.ExtendedPlanning, error) {
 	t.userID = uid
 	return t.plannings, t.err
 }
@@ -687,6 +1720,21 @@ func (t *testPlanningService) SpentTime(_ context.Context, uid ctxtg.UserID, fro
 	return t.spent, t.err
 }
 
+func (t *testPlanningService) Burndown(_ context.Context, q entities.BurndownQuery) ([]entities.BurndownPoint, error) {
+	t.burndown = q
+	return t.points, t.err
+}
+
+func (t *testPlanningService) SubmitTimesheet(_ context.Context, ts entities.Timesheet) (entities.TimesheetID, error) {
+	t.timesheet = ts
+	return t.id, t.err
+}
+
+func (t *testPlanningService) BillingReport(_ context.Context, q entities.BillingQuery) (*entities.BillingReport, error) {
+	t.billing = q
+	return t.billingReport, t.err
+}
+
 type testPlanningStorage struct {
 	err         error
 	newPlanning entities.NewPlanning
@@ -705,3 +1753,244 @@ func (t *testPlanningStorage) AddExtraTime(_ context.Context, userID ctxtg.UserI
 	t.extraTime = et
 	return t.err
 }
+
+type testFeedStorage struct {
+	userID ctxtg.UserID
+	token  string
+	reset  bool
+
+	err error
+}
+
+func (t *testFeedStorage) FeedToken(_ context.Context, uid ctxtg.UserID) (string, error) {
+	t.userID = uid
+	if t.err != nil {
+		return "", t.err
+	}
+	return t.token, nil
+}
+
+func (t *testFeedStorage) ResetFeedToken(_ context.Context, uid ctxtg.UserID) (string, error) {
+	t.userID = uid
+	t.reset = true
+	if t.err != nil {
+		return "", t.err
+	}
+	return t.token, nil
+}
+
+type testAccess struct {
+	admin  ctxtg.UserID
+	actor  ctxtg.UserID
+	userID ctxtg.UserID
+	teamID entities.TeamID
+
+	err error
+}
+
+func (t *testAccess) Admin(_ context.Context, actor ctxtg.Claims) error {
+	t.admin = actor.UserID
+	return t.err
+}
+
+func (t *testAccess) View(_ context.Context, actor ctxtg.Claims, uid ctxtg.UserID) error {
+	t.actor = actor.UserID
+	t.userID = uid
+	return t.err
+}
+
+func (t *testAccess) Approve(_ context.Context, actor ctxtg.Claims, uid ctxtg.UserID) error {
+	t.actor = actor.UserID
+	t.userID = uid
+	return t.err
+}
+
+func (t *testAccess) ViewTeam(_ context.Context, actor ctxtg.Claims, tid entities.TeamID) error {
+	t.actor = actor.UserID
+	t.teamID = tid
+	return t.err
+}
+
+type testTeamStorage struct {
+	name    string
+	teamID  entities.TeamID
+	userID  ctxtg.UserID
+	member  entities.TeamMember
+	teams   []entities.Team
+	members []entities.TeamMember
+
+	err error
+}
+
+func (t *testTeamStorage) CreateTeam(_ context.Context, name string) (entities.TeamID, error) {
+	t.name = name
+	return t.teamID, t.err
+}
+
+func (t *testTeamStorage) DeleteTeam(_ context.Context, tid entities.TeamID) error {
+	t.teamID = tid
+	return t.err
+}
+
+func (t *testTeamStorage) Teams(_ context.Context) ([]entities.Team, error) {
+	return t.teams, t.err
+}
+
+func (t *testTeamStorage) SetTeamMember(_ context.Context, m entities.TeamMember) error {
+	t.member = m
+	return t.err
+}
+
+func (t *testTeamStorage) RemoveTeamMember(_ context.Context, tid entities.TeamID, uid ctxtg.UserID) error {
+	t.teamID = tid
+	t.userID = uid
+	return t.err
+}
+
+func (t *testTeamStorage) TeamMembers(_ context.Context, tid entities.TeamID) ([]entities.TeamMember, error) {
+	t.teamID = tid
+	return t.members, t.err
+}
+
+type testTimesheetStorage struct {
+	id         entities.TimesheetID
+	userID     ctxtg.UserID
+	from       int64
+	to         int64
+	timesheet  *entities.Timesheet
+	timesheets []entities.Timesheet
+	review     entities.TimesheetReview
+
+	err error
+}
+
+func (t *testTimesheetStorage) Timesheet(_ context.Context, id entities.TimesheetID) (*entities.Timesheet, error) {
+	t.id = id
+	if t.timesheet == nil {
+		return nil, entities.ErrInvalidTimesheet
+	}
+	return t.timesheet, nil
+}
+
+func (t *testTimesheetStorage) Timesheets(_ context.Context, uid ctxtg.UserID, from, to int64) ([]entities.Timesheet, error) {
+	t.userID = uid
+	t.from = from
+	t.to = to
+	return t.timesheets, t.err
+}
+
+func (t *testTimesheetStorage) ReviewTimesheet(_ context.Context, r entities.TimesheetReview) error {
+	t.review = r
+	return t.err
+}
+
+type testBillingStorage struct {
+	projectBilling entities.ProjectBilling
+	planningID     entities.PlanningID
+	billable       bool
+	rate           entities.Rate
+	id             entities.RateID
+	rates          []entities.Rate
+
+	err error
+}
+
+func (t *testBillingStorage) SetProjectBilling(_ context.Context, pb entities.ProjectBilling) error {
+	t.projectBilling = pb
+	return t.err
+}
+
+func (t *testBillingStorage) SetPlanningBillable(_ context.Context, pid entities.PlanningID, billable bool) error {
+	t.planningID = pid
+	t.billable = billable
+	return t.err
+}
+
+func (t *testBillingStorage) AddRate(_ context.Context, r entities.Rate) (entities.RateID, error) {
+	t.rate = r
+	return t.id, t.err
+}
+
+func (t *testBillingStorage) DeleteRate(_ context.Context, id entities.RateID) error {
+	t.id = id
+	return t.err
+}
+
+func (t *testBillingStorage) Rates(_ context.Context) ([]entities.Rate, error) {
+	return t.rates, t.err
+}
+
+type testPrivacyStorage struct {
+	request  entities.PrivacyRequest
+	data     *entities.UserData
+	requests []entities.PrivacyRequest
+
+	err error
+}
+
+func (t *testPrivacyStorage) ExportUserData(_ context.Context, r entities.PrivacyRequest) (*entities.UserData, error) {
+	t.request = r
+	return t.data, t.err
+}
+
+func (t *testPrivacyStorage) EraseUserData(_ context.Context, r entities.PrivacyRequest) error {
+	t.request = r
+	return t.err
+}
+
+func (t *testPrivacyStorage) PrivacyRequests(_ context.Context, uid ctxtg.UserID) ([]entities.PrivacyRequest, error) {
+	t.request.UserID = uid
+	return t.requests, t.err
+}
+
+type testSpentTimeCache struct {
+	userID    ctxtg.UserID
+	spentTime *entities.SpentTime
+	backups   int
+
+	err error
+}
+
+func (t *testSpentTimeCache) SpentTime(_ context.Context, uid ctxtg.UserID) (*entities.SpentTime, error) {
+	t.userID = uid
+	return t.spentTime, t.err
+}
+
+func (t *testSpentTimeCache) Erase(_ context.Context, uid ctxtg.UserID) error {
+	if t.err != nil {
+		return t.err
+	}
+	t.userID = uid
+	return nil
+}
+
+func (t *testSpentTimeCache) Backup(_ context.Context) error {
+	if t.err != nil {
+		return t.err
+	}
+	t.backups++
+	return nil
+}
+
+type testTimerService struct {
+	userID    ctxtg.UserID
+	timers    []entities.Timer
+	flushed   bool
+	spentTime *entities.SpentTime
+
+	err error
+}
+
+func (t *testTimerService) Timers(_ context.Context) ([]entities.Timer, error) {
+	return t.timers, t.err
+}
+
+func (t *testTimerService) FlushTimer(_ context.Context, uid ctxtg.UserID) (bool, error) {
+	t.userID = uid
+	return t.flushed, t.err
+}
+
+func (t *testTimerService) DiscardTimer(_ context.Context, uid ctxtg.UserID) (*entities.SpentTime, error) {
+	t.userID = uid
+	return t.spentTime, t.err
+}
@@ -0,0 +1,182 @@
+package rpcsvc
+
+import (
+	"context"
+
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+// TeamStorage is required dependency for API
+type TeamStorage interface {
+	CreateTeam(context.Context, string) (entities.TeamID, error)
+	DeleteTeam(context.Context, entities.TeamID) error
+	Teams(context.Context) ([]entities.Team, error)
+	SetTeamMember(context.Context, entities.TeamMember) error
+	RemoveTeamMember(context.Context, entities.TeamID, ctxtg.UserID) error
+	TeamMembers(context.Context, entities.TeamID) ([]entities.TeamMember, error)
+}
+
+// CreateTeamReq is input parameter to CreateTeam
+type CreateTeamReq struct {
+	Context ctxtg.Context
+	Name    string
+}
+
+// CreateTeamResp is output from CreateTeam
+type CreateTeamResp struct {
+	TeamID entities.TeamID
+}
+
+// CreateTeam creates new empty team, admin only
+func (p *API) CreateTeam(req *CreateTeamReq, resp *CreateTeamResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		id, err := p.teamStorage.CreateTeam(ctx, req.Name)
+		*resp = CreateTeamResp{
+			TeamID: id,
+		}
+		return err
+	})
+	return errWithLog(req.Context, "failed to CreateTeam", err)
+}
+
+// TeamReq is input parameter to DeleteTeam, GetTeamMembers and GetTeamOverview
+type TeamReq struct {
+	Context ctxtg.Context
+	TeamID  entities.TeamID
+}
+
+// DeleteTeam removes team with all its members, admin only
+func (p *API) DeleteTeam(req *TeamReq, _ *struct{}) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		return p.teamStorage.DeleteTeam(ctx, req.TeamID)
+	})
+	return errWithLog(req.Context, "failed to DeleteTeam", err)
+}
+
+// GetTeamsReq is input parameter to GetTeams
+type GetTeamsReq struct {
+	Context ctxtg.Context
+}
+
+// GetTeamsResp is output from GetTeams
+type GetTeamsResp struct {
+	Teams []entities.Team
+}
+
+// GetTeams returns all teams, admin only
+func (p *API) GetTeams(req *GetTeamsReq, resp *GetTeamsResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		teams, err := p.teamStorage.Teams(ctx)
+		*resp = GetTeamsResp{
+			Teams: teams,
+		}
+		return err
+	})
+	return errWithLog(req.Context, "failed to GetTeams", err)
+}
+
+// SetTeamMemberReq is input parameter to SetTeamMember
+type SetTeamMemberReq struct {
+	Context ctxtg.Context
+	TeamID  entities.TeamID
+	UserID  ctxtg.UserID
+	Role    entities.TeamRole
+}
+
+// SetTeamMember adds user to team or changes role of existing member, admin only
+func (p *API) SetTeamMember(req *SetTeamMemberReq, _ *struct{}) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		return p.teamStorage.SetTeamMember(ctx, entities.TeamMember{
+			TeamID: req.TeamID,
+			UserID: req.UserID,
+			Role:   req.Role,
+		})
+	})
+	return errWithLog(req.Context, "failed to SetTeamMember", err)
+}
+
+// RemoveTeamMemberReq is input parameter to RemoveTeamMember
+type RemoveTeamMemberReq struct {
+	Context ctxtg.Context
+	TeamID  entities.TeamID
+	UserID  ctxtg.UserID
+}
+
+// RemoveTeamMember removes user from team, admin only
+func (p *API) RemoveTeamMember(req *RemoveTeamMemberReq, _ *struct{}) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		return p.teamStorage.RemoveTeamMember(ctx, req.TeamID, req.UserID)
+	})
+	return errWithLog(req.Context, "failed to RemoveTeamMember", err)
+}
+
+// GetTeamMembersResp is output from GetTeamMembers
+type GetTeamMembersResp struct {
+	Members []entities.TeamMember
+}
+
+// GetTeamMembers returns members of team, allowed for admins and team leads
+func (p *API) GetTeamMembers(req *TeamReq, resp *GetTeamMembersResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.ViewTeam(ctx, c, req.TeamID); err != nil {
+			return err
+		}
+		ms, err := p.teamStorage.TeamMembers(ctx, req.TeamID)
+		*resp = GetTeamMembersResp{
+			Members: ms,
+		}
+		return err
+	})
+	return errWithLog(req.Context, "failed to GetTeamMembers", err)
+}
+
+// GetTeamOverviewResp is output from GetTeamOverview
+type GetTeamOverviewResp struct {
+	Members []entities.MemberOverview
+}
+
+// GetTeamOverview returns opened plannings of every team member, allowed for admins and team leads
+func (p *API) GetTeamOverview(req *TeamReq, resp *GetTeamOverviewResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.ViewTeam(ctx, c, req.TeamID); err != nil {
+			return err
+		}
+		ms, err := p.teamStorage.TeamMembers(ctx, req.TeamID)
+		if err != nil {
+			return err
+		}
+		var overview []entities.MemberOverview
+		for _, m := range ms {
+			ps, err := p.planningService.OpenedPlannings(ctx, m.UserID)
+			if err != nil {
+				return err
+			}
+			overview = append(overview, entities.MemberOverview{
+				UserID:    m.UserID,
+				Role:      m.Role,
+				Plannings: ps,
+			})
+		}
+		*resp = GetTeamOverviewResp{
+			Members: overview,
+		}
+		return nil
+	})
+	return errWithLog(req.Context, "failed to GetTeamOverview", err)
+}
@@ -0,0 +1,125 @@
+package rpcsvc
+
+import (
+	"context"
+
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+// TimerService is required dependency for API, it manages running timers of users
+type TimerService interface {
+	Timers(context.Context) ([]entities.Timer, error)
+	FlushTimer(context.Context, ctxtg.UserID) (bool, error)
+	DiscardTimer(context.Context, ctxtg.UserID) (*entities.SpentTime, error)
+}
+
+// GetTimersReq is input parameter to GetTimers
+type GetTimersReq struct {
+	Context ctxtg.Context
+}
+
+// GetTimersResp is output from GetTimers
+type GetTimersResp struct {
+	Timers []entities.Timer
+}
+
+// GetTimers returns running timers of all users, admin only
+func (p *API) GetTimers(req *GetTimersReq, resp *GetTimersResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		ts, err := p.timerService.Timers(ctx)
+		*resp = GetTimersResp{
+			Timers: ts,
+		}
+		return err
+	})
+	return errWithLog(req.Context, "failed to GetTimers", err)
+}
+
+// FlushTimerReq is input parameter to FlushTimer
+type FlushTimerReq struct {
+	Context ctxtg.Context
+	UserID  ctxtg.UserID
+}
+
+// FlushTimerResp is output from FlushTimer
+type FlushTimerResp struct {
+	Flushed bool
+}
+
+// FlushTimer saves user's running timer to spent time histories and stops it like
+// if user stopped it, Flushed is false if user has no running timer, admin only
+func (p *API) FlushTimer(req *FlushTimerReq, resp *FlushTimerResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		if req.UserID == 0 {
+			return entities.ErrInvalidUserID
+		}
+		flushed, err := p.timerService.FlushTimer(ctx, req.UserID)
+		if err != nil {
+			return err
+		}
+		log.NOTICE("Admin %d flushed timer of user %d: %v", c.UserID, req.UserID, flushed)
+		*resp = FlushTimerResp{
+			Flushed: flushed,
+		}
+		return nil
+	})
+	return errWithLog(req.Context, "failed to FlushTimer", err)
+}
+
+// DiscardTimerReq is input parameter to DiscardTimer
+type DiscardTimerReq struct {
+	Context ctxtg.Context
+	UserID  ctxtg.UserID
+}
+
+// DiscardTimerResp is output from DiscardTimer
+type DiscardTimerResp struct {
+	SpentTime *entities.SpentTime
+}
+
+// DiscardTimer stops user's running timer without saving spent time and returns it,
+// SpentTime is nil if user has no running timer, admin only
+func (p *API) DiscardTimer(req *DiscardTimerReq, resp *DiscardTimerResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		if req.UserID == 0 {
+			return entities.ErrInvalidUserID
+		}
+		st, err := p.timerService.DiscardTimer(ctx, req.UserID)
+		if err != nil {
+			return err
+		}
+		log.NOTICE("Admin %d discarded timer of user %d: %+v", c.UserID, req.UserID, st)
+		*resp = DiscardTimerResp{
+			SpentTime: st,
+		}
+		return nil
+	})
+	return errWithLog(req.Context, "failed to DiscardTimer", err)
+}
+
+// BackupTimersReq is input parameter to BackupTimers
+type BackupTimersReq struct {
+	Context ctxtg.Context
+}
+
+// BackupTimers writes backup of running timers at once, it does nothing if timers
+// are kept by Redis or database, admin only
+func (p *API) BackupTimers(req *BackupTimersReq, _ *struct{}) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		if err := p.access.Admin(ctx, c); err != nil {
+			return err
+		}
+		return p.spentTimeCache.Backup(ctx)
+	})
+	return errWithLog(req.Context, "failed to BackupTimers", err)
+}
@@ -0,0 +1,116 @@
+package rpcsvc
+
+import (
+	"context"
+
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+// TimesheetStorage is required dependency for API
+type TimesheetStorage interface {
+	Timesheet(context.Context, entities.TimesheetID) (*entities.Timesheet, error)
+	Timesheets(context.Context, ctxtg.UserID, int64, int64) ([]entities.Timesheet, error)
+	ReviewTimesheet(context.Context, entities.TimesheetReview) error
+}
+
+// SubmitTimesheetReq is input parameter to SubmitTimesheet
+type SubmitTimesheetReq struct {
+	Context ctxtg.Context
+	From    int64
+	To      int64
+}
+
+// SubmitTimesheetResp is output from SubmitTimesheet
+type SubmitTimesheetResp struct {
+	TimesheetID entities.TimesheetID
+}
+
+// SubmitTimesheet locks user's period From - To and submits it for approval
+func (p *API) SubmitTimesheet(req *SubmitTimesheetReq, resp *SubmitTimesheetResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		id, err := p.planningService.SubmitTimesheet(ctx, entities.Timesheet{
+			UserID: c.UserID,
+			From:   req.From,
+			To:     req.To,
+		})
+		*resp = SubmitTimesheetResp{
+			TimesheetID: id,
+		}
+		return err
+	})
+	return errWithLog(req.Context, "failed to SubmitTimesheet", err)
+}
+
+// GetTimesheetsReq is input parameter to GetTimesheets
+type GetTimesheetsReq struct {
+	Context ctxtg.Context
+	UserID  ctxtg.UserID
+	From    int64
+	To      int64
+}
+
+// GetTimesheetsResp is output from GetTimesheets
+type GetTimesheetsResp struct {
+	Timesheets []entities.Timesheet
+}
+
+// GetTimesheets returns user's timesheets overlapping From - To,
+// timesheets of UserID are returned if it's set and caller is allowed to view them
+func (p *API) GetTimesheets(req *GetTimesheetsReq, resp *GetTimesheetsResp) error {
+	err := p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		uid, err := p.viewedUserID(ctx, c, req.UserID)
+		if err != nil {
+			return err
+		}
+		ts, err := p.timesheetStorage.Timesheets(ctx, uid, req.From, req.To)
+		*resp = GetTimesheetsResp{
+			Timesheets: ts,
+		}
+		return err
+	})
+	return errWithLog(req.Context, "failed to GetTimesheets", err)
+}
+
+// ReviewTimesheetReq is input parameter to ApproveTimesheet, RejectTimesheet and UnlockTimesheet
+type ReviewTimesheetReq struct {
+	Context     ctxtg.Context
+	TimesheetID entities.TimesheetID
+	Comment     string
+}
+
+// ApproveTimesheet approves submitted timesheet, period stays locked
+func (p *API) ApproveTimesheet(req *ReviewTimesheetReq, _ *struct{}) error {
+	err := p.reviewTimesheet(req, entities.Approved)
+	return errWithLog(req.Context, "failed to ApproveTimesheet", err)
+}
+
+// RejectTimesheet rejects submitted timesheet and unlocks its period
+func (p *API) RejectTimesheet(req *ReviewTimesheetReq, _ *struct{}) error {
+	err := p.reviewTimesheet(req, entities.Rejected)
+	return errWithLog(req.Context, "failed to RejectTimesheet", err)
+}
+
+// UnlockTimesheet unlocks period of approved timesheet
+func (p *API) UnlockTimesheet(req *ReviewTimesheetReq, _ *struct{}) error {
+	err := p.reviewTimesheet(req, entities.Unlocked)
+	return errWithLog(req.Context, "failed to UnlockTimesheet", err)
+}
+
+func (p *API) reviewTimesheet(req *ReviewTimesheetReq, status entities.TimesheetStatus) error {
+	return p.tokenParser.ParseCtxWithClaims(req.Context, func(ctx context.Context, c ctxtg.Claims) error {
+		t, err := p.timesheetStorage.Timesheet(ctx, req.TimesheetID)
+		if err != nil {
+			return err
+		}
+		if err := p.access.Approve(ctx, c, t.UserID); err != nil {
+			return err
+		}
+		return p.timesheetStorage.ReviewTimesheet(ctx, entities.TimesheetReview{
+			ID:         req.TimesheetID,
+			ApproverID: c.UserID,
+			Status:     status,
+			Comment:    req.Comment,
+		})
+	})
+}
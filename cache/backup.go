@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/powerman/narada-go/narada"
+
+	"github.com/qarea/planningms/entities"
+)
+
+// backupVersion is version of backup format written by backup,
+// version 0 is bare JSON array of SpentTime written before backups had envelope,
+// it's null if there were no timers
+const backupVersion = 1
+
+var (
+	errCorruptedBackup      = errors.New("backup is corrupted")
+	errUnknownBackupVersion = errors.New("backup is written by newer version of service")
+)
+
+// backupEnvelope is content of backup file, CRC32 is IEEE checksum of SpentTime
+type backupEnvelope struct {
+	Version        int
+	WrittenAt      time.Time
+	ServiceVersion string
+	CRC32          uint32
+	SpentTime      json.RawMessage
+}
+
+func encodeBackup(sts []entities.SpentTime, writtenAt time.Time) ([]byte, error) {
+	if sts == nil {
+		sts = []entities.SpentTime{}
+	}
+	data, err := json.Marshal(sts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal data")
+	}
+	serviceVersion, _ := narada.Version()
+	return json.Marshal(backupEnvelope{
+		Version:        backupVersion,
+		WrittenAt:      writtenAt.UTC(),
+		ServiceVersion: serviceVersion,
+		CRC32:          crc32.ChecksumIEEE(data),
+		SpentTime:      data,
+	})
+}
+
+// decodeBackup returns SpentTime of backup of any known version, errCorruptedBackup is returned
+// if b isn't complete backup or its checksum doesn't match
+func decodeBackup(b []byte) ([]entities.SpentTime, error) {
+	var sts []entities.SpentTime
+	b = bytes.TrimSpace(b)
+	if !bytes.HasPrefix(b, []byte("{")) {
+		if err := json.Unmarshal(b, &sts); err != nil {
+			return nil, errors.Wrapf(errCorruptedBackup, "version 0: %v", err)
+		}
+		log.NOTICE("Migrating backup of version 0 to version %d", backupVersion)
+		return sts, nil
+	}
+	var e backupEnvelope
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, errors.Wrap(errCorruptedBackup, err.Error())
+	}
+	if e.Version > backupVersion {
+		return nil, errors.Wrapf(errUnknownBackupVersion, "version %d of %q, supported %d",
+			e.Version, e.ServiceVersion, backupVersion)
+	}
+	if e.Version < 1 || crc32.ChecksumIEEE(e.SpentTime) != e.CRC32 {
+		return nil, errors.Wrapf(errCorruptedBackup, "checksum mismatch of version %d written at %v",
+			e.Version, e.WrittenAt)
+	}
+	if err := json.Unmarshal(e.SpentTime, &sts); err != nil {
+		return nil, errors.Wrap(errCorruptedBackup, err.Error())
+	}
+	return sts, nil
+}
+
+// retainBackup links current backup as first retained backup, the oldest one is removed
+// when there are keep retained backups already. Current backup is kept in place,
+// so it's replaced atomically by new one afterwards
+func retainBackup(path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	if err := os.Remove(retainedBackupPath(path, keep)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove oldest retained backup")
+	}
+	for i := keep - 1; i >= 1; i-- {
+		err := os.Rename(retainedBackupPath(path, i), retainedBackupPath(path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to rotate retained backups")
+		}
+	}
+	if err := os.Link(path, retainedBackupPath(path, 1)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to retain backup")
+	}
+	return nil
+}
+
+// removeRetainedBackups removes up to keep retained backups of path
+func removeRetainedBackups(path string, keep int) error {
+	for i := 1; i <= keep; i++ {
+		if err := os.Remove(retainedBackupPath(path, i)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to remove retained backup")
+		}
+	}
+	return nil
+}
+
+// retainedBackupPath returns path of n-th retained backup, 0 is current backup
+func retainedBackupPath(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s.%d", path, n)
+}
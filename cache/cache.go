@@ -1,9 +1,9 @@
-// Package cache provide in-memory cache for spent time with file backup
+// Package cache provide storages of spent time: in-memory cache with file backup and journal
+// or Redis-compatible server shared by replicas
 package cache
 
 import (
 	"context"
-	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -22,70 +22,493 @@ var log = narada.NewLog("spent time cache: ")
 const (
 	backupFileName = "backup.json"
 	tmpExtension   = ".tmp"
+	// shardCount is number of independently locked parts of cache
+	shardCount = 64
 )
 
-//NewSpentTimeInMemory correctly initialize SpentTimeInMemory instance with configuration
-func NewSpentTimeInMemory(backupFreq time.Duration, backupFolder string, sharedLock time.Duration) *SpentTimeInMemory {
+// SpentTimeInMemoryCfg is configuration of SpentTimeInMemory
+type SpentTimeInMemoryCfg struct {
+	// Folder keeps backup, retained backups and journal
+	Folder string
+	// Frequency of backups
+	Frequency time.Duration
+	// Keep is number of previous backups retained besides current one
+	Keep int
+	// SharedLock is timeout of narada.SharedLock
+	SharedLock time.Duration
+}
+
+//NewSpentTimeInMemory correctly initialize SpentTimeInMemory instance with configuration.
+//It restores SpentTime from backup and journal and returns error if they can't be restored,
+//e.g. backup is corrupted or written by newer version, they are never overwritten then
+//and should be fixed by operator
+func NewSpentTimeInMemory(cfg SpentTimeInMemoryCfg) (*SpentTimeInMemory, error) {
 	s := &SpentTimeInMemory{
-		backupFolder:       backupFolder,
-		spentTime:          make(map[ctxtg.UserID]entities.SpentTime),
-		sharedLockDuration: sharedLock,
+		backupFolder:       cfg.Folder,
+		keepBackups:        cfg.Keep,
+		sharedLockDuration: cfg.SharedLock,
 	}
 
 	if err := s.withSharedLock(s.restore); err != nil {
-		log.ERR("Failed to restore %v", err)
+		if errors.Cause(err) == errCorruptedBackup || errors.Cause(err) == errUnknownBackupVersion {
+			return nil, errors.Wrapf(err, "refusing to start with backup %s, replace it by one of retained backups %s.N or remove it to drop all running timers",
+				s.backupFilePath(), s.backupFilePath())
+		}
+		// Backup at start would remove journal segments which weren't replayed
+		return nil, errors.Wrapf(err, "refusing to start without restored timers, backup and journal in %s are kept",
+			s.backupFolder)
+	}
+	j, err := openJournal(cfg.Folder)
+	if err != nil {
+		log.ERR("Failed to open journal, changes will be lost on crash until next backup %+v", err)
+	} else {
+		s.journal = j
 	}
-	s.backupEvery(backupFreq)
+	// Backup at start removes replayed journal segments
+	if err := s.withSharedLock(s.backup); err != nil {
+		log.ERR("Failed to backup %+v", err)
+	}
+	s.backupEvery(cfg.Frequency)
+
+	return s, nil
+}
 
-	return s
+// HistoryStorage saves spent time histories flushed from SpentTimeInMemory
+type HistoryStorage interface {
+	AddSpentTime(context.Context, entities.SpentTimeHistory) error
 }
 
-//SpentTimeInMemory cache with backup in-memory storage to hard drive
+//SpentTimeInMemory cache with backup in-memory storage to hard drive.
+//Every change is written to journal when it becomes visible and synced to disk before call returns,
+//syncs of concurrent changes are grouped and made without shard lock. Journal is replayed over
+//backup on restore and compacted by every backup. Users are split into shards by UserID, so changes
+//of users in different shards and backup of other shards don't wait for each other
 type SpentTimeInMemory struct {
-	l         sync.Mutex
-	spentTime map[ctxtg.UserID]entities.SpentTime
+	shards [shardCount]shard
+
+	// l guards closed and histories, it's held for reading while user is locked
+	l         sync.RWMutex
+	closed    bool
+	histories HistoryStorage
+	inflight  sync.WaitGroup
+	journal   *journal
+
+	// bl serializes writes of backup file by backupEvery, Erase and Close
+	bl sync.Mutex
+	// done stops backupEvery goroutine, it closes stopped on exit
+	done    chan struct{}
+	stopped chan struct{}
 
 	backupFolder       string
+	keepBackups        int
 	sharedLockDuration time.Duration
 }
 
+//SetHistoryStorage enables outbox for ModifySpentTimeFunc: histories it adds to entities.Outbox
+//are saved to h after SpentTime is modified, see deliver. It should be called before other methods
+func (s *SpentTimeInMemory) SetHistoryStorage(h HistoryStorage) {
+	s.l.Lock()
+	s.histories = h
+	s.l.Unlock()
+}
+
 //NewSpentTime save st into in-memory storage. SpentTime in storage unique by UserID.
 //Only single instance per user could be stored in in-memory storage
 func (s *SpentTimeInMemory) NewSpentTime(_ context.Context, st entities.SpentTime) error {
-	s.save(st)
-	return nil
+	unlock, err := s.lockUser(st.UserID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	sh := s.shard(st.UserID)
+	sh.Lock()
+	e := journalEntry{
+		UserID:    st.UserID,
+		SpentTime: &st,
+		Pending:   sh.pending[st.UserID],
+	}
+	n, err := s.log(e)
+	if err != nil {
+		sh.Unlock()
+		return err
+	}
+	sh.apply(e)
+	sh.Unlock()
+	return s.sync(n)
 }
 
 //Modify get SpentTime from in-memory storage and pass it to f.
-//If f returns not nil SpentTime it saves it back to in-memory storage else it removes it from storage
-func (s *SpentTimeInMemory) Modify(_ context.Context, userID ctxtg.UserID, f entities.ModifySpentTimeFunc) error {
-	s.l.Lock()
-	defer s.l.Unlock()
-	var err error
+//If f returns not nil SpentTime it saves it back to in-memory storage else it removes it from storage.
+//Result of f is kept even if it can't be written to journal because f may already have side effects.
+//Only user's SpentTime is locked while f is running. If HistoryStorage is set, histories added by f
+//to outbox are journaled together with result of f and delivered afterwards, histories of f
+//which returned error are dropped. Pending histories of user are delivered before f is called
+func (s *SpentTimeInMemory) Modify(ctx context.Context, userID ctxtg.UserID, f entities.ModifySpentTimeFunc) error {
+	unlock, err := s.lockUser(userID)
+	if err != nil {
+		observeModify(nil, err, nil)
+		return err
+	}
+	defer unlock()
+	if err := s.deliver(ctx, userID); err != nil {
+		observeModify(nil, err, nil)
+		return err
+	}
+	sh := s.shard(userID)
+	sh.Lock()
+	st, ok := sh.spentTime[userID]
+	sh.Unlock()
+	s.l.RLock()
+	histories := s.histories
+	s.l.RUnlock()
+
+	var outbox *entities.Outbox
+	if histories != nil {
+		outbox = &entities.Outbox{}
+		ctx = entities.WithOutbox(ctx, outbox)
+	}
 	var spentTime *entities.SpentTime
-	if st, ok := s.spentTime[userID]; ok {
-		spentTime, err = f(&st)
+	if ok {
+		// f may change given SpentTime, st is kept to restore it
+		cur := st
+		spentTime, err = f(ctx, &cur)
 	} else {
-		spentTime, err = f(nil)
+		spentTime, err = f(ctx, nil)
 	}
-	if spentTime == nil {
-		delete(s.spentTime, userID)
-	} else {
-		s.spentTime[userID] = *spentTime
+	var p *pending
+	if err == nil && outbox != nil && len(outbox.Histories) > 0 {
+		p = &pending{Histories: outbox.Histories}
+		if ok {
+			p.SpentTime = &st
+		}
+	}
+	sh.Lock()
+	n := s.set(sh, userID, spentTime, p)
+	sh.Unlock()
+	s.syncSet(n, userID)
+	if err != nil {
+		observeModify(spentTime, err, nil)
+		return err
 	}
+	err = s.deliver(ctx, userID)
+	observeModify(spentTime, nil, err)
 	return err
 }
 
-func (s *SpentTimeInMemory) save(st entities.SpentTime) {
+//SpentTime returns copy of user's SpentTime or nil if user hasn't active planning
+func (s *SpentTimeInMemory) SpentTime(_ context.Context, userID ctxtg.UserID) (*entities.SpentTime, error) {
+	sh := s.shard(userID)
+	sh.Lock()
+	defer sh.Unlock()
+	return sh.current(userID), nil
+}
+
+//UserIDs returns users with active planning or pending histories
+func (s *SpentTimeInMemory) UserIDs(_ context.Context) ([]ctxtg.UserID, error) {
+	var uids []ctxtg.UserID
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.Lock()
+		for uid := range sh.spentTime {
+			uids = append(uids, uid)
+		}
+		for uid := range sh.pending {
+			if _, ok := sh.spentTime[uid]; !ok {
+				uids = append(uids, uid)
+			}
+		}
+		sh.Unlock()
+	}
+	return uids, nil
+}
+
+//Close waits for running modifications, stops periodic backup, writes final backup and closes journal.
+//NewSpentTime and Modify return entities.ErrMaintenance after Close
+func (s *SpentTimeInMemory) Close() error {
 	s.l.Lock()
-	s.spentTime[st.UserID] = st
+	if s.closed {
+		s.l.Unlock()
+		return nil
+	}
+	s.closed = true
 	s.l.Unlock()
+	s.inflight.Wait()
+	if s.done != nil {
+		close(s.done)
+		<-s.stopped
+	}
+	err := s.withSharedLock(s.backup)
+	if s.journal != nil {
+		if closeErr := s.journal.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+//Backup writes backup immediately instead of waiting for next periodic backup
+func (s *SpentTimeInMemory) Backup(_ context.Context) error {
+	s.l.RLock()
+	closed := s.closed
+	s.l.RUnlock()
+	if closed {
+		return errors.Wrap(entities.ErrMaintenance, "spent time cache is closed")
+	}
+	return s.withSharedLock(s.backup)
+}
+
+//Erase removes user's SpentTime and pending histories from in-memory storage and rewrites backup
+//immediately, so neither backup file nor temp file contains user afterwards. Retained backups
+//are removed because they may contain user
+func (s *SpentTimeInMemory) Erase(_ context.Context, userID ctxtg.UserID) error {
+	unlock, err := s.lockUser(userID)
+	if err != nil {
+		return err
+	}
+	sh := s.shard(userID)
+	sh.Lock()
+	e := journalEntry{UserID: userID}
+	sh.apply(e)
+	n, err := s.log(e)
+	sh.Unlock()
+	if err == nil {
+		err = s.sync(n)
+	}
+	unlock()
+	if err != nil {
+		return err
+	}
+	return s.withSharedLock(func() error {
+		if err := s.backup(); err != nil {
+			return err
+		}
+		return removeRetainedBackups(s.backupFilePath(), s.keepBackups)
+	})
+}
+
+// lockUser waits until SpentTime of user isn't modified by others and locks it,
+// returned func unlocks it. Close waits until all users are unlocked
+func (s *SpentTimeInMemory) lockUser(userID ctxtg.UserID) (func(), error) {
+	s.l.RLock()
+	if s.closed {
+		s.l.RUnlock()
+		return nil, errors.Wrap(entities.ErrMaintenance, "spent time cache is closed")
+	}
+	s.inflight.Add(1)
+	s.l.RUnlock()
+	sh := s.shard(userID)
+	sh.Lock()
+	if sh.users == nil {
+		sh.users = make(map[ctxtg.UserID]*userLock)
+	}
+	ul := sh.users[userID]
+	if ul == nil {
+		ul = &userLock{}
+		sh.users[userID] = ul
+	}
+	ul.refs++
+	sh.Unlock()
+	ul.Lock()
+	return func() {
+		ul.Unlock()
+		sh.Lock()
+		ul.refs--
+		if ul.refs == 0 {
+			delete(sh.users, userID)
+		}
+		sh.Unlock()
+		s.inflight.Done()
+	}, nil
+}
+
+// deliver saves pending histories of user in order they were added, user must be locked.
+// History saved already is skipped, so it's safe to deliver it again after unknown result.
+// If history is rejected by HistoryStorage flushed SpentTime is restored, unless user has
+// started new one, and error is returned. On other errors delivery is retried by next Modify
+// of user or by periodic backup
+func (s *SpentTimeInMemory) deliver(ctx context.Context, userID ctxtg.UserID) error {
+	s.l.RLock()
+	histories := s.histories
+	s.l.RUnlock()
+	sh := s.shard(userID)
+	sh.Lock()
+	p := sh.pending[userID]
+	sh.Unlock()
+	if p == nil || histories == nil {
+		return nil
+	}
+	for len(p.Histories) > 0 {
+		err := histories.AddSpentTime(ctx, p.Histories[0])
+		if isRejected(err) {
+			sh.Lock()
+			var n int64
+			st, ok := sh.spentTime[userID]
+			if ok {
+				log.ERR("Flushed spent time of user %d isn't restored because of new one %+v", userID, st)
+				n = s.set(sh, userID, &st, nil)
+			} else {
+				n = s.set(sh, userID, p.SpentTime, nil)
+			}
+			sh.Unlock()
+			s.syncSet(n, userID)
+			return err
+		}
+		if err != nil && errors.Cause(err) != entities.ErrDuplicateHistory {
+			return errors.Wrap(err, "failed to save spent time history, it will be retried")
+		}
+		p = &pending{SpentTime: p.SpentTime, Histories: p.Histories[1:]}
+		sh.Lock()
+		if len(p.Histories) == 0 {
+			p = nil
+		}
+		n := s.set(sh, userID, sh.current(userID), p)
+		sh.Unlock()
+		s.syncSet(n, userID)
+		if p == nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// deliverPending retries delivery of pending histories of all users
+func (s *SpentTimeInMemory) deliverPending(ctx context.Context) {
+	var uids []ctxtg.UserID
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.Lock()
+		for uid := range sh.pending {
+			uids = append(uids, uid)
+		}
+		sh.Unlock()
+	}
+	for _, uid := range uids {
+		unlock, err := s.lockUser(uid)
+		if err != nil {
+			return
+		}
+		if err := s.deliver(ctx, uid); err != nil {
+			log.ERR("Failed to deliver spent time history of user %d: %+v", uid, err)
+		}
+		unlock()
+	}
+}
+
+// shard returns part of cache which keeps user
+func (s *SpentTimeInMemory) shard(userID ctxtg.UserID) *shard {
+	return &s.shards[uint64(userID)%shardCount]
+}
+
+// set replaces state of user and writes it to journal, sh must be locked shard of user.
+// State is kept even if it can't be written to journal. Returned entry should be synced
+// by syncSet after shard is unlocked
+func (s *SpentTimeInMemory) set(sh *shard, userID ctxtg.UserID, st *entities.SpentTime, p *pending) int64 {
+	e := journalEntry{
+		UserID:    userID,
+		SpentTime: st,
+		Pending:   p,
+	}
+	sh.apply(e)
+	n, err := s.log(e)
+	if err != nil {
+		log.ERR("Failed to journal spent time of user %d: %+v", userID, err)
+	}
+	return n
+}
+
+// syncSet waits until entry n written by set is flushed to disk
+func (s *SpentTimeInMemory) syncSet(n int64, userID ctxtg.UserID) {
+	if err := s.sync(n); err != nil {
+		log.ERR("Failed to journal spent time of user %d: %+v", userID, err)
+	}
+}
+
+// log writes state of user to journal and returns its number for sync, shard of user must be
+// locked to keep order of entries. It doesn't wait for disk, so other users of shard aren't
+// blocked by it
+func (s *SpentTimeInMemory) log(e journalEntry) (int64, error) {
+	if s.journal == nil {
+		return 0, nil
+	}
+	return s.journal.append(e)
+}
+
+// sync waits until journal entry n is flushed to disk together with entries of other users
+func (s *SpentTimeInMemory) sync(n int64) error {
+	if s.journal == nil || n == 0 {
+		return nil
+	}
+	return s.journal.sync(n)
+}
+
+// isRejected reports if HistoryStorage will never save history
+func isRejected(err error) bool {
+	switch errors.Cause(err) {
+	case entities.ErrInvalidPlanningID, entities.ErrPeriodLocked:
+		return true
+	}
+	return false
+}
+
+// shard is part of cache, its lock is held only to access maps
+type shard struct {
+	sync.Mutex
+	spentTime map[ctxtg.UserID]entities.SpentTime
+	// pending are flushes waiting for delivery to histories
+	pending map[ctxtg.UserID]*pending
+	// users are locked while their SpentTime is modified
+	users map[ctxtg.UserID]*userLock
+}
+
+// current returns copy of user's SpentTime or nil, sh must be locked
+func (sh *shard) current(userID ctxtg.UserID) *entities.SpentTime {
+	st, ok := sh.spentTime[userID]
+	if !ok {
+		return nil
+	}
+	return &st
+}
+
+// apply sets state of user from e, sh must be locked
+func (sh *shard) apply(e journalEntry) {
+	if e.SpentTime == nil {
+		delete(sh.spentTime, e.UserID)
+	} else {
+		if sh.spentTime == nil {
+			sh.spentTime = make(map[ctxtg.UserID]entities.SpentTime)
+		}
+		sh.spentTime[e.UserID] = *e.SpentTime
+	}
+	if e.Pending == nil {
+		delete(sh.pending, e.UserID)
+	} else {
+		if sh.pending == nil {
+			sh.pending = make(map[ctxtg.UserID]*pending)
+		}
+		sh.pending[e.UserID] = e.Pending
+	}
+}
+
+// userLock is lock of user's SpentTime, refs counts its holders and waiters
+type userLock struct {
+	sync.Mutex
+	refs int
 }
 
 func (s *SpentTimeInMemory) backupEvery(t time.Duration) {
+	s.done = make(chan struct{})
+	s.stopped = make(chan struct{})
 	go func() {
+		defer close(s.stopped)
 		for {
-			<-time.After(t)
+			select {
+			case <-time.After(t):
+			case <-s.done:
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), t)
+			s.deliverPending(ctx)
+			cancel()
 			if err := s.withSharedLock(s.backup); err != nil {
 				log.ERR("Failed to backup %+v", err)
 			}
@@ -93,41 +516,131 @@ func (s *SpentTimeInMemory) backupEvery(t time.Duration) {
 	}()
 }
 
+// restore loads backup and replays journal over it, journal is replayed even if there
+// is no backup but it isn't replayed over backup which can't be loaded
 func (s *SpentTimeInMemory) restore() error {
-	var b []byte
-	b, err := ioutil.ReadFile(s.backupFilePath())
-	if err != nil {
-		log.ERR("Failed to read %s", s.backupFilePath())
-		b, err = ioutil.ReadFile(s.tempBackupFilePath())
-		if err != nil {
-			log.ERR("Failed to read %s", s.tempBackupFilePath())
-			return err
+	backupErr := s.restoreBackup()
+	if backupErr != nil {
+		return backupErr
+	}
+	err := replayJournal(s.backupFolder, func(e journalEntry) {
+		sh := s.shard(e.UserID)
+		sh.Lock()
+		sh.apply(e)
+		sh.Unlock()
+	})
+	return errors.Wrap(err, "failed to replay journal")
+}
+
+// restoreBackup loads backup file or temp file if backup file doesn't exist,
+// it isn't error if neither exists
+func (s *SpentTimeInMemory) restoreBackup() error {
+	path := s.backupFilePath()
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		path = s.tempBackupFilePath()
+		b, err = ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			log.NOTICE("There is no backup in %s", s.backupFolder)
+			restoresTotal.WithLabelValues(resultMissing).Inc()
+			return nil
 		}
 	}
-	var sts []entities.SpentTime
-	err = json.Unmarshal(b, &sts)
 	if err != nil {
-		return err
+		restoresTotal.WithLabelValues(resultFailed).Inc()
+		return errors.Wrapf(err, "failed to read %s", path)
+	}
+	sts, err := decodeBackup(b)
+	if err != nil {
+		result := resultFailed
+		if errors.Cause(err) == errCorruptedBackup {
+			result = resultCorrupted
+		}
+		restoresTotal.WithLabelValues(result).Inc()
+		return errors.Wrapf(err, "failed to load %s", path)
 	}
 	s.fromSlice(sts)
+	restoresTotal.WithLabelValues(resultOK).Inc()
+	restoredTimers.Set(float64(len(sts)))
 	return nil
 }
 
+// backup writes all SpentTime to backup file and removes journal segments included in it
 func (s *SpentTimeInMemory) backup() error {
-	b, err := json.Marshal(s.toSlice())
+	s.bl.Lock()
+	defer s.bl.Unlock()
+	start := time.Now()
+	size, err := s.writeBackup(start)
+	observeBackup(start, size, err)
+	return err
+}
+
+// writeBackup returns size of written backup, s.bl must be held
+func (s *SpentTimeInMemory) writeBackup(now time.Time) (int, error) {
+	sts, seq, err := s.snapshot()
 	if err != nil {
-		return errors.Wrap(err, "failed to marshal data")
+		return 0, err
+	}
+	observeTimers(sts, now)
+	b, err := encodeBackup(sts, now)
+	if err != nil {
+		return 0, err
 	}
 	tempFilePath := s.tempBackupFilePath()
-	err = ioutil.WriteFile(tempFilePath, b, 0644)
+	err = writeFileSync(tempFilePath, b)
 	if err != nil {
-		return errors.Wrap(err, "failed to write to temp file")
+		return 0, errors.Wrap(err, "failed to write to temp file")
+	}
+	if err := retainBackup(s.backupFilePath(), s.keepBackups); err != nil {
+		return 0, err
 	}
 	err = os.Rename(tempFilePath, s.backupFilePath())
 	if err != nil {
-		return errors.Wrap(err, "failed to rename temp file")
+		return 0, errors.Wrap(err, "failed to rename temp file")
 	}
-	return nil
+	if s.journal == nil {
+		return len(b), nil
+	}
+	if err := syncDir(s.backupFolder); err != nil {
+		return 0, errors.Wrap(err, "failed to sync backup folder")
+	}
+	return len(b), s.journal.removeBefore(seq)
+}
+
+// snapshot starts new journal segment and then copies shards one by one, so every change
+// missing in snapshot is written to new segment and only one shard is locked at a time.
+// Backup doesn't include pending histories, they are written to new segment instead
+func (s *SpentTimeInMemory) snapshot() ([]entities.SpentTime, int64, error) {
+	var seq int64
+	if s.journal != nil {
+		var err error
+		seq, err = s.journal.rotate()
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	var sts []entities.SpentTime
+	var last int64
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.Lock()
+		for uid, p := range sh.pending {
+			e := journalEntry{
+				UserID:    uid,
+				SpentTime: sh.current(uid),
+				Pending:   p,
+			}
+			n, err := s.log(e)
+			if err != nil {
+				sh.Unlock()
+				return nil, 0, err
+			}
+			last = n
+		}
+		sts = sh.appendTo(sts)
+		sh.Unlock()
+	}
+	return sts, seq, s.sync(last)
 }
 
 func (s *SpentTimeInMemory) backupFilePath() string {
@@ -139,21 +652,25 @@ func (s *SpentTimeInMemory) tempBackupFilePath() string {
 }
 
 func (s *SpentTimeInMemory) fromSlice(sts []entities.SpentTime) {
-	s.l.Lock()
 	for _, st := range sts {
-		s.spentTime[st.UserID] = st
+		st := st
+		sh := s.shard(st.UserID)
+		sh.Lock()
+		sh.apply(journalEntry{
+			UserID:    st.UserID,
+			SpentTime: &st,
+			Pending:   sh.pending[st.UserID],
+		})
+		sh.Unlock()
 	}
-	s.l.Unlock()
 }
 
-func (s *SpentTimeInMemory) toSlice() []entities.SpentTime {
-	var sts []entities.SpentTime
-	s.l.Lock()
-	for id, st := range s.spentTime {
+// appendTo appends SpentTime of shard to sts, sh must be locked
+func (sh *shard) appendTo(sts []entities.SpentTime) []entities.SpentTime {
+	for id, st := range sh.spentTime {
 		st.UserID = id
 		sts = append(sts, st)
 	}
-	s.l.Unlock()
 	return sts
 }
 
@@ -165,3 +682,28 @@ func (s *SpentTimeInMemory) withSharedLock(f func() error) error {
 	defer l.UnLock()
 	return f()
 }
+
+func writeFileSync(path string, b []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -1,15 +1,20 @@
 package cache
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
+	pkgerrors "github.com/pkg/errors"
 	"github.com/powerman/narada-go/narada/staging"
 	"github.com/qarea/ctxtg"
 	"github.com/qarea/planningms/entities"
@@ -29,22 +34,19 @@ func TestLoadFromDump(t *testing.T) {
 	s2 := randSpentTime()
 	storage1 := SpentTimeInMemory{}
 	storage1.backupFolder = dir
-	storage1.spentTime = make(map[ctxtg.UserID]entities.SpentTime)
-	storage1.spentTime[s1.UserID] = s1
-	storage1.spentTime[s2.UserID] = s2
+	storage1.fromSlice([]entities.SpentTime{s1, s2})
 	err := storage1.backup()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	storage2 := NewSpentTimeInMemory(10*time.Hour, dir, 1*time.Second)
+	storage2 := newSpentTimeInMemory(t, dir)
 	storage2.backupFolder = dir
-	storage2.spentTime = make(map[ctxtg.UserID]entities.SpentTime)
 	err = storage2.restore()
 	if err != nil {
 		t.Error(err)
 	}
-	if !reflect.DeepEqual(storage1.spentTime, storage2.spentTime) {
+	if !reflect.DeepEqual(spentTimeOf(&storage1), spentTimeOf(storage2)) {
 		t.Error("Invalid backup loaded")
 	}
 }
@@ -52,11 +54,11 @@ func TestLoadFromDump(t *testing.T) {
 func TestSuccessfullyLoadWithOutDump(t *testing.T) {
 	dir, clean := tempDir(t)
 	defer clean()
-	storage := NewSpentTimeInMemory(10*time.Hour, dir, 1*time.Second)
+	storage := newSpentTimeInMemory(t, dir)
 	if storage == nil {
 		t.Error("Should be ok")
 	}
-	if len(storage.spentTime) > 0 {
+	if len(spentTimeOf(storage)) > 0 {
 		t.Error("Should be empty")
 	}
 }
@@ -68,9 +70,7 @@ func TestLoadFromDumpLoadFromTmpIfNotExist(t *testing.T) {
 	s2 := randSpentTime()
 	storage1 := SpentTimeInMemory{}
 	storage1.backupFolder = dir
-	storage1.spentTime = make(map[ctxtg.UserID]entities.SpentTime)
-	storage1.spentTime[s1.UserID] = s1
-	storage1.spentTime[s2.UserID] = s2
+	storage1.fromSlice([]entities.SpentTime{s1, s2})
 	err := storage1.backup()
 	if err != nil {
 		t.Fatal(err)
@@ -81,34 +81,31 @@ func TestLoadFromDumpLoadFromTmpIfNotExist(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	storage2 := NewSpentTimeInMemory(10*time.Hour, dir, 1*time.Second)
+	storage2 := newSpentTimeInMemory(t, dir)
 	storage2.backupFolder = dir
-	storage2.spentTime = make(map[ctxtg.UserID]entities.SpentTime)
 	err = storage2.restore()
 	if err != nil {
 		t.Error(err)
 	}
-	if !reflect.DeepEqual(storage1.spentTime, storage2.spentTime) {
+	if !reflect.DeepEqual(spentTimeOf(&storage1), spentTimeOf(storage2)) {
 		t.Error("Invalid backup loaded")
 	}
 }
 
 func TestNewSpentTime(t *testing.T) {
 	s := SpentTimeInMemory{}
-	s.spentTime = make(map[ctxtg.UserID]entities.SpentTime)
 	st := randSpentTime()
 	err := s.NewSpentTime(ctx, st)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if s.spentTime[st.UserID] != st {
+	if spentTimeOf(&s)[st.UserID] != st {
 		t.Error("Invalid spent time")
 	}
 }
 
 func TestNewSpentTimeRewrite(t *testing.T) {
 	s := SpentTimeInMemory{}
-	s.spentTime = make(map[ctxtg.UserID]entities.SpentTime)
 	st := entities.SpentTime{
 		UserID:     ctxtg.UserID(rand.Int63()),
 		PlanningID: entities.PlanningID(rand.Int63()),
@@ -121,20 +118,19 @@ func TestNewSpentTimeRewrite(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if s.spentTime[st.UserID] != st {
+	if spentTimeOf(&s)[st.UserID] != st {
 		t.Error("Invalid spent time")
 	}
 }
 
 func TestModifyInvalidUserID(t *testing.T) {
 	s := SpentTimeInMemory{}
-	s.spentTime = make(map[ctxtg.UserID]entities.SpentTime)
 	st := randSpentTime()
 	err := s.NewSpentTime(ctx, st)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = s.Modify(ctx, st.UserID/2, func(st1 *entities.SpentTime) (*entities.SpentTime, error) {
+	err = s.Modify(ctx, st.UserID/2, func(_ context.Context, st1 *entities.SpentTime) (*entities.SpentTime, error) {
 		if st1 != nil {
 			t.Error("Should be empty")
 		}
@@ -147,33 +143,31 @@ func TestModifyInvalidUserID(t *testing.T) {
 
 func TestModifyDeleteFromStorage(t *testing.T) {
 	s := SpentTimeInMemory{}
-	s.spentTime = make(map[ctxtg.UserID]entities.SpentTime)
 	st := randSpentTime()
 	err := s.NewSpentTime(ctx, st)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = s.Modify(ctx, st.UserID, func(st1 *entities.SpentTime) (*entities.SpentTime, error) {
+	err = s.Modify(ctx, st.UserID, func(_ context.Context, st1 *entities.SpentTime) (*entities.SpentTime, error) {
 		return nil, nil
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if _, ok := s.spentTime[st.UserID]; ok {
+	if _, ok := spentTimeOf(&s)[st.UserID]; ok {
 		t.Error("Should be empty")
 	}
 }
 
 func TestModifyInvalidUserIDWithErr(t *testing.T) {
 	s := SpentTimeInMemory{}
-	s.spentTime = make(map[ctxtg.UserID]entities.SpentTime)
 	st := randSpentTime()
 	err := s.NewSpentTime(ctx, st)
 	if err != nil {
 		t.Fatal(err)
 	}
 	testErr := errors.New("test err")
-	err = s.Modify(ctx, st.UserID/2, func(st1 *entities.SpentTime) (*entities.SpentTime, error) {
+	err = s.Modify(ctx, st.UserID/2, func(_ context.Context, st1 *entities.SpentTime) (*entities.SpentTime, error) {
 		if st1 != nil {
 			t.Error("Should be empty")
 		}
@@ -186,43 +180,40 @@ func TestModifyInvalidUserIDWithErr(t *testing.T) {
 
 func TestModifyDeleteFromStorageWithErr(t *testing.T) {
 	s := SpentTimeInMemory{}
-	s.spentTime = make(map[ctxtg.UserID]entities.SpentTime)
 	st := randSpentTime()
 	err := s.NewSpentTime(ctx, st)
 	if err != nil {
 		t.Fatal(err)
 	}
 	testErr := errors.New("test err")
-	err = s.Modify(ctx, st.UserID, func(st1 *entities.SpentTime) (*entities.SpentTime, error) {
+	err = s.Modify(ctx, st.UserID, func(_ context.Context, st1 *entities.SpentTime) (*entities.SpentTime, error) {
 		return nil, testErr
 	})
 	if err != testErr {
 		t.Fatal(err)
 	}
-	if _, ok := s.spentTime[st.UserID]; ok {
+	if _, ok := spentTimeOf(&s)[st.UserID]; ok {
 		t.Error("Should be empty")
 	}
 }
 
 func TestModifyAddToStorageWithErr(t *testing.T) {
 	s := SpentTimeInMemory{}
-	s.spentTime = make(map[ctxtg.UserID]entities.SpentTime)
 	st := randSpentTime()
 	testErr := errors.New("test err")
-	err := s.Modify(ctx, st.UserID, func(st1 *entities.SpentTime) (*entities.SpentTime, error) {
+	err := s.Modify(ctx, st.UserID, func(_ context.Context, st1 *entities.SpentTime) (*entities.SpentTime, error) {
 		return &st, testErr
 	})
 	if err != testErr {
 		t.Fatal(err)
 	}
-	if s.spentTime[st.UserID] != st {
+	if spentTimeOf(&s)[st.UserID] != st {
 		t.Error("Should be empty")
 	}
 }
 
 func TestModifyStorageWithErr(t *testing.T) {
 	s := SpentTimeInMemory{}
-	s.spentTime = make(map[ctxtg.UserID]entities.SpentTime)
 	st := randSpentTime()
 	newLast := st.Last / 2
 	err := s.NewSpentTime(ctx, st)
@@ -230,39 +221,278 @@ func TestModifyStorageWithErr(t *testing.T) {
 		t.Fatal(err)
 	}
 	testErr := errors.New("test err")
-	err = s.Modify(ctx, st.UserID, func(st1 *entities.SpentTime) (*entities.SpentTime, error) {
+	err = s.Modify(ctx, st.UserID, func(_ context.Context, st1 *entities.SpentTime) (*entities.SpentTime, error) {
 		st1.Last = newLast
 		return st1, testErr
 	})
 	if err != testErr {
 		t.Fatal(err)
 	}
-	if s.spentTime[st.UserID].Last != newLast {
+	if spentTimeOf(&s)[st.UserID].Last != newLast {
 		t.Error("Should be empty")
 	}
 }
 
 func TestModifyStorage(t *testing.T) {
 	s := SpentTimeInMemory{}
-	s.spentTime = make(map[ctxtg.UserID]entities.SpentTime)
 	st := randSpentTime()
 	newLast := st.Last / 2
 	err := s.NewSpentTime(ctx, st)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = s.Modify(ctx, st.UserID, func(st1 *entities.SpentTime) (*entities.SpentTime, error) {
+	err = s.Modify(ctx, st.UserID, func(_ context.Context, st1 *entities.SpentTime) (*entities.SpentTime, error) {
 		st1.Last = newLast
 		return st1, nil
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if s.spentTime[st.UserID].Last != newLast {
+	if spentTimeOf(&s)[st.UserID].Last != newLast {
 		t.Error("Should be empty")
 	}
 }
 
+func TestSpentTime(t *testing.T) {
+	s := SpentTimeInMemory{}
+	st := randSpentTime()
+	err := s.NewSpentTime(ctx, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st1, err := s.SpentTime(ctx, st.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st1 == nil || *st1 != st {
+		t.Error("Invalid spent time", st1)
+	}
+	st1, err = s.SpentTime(ctx, st.UserID/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st1 != nil {
+		t.Error("Should be empty")
+	}
+}
+
+func TestErase(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	s1 := randSpentTime()
+	s2 := randSpentTime()
+	storage1 := newSpentTimeInMemory(t, dir)
+	storage1.fromSlice([]entities.SpentTime{s1, s2})
+	err := storage1.backup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(storage1.tempBackupFilePath(), []byte("[]"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage1.Erase(ctx, s1.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := spentTimeOf(storage1)[s1.UserID]; ok {
+		t.Error("Should be erased")
+	}
+	if _, err := os.Stat(storage1.tempBackupFilePath()); !os.IsNotExist(err) {
+		t.Error("Temp file should be replaced", err)
+	}
+	if _, err := os.Stat(retainedBackupPath(storage1.backupFilePath(), 1)); !os.IsNotExist(err) {
+		t.Error("Retained backups should be removed", err)
+	}
+
+	storage2 := newSpentTimeInMemory(t, dir)
+	if !reflect.DeepEqual(spentTimeOf(storage2), map[ctxtg.UserID]entities.SpentTime{s2.UserID: s2}) {
+		t.Error("Invalid backup loaded", spentTimeOf(storage2))
+	}
+}
+
+func TestRestoreFromJournal(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	s1 := randSpentTime()
+	s2 := randSpentTime()
+	s3 := randSpentTime()
+	storage1 := newSpentTimeInMemory(t, dir)
+	for _, st := range []entities.SpentTime{s1, s2, s3} {
+		if err := storage1.NewSpentTime(ctx, st); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage1.backup(); err != nil {
+		t.Fatal(err)
+	}
+	s1.Last /= 2
+	err := storage1.Modify(ctx, s1.UserID, func(_ context.Context, st *entities.SpentTime) (*entities.SpentTime, error) {
+		st.Last = s1.Last
+		return st, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage1.Modify(ctx, s2.UserID, func(_ context.Context, st *entities.SpentTime) (*entities.SpentTime, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage2 := newSpentTimeInMemory(t, dir)
+	expected := map[ctxtg.UserID]entities.SpentTime{s1.UserID: s1, s3.UserID: s3}
+	if !reflect.DeepEqual(spentTimeOf(storage2), expected) {
+		t.Error("Invalid journal replayed", spentTimeOf(storage2))
+	}
+}
+
+func TestRestoreFromJournalPartialEntry(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	s1 := randSpentTime()
+	s2 := randSpentTime()
+	storage1 := newSpentTimeInMemory(t, dir)
+	if err := storage1.NewSpentTime(ctx, s1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage1.journal.f.Write([]byte(`{"UserID":1,"Spent`)); err != nil {
+		t.Fatal(err)
+	}
+
+	storage2 := newSpentTimeInMemory(t, dir)
+	if err := storage2.NewSpentTime(ctx, s2); err != nil {
+		t.Fatal(err)
+	}
+	storage3 := newSpentTimeInMemory(t, dir)
+	expected := map[ctxtg.UserID]entities.SpentTime{s1.UserID: s1, s2.UserID: s2}
+	if !reflect.DeepEqual(spentTimeOf(storage3), expected) {
+		t.Error("Invalid journal replayed", spentTimeOf(storage3))
+	}
+}
+
+func TestRestoreFailedKeepsJournal(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	s1 := randSpentTime()
+	s2 := randSpentTime()
+	storage1 := newSpentTimeInMemory(t, dir)
+	if err := storage1.NewSpentTime(ctx, s1); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage1.backup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage1.NewSpentTime(ctx, s2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Backup which is directory can't be read
+	path := filepath.Join(dir, backupFileName)
+	if err := os.Rename(path, path+".saved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewSpentTimeInMemory(SpentTimeInMemoryCfg{
+		Folder:     dir,
+		Frequency:  10 * time.Hour,
+		SharedLock: 1 * time.Second,
+	})
+	if err == nil {
+		t.Fatal("should fail to start without restored backup")
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path+".saved", path); err != nil {
+		t.Fatal(err)
+	}
+
+	storage2 := newSpentTimeInMemory(t, dir)
+	expected := map[ctxtg.UserID]entities.SpentTime{s1.UserID: s1, s2.UserID: s2}
+	if !reflect.DeepEqual(spentTimeOf(storage2), expected) {
+		t.Error("Journal should be kept", spentTimeOf(storage2))
+	}
+}
+
+func TestBackupCompactsJournal(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	storage := newSpentTimeInMemory(t, dir)
+	for i := 0; i < 10; i++ {
+		if err := storage.NewSpentTime(ctx, randSpentTime()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.backup(); err != nil {
+		t.Fatal(err)
+	}
+	seqs, err := journalSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 1 || seqs[0] != storage.journal.seq {
+		t.Error("Only current journal segment should be kept", seqs)
+	}
+	fi, err := os.Stat(storage.journal.segmentPath(storage.journal.seq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 0 {
+		t.Error("Current journal segment should be empty")
+	}
+}
+
+func TestJournalGroupSync(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	j, err := openJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.close()
+	const n = 100
+	var wg sync.WaitGroup
+	errs := make(chan error, n+1)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i == n/2 {
+				_, err := j.rotate()
+				errs <- err
+			}
+			st := randSpentTime()
+			seq, err := j.append(journalEntry{UserID: st.UserID, SpentTime: &st})
+			if err == nil {
+				err = j.sync(seq)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if j.synced != n || j.written != n {
+		t.Errorf("All entries should be synced, written %d, synced %d", j.written, j.synced)
+	}
+	var replayed int
+	if err := replayJournal(dir, func(journalEntry) { replayed++ }); err != nil {
+		t.Fatal(err)
+	}
+	if replayed != n {
+		t.Errorf("Expected %d entries, replayed %d", n, replayed)
+	}
+}
+
 func randSpentTime() entities.SpentTime {
 	return entities.SpentTime{
 		UserID:     ctxtg.UserID(rand.Int63()),
@@ -272,10 +502,607 @@ func randSpentTime() entities.SpentTime {
 	}
 }
 
-func tempDir(t *testing.T) (string, func()) {
+func newSpentTimeInMemory(t *testing.T, dir string) *SpentTimeInMemory {
+	s, err := NewSpentTimeInMemory(SpentTimeInMemoryCfg{
+		Folder:     dir,
+		Frequency:  10 * time.Hour,
+		Keep:       2,
+		SharedLock: 1 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// spentTimeOf returns SpentTime of all shards of s
+func spentTimeOf(s *SpentTimeInMemory) map[ctxtg.UserID]entities.SpentTime {
+	sts := make(map[ctxtg.UserID]entities.SpentTime)
+	for i := range s.shards {
+		s.shards[i].Lock()
+		for uid, st := range s.shards[i].spentTime {
+			sts[uid] = st
+		}
+		s.shards[i].Unlock()
+	}
+	return sts
+}
+
+// pendingOf returns pending histories of all shards of s
+func pendingOf(s *SpentTimeInMemory) map[ctxtg.UserID]*pending {
+	ps := make(map[ctxtg.UserID]*pending)
+	for i := range s.shards {
+		s.shards[i].Lock()
+		for uid, p := range s.shards[i].pending {
+			ps[uid] = p
+		}
+		s.shards[i].Unlock()
+	}
+	return ps
+}
+
+func tempDir(t testing.TB) (string, func()) {
 	dir, err := ioutil.TempDir("", "planning-cache-test")
 	if err != nil {
 		t.Fatal(err)
 	}
 	return dir, func() { os.RemoveAll(dir) }
 }
+
+func TestClose(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	s1 := randSpentTime()
+	storage1 := newSpentTimeInMemory(t, dir)
+	if err := storage1.NewSpentTime(ctx, s1); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage1.Close(); err != nil {
+		t.Error("Close should be idempotent", err)
+	}
+	err := storage1.NewSpentTime(ctx, randSpentTime())
+	if pkgerrors.Cause(err) != entities.ErrMaintenance {
+		t.Error("Closed storage shouldn't be modified", err)
+	}
+	err = storage1.Modify(ctx, s1.UserID, func(_ context.Context, st *entities.SpentTime) (*entities.SpentTime, error) {
+		t.Error("Closed storage shouldn't be modified")
+		return nil, nil
+	})
+	if pkgerrors.Cause(err) != entities.ErrMaintenance {
+		t.Error("Closed storage shouldn't be modified", err)
+	}
+
+	b, err := ioutil.ReadFile(storage1.backupFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sts, err := decodeBackup(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sts) != 1 || sts[0] != s1 {
+		t.Error("Final backup expected", sts)
+	}
+}
+
+func TestUserIDs(t *testing.T) {
+	s := SpentTimeInMemory{}
+	st := randSpentTime()
+	if err := s.NewSpentTime(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	uids, err := s.UserIDs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(uids, []ctxtg.UserID{st.UserID}) {
+		t.Error("Invalid user ids", uids)
+	}
+}
+
+// testHistoryStorage saves histories unless err is set, errAfterSave is returned after history
+// is saved like if response of storage is lost
+type testHistoryStorage struct {
+	l            sync.Mutex
+	saved        []entities.SpentTimeHistory
+	err          error
+	errAfterSave error
+}
+
+func (h *testHistoryStorage) AddSpentTime(_ context.Context, hist entities.SpentTimeHistory) error {
+	h.l.Lock()
+	defer h.l.Unlock()
+	if h.err != nil {
+		return h.err
+	}
+	for _, saved := range h.saved {
+		if saved == hist {
+			return entities.ErrDuplicateHistory
+		}
+	}
+	h.saved = append(h.saved, hist)
+	return h.errAfterSave
+}
+
+func flushToOutbox(ctx context.Context, st *entities.SpentTime) (*entities.SpentTime, error) {
+	o := entities.OutboxFrom(ctx)
+	o.Histories = append(o.Histories, testHistory(*st))
+	return nil, nil
+}
+
+func testHistory(st entities.SpentTime) entities.SpentTimeHistory {
+	return entities.SpentTimeHistory{
+		PlanningID: st.PlanningID,
+		Spent:      st.SpentOnline,
+		StartedAt:  st.Started,
+		EndedAt:    st.Last,
+		Status:     entities.Online,
+	}
+}
+
+func TestModifyLocksOnlyUser(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	s := newSpentTimeInMemory(t, dir)
+	s1 := randSpentTime()
+	s2 := randSpentTime()
+	for _, st := range []entities.SpentTime{s1, s2} {
+		if err := s.NewSpentTime(ctx, st); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slow := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- s.Modify(ctx, s1.UserID, func(_ context.Context, st *entities.SpentTime) (*entities.SpentTime, error) {
+			<-slow
+			return st, nil
+		})
+	}()
+	err := s.Modify(ctx, s2.UserID, func(_ context.Context, st *entities.SpentTime) (*entities.SpentTime, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SpentTime(ctx, s1.UserID); err != nil {
+		t.Fatal(err)
+	}
+	close(slow)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModifyOutbox(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	h := &testHistoryStorage{}
+	s := newSpentTimeInMemory(t, dir)
+	s.SetHistoryStorage(h)
+	st := randSpentTime()
+	if err := s.NewSpentTime(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Modify(ctx, st.UserID, flushToOutbox); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h.saved, []entities.SpentTimeHistory{testHistory(st)}) {
+		t.Error("History should be saved", h.saved)
+	}
+	if len(spentTimeOf(s)) != 0 || len(pendingOf(s)) != 0 {
+		t.Error("Flushed spent time should be removed", spentTimeOf(s), pendingOf(s))
+	}
+}
+
+func TestModifyOutboxRetried(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	h := &testHistoryStorage{err: errors.New("storage is down")}
+	s1 := newSpentTimeInMemory(t, dir)
+	s1.SetHistoryStorage(h)
+	st := randSpentTime()
+	if err := s1.NewSpentTime(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if err := s1.Modify(ctx, st.UserID, flushToOutbox); pkgerrors.Cause(err) != h.err {
+		t.Error("Storage error expected", err)
+	}
+	if len(spentTimeOf(s1)) != 0 || len(pendingOf(s1)) != 1 {
+		t.Error("Spent time should be flushed to outbox", spentTimeOf(s1), pendingOf(s1))
+	}
+	if err := s1.backup(); err != nil {
+		t.Fatal(err)
+	}
+
+	h.err = nil
+	s2 := newSpentTimeInMemory(t, dir)
+	s2.SetHistoryStorage(h)
+	uids, err := s2.UserIDs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(uids, []ctxtg.UserID{st.UserID}) {
+		t.Error("User with pending history expected", uids)
+	}
+	err = s2.Modify(ctx, st.UserID, func(_ context.Context, cur *entities.SpentTime) (*entities.SpentTime, error) {
+		if cur != nil {
+			t.Error("Flushed spent time shouldn't be restored", cur)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h.saved, []entities.SpentTimeHistory{testHistory(st)}) {
+		t.Error("History should be saved once", h.saved)
+	}
+	if len(pendingOf(s2)) != 0 {
+		t.Error("Delivered history should be removed", pendingOf(s2))
+	}
+}
+
+func TestModifyOutboxRejected(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	h := &testHistoryStorage{err: entities.ErrPeriodLocked}
+	s := newSpentTimeInMemory(t, dir)
+	s.SetHistoryStorage(h)
+	st := randSpentTime()
+	if err := s.NewSpentTime(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Modify(ctx, st.UserID, flushToOutbox); pkgerrors.Cause(err) != entities.ErrPeriodLocked {
+		t.Error("Storage error expected", err)
+	}
+	if len(h.saved) != 0 || len(pendingOf(s)) != 0 {
+		t.Error("Rejected history should be dropped", h.saved, pendingOf(s))
+	}
+	if !reflect.DeepEqual(spentTimeOf(s), map[ctxtg.UserID]entities.SpentTime{st.UserID: st}) {
+		t.Error("Spent time should be restored", spentTimeOf(s))
+	}
+
+	s2 := newSpentTimeInMemory(t, dir)
+	if !reflect.DeepEqual(spentTimeOf(s2), spentTimeOf(s)) || len(pendingOf(s2)) != 0 {
+		t.Error("Restored spent time should be journaled", spentTimeOf(s2), pendingOf(s2))
+	}
+}
+
+func TestModifyOutboxLostResponse(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	h := &testHistoryStorage{errAfterSave: entities.ErrTimeout}
+	s := newSpentTimeInMemory(t, dir)
+	s.SetHistoryStorage(h)
+	st := randSpentTime()
+	if err := s.NewSpentTime(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Modify(ctx, st.UserID, flushToOutbox); pkgerrors.Cause(err) != entities.ErrTimeout {
+		t.Error("Storage error expected", err)
+	}
+	if len(pendingOf(s)) != 1 {
+		t.Error("History with unknown result should be kept", pendingOf(s))
+	}
+	h.errAfterSave = nil
+	s.deliverPending(ctx)
+	if len(h.saved) != 1 || len(pendingOf(s)) != 0 {
+		t.Error("History should be saved once", h.saved, pendingOf(s))
+	}
+	if len(spentTimeOf(s)) != 0 {
+		t.Error("Flushed spent time shouldn't be restored", spentTimeOf(s))
+	}
+}
+
+func TestBackupDuringModify(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	s1 := newSpentTimeInMemory(t, dir)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		st := randSpentTime()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s1.NewSpentTime(ctx, st); err != nil {
+				t.Error(err)
+				return
+			}
+			for j := 0; j < 10; j++ {
+				err := s1.Modify(ctx, st.UserID, func(_ context.Context, cur *entities.SpentTime) (*entities.SpentTime, error) {
+					cur.SpentOnline++
+					return cur, nil
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		if err := s1.backup(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s2 := newSpentTimeInMemory(t, dir)
+	expected := spentTimeOf(s1)
+	if len(expected) != 100 {
+		t.Error("Invalid spent time", expected)
+	}
+	for _, st := range expected {
+		if st.SpentOnline != 10 {
+			t.Error("Modification lost", st)
+		}
+	}
+	if !reflect.DeepEqual(spentTimeOf(s2), expected) {
+		t.Error("Invalid backup loaded", spentTimeOf(s2))
+	}
+}
+
+const (
+	benchmarkUsers = 10000
+	// benchmarkParallelism is goroutines per CPU of journaled benchmarks,
+	// they wait for disk, so thousands of users modify spent time concurrently
+	benchmarkParallelism = 1000
+)
+
+// newBenchmarkStorage returns SpentTimeInMemory, without journal benchmarks measure
+// locking only and with journal they measure waiting for disk too
+func newBenchmarkStorage(b *testing.B, journaled bool) (*SpentTimeInMemory, []ctxtg.UserID, func()) {
+	dir, clean := tempDir(b)
+	s := &SpentTimeInMemory{backupFolder: dir}
+	if journaled {
+		j, err := openJournal(dir)
+		if err != nil {
+			b.Fatal(err)
+		}
+		s.journal = j
+	}
+	uids := make([]ctxtg.UserID, benchmarkUsers)
+	for i := range uids {
+		st := randSpentTime()
+		uids[i] = st.UserID
+		if err := s.NewSpentTime(ctx, st); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return s, uids, func() {
+		if s.journal != nil {
+			s.journal.close()
+		}
+		clean()
+	}
+}
+
+func benchmarkModify(b *testing.B, s *SpentTimeInMemory, uids []ctxtg.UserID) {
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			err := s.Modify(ctx, uids[r.Intn(len(uids))], func(_ context.Context, st *entities.SpentTime) (*entities.SpentTime, error) {
+				st.SpentOnline++
+				return st, nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkModify(b *testing.B) {
+	s, uids, clean := newBenchmarkStorage(b, false)
+	defer clean()
+	b.ResetTimer()
+	benchmarkModify(b, s, uids)
+}
+
+func BenchmarkModifyJournaled(b *testing.B) {
+	s, uids, clean := newBenchmarkStorage(b, true)
+	defer clean()
+	b.SetParallelism(benchmarkParallelism)
+	b.ResetTimer()
+	benchmarkModify(b, s, uids)
+}
+
+func BenchmarkModifyDuringBackup(b *testing.B) {
+	benchmarkModifyDuringBackup(b, false)
+}
+
+func BenchmarkModifyJournaledDuringBackup(b *testing.B) {
+	benchmarkModifyDuringBackup(b, true)
+}
+
+func benchmarkModifyDuringBackup(b *testing.B, journaled bool) {
+	s, uids, clean := newBenchmarkStorage(b, journaled)
+	defer clean()
+	if journaled {
+		b.SetParallelism(benchmarkParallelism)
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := s.backup(); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	}()
+	b.ResetTimer()
+	benchmarkModify(b, s, uids)
+	b.StopTimer()
+	close(done)
+	<-stopped
+}
+
+func BenchmarkBackup(b *testing.B) {
+	s, _, clean := newBenchmarkStorage(b, false)
+	defer clean()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.backup(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRestoreVersion0Backup(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	st := randSpentTime()
+	b, err := json.Marshal([]entities.SpentTime{st})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, backupFileName), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := newSpentTimeInMemory(t, dir)
+	if !reflect.DeepEqual(spentTimeOf(s), map[ctxtg.UserID]entities.SpentTime{st.UserID: st}) {
+		t.Error("Invalid backup loaded", spentTimeOf(s))
+	}
+	b, err = ioutil.ReadFile(s.backupFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e backupEnvelope
+	if err := json.Unmarshal(b, &e); err != nil || e.Version != backupVersion {
+		t.Error("Backup should be migrated", e.Version, err)
+	}
+}
+
+func TestRestoreEmptyVersion0Backup(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	if err := ioutil.WriteFile(filepath.Join(dir, backupFileName), []byte("null"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := newSpentTimeInMemory(t, dir)
+	if len(spentTimeOf(s)) != 0 {
+		t.Error("Invalid backup loaded", spentTimeOf(s))
+	}
+}
+
+func TestRestoreCorruptedBackup(t *testing.T) {
+	b, err := encodeBackup([]entities.SpentTime{randSpentTime()}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := append([]byte(nil), b...)
+	i := len(flipped) - 10
+	flipped[i] ^= 1
+	newer := bytes.Replace(b, []byte(`"Version":1`), []byte(`"Version":2`), 1)
+	cases := []struct {
+		name string
+		b    []byte
+		err  error
+	}{
+		{"truncated", b[:len(b)/2], errCorruptedBackup},
+		{"flipped", flipped, errCorruptedBackup},
+		{"truncated version 0", []byte(`[{"UserID":1`), errCorruptedBackup},
+		{"newer", newer, errUnknownBackupVersion},
+	}
+	for _, c := range cases {
+		dir, clean := tempDir(t)
+		path := filepath.Join(dir, backupFileName)
+		if err := ioutil.WriteFile(path, c.b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := NewSpentTimeInMemory(SpentTimeInMemoryCfg{
+			Folder:     dir,
+			Frequency:  10 * time.Hour,
+			SharedLock: 1 * time.Second,
+		})
+		if pkgerrors.Cause(err) != c.err {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+		if saved, _ := ioutil.ReadFile(path); !bytes.Equal(saved, c.b) {
+			t.Errorf("%s: backup shouldn't be overwritten", c.name)
+		}
+		clean()
+	}
+}
+
+func TestBackupRetention(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	s := newSpentTimeInMemory(t, dir)
+	var sts []entities.SpentTime
+	for i := 0; i < 4; i++ {
+		st := randSpentTime()
+		sts = append(sts, st)
+		if err := s.NewSpentTime(ctx, st); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.backup(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for n, count := range []int{4, 3, 2} {
+		b, err := ioutil.ReadFile(retainedBackupPath(s.backupFilePath(), n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		retained, err := decodeBackup(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(retained) != count {
+			t.Errorf("Backup %d should contain %d spent time, got %+v", n, count, retained)
+		}
+	}
+	if _, err := os.Stat(retainedBackupPath(s.backupFilePath(), 3)); !os.IsNotExist(err) {
+		t.Error("Only 2 backups should be retained", err)
+	}
+}
+
+func TestBackupNow(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	s := newSpentTimeInMemory(t, dir)
+	st := randSpentTime()
+	if err := s.NewSpentTime(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Backup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(s.backupFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sts, err := decodeBackup(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sts) != 1 || sts[0] != st {
+		t.Error("Spent time should be backed up", sts)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Backup(ctx); pkgerrors.Cause(err) != entities.ErrMaintenance {
+		t.Error("Closed storage shouldn't be backed up", err)
+	}
+}
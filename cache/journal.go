@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+const (
+	journalPrefix    = "journal."
+	journalExtension = ".log"
+)
+
+// journalEntry is state of user's SpentTime and pending histories after NewSpentTime, Modify,
+// delivery or Erase, nil means it was removed. Entries contain whole state, so replaying
+// entries already included in backup doesn't change it.
+type journalEntry struct {
+	UserID    ctxtg.UserID
+	SpentTime *entities.SpentTime
+	Pending   *pending `json:",omitempty"`
+}
+
+// pending are histories of flushed SpentTime which aren't saved yet, SpentTime is restored
+// if they are rejected
+type pending struct {
+	SpentTime *entities.SpentTime
+	Histories []entities.SpentTimeHistory
+}
+
+// journal is append-only log of changes made since last backup. It's split into segments,
+// new segment is started on every backup and older segments are removed once backup is written.
+// Entries are synced to disk in groups: writer which waits for sync syncs all entries written
+// so far, others wait for it. It's safe for concurrent use.
+type journal struct {
+	folder string
+	// l guards seq, f and written, it's taken before sl
+	l       sync.Mutex
+	seq     int64
+	f       *os.File
+	written int64
+	// sl guards synced and syncing, synced signals end of every sync
+	sl      sync.Mutex
+	synced  int64
+	syncing bool
+	cond    *sync.Cond
+}
+
+// openJournal starts new segment after all existing ones, existing segments are never
+// appended because their last entry may be partially written
+func openJournal(folder string) (*journal, error) {
+	seqs, err := journalSegments(folder)
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{folder: folder}
+	j.cond = sync.NewCond(&j.sl)
+	if len(seqs) > 0 {
+		j.seq = seqs[len(seqs)-1]
+	}
+	if _, err := j.rotate(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// append writes e and returns its number for sync, it doesn't wait until e is flushed to disk
+func (j *journal) append(e journalEntry) (int64, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to marshal journal entry")
+	}
+	j.l.Lock()
+	defer j.l.Unlock()
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return 0, errors.Wrap(err, "failed to write journal")
+	}
+	j.written++
+	return j.written, nil
+}
+
+// sync waits until entry n and all entries written before it are flushed to disk
+func (j *journal) sync(n int64) error {
+	j.sl.Lock()
+	defer j.sl.Unlock()
+	for j.synced < n {
+		if j.syncing {
+			j.cond.Wait()
+			continue
+		}
+		j.syncing = true
+		j.sl.Unlock()
+		j.l.Lock()
+		f, written := j.f, j.written
+		j.l.Unlock()
+		err := f.Sync()
+		j.sl.Lock()
+		j.syncing = false
+		if err == nil && written > j.synced {
+			j.synced = written
+		}
+		j.cond.Broadcast()
+		// segment may be synced and closed by rotate meanwhile
+		if err != nil && j.synced < n {
+			return errors.Wrap(err, "failed to sync journal")
+		}
+	}
+	return nil
+}
+
+// rotate syncs current segment, starts new one and returns its sequence number,
+// current segment is kept if it can't be synced or new one can't be created
+func (j *journal) rotate() (int64, error) {
+	j.l.Lock()
+	defer j.l.Unlock()
+	if j.f != nil {
+		if err := j.f.Sync(); err != nil {
+			return 0, errors.Wrap(err, "failed to sync journal segment")
+		}
+		j.sl.Lock()
+		j.synced = j.written
+		j.cond.Broadcast()
+		j.sl.Unlock()
+	}
+	f, err := os.OpenFile(j.segmentPath(j.seq+1), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create journal segment")
+	}
+	if j.f != nil {
+		if err := j.f.Close(); err != nil {
+			log.ERR("Failed to close journal segment %d: %v", j.seq, err)
+		}
+	}
+	j.f = f
+	j.seq++
+	return j.seq, nil
+}
+
+func (j *journal) close() error {
+	j.l.Lock()
+	defer j.l.Unlock()
+	return errors.Wrap(j.f.Close(), "failed to close journal")
+}
+
+// removeBefore removes segments which are included in backup
+func (j *journal) removeBefore(seq int64) error {
+	seqs, err := journalSegments(j.folder)
+	if err != nil {
+		return err
+	}
+	for _, s := range seqs {
+		if s >= seq {
+			break
+		}
+		if err := os.Remove(j.segmentPath(s)); err != nil {
+			return errors.Wrap(err, "failed to remove journal segment")
+		}
+	}
+	return nil
+}
+
+func (j *journal) segmentPath(seq int64) string {
+	return segmentPath(j.folder, seq)
+}
+
+// replayJournal passes entries of all segments in folder to f in order they were written,
+// partially written entry at the end of segment is skipped
+func replayJournal(folder string, f func(journalEntry)) error {
+	seqs, err := journalSegments(folder)
+	if err != nil {
+		return err
+	}
+	for _, seq := range seqs {
+		if err := replaySegment(segmentPath(folder, seq), f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replaySegment(path string, f func(journalEntry)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open journal segment")
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.ERR("Skipping rest of %s: %v", path, err)
+			return nil
+		}
+		f(e)
+	}
+	return errors.Wrap(scanner.Err(), "failed to read journal segment")
+}
+
+// journalSegments returns sorted sequence numbers of segments in folder
+func journalSegments(folder string) ([]int64, error) {
+	paths, err := filepath.Glob(filepath.Join(folder, journalPrefix+"*"+journalExtension))
+	if err != nil {
+		return nil, err
+	}
+	var seqs []int64
+	for _, path := range paths {
+		var seq int64
+		name := filepath.Base(path)
+		if _, err := fmt.Sscanf(name, journalPrefix+"%d"+journalExtension, &seq); err != nil {
+			log.ERR("Skipping unknown journal segment %s", name)
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Sort(int64s(seqs))
+	return seqs, nil
+}
+
+func segmentPath(folder string, seq int64) string {
+	return filepath.Join(folder, fmt.Sprintf("%s%d%s", journalPrefix, seq, journalExtension))
+}
+
+type int64s []int64
+
+func (s int64s) Len() int           { return len(s) }
+func (s int64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s int64s) Less(i, j int) bool { return s[i] < s[j] }
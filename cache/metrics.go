@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/qarea/planningms/entities"
+)
+
+const (
+	metricsNamespace = "planningms"
+	metricsSubsystem = "spent_time_cache"
+)
+
+// Outcomes of SpentTimeInMemory.Modify
+const (
+	modifyUpdated     = "updated"
+	modifyRemoved     = "removed"
+	modifyFailed      = "failed"
+	modifyUndelivered = "undelivered"
+)
+
+// Results of backup and restore
+const (
+	resultOK        = "ok"
+	resultFailed    = "failed"
+	resultMissing   = "missing"
+	resultCorrupted = "corrupted"
+)
+
+// timerAgeBuckets are upper bounds of age of timers in seconds
+var timerAgeBuckets = []int64{5 * 60, 60 * 60, 8 * 60 * 60, 24 * 60 * 60}
+
+var (
+	activeTimers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "active_timers",
+		Help:      "Running timers at last backup, it lags behind by up to backup frequency.",
+	})
+	timersByAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "timers_by_age",
+		Help:      "Running timers started less than le seconds before last backup, it lags behind by up to backup frequency.",
+	}, []string{"le"})
+	modifyTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "modify_total",
+		Help:      "Modifications of timers by outcome.",
+	}, []string{"outcome"})
+	backupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "backups_total",
+		Help:      "Backups by result.",
+	}, []string{"result"})
+	backupDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "backup_duration_seconds",
+		Help:      "Duration of successful backups.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	})
+	backupSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "backup_size_bytes",
+		Help:      "Size of successful backups.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 8),
+	})
+	lastBackup = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "last_backup_timestamp_seconds",
+		Help:      "Unix time of last successful backup.",
+	})
+	restoresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "restores_total",
+		Help:      "Restores of backup on start by result.",
+	}, []string{"result"})
+	restoredTimers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "restored_timers",
+		Help:      "Running timers loaded from backup on start.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		activeTimers,
+		timersByAge,
+		modifyTotal,
+		backupsTotal,
+		backupDuration,
+		backupSize,
+		lastBackup,
+		restoresTotal,
+		restoredTimers,
+	)
+}
+
+// observeTimers sets gauges of running timers to sts at now, it's called by every backup
+// because ages of timers change without changes of timers
+func observeTimers(sts []entities.SpentTime, now time.Time) {
+	activeTimers.Set(float64(len(sts)))
+	counts := make([]int, len(timerAgeBuckets))
+	for _, st := range sts {
+		age := now.Unix() - st.Started
+		for i, le := range timerAgeBuckets {
+			if age < le {
+				counts[i]++
+			}
+		}
+	}
+	for i, le := range timerAgeBuckets {
+		timersByAge.WithLabelValues(strconv.FormatInt(le, 10)).Set(float64(counts[i]))
+	}
+	timersByAge.WithLabelValues("+Inf").Set(float64(len(sts)))
+}
+
+// observeModify counts outcome of Modify, spentTime and err are result of f or error
+// returned before f is called, deliverErr is error of delivery of histories made by f
+func observeModify(spentTime *entities.SpentTime, err, deliverErr error) {
+	switch {
+	case err != nil:
+		modifyTotal.WithLabelValues(modifyFailed).Inc()
+	case deliverErr != nil:
+		modifyTotal.WithLabelValues(modifyUndelivered).Inc()
+	case spentTime == nil:
+		modifyTotal.WithLabelValues(modifyRemoved).Inc()
+	default:
+		modifyTotal.WithLabelValues(modifyUpdated).Inc()
+	}
+}
+
+// observeBackup records result of backup of size bytes started at start
+func observeBackup(start time.Time, size int, err error) {
+	if err != nil {
+		backupsTotal.WithLabelValues(resultFailed).Inc()
+		return
+	}
+	backupsTotal.WithLabelValues(resultOK).Inc()
+	backupDuration.Observe(time.Since(start).Seconds())
+	backupSize.Observe(float64(size))
+	lastBackup.SetToCurrentTime()
+}
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/qarea/planningms/entities"
+)
+
+// metricValue returns value of counter or gauge m or number of observations of histogram m
+func metricValue(t *testing.T, m prometheus.Metric) float64 {
+	var d dto.Metric
+	if err := m.Write(&d); err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case d.Counter != nil:
+		return d.Counter.GetValue()
+	case d.Gauge != nil:
+		return d.Gauge.GetValue()
+	case d.Histogram != nil:
+		return float64(d.Histogram.GetSampleCount())
+	}
+	t.Fatalf("Unsupported metric %+v", d)
+	return 0
+}
+
+// counterValues returns values of counter vec by labels
+func counterValues(t *testing.T, v *prometheus.CounterVec, labels ...string) map[string]float64 {
+	values := make(map[string]float64)
+	for _, label := range labels {
+		values[label] = metricValue(t, v.WithLabelValues(label))
+	}
+	return values
+}
+
+// checkIncremented checks only label of counter vec was incremented since before
+func checkIncremented(t *testing.T, name string, v *prometheus.CounterVec, before map[string]float64, label string) {
+	for l, value := range before {
+		expected := value
+		if l == label {
+			expected++
+		}
+		if got := metricValue(t, v.WithLabelValues(l)); got != expected {
+			t.Errorf("%s: expected %s %v, got %v", name, l, expected, got)
+		}
+	}
+}
+
+func TestObserveModify(t *testing.T) {
+	st := randSpentTime()
+	errModify := errors.New("modify")
+	cases := []struct {
+		name       string
+		spentTime  *entities.SpentTime
+		err        error
+		deliverErr error
+		outcome    string
+	}{
+		{"updated", &st, nil, nil, modifyUpdated},
+		{"removed", nil, nil, nil, modifyRemoved},
+		{"failed", &st, errModify, nil, modifyFailed},
+		{"failed with undelivered", nil, errModify, errModify, modifyFailed},
+		{"undelivered", &st, nil, errModify, modifyUndelivered},
+	}
+	for _, c := range cases {
+		before := counterValues(t, modifyTotal, modifyUpdated, modifyRemoved, modifyFailed, modifyUndelivered)
+		observeModify(c.spentTime, c.err, c.deliverErr)
+		checkIncremented(t, c.name, modifyTotal, before, c.outcome)
+	}
+}
+
+func TestObserveBackup(t *testing.T) {
+	lastBackup.Set(0)
+	before := counterValues(t, backupsTotal, resultOK, resultFailed)
+	duration, size := metricValue(t, backupDuration), metricValue(t, backupSize)
+	observeBackup(time.Now(), 100, errors.New("backup"))
+	checkIncremented(t, "failed", backupsTotal, before, resultFailed)
+	if got := metricValue(t, lastBackup); got != 0 {
+		t.Errorf("Failed backup shouldn't set last backup time, got %v", got)
+	}
+	if metricValue(t, backupDuration) != duration || metricValue(t, backupSize) != size {
+		t.Error("Failed backup shouldn't be observed")
+	}
+
+	before = counterValues(t, backupsTotal, resultOK, resultFailed)
+	start := time.Now()
+	observeBackup(start, 100, nil)
+	checkIncremented(t, "ok", backupsTotal, before, resultOK)
+	if got := metricValue(t, lastBackup); got < float64(start.Unix()) {
+		t.Errorf("Expected last backup time after %v, got %v", start.Unix(), got)
+	}
+	if metricValue(t, backupDuration) != duration+1 || metricValue(t, backupSize) != size+1 {
+		t.Error("Successful backup should be observed")
+	}
+}
+
+func TestObserveTimers(t *testing.T) {
+	now := time.Now()
+	sts := []entities.SpentTime{
+		{Started: now.Unix() - 60},
+		{Started: now.Unix() - 2*60*60},
+		{Started: now.Unix() - 2*24*60*60},
+	}
+	observeTimers(sts, now)
+	if got := metricValue(t, activeTimers); got != 3 {
+		t.Errorf("Expected 3 active timers, got %v", got)
+	}
+	expected := map[string]float64{"300": 1, "3600": 1, "28800": 2, "86400": 2, "+Inf": 3}
+	for le, value := range expected {
+		if got := metricValue(t, timersByAge.WithLabelValues(le)); got != value {
+			t.Errorf("Expected %v timers younger than %s, got %v", value, le, got)
+		}
+	}
+}
+
+func TestRestoreMetrics(t *testing.T) {
+	results := []string{resultOK, resultFailed, resultMissing, resultCorrupted}
+	dir, clean := tempDir(t)
+	defer clean()
+	path := filepath.Join(dir, backupFileName)
+
+	before := counterValues(t, restoresTotal, results...)
+	s := newSpentTimeInMemory(t, dir)
+	checkIncremented(t, "missing", restoresTotal, before, resultMissing)
+	for i := 0; i < 2; i++ {
+		if err := s.NewSpentTime(ctx, randSpentTime()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	before = counterValues(t, restoresTotal, results...)
+	s = newSpentTimeInMemory(t, dir)
+	checkIncremented(t, "ok", restoresTotal, before, resultOK)
+	if got := metricValue(t, restoredTimers); got != 2 {
+		t.Errorf("Expected 2 restored timers, got %v", got)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b[:len(b)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	before = counterValues(t, restoresTotal, results...)
+	if _, err := NewSpentTimeInMemory(SpentTimeInMemoryCfg{Folder: dir}); err == nil {
+		t.Error("Expected error on corrupted backup")
+	}
+	checkIncremented(t, "corrupted", restoresTotal, before, resultCorrupted)
+
+	// Backup which is directory can't be read
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	before = counterValues(t, restoresTotal, results...)
+	if _, err := NewSpentTimeInMemory(SpentTimeInMemoryCfg{Folder: dir}); err == nil {
+		t.Error("Expected error on unreadable backup")
+	}
+	checkIncremented(t, "failed", restoresTotal, before, resultFailed)
+}
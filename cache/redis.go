@@ -0,0 +1,296 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+const (
+	redisSpentTimeKey = "spent_time"
+	redisLockKey      = "spent_time:lock:"
+	redisLockRetry    = 10 * time.Millisecond
+)
+
+// SpentTimeRedisCfg is configuration of SpentTimeRedis
+type SpentTimeRedisCfg struct {
+	Addr     string
+	Password string
+	DB       int
+	// Prefix is prepended to all keys, so several services may share database
+	Prefix string
+	// LockTTL limits time user's SpentTime is locked by Modify,
+	// it should be longer than slowest ModifySpentTimeFunc
+	LockTTL time.Duration
+}
+
+//NewSpentTimeRedis creates SpentTimeRedis, connections are established on demand
+func NewSpentTimeRedis(cfg SpentTimeRedisCfg) *SpentTimeRedis {
+	return &SpentTimeRedis{
+		pool: &redisPool{
+			addr:     cfg.Addr,
+			password: cfg.Password,
+			db:       cfg.DB,
+			timeout:  cfg.LockTTL,
+		},
+		key:        cfg.Prefix + redisSpentTimeKey,
+		lockPrefix: cfg.Prefix + redisLockKey,
+		lockTTL:    cfg.LockTTL,
+	}
+}
+
+//SpentTimeRedis keeps SpentTime in Redis-compatible server shared by all replicas of service.
+//All SpentTime is stored in single hash by UserID. Changes of user's SpentTime are serialized
+//by per-user lock with expiration and saved only while lock is still held
+type SpentTimeRedis struct {
+	pool       *redisPool
+	key        string
+	lockPrefix string
+	lockTTL    time.Duration
+}
+
+//NewSpentTime save st into storage. SpentTime in storage unique by UserID
+func (s *SpentTimeRedis) NewSpentTime(ctx context.Context, st entities.SpentTime) error {
+	return s.withUserLock(ctx, st.UserID, func(c *redisConn) (*entities.SpentTime, error) {
+		return &st, nil
+	})
+}
+
+//Modify get SpentTime from storage and pass it to f.
+//If f returns not nil SpentTime it saves it back to storage else it removes it from storage.
+//Result of f is saved even if f returns error because f may already have side effects.
+//Nothing is saved if SpentTime can't be read, f isn't called then
+func (s *SpentTimeRedis) Modify(ctx context.Context, userID ctxtg.UserID, f entities.ModifySpentTimeFunc) error {
+	return s.withUserLock(ctx, userID, func(c *redisConn) (*entities.SpentTime, error) {
+		st, err := s.get(ctx, c, userID)
+		if err != nil {
+			return nil, readError{err}
+		}
+		return f(ctx, st)
+	})
+}
+
+//SpentTime returns user's SpentTime or nil if user hasn't active planning
+func (s *SpentTimeRedis) SpentTime(ctx context.Context, userID ctxtg.UserID) (*entities.SpentTime, error) {
+	c, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.pool.put(c)
+	return s.get(ctx, c, userID)
+}
+
+//UserIDs returns users with active planning
+func (s *SpentTimeRedis) UserIDs(ctx context.Context) ([]ctxtg.UserID, error) {
+	c, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.pool.put(c)
+	fields, err := replyStrings(c.do(ctx, "HKEYS", s.key))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get users with spent time")
+	}
+	uids := make([]ctxtg.UserID, 0, len(fields))
+	for _, field := range fields {
+		uid, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid user id %q in spent time", field)
+		}
+		uids = append(uids, ctxtg.UserID(uid))
+	}
+	return uids, nil
+}
+
+//Erase removes user's SpentTime from storage
+func (s *SpentTimeRedis) Erase(ctx context.Context, userID ctxtg.UserID) error {
+	return s.withUserLock(ctx, userID, func(c *redisConn) (*entities.SpentTime, error) {
+		return nil, nil
+	})
+}
+
+//Backup does nothing, SpentTime is persisted by server
+func (s *SpentTimeRedis) Backup(_ context.Context) error {
+	return nil
+}
+
+//Close closes idle connections, NewSpentTime and Modify return entities.ErrMaintenance after Close
+func (s *SpentTimeRedis) Close() error {
+	return s.pool.close()
+}
+
+func (s *SpentTimeRedis) conn(ctx context.Context) (*redisConn, error) {
+	c, err := s.pool.get(ctx)
+	if err == errPoolClosed {
+		return nil, errors.Wrap(entities.ErrMaintenance, "spent time storage is closed")
+	}
+	return c, err
+}
+
+func (s *SpentTimeRedis) get(ctx context.Context, c *redisConn, userID ctxtg.UserID) (*entities.SpentTime, error) {
+	b, err := replyBytes(c.do(ctx, "HGET", s.key, userField(userID)))
+	if err == errNilReply {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get spent time")
+	}
+	var st entities.SpentTime
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal spent time")
+	}
+	return &st, nil
+}
+
+// readError is returned by function given to withUserLock when SpentTime can't be read
+type readError struct {
+	error
+}
+
+// withUserLock locks user's SpentTime, saves result of f and releases lock at once.
+// Result isn't saved if lock expired while f was running, it may be changed by other replica then.
+// If f returns readError lock is released without saving by other connection and
+// connection of f is closed because it may be out of sync with server
+func (s *SpentTimeRedis) withUserLock(ctx context.Context, userID ctxtg.UserID, f func(*redisConn) (*entities.SpentTime, error)) error {
+	c, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.pool.put(c)
+	lockKey := s.lockPrefix + userField(userID)
+	token, err := s.lock(ctx, c, lockKey)
+	if err != nil {
+		return err
+	}
+	st, err := f(c)
+	if readErr, ok := err.(readError); ok {
+		c.broken = true
+		if unlockErr := s.unlock(lockKey, token); unlockErr != nil {
+			log.ERR("Failed to unlock spent time of user %d: %+v", userID, unlockErr)
+		}
+		return readErr.error
+	}
+	// ctx may be already done by f, but its result must be saved anyway
+	if saveErr := s.save(context.Background(), c, lockKey, token, userID, st); saveErr != nil {
+		log.ERR("Failed to save spent time of user %d: %+v", userID, saveErr)
+		if err == nil {
+			err = saveErr
+		}
+	}
+	return err
+}
+
+// lock waits until lockKey is set to new random token which is returned
+func (s *SpentTimeRedis) lock(ctx context.Context, c *redisConn, lockKey string) (string, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return "", err
+	}
+	ttl := strconv.FormatInt(int64(s.lockTTL/time.Millisecond), 10)
+	timeout := time.After(s.lockTTL)
+	for {
+		reply, err := c.do(ctx, "SET", lockKey, token, "NX", "PX", ttl)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to lock spent time")
+		}
+		if reply != nil {
+			return token, nil
+		}
+		select {
+		case <-time.After(redisLockRetry):
+		case <-timeout:
+			return "", errors.Wrapf(entities.ErrMaintenance, "spent time is locked by %s", lockKey)
+		case <-ctx.Done():
+			return "", errors.Wrap(ctx.Err(), "failed to lock spent time")
+		}
+	}
+}
+
+// save writes st, or removes it if st is nil, and releases lock
+func (s *SpentTimeRedis) save(ctx context.Context, c *redisConn, lockKey, token string, userID ctxtg.UserID, st *entities.SpentTime) error {
+	write := []interface{}{"HDEL", s.key, userField(userID)}
+	if st != nil {
+		b, err := json.Marshal(st)
+		if err != nil {
+			c.broken = true
+			return errors.Wrap(err, "failed to marshal spent time")
+		}
+		write = []interface{}{"HSET", s.key, userField(userID), b}
+	}
+	return s.release(ctx, c, lockKey, token, write)
+}
+
+// unlock releases lock without changes using new connection
+func (s *SpentTimeRedis) unlock(lockKey, token string) error {
+	ctx := context.Background()
+	c, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.pool.put(c)
+	return s.release(ctx, c, lockKey, token)
+}
+
+// release executes writes and deletes lockKey in single transaction
+// which fails if lockKey doesn't contain token anymore
+func (s *SpentTimeRedis) release(ctx context.Context, c *redisConn, lockKey, token string, writes ...[]interface{}) (err error) {
+	defer func() {
+		// Connection may be left in WATCH or MULTI state
+		if err != nil {
+			c.broken = true
+		}
+	}()
+	if _, err := c.do(ctx, "WATCH", lockKey); err != nil {
+		return err
+	}
+	owner, err := replyBytes(c.do(ctx, "GET", lockKey))
+	if err != nil && err != errNilReply {
+		return err
+	}
+	if string(owner) != token {
+		return errors.New("lock of spent time expired")
+	}
+	if _, err := c.do(ctx, "MULTI"); err != nil {
+		return err
+	}
+	for _, cmd := range append(writes, []interface{}{"DEL", lockKey}) {
+		if _, err := c.do(ctx, cmd...); err != nil {
+			return err
+		}
+	}
+	reply, err := c.do(ctx, "EXEC")
+	if err != nil {
+		return err
+	}
+	replies, ok := reply.([]interface{})
+	if !ok {
+		return errors.New("lock of spent time expired")
+	}
+	for _, r := range replies {
+		if err, ok := r.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func userField(userID ctxtg.UserID) string {
+	return fmt.Sprint(int64(userID))
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate lock token")
+	}
+	return hex.EncodeToString(b), nil
+}
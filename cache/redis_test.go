@@ -0,0 +1,506 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+// testRedis is in-process stand-in for Redis server implementing only commands used by SpentTimeRedis
+type testRedis struct {
+	l        sync.Mutex
+	ln       net.Listener
+	strings  map[string]string
+	expires  map[string]time.Time
+	hashes   map[string]map[string]string
+	versions map[string]int64
+	// hget replaces replies to HGET if it's set
+	hget string
+}
+
+func newTestRedis(t *testing.T) *testRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &testRedis{
+		ln:       ln,
+		strings:  make(map[string]string),
+		expires:  make(map[string]time.Time),
+		hashes:   make(map[string]map[string]string),
+		versions: make(map[string]int64),
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(c)
+		}
+	}()
+	return r
+}
+
+func (r *testRedis) close() {
+	r.ln.Close()
+}
+
+func (r *testRedis) newStorage(lockTTL time.Duration) *SpentTimeRedis {
+	return NewSpentTimeRedis(SpentTimeRedisCfg{
+		Addr:    r.ln.Addr().String(),
+		Prefix:  "test:",
+		LockTTL: lockTTL,
+	})
+}
+
+func (r *testRedis) serve(c net.Conn) {
+	defer c.Close()
+	rd := bufio.NewReader(c)
+	watched := make(map[string]int64)
+	var queue [][]string
+	multi := false
+	for {
+		args, err := readTestCommand(rd)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		var reply string
+		switch {
+		case cmd == "MULTI":
+			multi, reply = true, "+OK\r\n"
+		case cmd == "EXEC":
+			reply = r.exec(watched, queue)
+			watched, queue, multi = make(map[string]int64), nil, false
+		case cmd == "WATCH":
+			r.l.Lock()
+			for _, key := range args[1:] {
+				r.expire(key)
+				watched[key] = r.versions[key]
+			}
+			r.l.Unlock()
+			reply = "+OK\r\n"
+		case cmd == "UNWATCH":
+			watched, reply = make(map[string]int64), "+OK\r\n"
+		case multi:
+			queue, reply = append(queue, args), "+QUEUED\r\n"
+		default:
+			r.l.Lock()
+			reply = r.do(args)
+			r.l.Unlock()
+		}
+		if _, err := io.WriteString(c, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (r *testRedis) exec(watched map[string]int64, queue [][]string) string {
+	r.l.Lock()
+	defer r.l.Unlock()
+	for key, v := range watched {
+		r.expire(key)
+		if r.versions[key] != v {
+			return "*-1\r\n"
+		}
+	}
+	reply := fmt.Sprintf("*%d\r\n", len(queue))
+	for _, args := range queue {
+		reply += r.do(args)
+	}
+	return reply
+}
+
+// do executes command, r.l must be held
+func (r *testRedis) do(args []string) string {
+	for _, key := range args[1:2] {
+		r.expire(key)
+	}
+	switch strings.ToUpper(args[0]) {
+	case "PING", "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		v, ok := r.strings[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return testBulk(v)
+	case "SET":
+		key := args[1]
+		if _, ok := r.strings[key]; ok && len(args) > 3 && strings.ToUpper(args[3]) == "NX" {
+			return "$-1\r\n"
+		}
+		r.strings[key] = args[2]
+		delete(r.expires, key)
+		if len(args) > 5 && strings.ToUpper(args[4]) == "PX" {
+			ms, _ := strconv.Atoi(args[5])
+			r.expires[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		r.versions[key]++
+		return "+OK\r\n"
+	case "DEL":
+		_, ok := r.strings[args[1]]
+		delete(r.strings, args[1])
+		delete(r.expires, args[1])
+		r.versions[args[1]]++
+		return testInt(ok)
+	case "HGET":
+		if r.hget != "" {
+			return r.hget
+		}
+		v, ok := r.hashes[args[1]][args[2]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return testBulk(v)
+	case "HSET":
+		h, ok := r.hashes[args[1]]
+		if !ok {
+			h = make(map[string]string)
+			r.hashes[args[1]] = h
+		}
+		_, ok = h[args[2]]
+		h[args[2]] = args[3]
+		r.versions[args[1]]++
+		return testInt(!ok)
+	case "HDEL":
+		_, ok := r.hashes[args[1]][args[2]]
+		delete(r.hashes[args[1]], args[2])
+		r.versions[args[1]]++
+		return testInt(ok)
+	case "HKEYS":
+		reply := fmt.Sprintf("*%d\r\n", len(r.hashes[args[1]]))
+		for field := range r.hashes[args[1]] {
+			reply += testBulk(field)
+		}
+		return reply
+	}
+	return "-ERR unknown command\r\n"
+}
+
+// expire removes key if it's expired, r.l must be held
+func (r *testRedis) expire(key string) {
+	if t, ok := r.expires[key]; ok && time.Now().After(t) {
+		delete(r.strings, key)
+		delete(r.expires, key)
+		r.versions[key]++
+	}
+}
+
+func readTestCommand(rd *bufio.Reader) ([]string, error) {
+	var n int
+	if _, err := fmt.Fscanf(rd, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		var size int
+		if _, err := fmt.Fscanf(rd, "$%d\r\n", &size); err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func testBulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func testInt(ok bool) string {
+	if ok {
+		return ":1\r\n"
+	}
+	return ":0\r\n"
+}
+
+func TestRedisNewSpentTime(t *testing.T) {
+	r := newTestRedis(t)
+	defer r.close()
+	s := r.newStorage(time.Second)
+	defer s.Close()
+
+	st := randSpentTime()
+	if err := s.NewSpentTime(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.SpentTime(ctx, st.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil || !reflect.DeepEqual(*res, st) {
+		t.Errorf("Expected %+v, got %+v", st, res)
+	}
+	uids, err := s.UserIDs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(uids, []ctxtg.UserID{st.UserID}) {
+		t.Errorf("Expected %v, got %v", st.UserID, uids)
+	}
+	if err := s.Erase(ctx, st.UserID); err != nil {
+		t.Fatal(err)
+	}
+	res, err = s.SpentTime(ctx, st.UserID)
+	if err != nil || res != nil {
+		t.Errorf("Expected erased spent time, got %+v %v", res, err)
+	}
+}
+
+func TestRedisModify(t *testing.T) {
+	r := newTestRedis(t)
+	defer r.close()
+	s := r.newStorage(time.Second)
+	defer s.Close()
+
+	st := randSpentTime()
+	modified := st
+	modified.PlanningID++
+	err := s.Modify(ctx, st.UserID, func(_ context.Context, cur *entities.SpentTime) (*entities.SpentTime, error) {
+		if cur != nil {
+			t.Errorf("Expected nil spent time, got %+v", cur)
+		}
+		return &st, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errModify := errors.New("modify")
+	err = s.Modify(ctx, st.UserID, func(_ context.Context, cur *entities.SpentTime) (*entities.SpentTime, error) {
+		if cur == nil || !reflect.DeepEqual(*cur, st) {
+			t.Errorf("Expected %+v, got %+v", st, cur)
+		}
+		return &modified, errModify
+	})
+	if err != errModify {
+		t.Errorf("Expected %v, got %v", errModify, err)
+	}
+	res, err := s.SpentTime(ctx, st.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil || !reflect.DeepEqual(*res, modified) {
+		t.Errorf("Result of f with error should be saved, expected %+v, got %+v", modified, res)
+	}
+	err = s.Modify(ctx, st.UserID, func(context.Context, *entities.SpentTime) (*entities.SpentTime, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = s.SpentTime(ctx, st.UserID)
+	if err != nil || res != nil {
+		t.Errorf("Expected removed spent time, got %+v %v", res, err)
+	}
+}
+
+func TestRedisModifyReadFailed(t *testing.T) {
+	r := newTestRedis(t)
+	defer r.close()
+	s := r.newStorage(time.Second)
+	defer s.Close()
+
+	st := randSpentTime()
+	if err := s.NewSpentTime(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	replies := map[string]string{
+		"invalid json":    testBulk("garbage"),
+		"invalid length":  "$3\r\ngarbage\r\n",
+		"invalid reply":   "garbage\r\n",
+		"unexpected type": ":1\r\n",
+	}
+	for name, reply := range replies {
+		r.l.Lock()
+		r.hget = reply
+		r.l.Unlock()
+		called := false
+		start := time.Now()
+		err := s.Modify(ctx, st.UserID, func(context.Context, *entities.SpentTime) (*entities.SpentTime, error) {
+			called = true
+			return nil, nil
+		})
+		if err == nil || called {
+			t.Errorf("%s: f shouldn't be called on failed read, got %v", name, err)
+		}
+		r.l.Lock()
+		r.hget = ""
+		r.l.Unlock()
+		err = s.Modify(ctx, st.UserID, func(_ context.Context, cur *entities.SpentTime) (*entities.SpentTime, error) {
+			return cur, nil
+		})
+		if err != nil {
+			t.Errorf("%s: lock should be released: %v", name, err)
+		}
+		if time.Since(start) > time.Second/2 {
+			t.Errorf("%s: lock should be released at once", name)
+		}
+		res, err := s.SpentTime(ctx, st.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res == nil || !reflect.DeepEqual(*res, st) {
+			t.Errorf("%s: timer should survive failed read, expected %+v, got %+v", name, st, res)
+		}
+	}
+}
+
+func TestRedisModifyConcurrentReplicas(t *testing.T) {
+	r := newTestRedis(t)
+	defer r.close()
+	replicas := []*SpentTimeRedis{r.newStorage(5 * time.Second), r.newStorage(5 * time.Second)}
+	for _, s := range replicas {
+		defer s.Close()
+	}
+
+	st := randSpentTime()
+	st.PlanningID = 0
+	if err := replicas[0].NewSpentTime(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	const n = 20
+	var wg sync.WaitGroup
+	var inside int32
+	var mu sync.Mutex
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(s *SpentTimeRedis) {
+			defer wg.Done()
+			err := s.Modify(ctx, st.UserID, func(_ context.Context, cur *entities.SpentTime) (*entities.SpentTime, error) {
+				mu.Lock()
+				inside++
+				if inside > 1 {
+					t.Error("Modify of same user runs concurrently")
+				}
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				inside--
+				mu.Unlock()
+				cur.PlanningID++
+				return cur, nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(replicas[i%len(replicas)])
+	}
+	wg.Wait()
+	res, err := replicas[1].SpentTime(ctx, st.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil || res.PlanningID != n {
+		t.Errorf("Expected %d increments, got %+v", n, res)
+	}
+}
+
+func TestRedisModifyLockExpired(t *testing.T) {
+	r := newTestRedis(t)
+	defer r.close()
+	s := r.newStorage(50 * time.Millisecond)
+	defer s.Close()
+
+	st := randSpentTime()
+	if err := s.NewSpentTime(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	err := s.Modify(ctx, st.UserID, func(_ context.Context, cur *entities.SpentTime) (*entities.SpentTime, error) {
+		time.Sleep(100 * time.Millisecond)
+		return nil, nil
+	})
+	if err == nil {
+		t.Error("Expected error when lock expired")
+	}
+	res, err := s.SpentTime(ctx, st.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil || !reflect.DeepEqual(*res, st) {
+		t.Errorf("Result of f shouldn't be saved without lock, expected %+v, got %+v", st, res)
+	}
+}
+
+func TestRedisModifyLocked(t *testing.T) {
+	r := newTestRedis(t)
+	defer r.close()
+	s := r.newStorage(50 * time.Millisecond)
+	defer s.Close()
+
+	st := randSpentTime()
+	r.l.Lock()
+	r.strings["test:"+redisLockKey+userField(st.UserID)] = "other replica"
+	r.l.Unlock()
+	err := s.NewSpentTime(ctx, st)
+	if pkgerrors.Cause(err) != entities.ErrMaintenance {
+		t.Errorf("Expected %v, got %v", entities.ErrMaintenance, err)
+	}
+}
+
+func TestRedisClose(t *testing.T) {
+	r := newTestRedis(t)
+	defer r.close()
+	s := r.newStorage(time.Second)
+
+	st := randSpentTime()
+	if err := s.NewSpentTime(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	err := s.Modify(ctx, st.UserID, func(_ context.Context, cur *entities.SpentTime) (*entities.SpentTime, error) {
+		return cur, nil
+	})
+	if pkgerrors.Cause(err) != entities.ErrMaintenance {
+		t.Errorf("Expected %v, got %v", entities.ErrMaintenance, err)
+	}
+}
+
+func TestRedisUserIDs(t *testing.T) {
+	r := newTestRedis(t)
+	defer r.close()
+	s := r.newStorage(time.Second)
+	defer s.Close()
+
+	var expected []int
+	for i := 0; i < 3; i++ {
+		st := randSpentTime()
+		st.UserID = ctxtg.UserID(i + 1)
+		if err := s.NewSpentTime(ctx, st); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, i+1)
+	}
+	uids, err := s.UserIDs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, uid := range uids {
+		got = append(got, int(uid))
+	}
+	sort.Ints(got)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,262 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// errNilReply is returned by reply helpers when server replied with nil
+	errNilReply = errors.New("nil reply")
+	// errPoolClosed is returned by redisPool after close
+	errPoolClosed = errors.New("redis pool is closed")
+	// errBrokenConn is returned by redisConn which may be out of sync with server
+	errBrokenConn = errors.New("redis connection is broken")
+)
+
+// redisError is error reply of Redis server
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// redisPool keeps idle connections to Redis-compatible server,
+// connections are authenticated and switched to configured database once
+type redisPool struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+
+	l      sync.Mutex
+	idle   []*redisConn
+	closed bool
+}
+
+const maxIdleRedisConns = 16
+
+func (p *redisPool) get(ctx context.Context) (*redisConn, error) {
+	p.l.Lock()
+	if p.closed {
+		p.l.Unlock()
+		return nil, errPoolClosed
+	}
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.l.Unlock()
+		return c, nil
+	}
+	p.l.Unlock()
+	return p.dial(ctx)
+}
+
+// put returns c to pool, broken connections are closed
+func (p *redisPool) put(c *redisConn) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	if c.broken || p.closed || len(p.idle) >= maxIdleRedisConns {
+		c.close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+func (p *redisPool) close() error {
+	p.l.Lock()
+	defer p.l.Unlock()
+	p.closed = true
+	for _, c := range p.idle {
+		c.close()
+	}
+	p.idle = nil
+	return nil
+}
+
+func (p *redisPool) dial(ctx context.Context) (*redisConn, error) {
+	d := net.Dialer{Timeout: p.timeout}
+	nc, err := d.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to redis")
+	}
+	c := &redisConn{
+		c:       nc,
+		r:       bufio.NewReader(nc),
+		w:       bufio.NewWriter(nc),
+		timeout: p.timeout,
+	}
+	if p.password != "" {
+		if _, err := c.do(ctx, "AUTH", p.password); err != nil {
+			c.close()
+			return nil, errors.Wrap(err, "failed to authenticate to redis")
+		}
+	}
+	if p.db != 0 {
+		if _, err := c.do(ctx, "SELECT", p.db); err != nil {
+			c.close()
+			return nil, errors.Wrap(err, "failed to select redis database")
+		}
+	}
+	return c, nil
+}
+
+// redisConn is connection speaking Redis serialization protocol (RESP)
+type redisConn struct {
+	c       net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+	broken  bool
+}
+
+// do sends command and returns its reply: string for status, int64 for integer,
+// []byte or nil for bulk string and []interface{} or nil for array. Error reply is returned
+// as redisError, connection is marked broken on any other error and isn't used anymore.
+func (c *redisConn) do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	if c.broken {
+		return nil, errBrokenConn
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok && c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	if err := c.c.SetDeadline(deadline); err != nil {
+		c.broken = true
+		return nil, err
+	}
+	if err := c.writeCommand(args); err != nil {
+		c.broken = true
+		return nil, errors.Wrap(err, "failed to send redis command")
+	}
+	reply, err := c.readReply()
+	if _, ok := err.(redisError); err != nil && !ok {
+		c.broken = true
+		return nil, errors.Wrap(err, "failed to read redis reply")
+	}
+	return reply, err
+}
+
+func (c *redisConn) close() {
+	c.broken = true
+	c.c.Close()
+}
+
+func (c *redisConn) writeCommand(args []interface{}) error {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var b []byte
+		switch arg := arg.(type) {
+		case string:
+			b = []byte(arg)
+		case []byte:
+			b = arg
+		default:
+			b = []byte(fmt.Sprint(arg))
+		}
+		fmt.Fprintf(c.w, "$%d\r\n", len(b))
+		c.w.Write(b)
+		c.w.WriteString("\r\n")
+	}
+	return c.w.Flush()
+}
+
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		if b[n] != '\r' || b[n+1] != '\n' {
+			return nil, errors.Errorf("invalid redis bulk string of length %d", n)
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			// Errors inside of EXEC reply belong to queued commands
+			replies[i], err = c.readReply()
+			if _, ok := err.(redisError); ok {
+				replies[i], err = err, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, errors.Errorf("invalid redis reply %q", line)
+}
+
+func (c *redisConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.Errorf("invalid redis reply line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// replyBytes converts bulk string reply, nil reply is returned as errNilReply
+func replyBytes(reply interface{}, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch reply := reply.(type) {
+	case []byte:
+		return reply, nil
+	case nil:
+		return nil, errNilReply
+	}
+	return nil, errors.Errorf("unexpected redis reply %T", reply)
+}
+
+// replyStrings converts array reply of bulk strings
+func replyStrings(reply interface{}, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	replies, ok := reply.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("unexpected redis reply %T", reply)
+	}
+	ss := make([]string, len(replies))
+	for i, r := range replies {
+		b, ok := r.([]byte)
+		if !ok {
+			return nil, errors.Errorf("unexpected redis reply %T", r)
+		}
+		ss[i] = string(b)
+	}
+	return ss, nil
+}
@@ -1,10 +1,12 @@
 package cfg
 
 import (
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/powerman/narada-go/narada"
+	"github.com/qarea/ctxtg"
 )
 
 var log = narada.NewLog("")
@@ -19,6 +21,14 @@ var (
 	// RSAPublicKey for JWT token verification
 	RSAPublicKey []byte
 
+	// Admins is list of users allowed to call admin methods, token claims don't carry roles
+	Admins []ctxtg.UserID
+
+	// Storage configuration, Driver is "mysql", "postgres" or "sqlite3"
+	Storage struct {
+		Driver string
+	}
+
 	// MySQL configuration
 	MySQL struct {
 		Host     string
@@ -28,6 +38,30 @@ var (
 		Password string
 	}
 
+	// Postgres configuration
+	Postgres struct {
+		Host     string
+		Port     int
+		DB       string
+		Login    string
+		Password string
+		SSLMode  string
+	}
+
+	// SQLite configuration
+	SQLite struct {
+		Path string
+	}
+
+	// Migrations configuration, Dir contains sql files of Storage.Driver or is empty
+	// to use migrations embedded into binary. Baseline is version of mysql databases
+	// migrated by App::migrate before migrations were tracked or -1
+	Migrations struct {
+		Dir      string
+		OnStart  bool
+		Baseline int
+	}
+
 	// HTTP configuration for application http server
 	HTTP struct {
 		Listen       string
@@ -35,10 +69,27 @@ var (
 		RealIPHeader string
 	}
 
-	// TimeSpent configuration for backup of in-memory data
+	// TimeSpent configuration, Storage is "memory", "redis" or "database". In-memory data
+	// is journaled to Folder and journal is compacted into backup every Frequency,
+	// Keep previous backups are retained. RestoreAction "flush", "drop" or "keep" is applied
+	// on start to timers of closed, missing, other user's or outdated plannings, only timers
+	// of outdated plannings are flushed, other ones are dropped
 	TimeSpent struct {
-		Folder    string
-		Frequency time.Duration
+		Storage       string
+		Folder        string
+		Frequency     time.Duration
+		Keep          int
+		RestoreAction string
+	}
+
+	// Redis configuration for "redis" TimeSpent.Storage shared by replicas,
+	// user's spent time is locked by one of them for up to LockTTL
+	Redis struct {
+		Addr     string
+		Password string
+		DB       int
+		Prefix   string
+		LockTTL  time.Duration
 	}
 
 	// Plannings configuration for plannings types
@@ -46,6 +97,26 @@ var (
 		MaxAge           time.Duration
 		OldestLastUpdate time.Duration
 	}
+
+	// ICS configuration for iCalendar feed of work sessions
+	ICS struct {
+		Period time.Duration
+	}
+
+	// Archive configuration, closed plannings without activity for Retention are moved
+	// to archive tables every Frequency, zero Retention disables archival
+	Archive struct {
+		Retention   time.Duration
+		Frequency   time.Duration
+		ReadThrough bool
+	}
+
+	// Shutdown configuration, in-flight requests are drained for up to Timeout on SIGTERM
+	// or SIGINT, running timers are saved to spent time histories if FlushTimers is set
+	Shutdown struct {
+		Timeout     time.Duration
+		FlushTimers bool
+	}
 )
 
 func init() {
@@ -69,27 +140,118 @@ func load() error {
 
 	HTTP.RealIPHeader = narada.GetConfigLine("http/real_ip_header")
 
+	Storage.Driver = narada.GetConfigLine("storage/driver")
+	switch Storage.Driver {
+	case "":
+		Storage.Driver = "mysql"
+	case "mysql", "postgres", "sqlite3":
+	default:
+		log.Fatal("config/storage/driver should be mysql, postgres or sqlite3")
+	}
+
 	MySQL.Host = narada.GetConfigLine("mysql/host")
 	MySQL.Port = narada.GetConfigInt("mysql/port")
 	MySQL.DB = narada.GetConfigLine("mysql/db")
 	MySQL.Login = narada.GetConfigLine("mysql/login")
 	MySQL.Password = narada.GetConfigLine("mysql/pass")
 
+	Postgres.Host = narada.GetConfigLine("postgres/host")
+	Postgres.Port = narada.GetConfigInt("postgres/port")
+	Postgres.DB = narada.GetConfigLine("postgres/db")
+	Postgres.Login = narada.GetConfigLine("postgres/login")
+	Postgres.Password = narada.GetConfigLine("postgres/pass")
+	Postgres.SSLMode = narada.GetConfigLine("postgres/sslmode")
+
+	SQLite.Path = narada.GetConfigLine("sqlite/path")
+	if Storage.Driver == "sqlite3" && SQLite.Path == "" {
+		log.Fatal("please setup config/sqlite/path")
+	}
+
+	Migrations.Dir = narada.GetConfigLine("migrations/dir")
+	Migrations.OnStart = narada.GetConfigLine("migrations/on_start") == "true"
+	Migrations.Baseline = -1
+	if baseline := narada.GetConfigLine("migrations/baseline"); baseline != "" {
+		var err error
+		Migrations.Baseline, err = strconv.Atoi(baseline)
+		if err != nil {
+			log.Fatal("config/migrations/baseline should be version number")
+		}
+	}
+
 	var err error
 	RSAPublicKey, err = narada.GetConfig("rsa_public_key")
 	if err != nil {
 		return err
 	}
 
+	admins, err := narada.GetConfig("admin/users")
+	if err != nil {
+		return err
+	}
+	for _, field := range strings.Fields(string(admins)) {
+		uid, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			log.Fatal("config/admin/users should contain user ids separated by spaces or new lines")
+		}
+		Admins = append(Admins, ctxtg.UserID(uid))
+	}
+
+	TimeSpent.Storage = narada.GetConfigLine("timespent/storage")
+	switch TimeSpent.Storage {
+	case "":
+		TimeSpent.Storage = "memory"
+	case "memory", "redis", "database":
+	default:
+		log.Fatal("config/timespent/storage should be memory, redis or database")
+	}
 	TimeSpent.Folder = narada.GetConfigLine("timespent/backup/folder")
-	if TimeSpent.Folder == "" {
+	if TimeSpent.Storage == "memory" && TimeSpent.Folder == "" {
 		log.Fatal("Please setup backup folder timespent/backup/folder")
 	}
 	TimeSpent.Frequency = narada.GetConfigDuration("timespent/backup/frequency")
+	TimeSpent.Keep = narada.GetConfigInt("timespent/backup/keep")
+	if TimeSpent.Keep < 0 {
+		log.Fatal("config/timespent/backup/keep should be 0 or more")
+	}
+	TimeSpent.RestoreAction = narada.GetConfigLine("timespent/restore/action")
+	switch TimeSpent.RestoreAction {
+	case "":
+		TimeSpent.RestoreAction = "flush"
+	case "flush", "drop", "keep":
+	default:
+		log.Fatal("config/timespent/restore/action should be flush, drop or keep")
+	}
+
+	Redis.Addr = narada.GetConfigLine("redis/addr")
+	if TimeSpent.Storage == "redis" && strings.Index(Redis.Addr, ":") == -1 {
+		log.Fatal("please setup config/redis/addr")
+	}
+	Redis.Password = narada.GetConfigLine("redis/pass")
+	Redis.DB = narada.GetConfigInt("redis/db")
+	Redis.Prefix = narada.GetConfigLine("redis/prefix")
+	Redis.LockTTL = narada.GetConfigDuration("redis/lock_ttl")
+	if TimeSpent.Storage == "redis" && Redis.LockTTL <= 0 {
+		log.Fatal("please setup config/redis/lock_ttl")
+	}
 
 	Plannings.MaxAge = narada.GetConfigDuration("plannings/max_age")
 	Plannings.OldestLastUpdate = narada.GetConfigDuration("plannings/oldest_last_update")
 
+	ICS.Period = narada.GetConfigDuration("ics/period")
+
+	Archive.Retention = narada.GetConfigDuration("archive/retention")
+	Archive.Frequency = narada.GetConfigDuration("archive/frequency")
+	if Archive.Retention > 0 && Archive.Frequency <= 0 {
+		log.Fatal("please setup config/archive/frequency")
+	}
+	Archive.ReadThrough = narada.GetConfigLine("archive/read_through") == "true"
+
+	Shutdown.Timeout = narada.GetConfigDuration("shutdown/timeout")
+	if Shutdown.Timeout <= 0 {
+		log.Fatal("please setup config/shutdown/timeout")
+	}
+	Shutdown.FlushTimers = narada.GetConfigLine("shutdown/flush_timers") == "true"
+
 	LockTimeout = narada.GetConfigDuration("lock_timeout")
 	return nil
 }
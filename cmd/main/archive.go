@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/qarea/planningms/cfg"
+	"github.com/qarea/planningms/storage"
+)
+
+const archiveUsage = "usage: main archive [-retention DURATION]"
+
+// archive moves closed plannings older than retention to archive tables once
+func archive(db *sqlx.DB, args []string) error {
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	retention := fs.Duration("retention", cfg.Archive.Retention, "archive plannings without activity for this duration")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errors.New(archiveUsage)
+	}
+	if *retention <= 0 {
+		return errors.New("retention should be positive")
+	}
+	st := storage.NewPlanningStorage(db, cfg.LockTimeout)
+	n, err := st.Archive(context.Background(), time.Now().Add(-*retention).Unix())
+	fmt.Printf("archived %d plannings\n", n)
+	return err
+}
+
+// archiveEvery moves closed plannings older than retention to archive tables every freq,
+// returned stop cancels archiving, batch in progress is rolled back, and waits for it
+func archiveEvery(st *storage.PlanningStorage, freq, retention time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-time.After(freq):
+			case <-ctx.Done():
+				return
+			}
+			n, err := st.Archive(ctx, time.Now().Add(-retention).Unix())
+			if err != nil && ctx.Err() == nil {
+				log.ERR("Failed to archive %+v", err)
+			}
+			if n > 0 {
+				log.NOTICE("Archived %d plannings", n)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-stopped
+	}
+}
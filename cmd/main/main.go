@@ -2,43 +2,82 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"os"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/powerman/narada-go/narada/bootstrap"
 	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/access"
+	"github.com/qarea/planningms/api/icssvc"
 	"github.com/qarea/planningms/api/rpcsvc"
 	"github.com/qarea/planningms/cache"
 	"github.com/qarea/planningms/cfg"
 	"github.com/qarea/planningms/mysqldb"
 	"github.com/qarea/planningms/plannings"
+	"github.com/qarea/planningms/postgresdb"
+	"github.com/qarea/planningms/sqlitedb"
 	"github.com/qarea/planningms/storage"
 
 	"github.com/powerman/narada-go/narada"
 	"github.com/prometheus/client_golang/prometheus"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 var log = narada.NewLog("")
 
+// subcommands are run instead of service by "main SUBCOMMAND ARGS..."
+var subcommands = map[string]func(db *sqlx.DB, args []string) error{
+	"archive":         archive,
+	"migrate":         migrate,
+	"repair-activity": repairActivity,
+}
+
 func main() {
-	db := mysqldb.New()
+	db := newDB()
+
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(db, os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	if cfg.Migrations.OnStart {
+		r, err := newMigrationRunner(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.Logf = log.NOTICE
+		if err := r.Up(-1); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	parser, err := ctxtg.NewRSATokenParser(cfg.RSAPublicKey)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	spentTimeStorage := cache.NewSpentTimeInMemory(
-		cfg.TimeSpent.Frequency,
-		cfg.TimeSpent.Folder,
-		cfg.LockTimeout,
-	)
-
 	planningStorage := storage.NewPlanningStorage(
 		db,
 		cfg.LockTimeout,
 	)
+	planningStorage.SetReadArchive(cfg.Archive.ReadThrough)
+	stopArchive := func() {}
+	if cfg.Archive.Retention > 0 {
+		stopArchive = archiveEvery(planningStorage, cfg.Archive.Frequency, cfg.Archive.Retention)
+	}
+
+	spentTimeStorage := newSpentTimeStorage(planningStorage)
 
 	svc := plannings.NewService(plannings.PlanningServiceCfg{
 		SpentTimeStorage:        spentTimeStorage,
@@ -46,11 +85,25 @@ func main() {
 		MaxPlanningAge:          cfg.Plannings.MaxAge,
 		MaxPeriodFromLastUpdate: cfg.Plannings.OldestLastUpdate,
 	})
+	validateTimers(svc)
 
 	rpcsvc.Init(rpcsvc.RPCConfig{
-		TokenParser:     parser,
-		PlanningService: svc,
-		PlanningStorage: planningStorage,
+		TokenParser:      parser,
+		PlanningService:  svc,
+		PlanningStorage:  planningStorage,
+		FeedStorage:      planningStorage,
+		TeamStorage:      planningStorage,
+		TimesheetStorage: planningStorage,
+		BillingStorage:   planningStorage,
+		PrivacyStorage:   planningStorage,
+		SpentTimeCache:   spentTimeStorage,
+		TimerService:     svc,
+		Access:           access.NewChecker(cfg.Admins, planningStorage),
+	})
+
+	icssvc.Init(icssvc.ICSConfig{
+		FeedStorage: planningStorage,
+		Period:      cfg.ICS.Period,
 	})
 
 	if err := bootstrap.Unlock(); err != nil {
@@ -58,5 +111,60 @@ func main() {
 	}
 	http.Handle(cfg.HTTP.BasePath+"/metrics", prometheus.Handler())
 	log.NOTICE("Listening on %s", cfg.HTTP.Listen+cfg.HTTP.BasePath)
-	log.Fatal(http.ListenAndServe(cfg.HTTP.Listen, nil))
+	serve(&http.Server{Addr: cfg.HTTP.Listen}, svc, spentTimeStorage, stopArchive)
+}
+
+// spentTimeStorage is implemented by all storages of running timers
+type spentTimeStorage interface {
+	plannings.SpentTimeStorage
+	rpcsvc.SpentTimeCache
+	Close() error
+}
+
+func newSpentTimeStorage(planningStorage *storage.PlanningStorage) spentTimeStorage {
+	switch cfg.TimeSpent.Storage {
+	case "database":
+		return storage.NewSpentTimeStorage(planningStorage)
+	case "redis":
+		return cache.NewSpentTimeRedis(cache.SpentTimeRedisCfg{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+			Prefix:   cfg.Redis.Prefix,
+			LockTTL:  cfg.Redis.LockTTL,
+		})
+	default:
+		s, err := cache.NewSpentTimeInMemory(cache.SpentTimeInMemoryCfg{
+			Folder:     cfg.TimeSpent.Folder,
+			Frequency:  cfg.TimeSpent.Frequency,
+			Keep:       cfg.TimeSpent.Keep,
+			SharedLock: cfg.LockTimeout,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.SetHistoryStorage(planningStorage)
+		return s
+	}
+}
+
+// validateTimers applies configured action to invalid timers left running while service was down
+func validateTimers(svc *plannings.Service) {
+	res, err := svc.ValidateTimers(context.Background(), plannings.TimerAction(cfg.TimeSpent.RestoreAction))
+	if err != nil {
+		log.ERR("Failed to validate timers: %+v", err)
+	}
+	log.NOTICE("Validated %d timers, invalid by reason %v: flushed %d, dropped %d, kept %d, failed %d",
+		res.Checked, res.Invalid, res.Flushed, res.Dropped, res.Kept, res.Failed)
+}
+
+func newDB() *sqlx.DB {
+	switch cfg.Storage.Driver {
+	case storage.Postgres:
+		return postgresdb.New()
+	case storage.SQLite:
+		return sqlitedb.New()
+	default:
+		return mysqldb.New()
+	}
 }
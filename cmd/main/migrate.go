@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/qarea/planningms/cfg"
+	"github.com/qarea/planningms/migration"
+)
+
+const migrateUsage = "usage: main migrate [-dry-run] [up [VERSION] | down | status | verify]"
+
+// migrate runs migration subcommand with args following "migrate"
+func migrate(db *sqlx.DB, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "print statements instead of executing them")
+	if err := fs.Parse(args); err != nil {
+		return errors.New(migrateUsage)
+	}
+	r, err := newMigrationRunner(db)
+	if err != nil {
+		return err
+	}
+	r.DryRun = *dryRun
+	r.Logf = func(format string, args ...interface{}) {
+		fmt.Printf(format+"\n", args...)
+	}
+	switch fs.Arg(0) {
+	case "", "up":
+		to := -1
+		if fs.NArg() > 1 {
+			if to, err = strconv.Atoi(fs.Arg(1)); err != nil {
+				return errors.New(migrateUsage)
+			}
+		}
+		return r.Up(to)
+	case "down":
+		return r.Down()
+	case "status":
+		ss, err := r.Status()
+		if err != nil {
+			return err
+		}
+		for _, s := range ss {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%s\t%s\t%s\n", s.Migration, state, s.Checksum)
+		}
+		return nil
+	case "verify":
+		return r.Verify()
+	}
+	return errors.New(migrateUsage)
+}
+
+func newMigrationRunner(db *sqlx.DB) (*migration.Runner, error) {
+	r, err := migration.NewDriverRunner(db, cfg.Storage.Driver, cfg.Migrations.Dir, cfg.Migrations.Baseline)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load migrations")
+	}
+	return r, nil
+}
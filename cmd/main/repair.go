@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/qarea/planningms/cfg"
+	"github.com/qarea/planningms/storage"
+)
+
+const repairUsage = "usage: main repair-activity"
+
+// repairActivity recomputes denormalised last activities and current estimations,
+// it's safe to run while service is running
+func repairActivity(db *sqlx.DB, args []string) error {
+	if len(args) != 0 {
+		return errors.New(repairUsage)
+	}
+	st := storage.NewPlanningStorage(db, cfg.LockTimeout)
+	r, err := st.RepairActivity(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "failed to repair activity")
+	}
+	fmt.Printf("repaired plannings: %d last activities, %d current estimations\n", r.LastActivities, r.CurrentEstimations)
+	fmt.Printf("repaired users: %d last activities\n", r.UserActivities)
+	return nil
+}
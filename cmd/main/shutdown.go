@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/qarea/planningms/cfg"
+	"github.com/qarea/planningms/plannings"
+)
+
+// serve handles requests until SIGTERM or SIGINT, then it stops accepting new requests,
+// waits for in-flight ones, stops archiving, optionally saves running timers
+// and closes their storage
+func serve(srv *http.Server, svc *plannings.Service, spentTime spentTimeStorage, stopArchive func()) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	log.NOTICE("Received %v, shutting down", <-sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.ERR("Failed to wait for in-flight requests %v", err)
+	}
+	stopArchive()
+	if cfg.Shutdown.FlushTimers {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+		defer cancel()
+		n, err := svc.FlushSpentTime(ctx)
+		if err != nil {
+			log.ERR("Failed to flush timers %+v", err)
+		}
+		log.NOTICE("Flushed %d timers", n)
+	}
+	if err := spentTime.Close(); err != nil {
+		log.ERR("Failed to close spent time storage %+v", err)
+	}
+}
@@ -1,6 +1,10 @@
 package entities
 
-import "github.com/qarea/ctxtg"
+import (
+	"context"
+
+	"github.com/qarea/ctxtg"
+)
 
 // Planning represents user plan for today
 type Planning struct {
@@ -20,6 +24,8 @@ type Planning struct {
 	SpentOffline    int            `db:"spent_offline"`
 	Reported        int64          `db:"reported"`
 	CreatedAt       int64          `db:"created_at"`
+	Billable        bool           `db:"billable"`
+	Version         int64          `db:"version"`
 }
 
 // ExtendedPlanning is Planning with additional information
@@ -43,6 +49,8 @@ type NewPlanning struct {
 	IssueDone       int
 	ActivityID      ActivityID
 	Estimation      int64
+	// Billable overrides project's billable rule if set
+	Billable *bool
 }
 
 // PlannedTime represents expected time to spent on PlanningID by user
@@ -56,12 +64,20 @@ type PlannedTime struct {
 
 // SpentTime represents user's spent time on planning
 type SpentTime struct {
-	UserID            ctxtg.UserID
-	PlanningID        PlanningID
-	PlanningCreatedAt int64
-	Started           int64
-	Last              int64
-	SpentOnline       int
+	UserID            ctxtg.UserID `db:"user_id"`
+	PlanningID        PlanningID   `db:"planning_id"`
+	PlanningCreatedAt int64        `db:"planning_created_at"`
+	Started           int64        `db:"started_at"`
+	Last              int64        `db:"last_at"`
+	SpentOnline       int          `db:"spent_online"`
+}
+
+// Timer is user's running SpentTime as seen by operators, Stale is seconds since it was
+// last updated and Outdated is set if it would be rejected by next update
+type Timer struct {
+	SpentTime
+	Stale    int64
+	Outdated bool
 }
 
 // SpentTimeReport represents spent time on planning report
@@ -81,6 +97,13 @@ type SpentTimeHistory struct {
 	Status     SpentTimeStatus `db:"-"`
 }
 
+// WorkSession represents SpentTimeHistory interval with details of planned issue
+type WorkSession struct {
+	SpentTimeHistory
+	IssueTitle string `db:"issue_title"`
+	IssueURL   string `db:"issue_url"`
+}
+
 // PlanningReport represents final report in the end of planning
 type PlanningReport struct {
 	PlanningID PlanningID
@@ -88,6 +111,27 @@ type PlanningReport struct {
 	Time       int64
 }
 
+// BurndownQuery describes plannings and sampling for burndown data.
+// Empty PlanningIDs means all opened plannings of UserID
+type BurndownQuery struct {
+	UserID      ctxtg.UserID
+	PlanningIDs []PlanningID
+	From        int64
+	To          int64
+	Step        int64
+}
+
+// BurndownPoint represents state of set of plannings at Time
+type BurndownPoint struct {
+	Time       int64
+	Estimation int64
+	Spent      int64
+	Remaining  int64
+	Progress   int
+	Open       int
+	Closed     int
+}
+
 // NewActivePlanning represents new active planning for user
 type NewActivePlanning struct {
 	UserID     ctxtg.UserID
@@ -95,8 +139,144 @@ type NewActivePlanning struct {
 	Time       int64
 }
 
-// ModifySpentTimeFunc is function to modify SpentTime for user
-type ModifySpentTimeFunc func(*SpentTime) (*SpentTime, error)
+// Team represents group of users which may be viewed by team leads
+type Team struct {
+	ID        TeamID `db:"id"`
+	Name      string `db:"name"`
+	CreatedAt int64  `db:"created_at"`
+}
+
+// TeamMember represents membership of user in team
+type TeamMember struct {
+	TeamID TeamID       `db:"team_id"`
+	UserID ctxtg.UserID `db:"user_id"`
+	Role   TeamRole     `db:"-"`
+}
+
+// MemberOverview represents opened plannings of team member
+type MemberOverview struct {
+	UserID    ctxtg.UserID
+	Role      TeamRole
+	Plannings []ExtendedPlanning
+}
+
+// Timesheet represents user's time period submitted for approval,
+// spent time of submitted and approved periods can't be changed
+type Timesheet struct {
+	ID         TimesheetID     `db:"id"`
+	UserID     ctxtg.UserID    `db:"user_id"`
+	From       int64           `db:"period_from"`
+	To         int64           `db:"period_to"`
+	Status     TimesheetStatus `db:"-"`
+	ApproverID ctxtg.UserID    `db:"approver_id"`
+	Comment    string          `db:"comment"`
+	UpdatedAt  int64           `db:"updated_at"`
+}
+
+// TimesheetReview represents decision of approver about timesheet
+type TimesheetReview struct {
+	ID         TimesheetID
+	ApproverID ctxtg.UserID
+	Status     TimesheetStatus
+	Comment    string
+}
+
+// ProjectBilling is billable rule for new plannings of project,
+// plannings of projects without rule are billable
+type ProjectBilling struct {
+	ProjectID ProjectID `db:"project_id"`
+	Billable  bool      `db:"billable"`
+}
+
+// Rate is hourly rate in minimal currency units effective from EffectiveFrom.
+// Zero UserID, ProjectID or ActivityID matches any value
+type Rate struct {
+	ID            RateID       `db:"id"`
+	UserID        ctxtg.UserID `db:"user_id"`
+	ProjectID     ProjectID    `db:"project_id"`
+	ActivityID    ActivityID   `db:"activity_id"`
+	Rate          int64        `db:"rate"`
+	EffectiveFrom int64        `db:"effective_from"`
+}
+
+// BillableWork represents SpentTimeHistory interval of billable planning
+type BillableWork struct {
+	SpentTimeHistory
+	UserID     ctxtg.UserID `db:"user_id"`
+	ProjectID  ProjectID    `db:"project_id"`
+	ActivityID ActivityID   `db:"activity_id"`
+}
+
+// BillingQuery describes period and optional user and project filters of billing report
+type BillingQuery struct {
+	UserID    ctxtg.UserID
+	ProjectID ProjectID
+	From      int64
+	To        int64
+}
+
+// BillingLine is spent time in seconds and amount for user, project and activity billed with Rate
+type BillingLine struct {
+	UserID     ctxtg.UserID
+	ProjectID  ProjectID
+	ActivityID ActivityID
+	Rate       int64
+	Spent      int64
+	Amount     int64
+}
+
+// BillingReport is billable spent time and amount of period
+type BillingReport struct {
+	Lines  []BillingLine
+	Spent  int64
+	Amount int64
+}
+
+// PrivacyRequest is audit record of export or erasure of user's data made by admin
+type PrivacyRequest struct {
+	ID        PrivacyRequestID `db:"id"`
+	UserID    ctxtg.UserID     `db:"user_id"`
+	AdminID   ctxtg.UserID     `db:"admin_id"`
+	Action    PrivacyAction    `db:"-"`
+	CreatedAt int64            `db:"created_at"`
+}
+
+// UserData is everything stored about user including archived plannings and running timer
+type UserData struct {
+	UserID             ctxtg.UserID
+	Plannings          []Planning
+	PlannedTimes       []PlannedTime
+	SpentTimeHistories []SpentTimeHistory
+	Timesheets         []Timesheet
+	TeamMembers        []TeamMember
+	Rates              []Rate
+	HasFeedToken       bool
+	LastActivity       int64
+	SpentTime          *SpentTime
+}
+
+// ModifySpentTimeFunc is function to modify SpentTime for user, it should pass given ctx
+// to all storages it calls because ctx may carry transaction which saves result of function
+type ModifySpentTimeFunc func(context.Context, *SpentTime) (*SpentTime, error)
+
+// Outbox collects spent time histories made by ModifySpentTimeFunc, storage of SpentTime
+// which provides it in ctx saves them after result of function, so both are saved or neither
+type Outbox struct {
+	Histories []SpentTimeHistory
+}
+
+type outboxKey struct{}
+
+// WithOutbox returns ctx with outbox for ModifySpentTimeFunc
+func WithOutbox(ctx context.Context, o *Outbox) context.Context {
+	return context.WithValue(ctx, outboxKey{}, o)
+}
+
+// OutboxFrom returns outbox of ctx or nil if histories should be saved by ModifySpentTimeFunc
+func OutboxFrom(ctx context.Context) *Outbox {
+	o, _ := ctx.Value(outboxKey{}).(*Outbox)
+	return o
+}
 
 // PlanningID is helper type to avoid invalid int usage
 type PlanningID int64
@@ -113,6 +293,18 @@ type IssueID int64
 // ActivityID is helper type to avoid invalid int usage
 type ActivityID int64
 
+// TeamID is helper type to avoid invalid int usage
+type TeamID int64
+
+// RateID is helper type to avoid invalid int usage
+type RateID int64
+
+// TimesheetID is helper type to avoid invalid int usage
+type TimesheetID int64
+
+// PrivacyRequestID is helper type to avoid invalid int usage
+type PrivacyRequestID int64
+
 // Status is helper type to avoid invalid string usage
 type Status string
 
@@ -133,3 +325,33 @@ const (
 	Open   PlanningStatus = "OPEN"
 	Closed PlanningStatus = "CLOSED"
 )
+
+// TeamRole is type for lead or member team roles
+type TeamRole string
+
+// Available team roles
+const (
+	Lead   TeamRole = "LEAD"
+	Member TeamRole = "MEMBER"
+)
+
+// TimesheetStatus is type for timesheet approval statuses
+type TimesheetStatus string
+
+// Available timesheet statuses, period is locked while timesheet is submitted or approved
+const (
+	Submitted TimesheetStatus = "SUBMITTED"
+	Approved  TimesheetStatus = "APPROVED"
+	Rejected  TimesheetStatus = "REJECTED"
+	Unlocked  TimesheetStatus = "UNLOCKED"
+)
+
+// PrivacyAction is type for actions of privacy requests
+type PrivacyAction string
+
+// Available privacy actions, pseudonymised data is kept for reports under negative user id
+const (
+	Export       PrivacyAction = "EXPORT"
+	Erase        PrivacyAction = "ERASE"
+	Pseudonymise PrivacyAction = "PSEUDONYMISE"
+)
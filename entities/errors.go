@@ -17,4 +17,17 @@ var (
 	ErrPlanningOutdated  = jsonrpc2.NewError(104, "PLANNING_OUTDATED")
 	ErrOutdatedReport    = jsonrpc2.NewError(105, "OUTDATED_REPORT")
 	ErrNegativeSpentTime = jsonrpc2.NewError(106, "NEGATIVE_SPENT_TIME")
+	ErrInvalidFeedToken  = jsonrpc2.NewError(107, "INVALID_FEED_TOKEN")
+	ErrInvalidTimeRange  = jsonrpc2.NewError(108, "INVALID_TIME_RANGE")
+	ErrInvalidTeamID     = jsonrpc2.NewError(109, "INVALID_TEAM_ID")
+	ErrInvalidTeamRole   = jsonrpc2.NewError(110, "INVALID_TEAM_ROLE")
+	ErrAccessDenied      = jsonrpc2.NewError(111, "ACCESS_DENIED")
+	ErrPeriodLocked      = jsonrpc2.NewError(112, "PERIOD_LOCKED")
+	ErrInvalidTimesheet  = jsonrpc2.NewError(113, "INVALID_TIMESHEET_ID")
+	ErrTimesheetStatus   = jsonrpc2.NewError(114, "INVALID_TIMESHEET_STATUS")
+	ErrActivePlanning    = jsonrpc2.NewError(115, "ACTIVE_PLANNING_IN_PERIOD")
+	ErrInvalidRate       = jsonrpc2.NewError(116, "INVALID_RATE")
+	ErrInvalidRateID     = jsonrpc2.NewError(117, "INVALID_RATE_ID")
+	ErrPlanningConflict  = jsonrpc2.NewError(118, "PLANNING_CONFLICT")
+	ErrDuplicateHistory  = jsonrpc2.NewError(119, "DUPLICATE_SPENT_TIME_HISTORY")
 )
@@ -0,0 +1,409 @@
+// Package memstorage provides in-memory PlanningStorage for tests and ephemeral runs.
+// It behaves like storage.PlanningStorage but keeps nothing between restarts.
+package memstorage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+var timeNowFunc = func() int64 {
+	return time.Now().Unix()
+}
+
+// New returns empty PlanningStorage
+func New() *PlanningStorage {
+	return &PlanningStorage{
+		plannings: make(map[entities.PlanningID]*entities.Planning),
+	}
+}
+
+// PlanningStorage implements plannings.PlanningStorage and rpcsvc.PlanningStorage in memory
+type PlanningStorage struct {
+	mu           sync.Mutex
+	lastID       int64
+	plannings    map[entities.PlanningID]*entities.Planning
+	plannedTimes []entities.PlannedTime
+	histories    []entities.SpentTimeHistory
+	timesheets   []entities.Timesheet
+	rates        []entities.Rate
+}
+
+// CreatePlanning create new planning and new planned time,
+// planning is billable unless np.Billable is set
+func (s *PlanningStorage) CreatePlanning(_ context.Context, np entities.NewPlanning) (entities.PlanningID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := timeNowFunc()
+	p := &entities.Planning{
+		ID:              entities.PlanningID(s.nextID()),
+		UserID:          np.UserID,
+		Status:          entities.Open,
+		ProjectID:       np.ProjectID,
+		TrackerID:       np.TrackerID,
+		IssueID:         np.IssueID,
+		IssueTitle:      np.IssueTitle,
+		IssueURL:        np.IssueURL,
+		IssueEstimation: np.IssueEstimation,
+		IssueDueDate:    np.IssueDueDate,
+		IssueDone:       np.IssueDone,
+		ActivityID:      np.ActivityID,
+		CreatedAt:       now,
+		Billable:        true,
+	}
+	if np.Billable != nil {
+		p.Billable = *np.Billable
+	}
+	s.plannings[p.ID] = p
+	s.plannedTimes = append(s.plannedTimes, entities.PlannedTime{
+		ID:         s.nextID(),
+		PlanningID: p.ID,
+		Estimation: np.Estimation,
+		CreatedAt:  now,
+	})
+	return p.ID, nil
+}
+
+// AddExtraTime create new estimation for planning
+func (s *PlanningStorage) AddExtraTime(_ context.Context, uid ctxtg.UserID, np entities.PlannedTime) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.plannings[np.PlanningID]
+	if p == nil {
+		return entities.ErrInvalidPlanningID
+	}
+	if p.UserID != uid {
+		return entities.ErrInvalidUserID
+	}
+	np.ID = s.nextID()
+	np.CreatedAt = timeNowFunc()
+	s.plannedTimes = append(s.plannedTimes, np)
+	return nil
+}
+
+// AddSpentTime save new SpentTimeHistory,
+// returns entities.ErrPeriodLocked if history overlaps submitted or approved timesheet
+// and entities.ErrDuplicateHistory if history with same planning, start and status exists
+func (s *PlanningStorage) AddSpentTime(_ context.Context, h entities.SpentTimeHistory) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.plannings[h.PlanningID]
+	if p == nil {
+		return entities.ErrInvalidPlanningID
+	}
+	if s.isPeriodLocked(p.UserID, h.StartedAt, h.EndedAt) {
+		return entities.ErrPeriodLocked
+	}
+	for _, old := range s.histories {
+		if old.PlanningID == h.PlanningID && old.StartedAt == h.StartedAt && old.Status == h.Status {
+			return entities.ErrDuplicateHistory
+		}
+	}
+	switch h.Status {
+	case entities.Online:
+		p.SpentOnline += h.Spent
+	case entities.Offline:
+		p.SpentOffline += h.Spent
+	default:
+		return errors.New("invalid status")
+	}
+	p.Version++
+	s.histories = append(s.histories, h)
+	return nil
+}
+
+// ClosePlanning check user id, save history and update planning
+func (s *PlanningStorage) ClosePlanning(_ context.Context, uid ctxtg.UserID, report entities.PlanningReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.plannings[report.PlanningID]
+	if p == nil {
+		return entities.ErrInvalidPlanningID
+	}
+	if p.UserID != uid {
+		return entities.ErrInvalidUserID
+	}
+	if p.Status == entities.Closed {
+		return entities.ErrPlanningClosed
+	}
+	p.SpentOnline, p.SpentOffline = 0, 0
+	for _, h := range s.histories {
+		if h.PlanningID != p.ID {
+			continue
+		}
+		switch h.Status {
+		case entities.Online:
+			p.SpentOnline += h.Spent
+		case entities.Offline:
+			p.SpentOffline += h.Spent
+		}
+	}
+	p.Status = entities.Closed
+	p.Reported = report.Time
+	p.IssueDone = report.Progress
+	p.Version++
+	return nil
+}
+
+// PlanningCreatedAt returns createdAt field for pid
+func (s *PlanningStorage) PlanningCreatedAt(_ context.Context, pid entities.PlanningID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.plannings[pid]
+	if p == nil {
+		return 0, entities.ErrInvalidPlanningID
+	}
+	return p.CreatedAt, nil
+}
+
+// LastActivity for user id
+func (s *PlanningStorage) LastActivity(_ context.Context, uid ctxtg.UserID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var last int64
+	for _, h := range s.histories {
+		if s.plannings[h.PlanningID].UserID == uid && h.EndedAt > last {
+			last = h.EndedAt
+		}
+	}
+	return last, nil
+}
+
+// Planning return planning by pid
+func (s *PlanningStorage) Planning(_ context.Context, pid entities.PlanningID) (*entities.Planning, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.plannings[pid]
+	if p == nil {
+		return nil, nil
+	}
+	planning := *p
+	return &planning, nil
+}
+
+// Plannings return plannings by pids sorted by creation time, unknown pids are skipped
+func (s *PlanningStorage) Plannings(_ context.Context, pids []entities.PlanningID) ([]entities.Planning, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ps []entities.Planning
+	for _, pid := range uniquePlanningIDs(pids) {
+		if p := s.plannings[pid]; p != nil {
+			ps = append(ps, *p)
+		}
+	}
+	sort.Sort(byCreatedAt(ps))
+	return ps, nil
+}
+
+// PlannedTimes returns all estimations of plannings sorted by creation time
+func (s *PlanningStorage) PlannedTimes(_ context.Context, pids []entities.PlanningID) ([]entities.PlannedTime, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := planningIDSet(pids)
+	var pts []entities.PlannedTime
+	for _, pt := range s.plannedTimes {
+		if set[pt.PlanningID] {
+			pts = append(pts, pt)
+		}
+	}
+	// plannedTimes are appended in order of creation
+	return pts, nil
+}
+
+// SpentTimeHistories returns all spent time histories of plannings sorted by start time
+func (s *PlanningStorage) SpentTimeHistories(_ context.Context, pids []entities.PlanningID) ([]entities.SpentTimeHistory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := planningIDSet(pids)
+	var hs []entities.SpentTimeHistory
+	for _, h := range s.histories {
+		if set[h.PlanningID] {
+			hs = append(hs, h)
+		}
+	}
+	sort.Stable(byStartedAt(hs))
+	return hs, nil
+}
+
+// OpenedPlannings returned all opened plannings for uid
+func (s *PlanningStorage) OpenedPlannings(_ context.Context, uid ctxtg.UserID) ([]entities.ExtendedPlanning, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ps []entities.Planning
+	for _, p := range s.plannings {
+		if p.UserID == uid && p.Status == entities.Open {
+			ps = append(ps, *p)
+		}
+	}
+	sort.Sort(byCreatedAt(ps))
+	var plannings []entities.ExtendedPlanning
+	for _, p := range ps {
+		ep := entities.ExtendedPlanning{Planning: p}
+		for _, pt := range s.plannedTimes {
+			if pt.PlanningID == p.ID {
+				ep.Estimation = pt.Estimation
+			}
+		}
+		for _, h := range s.histories {
+			if h.PlanningID == p.ID && h.EndedAt > ep.LastActivity {
+				ep.LastActivity = h.EndedAt
+			}
+		}
+		plannings = append(plannings, ep)
+	}
+	return plannings, nil
+}
+
+// SpentTimeByUserIDTimeRange return total spent time for user for time range
+func (s *PlanningStorage) SpentTimeByUserIDTimeRange(_ context.Context, uid ctxtg.UserID, from, to int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var spent int
+	for _, p := range s.plannings {
+		if p.UserID == uid && p.CreatedAt >= from && p.CreatedAt <= to {
+			spent += p.SpentOnline + p.SpentOffline
+		}
+	}
+	return spent, nil
+}
+
+// IsPeriodLocked checks if from - to overlaps submitted or approved timesheet of user
+func (s *PlanningStorage) IsPeriodLocked(_ context.Context, uid ctxtg.UserID, from, to int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isPeriodLocked(uid, from, to), nil
+}
+
+// SubmitTimesheet locks user's period t.From - t.To and registers it for approval
+func (s *PlanningStorage) SubmitTimesheet(_ context.Context, t entities.Timesheet) (entities.TimesheetID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isPeriodLocked(t.UserID, t.From, t.To) {
+		return 0, entities.ErrPeriodLocked
+	}
+	t.ID = entities.TimesheetID(s.nextID())
+	t.Status = entities.Submitted
+	t.ApproverID = 0
+	t.UpdatedAt = timeNowFunc()
+	s.timesheets = append(s.timesheets, t)
+	return t.ID, nil
+}
+
+// AddRate saves new hourly rate
+func (s *PlanningStorage) AddRate(_ context.Context, r entities.Rate) (entities.RateID, error) {
+	if r.Rate < 0 {
+		return 0, entities.ErrInvalidRate
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.ID = entities.RateID(s.nextID())
+	s.rates = append(s.rates, r)
+	return r.ID, nil
+}
+
+// Rates returns all hourly rates sorted by effective time
+func (s *PlanningStorage) Rates(_ context.Context) ([]entities.Rate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rates := append([]entities.Rate(nil), s.rates...)
+	sort.Stable(byEffectiveFrom(rates))
+	return rates, nil
+}
+
+// BillableWork returns spent time histories of billable plannings intersecting q.From - q.To
+func (s *PlanningStorage) BillableWork(_ context.Context, q entities.BillingQuery) ([]entities.BillableWork, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hs []entities.SpentTimeHistory
+	for _, h := range s.histories {
+		p := s.plannings[h.PlanningID]
+		if !p.Billable || h.EndedAt <= q.From || h.StartedAt >= q.To ||
+			(q.UserID != 0 && p.UserID != q.UserID) ||
+			(q.ProjectID != 0 && p.ProjectID != q.ProjectID) {
+			continue
+		}
+		hs = append(hs, h)
+	}
+	sort.Stable(byStartedAt(hs))
+	var ws []entities.BillableWork
+	for _, h := range hs {
+		p := s.plannings[h.PlanningID]
+		ws = append(ws, entities.BillableWork{
+			SpentTimeHistory: h,
+			UserID:           p.UserID,
+			ProjectID:        p.ProjectID,
+			ActivityID:       p.ActivityID,
+		})
+	}
+	return ws, nil
+}
+
+// nextID returns unique id, ids of all entities share same sequence
+func (s *PlanningStorage) nextID() int64 {
+	s.lastID++
+	return s.lastID
+}
+
+func (s *PlanningStorage) isPeriodLocked(uid ctxtg.UserID, from, to int64) bool {
+	for _, t := range s.timesheets {
+		if t.UserID == uid && t.To > from && t.From < to &&
+			(t.Status == entities.Submitted || t.Status == entities.Approved) {
+			return true
+		}
+	}
+	return false
+}
+
+func planningIDSet(pids []entities.PlanningID) map[entities.PlanningID]bool {
+	set := make(map[entities.PlanningID]bool, len(pids))
+	for _, pid := range pids {
+		set[pid] = true
+	}
+	return set
+}
+
+func uniquePlanningIDs(pids []entities.PlanningID) []entities.PlanningID {
+	var unique []entities.PlanningID
+	set := make(map[entities.PlanningID]bool, len(pids))
+	for _, pid := range pids {
+		if !set[pid] {
+			set[pid] = true
+			unique = append(unique, pid)
+		}
+	}
+	return unique
+}
+
+type byCreatedAt []entities.Planning
+
+func (ps byCreatedAt) Len() int      { return len(ps) }
+func (ps byCreatedAt) Swap(i, j int) { ps[i], ps[j] = ps[j], ps[i] }
+func (ps byCreatedAt) Less(i, j int) bool {
+	if ps[i].CreatedAt != ps[j].CreatedAt {
+		return ps[i].CreatedAt < ps[j].CreatedAt
+	}
+	return ps[i].ID < ps[j].ID
+}
+
+type byStartedAt []entities.SpentTimeHistory
+
+func (hs byStartedAt) Len() int           { return len(hs) }
+func (hs byStartedAt) Swap(i, j int)      { hs[i], hs[j] = hs[j], hs[i] }
+func (hs byStartedAt) Less(i, j int) bool { return hs[i].StartedAt < hs[j].StartedAt }
+
+type byEffectiveFrom []entities.Rate
+
+func (rs byEffectiveFrom) Len() int      { return len(rs) }
+func (rs byEffectiveFrom) Swap(i, j int) { rs[i], rs[j] = rs[j], rs[i] }
+func (rs byEffectiveFrom) Less(i, j int) bool {
+	if rs[i].EffectiveFrom != rs[j].EffectiveFrom {
+		return rs[i].EffectiveFrom < rs[j].EffectiveFrom
+	}
+	return rs[i].ID < rs[j].ID
+}
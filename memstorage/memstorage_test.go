@@ -0,0 +1,13 @@
+package memstorage
+
+import (
+	"testing"
+
+	"github.com/qarea/planningms/storage/storagetest"
+)
+
+func TestContract(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (storagetest.PlanningStorage, func()) {
+		return New(), func() {}
+	})
+}
@@ -0,0 +1,71 @@
+//go:build ignore
+// +build ignore
+
+// gen_embedded writes embedded.go with sql files of project for Embedded.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	root   = ".."
+	output = "embedded.go"
+)
+
+func main() {
+	var names []string
+	err := filepath.Walk(filepath.Join(root, "sql"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".sql" {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		names = append(names, filepath.ToSlash(name))
+		return err
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "// Code generated by gen_embedded.go; DO NOT EDIT.")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "package migration")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "// embeddedFiles are contents of sql files of project by path relative to project root")
+	fmt.Fprintln(&buf, "var embeddedFiles = map[string]string{")
+	for _, name := range names {
+		b, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(&buf, "%q: %s,\n", name, quote(string(b)))
+	}
+	fmt.Fprintln(&buf, "}")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// quote returns raw string literal if s can be kept as is
+func quote(s string) string {
+	if strings.ContainsAny(s, "`\r") {
+		return strconv.Quote(s)
+	}
+	return "`" + s + "`"
+}
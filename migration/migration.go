@@ -0,0 +1,189 @@
+// Package migration applies and rolls back sql schema migrations.
+//
+// Migrations are read from directory or embedded into binary from sql files of project
+// named like 003_create_feed_token_table.sql, where 003 is version of migration. File of version
+// which name starts with drop_ or remove_ rolls back migration, another one applies it.
+// Files contain statements separated by ; outside of quotes and comments, line
+// "DELIMITER //" changes separator for statements with ; inside like trigger bodies.
+// Applied versions are tracked in SchemaMigration table together with checksum of applied file.
+package migration
+
+//go:generate go run gen_embedded.go
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	createSchemaTableStmt = `
+		CREATE TABLE IF NOT EXISTS SchemaMigration (
+		  version           BIGINT        NOT NULL,
+		  name              VARCHAR(255)  NOT NULL,
+		  checksum          VARCHAR(64)   NOT NULL,
+		  applied_at        BIGINT        NOT NULL,
+		  PRIMARY KEY (version)
+		)
+	`
+	findAppliedStmt = `
+		SELECT *
+		  FROM SchemaMigration
+		 ORDER BY version ASC
+	`
+	saveAppliedStmt = `
+		INSERT INTO SchemaMigration (version,
+									 name,
+									 checksum,
+									 applied_at)
+		VALUES						(:version,
+									 :name,
+									 :checksum,
+									 :applied_at)
+	`
+	deleteAppliedStmt = `
+		DELETE FROM SchemaMigration
+		 WHERE version = ?
+	`
+)
+
+var fileNameRe = regexp.MustCompile(`^(\d+)_(\w+)\.sql$`)
+
+// driverDirs are dirs of project sql files embedded for each storage driver
+var driverDirs = map[string]string{
+	"mysql":    "sql",
+	"postgres": "sql/postgres",
+	"sqlite3":  "sql/sqlite",
+}
+
+// Migration is pair of files applying and rolling back one version of schema,
+// Up and Down are paths of files
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+
+	up   string
+	down string
+}
+
+// Applied is migration recorded in SchemaMigration table
+type Applied struct {
+	Version   int    `db:"version"`
+	Name      string `db:"name"`
+	Checksum  string `db:"checksum"`
+	AppliedAt int64  `db:"applied_at"`
+}
+
+// Status describes migration and whether it was applied
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Load reads migrations from dir sorted by version
+func Load(dir string) ([]Migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read migrations dir")
+	}
+	contents := make(map[string]string)
+	for _, f := range files {
+		if f.IsDir() || !fileNameRe.MatchString(f.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", path)
+		}
+		contents[path] = string(b)
+	}
+	return parse(contents)
+}
+
+// Embedded returns migrations of driver embedded into binary from sql files of project
+func Embedded(driver string) ([]Migration, error) {
+	dir, ok := driverDirs[driver]
+	if !ok {
+		return nil, errors.Errorf("no migrations for driver %q", driver)
+	}
+	contents := make(map[string]string)
+	for name, content := range embeddedFiles {
+		if path.Dir(name) == dir {
+			contents[name] = content
+		}
+	}
+	return parse(contents)
+}
+
+// parse returns migrations sorted by version from contents of files by path
+func parse(contents map[string]string) ([]Migration, error) {
+	byVersion := make(map[int]*Migration)
+	for path, content := range contents {
+		m := fileNameRe.FindStringSubmatch(filepath.Base(path))
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid version of %s", path)
+		}
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version}
+			byVersion[version] = migration
+		}
+		if isDown(m[2]) {
+			if migration.Down != "" {
+				return nil, errors.Errorf("two rollback files for version %d", version)
+			}
+			migration.Down = path
+			migration.down = content
+			continue
+		}
+		if migration.Up != "" {
+			return nil, errors.Errorf("two migration files for version %d", version)
+		}
+		migration.Up = path
+		migration.up = content
+		migration.Name = m[2]
+		migration.Checksum = checksum(content)
+	}
+	var ms []Migration
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, errors.Errorf("no migration file for version %d", m.Version)
+		}
+		ms = append(ms, *m)
+	}
+	sort.Sort(byVersionAsc(ms))
+	return ms, nil
+}
+
+func isDown(name string) bool {
+	return strings.HasPrefix(name, "drop_") || strings.HasPrefix(name, "remove_")
+}
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+type byVersionAsc []Migration
+
+func (ms byVersionAsc) Len() int           { return len(ms) }
+func (ms byVersionAsc) Swap(i, j int)      { ms[i], ms[j] = ms[j], ms[i] }
+func (ms byVersionAsc) Less(i, j int) bool { return ms[i].Version < ms[j].Version }
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%03d_%s", m.Version, m.Name)
+}
@@ -0,0 +1,302 @@
+package migration
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var testFiles = map[string]string{
+	"000_create_a.sql": "CREATE TABLE A (id INT NOT NULL);\nINSERT INTO A VALUES (1);\n",
+	"000_drop_a.sql":   "DROP TABLE A;\n",
+	"001_add_b.sql":    "CREATE TABLE B (id INT NOT NULL);\n",
+	"001_remove_b.sql": "DROP TABLE B;\n",
+	"README":           "not a migration",
+}
+
+func prepareDir(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "migration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func newTestRunner(t *testing.T, dir string) *Runner {
+	db := sqlx.MustConnect("sqlite3", ":memory:")
+	db.SetMaxOpenConns(1)
+	ms, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRunner(db, ms)
+}
+
+func tableExists(t *testing.T, r *Runner, table string) bool {
+	var n int
+	err := r.db.Get(&n, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+func appliedVersions(t *testing.T, r *Runner) []bool {
+	ss, err := r.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vs []bool
+	for _, s := range ss {
+		vs = append(vs, s.Applied)
+	}
+	return vs
+}
+
+func TestLoad(t *testing.T) {
+	dir, cleanup := prepareDir(t, testFiles)
+	defer cleanup()
+	ms, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("unexpected migrations %+v", ms)
+	}
+	if ms[0].Version != 0 || ms[0].Name != "create_a" || filepath.Base(ms[0].Down) != "000_drop_a.sql" {
+		t.Errorf("unexpected migration %+v", ms[0])
+	}
+	if ms[1].Version != 1 || ms[1].Name != "add_b" || filepath.Base(ms[1].Up) != "001_add_b.sql" {
+		t.Errorf("unexpected migration %+v", ms[1])
+	}
+	if ms[0].Checksum == "" || ms[0].Checksum == ms[1].Checksum {
+		t.Errorf("unexpected checksums %s, %s", ms[0].Checksum, ms[1].Checksum)
+	}
+}
+
+func TestLoadWithoutUp(t *testing.T) {
+	dir, cleanup := prepareDir(t, map[string]string{"002_drop_c.sql": "DROP TABLE C;"})
+	defer cleanup()
+	if _, err := Load(dir); err == nil {
+		t.Error("should fail without migration file")
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	dir, cleanup := prepareDir(t, testFiles)
+	defer cleanup()
+	r := newTestRunner(t, dir)
+	if err := r.Up(0); err != nil {
+		t.Fatal(err)
+	}
+	if !tableExists(t, r, "A") || tableExists(t, r, "B") {
+		t.Error("only first migration should be applied")
+	}
+	if err := r.Up(-1); err != nil {
+		t.Fatal(err)
+	}
+	if vs := appliedVersions(t, r); !vs[0] || !vs[1] {
+		t.Errorf("unexpected status %v", vs)
+	}
+	if err := r.Up(-1); err != nil {
+		t.Errorf("applied migrations should be skipped: %v", err)
+	}
+	if err := r.Down(); err != nil {
+		t.Fatal(err)
+	}
+	if !tableExists(t, r, "A") || tableExists(t, r, "B") {
+		t.Error("only last migration should be rolled back")
+	}
+	if vs := appliedVersions(t, r); !vs[0] || vs[1] {
+		t.Errorf("unexpected status %v", vs)
+	}
+	if err := r.Down(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Down(); err == nil {
+		t.Error("should fail without applied migrations")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	dir, cleanup := prepareDir(t, testFiles)
+	defer cleanup()
+	r := newTestRunner(t, dir)
+	r.DryRun = true
+	var logged []string
+	r.Logf = func(format string, args ...interface{}) {
+		logged = append(logged, format)
+	}
+	if err := r.Up(-1); err != nil {
+		t.Fatal(err)
+	}
+	if tableExists(t, r, "A") || tableExists(t, r, "B") {
+		t.Error("dry run should not execute migrations")
+	}
+	if vs := appliedVersions(t, r); vs[0] || vs[1] {
+		t.Errorf("dry run should not record migrations %v", vs)
+	}
+	// apply, 2 statements, apply, 1 statement
+	if len(logged) != 5 {
+		t.Errorf("unexpected log %v", logged)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	dir, cleanup := prepareDir(t, testFiles)
+	defer cleanup()
+	r := newTestRunner(t, dir)
+	if err := r.Up(-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Verify(); err != nil {
+		t.Error(err)
+	}
+	err := ioutil.WriteFile(filepath.Join(dir, "001_add_b.sql"), []byte("CREATE TABLE C (id INT);"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.migrations, err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Verify(); err == nil {
+		t.Error("should fail on changed migration")
+	}
+	if err := r.Up(-1); err == nil {
+		t.Error("should not apply with changed migration")
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	dir, cleanup := prepareDir(t, testFiles)
+	defer cleanup()
+	r := newTestRunner(t, dir)
+	r.Baseline = 0
+	if err := r.Up(-1); err != nil {
+		t.Fatal(err)
+	}
+	if tableExists(t, r, "A") || !tableExists(t, r, "B") {
+		t.Error("baseline migration should not be executed")
+	}
+	if vs := appliedVersions(t, r); !vs[0] || !vs[1] {
+		t.Errorf("unexpected status %v", vs)
+	}
+}
+
+func TestProjectMigrations(t *testing.T) {
+	for driver, dir := range driverDirs {
+		ms, err := Load(filepath.Join("..", dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range ms {
+			if m.Down == "" {
+				t.Errorf("no rollback file for %s in %s", m, dir)
+			}
+		}
+		embedded, err := Embedded(driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(embedded) != len(ms) {
+			t.Fatalf("embedded migrations of %s are outdated, run go generate", driver)
+		}
+		for i, m := range embedded {
+			if m.Checksum != ms[i].Checksum || m.down != ms[i].down {
+				t.Errorf("embedded %s of %s is outdated, run go generate", m, driver)
+			}
+		}
+	}
+	r, err := NewDriverRunner(sqlx.MustConnect("sqlite3", ":memory:"), "sqlite3", "", NoBaseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.db.SetMaxOpenConns(1)
+	if err := r.Up(-1); err != nil {
+		t.Fatal(err)
+	}
+	if !tableExists(t, r, "Planning") {
+		t.Error("schema should be created")
+	}
+	for range r.migrations {
+		if err := r.Down(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tableExists(t, r, "Planning") {
+		t.Error("schema should be dropped")
+	}
+}
+
+// shippedBaseline returns migrations/baseline added by narada migrate file
+func shippedBaseline(t *testing.T) int {
+	b, err := ioutil.ReadFile("../migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`(?m)^add_config\s+migrations/baseline\s+(\d+)$`).FindSubmatch(b)
+	if m == nil {
+		t.Fatal("no migrations/baseline in migrate")
+	}
+	baseline, err := strconv.Atoi(string(m[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return baseline
+}
+
+func TestShippedBaseline(t *testing.T) {
+	baseline := shippedBaseline(t)
+	r, err := NewDriverRunner(sqlx.MustConnect("sqlite3", ":memory:"), "sqlite3", "", baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.db.SetMaxOpenConns(1)
+	if err := r.Up(-1); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := r.db.Get(&n, "SELECT COUNT(*) FROM Planning WHERE version = 0"); err != nil {
+		t.Errorf("schema of empty database should be created: %v", err)
+	}
+	r, err = NewDriverRunner(nil, LegacyDriver, "", baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Baseline != baseline {
+		t.Errorf("baseline of %s should be %d, got %d", LegacyDriver, baseline, r.Baseline)
+	}
+}
+
+func TestStatements(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"A;\n B ;\n\n", []string{"A", "B"}},
+		{"INSERT INTO A VALUES ('a;b', \"c;d\", `e;f`);", []string{"INSERT INTO A VALUES ('a;b', \"c;d\", `e;f`)"}},
+		{"SELECT 'it''s;', 'a\\';b'; C", []string{"SELECT 'it''s;', 'a\\';b'", "C"}},
+		{"-- a; b\nA; /* c; d */ B;\n-- e;\n", []string{"-- a; b\nA", "/* c; d */ B"}},
+		{"CREATE FUNCTION f() AS $$ BEGIN x; END $$; B", []string{"CREATE FUNCTION f() AS $$ BEGIN x; END $$", "B"}},
+		{"DELIMITER //\nCREATE TRIGGER t BEGIN a; b; END//\nDELIMITER ;\nC;", []string{"CREATE TRIGGER t BEGIN a; b; END", "C"}},
+	}
+	for _, test := range tests {
+		if got := statements(test.content); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("statements(%q) = %q, want %q", test.content, got, test.want)
+		}
+	}
+}
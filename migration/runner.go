@@ -0,0 +1,241 @@
+package migration
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+var timeNowFunc = func() int64 {
+	return time.Now().Unix()
+}
+
+// NoBaseline disables baseline of Runner
+const NoBaseline = -1
+
+// LegacyDriver is driver of databases migrated by App::migrate of narada before Runner
+const LegacyDriver = "mysql"
+
+// Runner applies migrations to db
+type Runner struct {
+	db         *sqlx.DB
+	migrations []Migration
+
+	// DryRun reports statements instead of executing them, only SchemaMigration table is created
+	DryRun bool
+	// Baseline is version up to which migrations are recorded as applied without execution
+	// when SchemaMigration table is empty, it's used for databases created before Runner
+	Baseline int
+	// Logf reports every applied or rolled back migration and statements in DryRun mode
+	Logf func(format string, args ...interface{})
+}
+
+// NewRunner returns Runner for migrations ms
+func NewRunner(db *sqlx.DB, ms []Migration) *Runner {
+	return &Runner{
+		db:         db,
+		migrations: ms,
+		Baseline:   NoBaseline,
+		Logf:       func(string, ...interface{}) {},
+	}
+}
+
+// NewDriverRunner returns Runner for migrations of driver read from dir or embedded into
+// binary if dir is empty. Baseline is set only for LegacyDriver, databases of another
+// drivers were never migrated by App::migrate, so their Runner applies all migrations
+func NewDriverRunner(db *sqlx.DB, driver, dir string, baseline int) (*Runner, error) {
+	var ms []Migration
+	var err error
+	if dir != "" {
+		ms, err = Load(dir)
+	} else {
+		ms, err = Embedded(driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := NewRunner(db, ms)
+	if driver == LegacyDriver {
+		r.Baseline = baseline
+	}
+	return r, nil
+}
+
+// Status returns all migrations and applied state of each one
+func (r *Runner) Status() ([]Status, error) {
+	applied, err := r.applied()
+	if err != nil {
+		return nil, err
+	}
+	var ss []Status
+	for _, m := range r.migrations {
+		_, ok := applied[m.Version]
+		ss = append(ss, Status{Migration: m, Applied: ok})
+	}
+	return ss, nil
+}
+
+// Verify returns error if any applied migration is unknown or its file was changed after applying
+func (r *Runner) Verify() error {
+	applied, err := r.applied()
+	if err != nil {
+		return err
+	}
+	return r.verify(applied)
+}
+
+// Up applies all not applied migrations with version up to `to` in order of versions,
+// negative `to` means all migrations
+func (r *Runner) Up(to int) error {
+	applied, err := r.applied()
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 && r.Baseline != NoBaseline {
+		if applied, err = r.baseline(); err != nil {
+			return errors.Wrap(err, "failed to record baseline")
+		}
+	}
+	if err := r.verify(applied); err != nil {
+		return err
+	}
+	for _, m := range r.migrations {
+		if _, ok := applied[m.Version]; ok || (to >= 0 && m.Version > to) {
+			continue
+		}
+		if err := r.apply(m); err != nil {
+			return errors.Wrapf(err, "failed to apply %s", m)
+		}
+	}
+	return nil
+}
+
+// Down rolls back latest applied migration
+func (r *Runner) Down() error {
+	applied, err := r.applied()
+	if err != nil {
+		return err
+	}
+	if err := r.verify(applied); err != nil {
+		return err
+	}
+	for i := len(r.migrations) - 1; i >= 0; i-- {
+		m := r.migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return errors.Errorf("no rollback file for %s", m)
+		}
+		if err := r.rollback(m); err != nil {
+			return errors.Wrapf(err, "failed to roll back %s", m)
+		}
+		return nil
+	}
+	return errors.New("no applied migrations")
+}
+
+func (r *Runner) applied() (map[int]Applied, error) {
+	if _, err := r.db.Exec(createSchemaTableStmt); err != nil {
+		return nil, errors.Wrap(err, "failed to create schema table")
+	}
+	var as []Applied
+	if err := r.db.Select(&as, findAppliedStmt); err != nil {
+		return nil, errors.Wrap(err, "failed to load applied migrations")
+	}
+	applied := make(map[int]Applied)
+	for _, a := range as {
+		applied[a.Version] = a
+	}
+	return applied, nil
+}
+
+func (r *Runner) verify(applied map[int]Applied) error {
+	known := make(map[int]Migration)
+	for _, m := range r.migrations {
+		known[m.Version] = m
+	}
+	for _, a := range applied {
+		m, ok := known[a.Version]
+		if !ok {
+			return errors.Errorf("applied migration %03d_%s is unknown", a.Version, a.Name)
+		}
+		if m.Checksum != a.Checksum {
+			return errors.Errorf("checksum of %s differs from applied one", m)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) baseline() (map[int]Applied, error) {
+	applied := make(map[int]Applied)
+	for _, m := range r.migrations {
+		if m.Version > r.Baseline {
+			break
+		}
+		a := newApplied(m)
+		r.Logf("baseline %s", m)
+		if !r.DryRun {
+			if _, err := r.db.NamedExec(saveAppliedStmt, a); err != nil {
+				return nil, err
+			}
+		}
+		applied[m.Version] = a
+	}
+	return applied, nil
+}
+
+func (r *Runner) apply(m Migration) error {
+	r.Logf("apply %s", m)
+	return r.execute(m.up, func(tx *sqlx.Tx) error {
+		_, err := tx.NamedExec(saveAppliedStmt, newApplied(m))
+		return err
+	})
+}
+
+func (r *Runner) rollback(m Migration) error {
+	r.Logf("roll back %s", m)
+	return r.execute(m.down, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(tx.Rebind(deleteAppliedStmt), m.Version)
+		return err
+	})
+}
+
+// execute runs statements of file content and record in single transaction,
+// it's atomic only for databases with transactional DDL
+func (r *Runner) execute(content string, record func(*sqlx.Tx) error) error {
+	stmts := statements(content)
+	if r.DryRun {
+		for _, stmt := range stmts {
+			r.Logf("%s;", stmt)
+		}
+		return nil
+	}
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+	defer tx.Rollback()
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return errors.Wrapf(err, "failed to execute %q", stmt)
+		}
+	}
+	if err := record(tx); err != nil {
+		return errors.Wrap(err, "failed to record migration")
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+	return nil
+}
+
+func newApplied(m Migration) Applied {
+	return Applied{
+		Version:   m.Version,
+		Name:      m.Name,
+		Checksum:  m.Checksum,
+		AppliedAt: timeNowFunc(),
+	}
+}
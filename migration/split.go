@@ -0,0 +1,89 @@
+package migration
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var dollarTagRe = regexp.MustCompile(`^\$[A-Za-z_]*\$`)
+
+// statements splits content of file into statements separated by delimiter outside of
+// quotes, comments and PostgreSQL dollar quotes. Delimiter is ; until changed by line
+// "DELIMITER //" like in mysql client, statements with comments only are skipped
+func statements(content string) []string {
+	var stmts []string
+	delim := ";"
+	start, hasCode := 0, false
+	flush := func(end int) {
+		if hasCode {
+			stmts = append(stmts, strings.TrimSpace(content[start:end]))
+		}
+	}
+	for i := 0; i < len(content); {
+		if i == 0 || content[i-1] == '\n' {
+			line := content[i:]
+			if n := strings.IndexByte(line, '\n'); n >= 0 {
+				line = line[:n]
+			}
+			if f := strings.Fields(line); len(f) == 2 && strings.EqualFold(f[0], "DELIMITER") {
+				flush(i)
+				delim = f[1]
+				i += len(line)
+				start, hasCode = i, false
+				continue
+			}
+		}
+		c := content[i]
+		rest := content[i:]
+		switch {
+		case strings.HasPrefix(rest, delim):
+			flush(i)
+			i += len(delim)
+			start, hasCode = i, false
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(content, i)
+			hasCode = true
+		case strings.HasPrefix(rest, "--"):
+			i = skipPast(content, i, "\n")
+		case strings.HasPrefix(rest, "/*"):
+			i = skipPast(content, i+2, "*/")
+		case dollarTagRe.MatchString(rest):
+			tag := dollarTagRe.FindString(rest)
+			i = skipPast(content, i+len(tag), tag)
+			hasCode = true
+		default:
+			hasCode = hasCode || !unicode.IsSpace(rune(c))
+			i++
+		}
+	}
+	flush(len(content))
+	return stmts
+}
+
+// skipQuoted returns index after string quoted by content[i], quote is escaped by
+// doubling it or by backslash
+func skipQuoted(content string, i int) int {
+	quote := content[i]
+	for i++; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(content) && content[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(content)
+}
+
+// skipPast returns index after first end found in content from i or length of content
+func skipPast(content string, i int, end string) int {
+	if n := strings.Index(content[i:], end); n >= 0 {
+		return i + n + len(end)
+	}
+	return len(content)
+}
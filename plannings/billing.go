@@ -0,0 +1,168 @@
+package plannings
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/qarea/planningms/entities"
+)
+
+const secondsInHour = 60 * 60
+
+// BillingReport returns billable spent time of q.From - q.To multiplied by hourly rates.
+// Histories are cut by report period and split proportionally when applicable rate changes inside of them.
+// Rate applicable at moment is the most specific matching rate effective at that moment,
+// user is more specific than project and project is more specific than activity,
+// the latest one is used from rates with same specificity.
+// Spent time without applicable rate is reported with zero rate.
+// Returns err if time range is invalid.
+func (s *Service) BillingReport(ctx context.Context, q entities.BillingQuery) (*entities.BillingReport, error) {
+	if q.To <= q.From {
+		return nil, entities.ErrInvalidTimeRange
+	}
+	rates, err := s.planningStorage.Rates(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load rates")
+	}
+	ws, err := s.planningStorage.BillableWork(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load billable work")
+	}
+	return billingReport(q, rates, ws), nil
+}
+
+func billingReport(q entities.BillingQuery, rates []entities.Rate, ws []entities.BillableWork) *entities.BillingReport {
+	// lines are keyed by BillingLine without Spent and Amount,
+	// costs are accumulated per second to round amount once per line
+	spent := make(map[entities.BillingLine]int64)
+	costs := make(map[entities.BillingLine]int64)
+	for _, w := range ws {
+		matching := matchingRates(rates, w)
+		for _, seg := range splitByRates(w.SpentTimeHistory, q.From, q.To, matching) {
+			if seg.spent == 0 {
+				continue
+			}
+			key := entities.BillingLine{
+				UserID:     w.UserID,
+				ProjectID:  w.ProjectID,
+				ActivityID: w.ActivityID,
+				Rate:       seg.rate,
+			}
+			spent[key] += seg.spent
+			costs[key] += seg.spent * seg.rate
+		}
+	}
+	report := &entities.BillingReport{}
+	for line, sp := range spent {
+		cost := costs[line]
+		line.Spent = sp
+		line.Amount = cost / secondsInHour
+		report.Lines = append(report.Lines, line)
+		report.Spent += line.Spent
+		report.Amount += line.Amount
+	}
+	sort.Sort(billingLines(report.Lines))
+	return report
+}
+
+type billingLines []entities.BillingLine
+
+func (ls billingLines) Len() int      { return len(ls) }
+func (ls billingLines) Swap(i, j int) { ls[i], ls[j] = ls[j], ls[i] }
+func (ls billingLines) Less(i, j int) bool {
+	a, b := ls[i], ls[j]
+	switch {
+	case a.UserID != b.UserID:
+		return a.UserID < b.UserID
+	case a.ProjectID != b.ProjectID:
+		return a.ProjectID < b.ProjectID
+	case a.ActivityID != b.ActivityID:
+		return a.ActivityID < b.ActivityID
+	}
+	return a.Rate < b.Rate
+}
+
+func matchingRates(rates []entities.Rate, w entities.BillableWork) []entities.Rate {
+	var matching []entities.Rate
+	for _, r := range rates {
+		if (r.UserID == 0 || r.UserID == w.UserID) &&
+			(r.ProjectID == 0 || r.ProjectID == w.ProjectID) &&
+			(r.ActivityID == 0 || r.ActivityID == w.ActivityID) {
+			matching = append(matching, r)
+		}
+	}
+	return matching
+}
+
+type billingSegment struct {
+	rate  int64
+	spent int64
+}
+
+// splitByRates cuts h by from - to and splits it on effective times of rates,
+// rates should be sorted by effective time
+func splitByRates(h entities.SpentTimeHistory, from, to int64, rates []entities.Rate) []billingSegment {
+	if h.EndedAt <= h.StartedAt {
+		if h.StartedAt < from || h.StartedAt >= to {
+			return nil
+		}
+		return []billingSegment{{rate: rateAt(rates, h.StartedAt), spent: int64(h.Spent)}}
+	}
+	start, end := h.StartedAt, h.EndedAt
+	if start < from {
+		start = from
+	}
+	if end > to {
+		end = to
+	}
+	if start >= end {
+		return nil
+	}
+	bounds := []int64{start}
+	for _, r := range rates {
+		if r.EffectiveFrom > bounds[len(bounds)-1] && r.EffectiveFrom < end {
+			bounds = append(bounds, r.EffectiveFrom)
+		}
+	}
+	bounds = append(bounds, end)
+	var segments []billingSegment
+	for i := 0; i+1 < len(bounds); i++ {
+		segments = append(segments, billingSegment{
+			rate:  rateAt(rates, bounds[i]),
+			spent: spentUntil(h, bounds[i+1]) - spentUntil(h, bounds[i]),
+		})
+	}
+	return segments
+}
+
+func rateAt(rates []entities.Rate, t int64) int64 {
+	var best *entities.Rate
+	for i, r := range rates {
+		if r.EffectiveFrom > t {
+			continue
+		}
+		if best == nil || specificity(r) > specificity(*best) ||
+			(specificity(r) == specificity(*best) && r.EffectiveFrom >= best.EffectiveFrom) {
+			best = &rates[i]
+		}
+	}
+	if best == nil {
+		return 0
+	}
+	return best.Rate
+}
+
+func specificity(r entities.Rate) int {
+	var s int
+	if r.UserID != 0 {
+		s += 4
+	}
+	if r.ProjectID != 0 {
+		s += 2
+	}
+	if r.ActivityID != 0 {
+		s++
+	}
+	return s
+}
@@ -0,0 +1,124 @@
+package plannings
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qarea/planningms/entities"
+)
+
+func TestBillingReportInvalidRange(t *testing.T) {
+	svc := &Service{}
+	for _, q := range []entities.BillingQuery{
+		{From: 10, To: 10},
+		{From: 20, To: 10},
+	} {
+		_, err := svc.BillingReport(ctx, q)
+		if err != entities.ErrInvalidTimeRange {
+			t.Errorf("Invalid err %v for %+v", err, q)
+		}
+	}
+}
+
+func TestBillingReportPlanningStorageErr(t *testing.T) {
+	ps := newPlanningStorage()
+	ps.err = errors.New("planning storage err")
+	svc := &Service{
+		planningStorage: ps,
+	}
+	_, err := svc.BillingReport(ctx, entities.BillingQuery{To: 10})
+	if errors.Cause(err) != ps.err {
+		t.Error("Invalid err", err)
+	}
+}
+
+func TestBillingReport(t *testing.T) {
+	uid := randomUserID()
+	ps := newPlanningStorage()
+	ps.rates = []entities.Rate{
+		{Rate: 3600, EffectiveFrom: 0},
+		{ProjectID: 1, Rate: 7200, EffectiveFrom: 0},
+		{UserID: uid, ActivityID: 5, Rate: 36000, EffectiveFrom: 100},
+		{ProjectID: 1, Rate: 10800, EffectiveFrom: 200},
+	}
+	ps.billableWork = []entities.BillableWork{
+		{
+			SpentTimeHistory: entities.SpentTimeHistory{Spent: 100, StartedAt: 150, EndedAt: 250},
+			UserID:           uid,
+			ProjectID:        1,
+		},
+		{
+			SpentTimeHistory: entities.SpentTimeHistory{Spent: 50, StartedAt: 50, EndedAt: 150},
+			UserID:           uid,
+			ProjectID:        2,
+			ActivityID:       5,
+		},
+		{
+			SpentTimeHistory: entities.SpentTimeHistory{Spent: 40, StartedAt: 260, EndedAt: 300},
+			UserID:           uid + 1,
+			ProjectID:        2,
+		},
+	}
+	svc := &Service{
+		planningStorage: ps,
+	}
+	q := entities.BillingQuery{UserID: 0, From: 100, To: 280}
+	report, err := svc.BillingReport(ctx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.billing != q {
+		t.Errorf("Invalid query passed %+v", ps.billing)
+	}
+	expected := &entities.BillingReport{
+		Lines: []entities.BillingLine{
+			{UserID: uid, ProjectID: 1, Rate: 7200, Spent: 50, Amount: 100},
+			{UserID: uid, ProjectID: 1, Rate: 10800, Spent: 50, Amount: 150},
+			{UserID: uid, ProjectID: 2, ActivityID: 5, Rate: 36000, Spent: 25, Amount: 250},
+			{UserID: uid + 1, ProjectID: 2, Rate: 3600, Spent: 20, Amount: 20},
+		},
+		Spent:  145,
+		Amount: 520,
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("Invalid report\n%+v\n%+v", report, expected)
+	}
+}
+
+func TestRateAt(t *testing.T) {
+	rates := []entities.Rate{
+		{Rate: 1, EffectiveFrom: 0},
+		{ActivityID: 1, Rate: 2, EffectiveFrom: 10},
+		{ProjectID: 1, Rate: 3, EffectiveFrom: 20},
+		{UserID: 1, Rate: 4, EffectiveFrom: 30},
+		{ProjectID: 1, Rate: 5, EffectiveFrom: 40},
+	}
+	for tm, rate := range map[int64]int64{
+		-1: 0,
+		5:  1,
+		10: 2,
+		25: 3,
+		35: 4,
+		45: 4,
+	} {
+		if r := rateAt(rates, tm); r != rate {
+			t.Error("Invalid rate", tm, r)
+		}
+	}
+}
+
+func TestSplitByRatesInstant(t *testing.T) {
+	rates := []entities.Rate{{Rate: 10, EffectiveFrom: 0}}
+	h := entities.SpentTimeHistory{Spent: 5, StartedAt: 10, EndedAt: 10}
+	segments := splitByRates(h, 0, 20, rates)
+	if !reflect.DeepEqual(segments, []billingSegment{{rate: 10, spent: 5}}) {
+		t.Errorf("Invalid segments %+v", segments)
+	}
+	if segments := splitByRates(h, 10, 20, rates); len(segments) != 1 {
+		t.Errorf("Invalid segments %+v", segments)
+	}
+	if segments := splitByRates(h, 0, 10, rates); segments != nil {
+		t.Errorf("Invalid segments %+v", segments)
+	}
+}
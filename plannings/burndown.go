@@ -0,0 +1,150 @@
+package plannings
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/qarea/planningms/entities"
+)
+
+const maxBurndownPoints = 1000
+
+// Burndown returns estimation, spent time, remaining estimation and progress of plannings
+// sampled every q.Step seconds from q.From to q.To inclusive.
+// Spent time of histories is split proportionally when sample time is inside of interval,
+// running spent time of user is counted as online history.
+// Progress is average issue progress of plannings created at sample time:
+// open plannings count their current progress, closed ones count reported progress after closing.
+// Returns err if:
+// - invalid time range or too many points requested
+// - any of q.PlanningIDs doesn't exist or belongs to another user
+func (s *Service) Burndown(ctx context.Context, q entities.BurndownQuery) ([]entities.BurndownPoint, error) {
+	if q.Step <= 0 || q.To < q.From || (q.To-q.From)/q.Step >= maxBurndownPoints {
+		return nil, entities.ErrInvalidTimeRange
+	}
+	ps, err := s.burndownPlannings(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if len(ps) == 0 {
+		return sampleBurndown(q, ps, nil, nil), nil
+	}
+	var pids []entities.PlanningID
+	for _, p := range ps {
+		pids = append(pids, p.ID)
+	}
+	pts, err := s.planningStorage.PlannedTimes(ctx, pids)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load planned times")
+	}
+	hs, err := s.planningStorage.SpentTimeHistories(ctx, pids)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load spent time histories")
+	}
+	err = s.spentTimeStorage.Modify(ctx, q.UserID, ifNotEmpty(func(_ context.Context, st entities.SpentTime) (*entities.SpentTime, error) {
+		for _, pid := range pids {
+			if st.PlanningID == pid {
+				hs = append(hs, spentTimeToHistory(st, entities.Online))
+			}
+		}
+		return &st, nil
+	}))
+	if err != nil {
+		return nil, errors.Wrap(err, "cache error")
+	}
+	return sampleBurndown(q, ps, pts, hs), nil
+}
+
+func (s *Service) burndownPlannings(ctx context.Context, q entities.BurndownQuery) ([]entities.Planning, error) {
+	if len(q.PlanningIDs) == 0 {
+		eps, err := s.planningStorage.OpenedPlannings(ctx, q.UserID)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load opened plannings")
+		}
+		var ps []entities.Planning
+		for _, p := range eps {
+			ps = append(ps, p.Planning)
+		}
+		return ps, nil
+	}
+	requested := make(map[entities.PlanningID]bool)
+	var pids []entities.PlanningID
+	for _, pid := range q.PlanningIDs {
+		if !requested[pid] {
+			requested[pid] = true
+			pids = append(pids, pid)
+		}
+	}
+	ps, err := s.planningStorage.Plannings(ctx, pids)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load plannings")
+	}
+	if len(ps) != len(pids) {
+		return nil, entities.ErrInvalidPlanningID
+	}
+	for _, p := range ps {
+		if p.UserID != q.UserID {
+			return nil, entities.ErrInvalidUserID
+		}
+	}
+	return ps, nil
+}
+
+func sampleBurndown(q entities.BurndownQuery, ps []entities.Planning, pts []entities.PlannedTime, hs []entities.SpentTimeHistory) []entities.BurndownPoint {
+	var points []entities.BurndownPoint
+	for t := q.From; t < q.To; t += q.Step {
+		points = append(points, burndownPoint(t, ps, pts, hs))
+	}
+	return append(points, burndownPoint(q.To, ps, pts, hs))
+}
+
+// burndownPoint expects pts sorted by creation time
+func burndownPoint(t int64, ps []entities.Planning, pts []entities.PlannedTime, hs []entities.SpentTimeHistory) entities.BurndownPoint {
+	estimations := make(map[entities.PlanningID]int64)
+	for _, pt := range pts {
+		if pt.CreatedAt <= t {
+			estimations[pt.PlanningID] = pt.Estimation
+		}
+	}
+	spent := make(map[entities.PlanningID]int64)
+	for _, h := range hs {
+		spent[h.PlanningID] += spentUntil(h, t)
+	}
+	point := entities.BurndownPoint{Time: t}
+	var progress, amount int
+	for _, p := range ps {
+		if p.CreatedAt > t {
+			continue
+		}
+		amount++
+		point.Estimation += estimations[p.ID]
+		point.Spent += spent[p.ID]
+		if estimations[p.ID] > spent[p.ID] {
+			point.Remaining += estimations[p.ID] - spent[p.ID]
+		}
+		switch {
+		case p.Status == entities.Closed && p.Reported <= t:
+			point.Closed++
+			progress += p.IssueDone
+		case p.Status == entities.Closed:
+			point.Open++
+		default:
+			point.Open++
+			progress += p.IssueDone
+		}
+	}
+	if amount > 0 {
+		point.Progress = progress / amount
+	}
+	return point
+}
+
+func spentUntil(h entities.SpentTimeHistory, t int64) int64 {
+	switch {
+	case h.EndedAt <= t:
+		return int64(h.Spent)
+	case h.StartedAt >= t:
+		return 0
+	}
+	return int64(h.Spent) * (t - h.StartedAt) / (h.EndedAt - h.StartedAt)
+}
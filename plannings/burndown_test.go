@@ -0,0 +1,201 @@
+package plannings
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qarea/planningms/entities"
+)
+
+func TestBurndownInvalidRange(t *testing.T) {
+	svc := &Service{}
+	for _, q := range []entities.BurndownQuery{
+		{From: 10, To: 20, Step: 0},
+		{From: 10, To: 20, Step: -1},
+		{From: 20, To: 10, Step: 1},
+		{From: 0, To: maxBurndownPoints, Step: 1},
+	} {
+		_, err := svc.Burndown(ctx, q)
+		if err != entities.ErrInvalidTimeRange {
+			t.Errorf("Invalid err %v for %+v", err, q)
+		}
+	}
+}
+
+func TestBurndownInvalidPlanningID(t *testing.T) {
+	uid := randomUserID()
+	ps := newPlanningStorage()
+	ps.addPlanning(entities.Planning{ID: 1, UserID: uid})
+	svc := &Service{
+		planningStorage: ps,
+	}
+	_, err := svc.Burndown(ctx, entities.BurndownQuery{
+		UserID:      uid,
+		PlanningIDs: []entities.PlanningID{1, 2},
+		To:          10,
+		Step:        10,
+	})
+	if err != entities.ErrInvalidPlanningID {
+		t.Error("Invalid err", err)
+	}
+}
+
+func TestBurndownInvalidUserID(t *testing.T) {
+	uid := randomUserID()
+	ps := newPlanningStorage()
+	ps.addPlanning(entities.Planning{ID: 1, UserID: uid})
+	ps.addPlanning(entities.Planning{ID: 2, UserID: uid + 1})
+	svc := &Service{
+		planningStorage: ps,
+	}
+	_, err := svc.Burndown(ctx, entities.BurndownQuery{
+		UserID:      uid,
+		PlanningIDs: []entities.PlanningID{1, 2},
+		To:          10,
+		Step:        10,
+	})
+	if err != entities.ErrInvalidUserID {
+		t.Error("Invalid err", err)
+	}
+}
+
+func TestBurndownPlanningStorageErr(t *testing.T) {
+	ps := newPlanningStorage()
+	ps.err = errors.New("planning storage err")
+	svc := &Service{
+		planningStorage: ps,
+	}
+	_, err := svc.Burndown(ctx, entities.BurndownQuery{To: 10, Step: 10})
+	if errors.Cause(err) != ps.err {
+		t.Error("Invalid err", err)
+	}
+}
+
+func TestBurndownNoPlannings(t *testing.T) {
+	svc := &Service{
+		planningStorage: newPlanningStorage(),
+	}
+	points, err := svc.Burndown(ctx, entities.BurndownQuery{From: 0, To: 10, Step: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []entities.BurndownPoint{{Time: 0}, {Time: 5}, {Time: 10}}
+	if !reflect.DeepEqual(points, expected) {
+		t.Errorf("Invalid points %+v", points)
+	}
+}
+
+func TestBurndown(t *testing.T) {
+	uid := randomUserID()
+	ps := newPlanningStorage()
+	ps.addPlanning(entities.Planning{
+		ID:        1,
+		UserID:    uid,
+		Status:    entities.Closed,
+		IssueDone: 100,
+		Reported:  150,
+		CreatedAt: 0,
+	})
+	ps.addPlanning(entities.Planning{
+		ID:        2,
+		UserID:    uid,
+		Status:    entities.Open,
+		IssueDone: 20,
+		CreatedAt: 100,
+	})
+	ps.plannedTimes = []entities.PlannedTime{
+		{PlanningID: 1, Estimation: 100, CreatedAt: 0},
+		{PlanningID: 2, Estimation: 200, CreatedAt: 100},
+		{PlanningID: 1, Estimation: 150, CreatedAt: 120},
+	}
+	ps.histories = []entities.SpentTimeHistory{
+		{PlanningID: 1, Spent: 50, StartedAt: 0, EndedAt: 50, Status: entities.Online},
+		{PlanningID: 1, Spent: 100, StartedAt: 50, EndedAt: 150, Status: entities.Offline},
+	}
+	spentTimeStorage := newSpentTimeStorage()
+	spentTimeStorage.spentTime[uid] = &entities.SpentTime{
+		UserID:      uid,
+		PlanningID:  2,
+		Started:     150,
+		Last:        200,
+		SpentOnline: 50,
+	}
+	svc := &Service{
+		planningStorage:  ps,
+		spentTimeStorage: spentTimeStorage,
+	}
+	points, err := svc.Burndown(ctx, entities.BurndownQuery{
+		UserID:      uid,
+		PlanningIDs: []entities.PlanningID{1, 2, 1},
+		From:        0,
+		To:          200,
+		Step:        75,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []entities.BurndownPoint{
+		{Time: 0, Estimation: 100, Spent: 0, Remaining: 100, Progress: 0, Open: 1},
+		{Time: 75, Estimation: 100, Spent: 75, Remaining: 25, Progress: 0, Open: 1},
+		{Time: 150, Estimation: 350, Spent: 150, Remaining: 200, Progress: 60, Open: 1, Closed: 1},
+		{Time: 200, Estimation: 350, Spent: 200, Remaining: 150, Progress: 60, Open: 1, Closed: 1},
+	}
+	if !reflect.DeepEqual(points, expected) {
+		t.Errorf("Invalid points\n%+v\n%+v", points, expected)
+	}
+	if spentTimeStorage.spentTime[uid] == nil {
+		t.Error("Spent time shouldn't be removed")
+	}
+}
+
+func TestBurndownOpenedPlannings(t *testing.T) {
+	uid := randomUserID()
+	ps := newPlanningStorage()
+	ps.addPlanning(entities.Planning{
+		ID:        1,
+		UserID:    uid,
+		Status:    entities.Open,
+		IssueDone: 50,
+	})
+	ps.plannedTimes = []entities.PlannedTime{
+		{PlanningID: 1, Estimation: 100},
+	}
+	svc := &Service{
+		planningStorage:  ps,
+		spentTimeStorage: newSpentTimeStorage(),
+	}
+	points, err := svc.Burndown(ctx, entities.BurndownQuery{
+		UserID: uid,
+		To:     10,
+		Step:   10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.userID != uid {
+		t.Error("Invalid user id passed", ps.userID)
+	}
+	expected := []entities.BurndownPoint{
+		{Time: 0, Estimation: 100, Remaining: 100, Progress: 50, Open: 1},
+		{Time: 10, Estimation: 100, Remaining: 100, Progress: 50, Open: 1},
+	}
+	if !reflect.DeepEqual(points, expected) {
+		t.Errorf("Invalid points %+v", points)
+	}
+}
+
+func TestSpentUntil(t *testing.T) {
+	h := entities.SpentTimeHistory{Spent: 50, StartedAt: 100, EndedAt: 200}
+	for tm, spent := range map[int64]int64{
+		50:  0,
+		100: 0,
+		150: 25,
+		200: 50,
+		250: 50,
+	} {
+		if s := spentUntil(h, tm); s != spent {
+			t.Error("Invalid spent", tm, s)
+		}
+	}
+}
@@ -42,8 +42,9 @@ type PlanningServiceCfg struct {
 
 // SpentTimeStorage required api
 type SpentTimeStorage interface {
-	NewSpentTime(context.Context, entities.SpentTime) error
 	Modify(context.Context, ctxtg.UserID, entities.ModifySpentTimeFunc) error
+	SpentTime(context.Context, ctxtg.UserID) (*entities.SpentTime, error)
+	UserIDs(context.Context) ([]ctxtg.UserID, error)
 }
 
 // PlanningStorage required api
@@ -53,8 +54,15 @@ type PlanningStorage interface {
 	PlanningCreatedAt(context.Context, entities.PlanningID) (int64, error)
 	LastActivity(context.Context, ctxtg.UserID) (int64, error)
 	Planning(context.Context, entities.PlanningID) (*entities.Planning, error)
+	Plannings(context.Context, []entities.PlanningID) ([]entities.Planning, error)
+	PlannedTimes(context.Context, []entities.PlanningID) ([]entities.PlannedTime, error)
+	SpentTimeHistories(context.Context, []entities.PlanningID) ([]entities.SpentTimeHistory, error)
 	OpenedPlannings(context.Context, ctxtg.UserID) ([]entities.ExtendedPlanning, error)
 	SpentTimeByUserIDTimeRange(ctx context.Context, uid ctxtg.UserID, from, to int64) (int, error)
+	IsPeriodLocked(ctx context.Context, uid ctxtg.UserID, from, to int64) (bool, error)
+	SubmitTimesheet(context.Context, entities.Timesheet) (entities.TimesheetID, error)
+	Rates(context.Context) ([]entities.Rate, error)
+	BillableWork(context.Context, entities.BillingQuery) ([]entities.BillableWork, error)
 }
 
 // Service contains implements all needed business logic
@@ -84,9 +92,10 @@ func (s *Service) OpenedPlannings(ctx context.Context, uid ctxtg.UserID) ([]enti
 // - no active planning
 // - invalid report
 // - failed to save offline time to database
+// - offline time is inside of locked timesheet period
 func (s *Service) AddSpentTime(ctx context.Context, report entities.SpentTimeReport) error {
 	var spentTime entities.SpentTime
-	spentTimeFunc := checkNotEmpty(func(st entities.SpentTime) (*entities.SpentTime, error) {
+	spentTimeFunc := checkNotEmpty(func(_ context.Context, st entities.SpentTime) (*entities.SpentTime, error) {
 		spentTime = st
 		return &st, nil
 	})
@@ -97,13 +106,16 @@ func (s *Service) AddSpentTime(ctx context.Context, report entities.SpentTimeRep
 	newReport, err := s.checkReport(spentTime, report)
 	if err == errOfflineSpentTime {
 		saveErr := s.planningStorage.AddSpentTime(ctx, reportToHistory(newReport, entities.Offline))
+		if errors.Cause(saveErr) == entities.ErrPeriodLocked {
+			return entities.ErrPeriodLocked
+		}
 		if saveErr != nil {
 			return errors.Wrapf(err, "fail to save spenttime history: %+v", saveErr)
 		}
 	} else if err != nil {
 		return errors.Wrap(err, "invalid report")
 	}
-	incrementTimeFunc := checkNotEmpty(func(st entities.SpentTime) (*entities.SpentTime, error) {
+	incrementTimeFunc := checkNotEmpty(func(_ context.Context, st entities.SpentTime) (*entities.SpentTime, error) {
 		st.Last = newReport.Time
 		st.SpentOnline += newReport.Spent
 		return &st, nil
@@ -114,7 +126,7 @@ func (s *Service) AddSpentTime(ctx context.Context, report entities.SpentTimeRep
 // ClosePlanning close planning for userID with report
 // Return error if planning id is invalid it returns error
 func (s *Service) ClosePlanning(ctx context.Context, userID ctxtg.UserID, report entities.PlanningReport) error {
-	modifyFunc := ifNotEmpty(ifPlanningID(report.PlanningID, s.toHistory(ctx)))
+	modifyFunc := ifNotEmpty(ifPlanningID(report.PlanningID, s.toHistory))
 	err := s.spentTimeStorage.Modify(ctx, userID, modifyFunc)
 	if err != nil {
 		return errors.Wrap(err, "failed to modify spentTime storage")
@@ -129,7 +141,7 @@ func (s *Service) ClosePlanning(ctx context.Context, userID ctxtg.UserID, report
 // SpentTime returns total SpentTime amount for time period in seconds
 func (s *Service) SpentTime(ctx context.Context, uid ctxtg.UserID, from, to int64) (int, error) {
 	var onlineSpent int
-	err := s.spentTimeStorage.Modify(ctx, uid, ifNotEmpty(func(st entities.SpentTime) (*entities.SpentTime, error) {
+	err := s.spentTimeStorage.Modify(ctx, uid, ifNotEmpty(func(_ context.Context, st entities.SpentTime) (*entities.SpentTime, error) {
 		if st.Started >= from && st.Started <= to {
 			onlineSpent = st.SpentOnline
 		}
@@ -148,7 +160,7 @@ func (s *Service) SpentTime(ctx context.Context, uid ctxtg.UserID, from, to int6
 // SetActive save previous active planning's spent time from spenttime storage to planning storage
 // and register new spent time storage instance
 func (s *Service) SetActive(ctx context.Context, a entities.NewActivePlanning) error {
-	err := s.spentTimeStorage.Modify(ctx, a.UserID, ifNotEmpty(s.toHistory(ctx)))
+	err := s.spentTimeStorage.Modify(ctx, a.UserID, ifNotEmpty(s.toHistory))
 	if err != nil {
 		return errors.Wrap(err, "failed spentTime storage modification")
 	}
@@ -166,31 +178,259 @@ func (s *Service) SetActive(ctx context.Context, a entities.NewActivePlanning) e
 	if err != nil {
 		return errors.Wrap(err, "failed to load planning StartedAt")
 	}
-	err = s.spentTimeStorage.NewSpentTime(ctx, entities.SpentTime{
-		UserID:            a.UserID,
-		PlanningID:        a.PlanningID,
-		PlanningCreatedAt: createdAt,
-		Started:           a.Time,
-		Last:              a.Time,
+	// period is checked under user's lock, so it can't be locked by SubmitTimesheet
+	// before new spent time is saved
+	err = s.spentTimeStorage.Modify(ctx, a.UserID, func(ctx context.Context, st *entities.SpentTime) (*entities.SpentTime, error) {
+		locked, err := s.planningStorage.IsPeriodLocked(ctx, a.UserID, a.Time, a.Time+1)
+		if err != nil {
+			return st, errors.Wrap(err, "failed to check timesheets")
+		}
+		if locked {
+			return st, entities.ErrPeriodLocked
+		}
+		return &entities.SpentTime{
+			UserID:            a.UserID,
+			PlanningID:        a.PlanningID,
+			PlanningCreatedAt: createdAt,
+			Started:           a.Time,
+			Last:              a.Time,
+		}, nil
 	})
+	if errors.Cause(err) == entities.ErrPeriodLocked {
+		return entities.ErrPeriodLocked
+	}
 	if err != nil {
 		return errors.Wrap(err, "failed to create new spent time")
 	}
 	return nil
 }
 
-func (s *Service) toHistory(ctx context.Context) spentTimeFunc {
-	return func(st entities.SpentTime) (*entities.SpentTime, error) {
-		err := s.spentTimeToHistory(ctx, st, entities.Online)
-		if err != nil {
-			return &st, err
+// SubmitTimesheet locks user's period t.From - t.To for approval.
+// Returns err if:
+// - period isn't finished yet
+// - period overlaps another locked period
+// - user has active planning started inside of period, it should be stopped first
+func (s *Service) SubmitTimesheet(ctx context.Context, t entities.Timesheet) (entities.TimesheetID, error) {
+	if t.From >= t.To || t.To > timeNowFunc() {
+		return 0, entities.ErrInvalidTimeRange
+	}
+	// timesheet is saved under user's lock, so SetActive can't start spent time inside of period
+	// after it was checked
+	var id entities.TimesheetID
+	err := s.spentTimeStorage.Modify(ctx, t.UserID, func(ctx context.Context, st *entities.SpentTime) (*entities.SpentTime, error) {
+		if st != nil && st.Started < t.To {
+			return st, entities.ErrActivePlanning
 		}
+		var err error
+		id, err = s.planningStorage.SubmitTimesheet(ctx, t)
+		return st, err
+	})
+	return id, err
+}
+
+// FlushSpentTime saves running spent time of all users to planning storage and stops it,
+// spent time which failed to save is kept. Returns number of saved spent times
+func (s *Service) FlushSpentTime(ctx context.Context) (int, error) {
+	uids, err := s.spentTimeStorage.UserIDs(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to load users with active planning")
+	}
+	var n int
+	var firstErr error
+	for _, uid := range uids {
+		flushed, err := s.FlushTimer(ctx, uid)
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "failed to flush spent time of user %d", uid)
+		}
+		if flushed {
+			n++
+		}
+	}
+	return n, firstErr
+}
+
+// FlushTimer saves user's running spent time to planning storage and stops it,
+// returns false if user hasn't running spent time
+func (s *Service) FlushTimer(ctx context.Context, uid ctxtg.UserID) (bool, error) {
+	flushed := false
+	err := s.spentTimeStorage.Modify(ctx, uid, ifNotEmpty(func(ctx context.Context, st entities.SpentTime) (*entities.SpentTime, error) {
+		res, err := s.toHistory(ctx, st)
+		flushed = err == nil
+		return res, err
+	}))
+	if err != nil {
+		return false, err
+	}
+	return flushed, nil
+}
+
+// DiscardTimer stops user's running spent time without saving it,
+// returns discarded spent time or nil if user hasn't running spent time
+func (s *Service) DiscardTimer(ctx context.Context, uid ctxtg.UserID) (*entities.SpentTime, error) {
+	var discarded *entities.SpentTime
+	err := s.spentTimeStorage.Modify(ctx, uid, ifNotEmpty(func(_ context.Context, st entities.SpentTime) (*entities.SpentTime, error) {
+		discarded = &st
 		return nil, nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return discarded, nil
+}
+
+// Timers returns running spent time of all users
+func (s *Service) Timers(ctx context.Context) ([]entities.Timer, error) {
+	uids, err := s.spentTimeStorage.UserIDs(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load users with active planning")
+	}
+	now := timeNowFunc()
+	var ts []entities.Timer
+	for _, uid := range uids {
+		st, err := s.spentTimeStorage.SpentTime(ctx, uid)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load spent time of user %d", uid)
+		}
+		// User may have only undelivered histories or timer was stopped meanwhile
+		if st == nil {
+			continue
+		}
+		last := st.Last
+		if last == 0 {
+			last = st.Started
+		}
+		ts = append(ts, entities.Timer{
+			SpentTime: *st,
+			Stale:     now - last,
+			Outdated:  s.isOutdated(now, st.PlanningCreatedAt, st.Last),
+		})
+	}
+	return ts, nil
+}
+
+// TimerAction is action applied to invalid timer by ValidateTimers
+type TimerAction string
+
+// Actions applied to invalid timers
+const (
+	ActionFlush TimerAction = "flush"
+	ActionDrop  TimerAction = "drop"
+	ActionKeep  TimerAction = "keep"
+)
+
+// Reasons of invalid timers
+const (
+	noPlanning       = "planning not found"
+	closedPlanning   = "planning closed"
+	otherOwner       = "planning of other user"
+	outdatedPlanning = "planning outdated"
+)
+
+// TimersValidation is summary of ValidateTimers
+type TimersValidation struct {
+	Checked int
+	Invalid map[string]int // by reason
+	Flushed int
+	Dropped int
+	Kept    int
+	Failed  int
+}
+
+// ValidateTimers checks running spent time of all users against plannings, it's used
+// after restart because timers restored from backup may be left running for a long time.
+// Timer is invalid if its planning doesn't exist, is closed, belongs to other user
+// or is outdated, action is applied to invalid timers. Timer is dropped on ActionFlush
+// if its planning doesn't exist, belongs to other user or is closed, because spent time
+// of closed planning is already reported. Failed timers are kept
+func (s *Service) ValidateTimers(ctx context.Context, action TimerAction) (TimersValidation, error) {
+	res := TimersValidation{Invalid: make(map[string]int)}
+	uids, err := s.spentTimeStorage.UserIDs(ctx)
+	if err != nil {
+		return res, errors.Wrap(err, "failed to load users with active planning")
+	}
+	now := timeNowFunc()
+	var firstErr error
+	for _, uid := range uids {
+		var checked bool
+		var reason string
+		var applied TimerAction
+		err := s.spentTimeStorage.Modify(ctx, uid, ifNotEmpty(func(ctx context.Context, st entities.SpentTime) (*entities.SpentTime, error) {
+			checked = true
+			var err error
+			reason, err = s.invalidTimerReason(ctx, uid, now, st)
+			if err != nil || reason == "" {
+				return &st, err
+			}
+			applied = action
+			if applied == ActionFlush && (reason == noPlanning || reason == otherOwner || reason == closedPlanning) {
+				applied = ActionDrop
+			}
+			switch applied {
+			case ActionFlush:
+				return s.toHistory(ctx, st)
+			case ActionDrop:
+				return nil, nil
+			}
+			return &st, nil
+		}))
+		if checked {
+			res.Checked++
+		}
+		if reason != "" {
+			res.Invalid[reason]++
+		}
+		switch {
+		case err != nil:
+			res.Failed++
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "failed to validate spent time of user %d", uid)
+			}
+		case applied == ActionFlush:
+			res.Flushed++
+		case applied == ActionDrop:
+			res.Dropped++
+		case applied == ActionKeep:
+			res.Kept++
+		}
+	}
+	return res, firstErr
+}
+
+// invalidTimerReason returns reason why st of uid is invalid at now or empty string if it's valid
+func (s *Service) invalidTimerReason(ctx context.Context, uid ctxtg.UserID, now int64, st entities.SpentTime) (string, error) {
+	p, err := s.planningStorage.Planning(ctx, st.PlanningID)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to load planning %d", st.PlanningID)
+	}
+	switch {
+	case p == nil:
+		return noPlanning, nil
+	case p.UserID != uid:
+		return otherOwner, nil
+	case p.Status == entities.Closed:
+		return closedPlanning, nil
+	case s.isOutdated(now, st.PlanningCreatedAt, st.Last):
+		return outdatedPlanning, nil
+	}
+	return "", nil
+}
+
+func (s *Service) toHistory(ctx context.Context, st entities.SpentTime) (*entities.SpentTime, error) {
+	err := s.spentTimeToHistory(ctx, st, entities.Online)
+	if err != nil {
+		return &st, err
 	}
+	return nil, nil
 }
 
+// spentTimeToHistory saves history by SpentTimeStorage if it provides outbox in ctx,
+// it's saved to PlanningStorage at once otherwise
 func (s *Service) spentTimeToHistory(ctx context.Context, spentTime entities.SpentTime, status entities.SpentTimeStatus) error {
 	history := spentTimeToHistory(spentTime, status)
+	if o := entities.OutboxFrom(ctx); o != nil {
+		o.Histories = append(o.Histories, history)
+		return nil
+	}
 	err := s.planningStorage.AddSpentTime(ctx, history)
 	if err != nil {
 		return errors.Wrap(err, "failed to save spent time history to storage")
@@ -198,32 +438,32 @@ func (s *Service) spentTimeToHistory(ctx context.Context, spentTime entities.Spe
 	return nil
 }
 
-type spentTimeFunc func(st entities.SpentTime) (*entities.SpentTime, error)
+type spentTimeFunc func(ctx context.Context, st entities.SpentTime) (*entities.SpentTime, error)
 
 func checkNotEmpty(f spentTimeFunc) entities.ModifySpentTimeFunc {
-	return func(st *entities.SpentTime) (*entities.SpentTime, error) {
+	return func(ctx context.Context, st *entities.SpentTime) (*entities.SpentTime, error) {
 		if st == nil {
 			return nil, entities.ErrNoActivePlanning
 		}
-		return f(*st)
+		return f(ctx, *st)
 	}
 }
 
 func ifNotEmpty(f spentTimeFunc) entities.ModifySpentTimeFunc {
-	return func(st *entities.SpentTime) (*entities.SpentTime, error) {
+	return func(ctx context.Context, st *entities.SpentTime) (*entities.SpentTime, error) {
 		if st == nil {
 			return nil, nil
 		}
-		return f(*st)
+		return f(ctx, *st)
 	}
 }
 
 func ifPlanningID(planningID entities.PlanningID, f spentTimeFunc) spentTimeFunc {
-	return func(st entities.SpentTime) (*entities.SpentTime, error) {
+	return func(ctx context.Context, st entities.SpentTime) (*entities.SpentTime, error) {
 		if st.PlanningID != planningID {
 			return &st, nil
 		}
-		return f(st)
+		return f(ctx, st)
 	}
 }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"math/rand"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -383,6 +384,43 @@ func TestAddSpentTimePlanningOffline(t *testing.T) {
 	}
 }
 
+func TestAddSpentTimePlanningOfflineLocked(t *testing.T) {
+	defer mockTimeNow(25 + fiveMinutesInSeconds)()
+	userID := ctxtg.UserID(rand.Int63())
+	planningID := entities.PlanningID(rand.Int63())
+	planningStorage := newPlanningStorage()
+	planningStorage.timesheets = []entities.Timesheet{
+		{UserID: userID, From: 0, To: 18, Status: entities.Approved},
+	}
+	spentTimeStorage := newSpentTimeStorage()
+	spentTimeStorage.spentTime[userID] = &entities.SpentTime{
+		UserID:     userID,
+		PlanningID: planningID,
+		Last:       15,
+	}
+	svc := NewService(PlanningServiceCfg{
+		PlanningStorage:         planningStorage,
+		SpentTimeStorage:        spentTimeStorage,
+		MaxPlanningAge:          35 * time.Second,
+		MaxPeriodFromLastUpdate: 10 * time.Second,
+	})
+	err := svc.AddSpentTime(ctx, entities.SpentTimeReport{
+		UserID:     userID,
+		PlanningID: planningID,
+		Spent:      5,
+		Time:       20,
+	})
+	if err != entities.ErrPeriodLocked {
+		t.Error("Invalid err", err)
+	}
+	if len(planningStorage.histories) != 0 {
+		t.Error("History shouldn't be saved")
+	}
+	if spentTimeStorage.spentTime[userID].Last != 15 {
+		t.Error("Spent time shouldn't be changed")
+	}
+}
+
 func TestAddSpentTimePlanningTimeLessThenLast(t *testing.T) {
 	maxPlanningAge := 35 * time.Second
 	maxFromLastUpdate := 10 * time.Second
@@ -837,6 +875,436 @@ func TestSetActivePlanningZeroID(t *testing.T) {
 	}
 }
 
+func TestSetActivePlanningLockedPeriod(t *testing.T) {
+	defer mockTimeNow(100)()
+	userID := ctxtg.UserID(rand.Int63())
+	planningID := entities.PlanningID(rand.Int63())
+	spentTimeStorage := newSpentTimeStorage()
+	planningStorage := newPlanningStorage()
+	planningStorage.timesheets = []entities.Timesheet{
+		{UserID: userID, From: 0, To: 50, Status: entities.Submitted},
+	}
+	svc := &Service{
+		planningStorage:  planningStorage,
+		spentTimeStorage: spentTimeStorage,
+	}
+	err := svc.SetActive(ctx, entities.NewActivePlanning{
+		UserID:     userID,
+		PlanningID: planningID,
+		Time:       40,
+	})
+	if err != entities.ErrPeriodLocked {
+		t.Error("Invalid err", err)
+	}
+	if spentTimeStorage.spentTime[userID] != nil {
+		t.Error("Spent time shouldn't be created")
+	}
+	planningStorage.timesheets[0].Status = entities.Rejected
+	err = svc.SetActive(ctx, entities.NewActivePlanning{
+		UserID:     userID,
+		PlanningID: planningID,
+		Time:       40,
+	})
+	if err != nil {
+		t.Error("Unexpected err", err)
+	}
+}
+
+func TestFlushSpentTime(t *testing.T) {
+	spentTimeStorage := newSpentTimeStorage()
+	for i := 0; i < 3; i++ {
+		userID := randomUserID()
+		spentTimeStorage.spentTime[userID] = &entities.SpentTime{
+			UserID:      userID,
+			PlanningID:  entities.PlanningID(rand.Int63()),
+			Started:     10,
+			Last:        20,
+			SpentOnline: 10,
+		}
+	}
+	planningStorage := newPlanningStorage()
+	svc := &Service{
+		planningStorage:  planningStorage,
+		spentTimeStorage: spentTimeStorage,
+	}
+	n, err := svc.FlushSpentTime(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || len(planningStorage.histories) != 3 {
+		t.Errorf("Invalid flushed %d, histories %+v", n, planningStorage.histories)
+	}
+	for _, h := range planningStorage.histories {
+		if h.Status != entities.Online || h.Spent != 10 || h.StartedAt != 10 || h.EndedAt != 20 {
+			t.Errorf("Invalid history %+v", h)
+		}
+	}
+	if uids, _ := spentTimeStorage.UserIDs(ctx); len(uids) != 0 {
+		t.Error("Spent time should be removed", uids)
+	}
+}
+
+func TestFlushSpentTimePlanningStorageErr(t *testing.T) {
+	userID := randomUserID()
+	spentTimeStorage := newSpentTimeStorage()
+	spentTimeStorage.spentTime[userID] = &entities.SpentTime{UserID: userID}
+	planningStorage := newPlanningStorage()
+	planningStorage.err = errors.New("test err")
+	svc := &Service{
+		planningStorage:  planningStorage,
+		spentTimeStorage: spentTimeStorage,
+	}
+	n, err := svc.FlushSpentTime(ctx)
+	if err == nil || n != 0 {
+		t.Errorf("Invalid flushed %d, err %v", n, err)
+	}
+	if spentTimeStorage.spentTime[userID] == nil {
+		t.Error("Spent time should be kept")
+	}
+}
+
+func TestFlushTimer(t *testing.T) {
+	userID := randomUserID()
+	spentTimeStorage := newSpentTimeStorage()
+	st := &entities.SpentTime{
+		UserID:      userID,
+		PlanningID:  randomPlanningID(),
+		Started:     10,
+		Last:        20,
+		SpentOnline: 10,
+	}
+	spentTimeStorage.spentTime[userID] = st
+	planningStorage := newPlanningStorage()
+	svc := &Service{
+		planningStorage:  planningStorage,
+		spentTimeStorage: spentTimeStorage,
+	}
+	flushed, err := svc.FlushTimer(ctx, userID)
+	if err != nil || !flushed {
+		t.Fatal("Timer should be flushed", flushed, err)
+	}
+	if len(planningStorage.histories) != 1 || planningStorage.histories[0] != spentTimeToHistory(*st, entities.Online) {
+		t.Errorf("Invalid histories %+v", planningStorage.histories)
+	}
+	flushed, err = svc.FlushTimer(ctx, userID)
+	if err != nil || flushed {
+		t.Error("Stopped timer shouldn't be flushed", flushed, err)
+	}
+}
+
+func TestDiscardTimer(t *testing.T) {
+	userID := randomUserID()
+	spentTimeStorage := newSpentTimeStorage()
+	st := entities.SpentTime{
+		UserID:     userID,
+		PlanningID: randomPlanningID(),
+		Started:    10,
+		Last:       20,
+	}
+	spentTimeStorage.spentTime[userID] = &st
+	planningStorage := newPlanningStorage()
+	svc := &Service{
+		planningStorage:  planningStorage,
+		spentTimeStorage: spentTimeStorage,
+	}
+	discarded, err := svc.DiscardTimer(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if discarded == nil || *discarded != st {
+		t.Errorf("Invalid discarded timer %+v", discarded)
+	}
+	if spentTimeStorage.spentTime[userID] != nil || len(planningStorage.histories) != 0 {
+		t.Error("Timer should be discarded without history")
+	}
+}
+
+func TestTimers(t *testing.T) {
+	defer mockTimeNow(100)()
+	spentTimeStorage := newSpentTimeStorage()
+	fresh := entities.SpentTime{
+		UserID:            randomUserID(),
+		PlanningCreatedAt: 50,
+		Started:           60,
+		Last:              90,
+	}
+	stale := entities.SpentTime{
+		UserID:            randomUserID(),
+		PlanningCreatedAt: 50,
+		Started:           60,
+		Last:              70,
+	}
+	spentTimeStorage.spentTime[fresh.UserID] = &fresh
+	spentTimeStorage.spentTime[stale.UserID] = &stale
+	svc := &Service{
+		spentTimeStorage:  spentTimeStorage,
+		maxPlanningAge:    time.Minute,
+		maxFromLastUpdate: 20 * time.Second,
+	}
+	ts, err := svc.Timers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[ctxtg.UserID]entities.Timer{
+		fresh.UserID: {SpentTime: fresh, Stale: 10},
+		stale.UserID: {SpentTime: stale, Stale: 30, Outdated: true},
+	}
+	if len(ts) != len(expected) {
+		t.Fatalf("Invalid timers %+v", ts)
+	}
+	for _, timer := range ts {
+		if timer != expected[timer.UserID] {
+			t.Errorf("Invalid timer %+v", timer)
+		}
+	}
+}
+
+func TestValidateTimers(t *testing.T) {
+	defer mockTimeNow(100)()
+	newTimer := func(created, last int64) entities.SpentTime {
+		return entities.SpentTime{
+			UserID:            randomUserID(),
+			PlanningID:        entities.PlanningID(rand.Int63()),
+			PlanningCreatedAt: created,
+			Started:           60,
+			Last:              last,
+			SpentOnline:       int(last - 60),
+		}
+	}
+	valid := newTimer(50, 90)
+	missing := newTimer(50, 90)
+	closed := newTimer(50, 90)
+	other := newTimer(50, 90)
+	outdated := newTimer(50, 70)
+	tests := []struct {
+		action  TimerAction
+		left    []entities.SpentTime
+		flushed []entities.SpentTime
+		res     TimersValidation
+	}{
+		{ActionFlush, []entities.SpentTime{valid}, []entities.SpentTime{outdated},
+			TimersValidation{Flushed: 1, Dropped: 3}},
+		{ActionDrop, []entities.SpentTime{valid}, nil,
+			TimersValidation{Dropped: 4}},
+		{ActionKeep, []entities.SpentTime{valid, missing, closed, other, outdated}, nil,
+			TimersValidation{Kept: 4}},
+	}
+	for _, test := range tests {
+		planningStorage := newPlanningStorage()
+		spentTimeStorage := newSpentTimeStorage()
+		for _, st := range []entities.SpentTime{valid, missing, closed, other, outdated} {
+			st := st
+			spentTimeStorage.spentTime[st.UserID] = &st
+			if st == missing {
+				continue
+			}
+			p := entities.Planning{ID: st.PlanningID, UserID: st.UserID, Status: entities.Open}
+			switch st {
+			case closed:
+				p.Status = entities.Closed
+			case other:
+				p.UserID = randomUserID()
+			}
+			planningStorage.addPlanning(p)
+		}
+		svc := &Service{
+			spentTimeStorage:  spentTimeStorage,
+			planningStorage:   planningStorage,
+			maxPlanningAge:    time.Minute,
+			maxFromLastUpdate: 20 * time.Second,
+		}
+		res, err := svc.ValidateTimers(ctx, test.action)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.res.Checked = 5
+		test.res.Invalid = map[string]int{
+			noPlanning:       1,
+			closedPlanning:   1,
+			otherOwner:       1,
+			outdatedPlanning: 1,
+		}
+		if !reflect.DeepEqual(res, test.res) {
+			t.Errorf("%s: invalid result %+v", test.action, res)
+		}
+		var left int
+		for _, st := range spentTimeStorage.spentTime {
+			if st != nil {
+				left++
+			}
+		}
+		if left != len(test.left) {
+			t.Errorf("%s: invalid timers left %+v", test.action, spentTimeStorage.spentTime)
+		}
+		for _, st := range test.left {
+			if left := spentTimeStorage.spentTime[st.UserID]; left == nil || *left != st {
+				t.Errorf("%s: timer %+v isn't kept", test.action, st)
+			}
+		}
+		if len(planningStorage.histories) != len(test.flushed) {
+			t.Fatalf("%s: invalid histories %+v", test.action, planningStorage.histories)
+		}
+		for _, st := range test.flushed {
+			found := false
+			for _, h := range planningStorage.histories {
+				found = found || h == spentTimeToHistory(st, entities.Online)
+			}
+			if !found {
+				t.Errorf("%s: timer %+v isn't flushed", test.action, st)
+			}
+		}
+	}
+}
+
+func TestValidateTimersFailed(t *testing.T) {
+	defer mockTimeNow(100)()
+	planningStorage := newPlanningStorage()
+	planningStorage.err = errors.New("planning storage error")
+	spentTimeStorage := newSpentTimeStorage()
+	st := entities.SpentTime{
+		UserID:            randomUserID(),
+		PlanningID:        entities.PlanningID(rand.Int63()),
+		PlanningCreatedAt: 50,
+		Started:           60,
+		Last:              90,
+	}
+	spentTimeStorage.spentTime[st.UserID] = &st
+	svc := &Service{
+		spentTimeStorage:  spentTimeStorage,
+		planningStorage:   planningStorage,
+		maxPlanningAge:    time.Minute,
+		maxFromLastUpdate: 20 * time.Second,
+	}
+	res, err := svc.ValidateTimers(ctx, ActionDrop)
+	if errors.Cause(err) != planningStorage.err {
+		t.Errorf("Invalid error %v", err)
+	}
+	if res.Checked != 1 || res.Failed != 1 || res.Dropped != 0 || len(res.Invalid) != 0 {
+		t.Errorf("Invalid result %+v", res)
+	}
+	if spentTimeStorage.spentTime[st.UserID] == nil {
+		t.Error("Failed timer isn't kept")
+	}
+}
+
+func TestSpentTimeToHistoryOutbox(t *testing.T) {
+	planningStorage := newPlanningStorage()
+	svc := &Service{
+		planningStorage: planningStorage,
+	}
+	o := &entities.Outbox{}
+	st := entities.SpentTime{
+		UserID:      randomUserID(),
+		PlanningID:  entities.PlanningID(rand.Int63()),
+		Started:     10,
+		Last:        20,
+		SpentOnline: 10,
+	}
+	err := svc.spentTimeToHistory(entities.WithOutbox(ctx, o), st, entities.Online)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(o.Histories) != 1 || o.Histories[0] != spentTimeToHistory(st, entities.Online) {
+		t.Errorf("Invalid outbox %+v", o.Histories)
+	}
+	if len(planningStorage.histories) != 0 {
+		t.Error("History should be left in outbox", planningStorage.histories)
+	}
+}
+
+func TestSubmitTimesheetInvalidRange(t *testing.T) {
+	defer mockTimeNow(100)()
+	svc := &Service{}
+	for _, ts := range []entities.Timesheet{
+		{From: 10, To: 10},
+		{From: 20, To: 10},
+		{From: 10, To: 101},
+	} {
+		_, err := svc.SubmitTimesheet(ctx, ts)
+		if err != entities.ErrInvalidTimeRange {
+			t.Errorf("Invalid err %v for %+v", err, ts)
+		}
+	}
+}
+
+func TestSubmitTimesheetActivePlanning(t *testing.T) {
+	defer mockTimeNow(100)()
+	userID := randomUserID()
+	spentTimeStorage := newSpentTimeStorage()
+	spentTimeStorage.spentTime[userID] = &entities.SpentTime{
+		UserID:  userID,
+		Started: 40,
+		Last:    90,
+	}
+	planningStorage := newPlanningStorage()
+	svc := &Service{
+		planningStorage:  planningStorage,
+		spentTimeStorage: spentTimeStorage,
+	}
+	_, err := svc.SubmitTimesheet(ctx, entities.Timesheet{UserID: userID, From: 0, To: 50})
+	if err != entities.ErrActivePlanning {
+		t.Error("Invalid err", err)
+	}
+	if spentTimeStorage.spentTime[userID] == nil {
+		t.Error("Spent time shouldn't be removed")
+	}
+	if len(planningStorage.timesheets) != 0 {
+		t.Error("Timesheet shouldn't be submitted")
+	}
+	id, err := svc.SubmitTimesheet(ctx, entities.Timesheet{UserID: userID, From: 0, To: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 1 || planningStorage.timesheets[0].To != 40 {
+		t.Errorf("Invalid timesheets %+v", planningStorage.timesheets)
+	}
+}
+
+// lockCheckingStorage fails test if period of user is checked or locked outside of user's Modify
+type lockCheckingStorage struct {
+	*testPlanningStorage
+	t *testing.T
+}
+
+func (s lockCheckingStorage) IsPeriodLocked(ctx context.Context, uid ctxtg.UserID, from, to int64) (bool, error) {
+	if ctx.Value(modifyKey{}) != uid {
+		s.t.Error("Period should be checked under user's lock")
+	}
+	return s.testPlanningStorage.IsPeriodLocked(ctx, uid, from, to)
+}
+
+func (s lockCheckingStorage) SubmitTimesheet(ctx context.Context, ts entities.Timesheet) (entities.TimesheetID, error) {
+	if ctx.Value(modifyKey{}) != ts.UserID {
+		s.t.Error("Timesheet should be submitted under user's lock")
+	}
+	return s.testPlanningStorage.SubmitTimesheet(ctx, ts)
+}
+
+func TestSubmitTimesheetUnderUserLock(t *testing.T) {
+	defer mockTimeNow(100)()
+	userID := randomUserID()
+	planningStorage := newPlanningStorage()
+	spentTimeStorage := newSpentTimeStorage()
+	svc := &Service{
+		planningStorage:  lockCheckingStorage{planningStorage, t},
+		spentTimeStorage: spentTimeStorage,
+	}
+	if _, err := svc.SubmitTimesheet(ctx, entities.Timesheet{UserID: userID, From: 0, To: 50}); err != nil {
+		t.Fatal(err)
+	}
+	err := svc.SetActive(ctx, entities.NewActivePlanning{
+		UserID:     userID,
+		PlanningID: entities.PlanningID(rand.Int63()),
+		Time:       40,
+	})
+	if err != entities.ErrPeriodLocked {
+		t.Error("Invalid err", err)
+	}
+	if spentTimeStorage.spentTime[userID] != nil {
+		t.Error("Spent time shouldn't be started inside of locked period")
+	}
+}
+
 func TestSpentTimeSpentTimeStorageErr(t *testing.T) {
 	spentTimeStorage := newSpentTimeStorage()
 	spentTimeStorage.err = errors.New("sts err")
@@ -996,11 +1464,16 @@ func newPlanningStorage() *testPlanningStorage {
 }
 
 type testPlanningStorage struct {
-	histories []entities.SpentTimeHistory
-	plannings map[entities.PlanningID]*entities.Planning
-	userID    ctxtg.UserID
-	from      int64
-	to        int64
+	histories    []entities.SpentTimeHistory
+	plannings    map[entities.PlanningID]*entities.Planning
+	plannedTimes []entities.PlannedTime
+	timesheets   []entities.Timesheet
+	rates        []entities.Rate
+	billableWork []entities.BillableWork
+	billing      entities.BillingQuery
+	userID       ctxtg.UserID
+	from         int64
+	to           int64
 
 	spent        int
 	lastActivity int64
@@ -1020,7 +1493,44 @@ func (t *testPlanningStorage) Planning(_ context.Context, pid entities.PlanningI
 	return t.plannings[pid], t.err
 }
 
-func (t *testPlanningStorage) AddSpentTime(_ context.Context, history entities.SpentTimeHistory) error {
+func (t *testPlanningStorage) Plannings(_ context.Context, pids []entities.PlanningID) ([]entities.Planning, error) {
+	var ps []entities.Planning
+	for _, pid := range pids {
+		if p := t.plannings[pid]; p != nil {
+			ps = append(ps, *p)
+		}
+	}
+	return ps, t.err
+}
+
+func (t *testPlanningStorage) PlannedTimes(_ context.Context, pids []entities.PlanningID) ([]entities.PlannedTime, error) {
+	var pts []entities.PlannedTime
+	for _, pt := range t.plannedTimes {
+		for _, pid := range pids {
+			if pt.PlanningID == pid {
+				pts = append(pts, pt)
+			}
+		}
+	}
+	return pts, t.err
+}
+
+func (t *testPlanningStorage) SpentTimeHistories(_ context.Context, pids []entities.PlanningID) ([]entities.SpentTimeHistory, error) {
+	var hs []entities.SpentTimeHistory
+	for _, h := range t.histories {
+		for _, pid := range pids {
+			if h.PlanningID == pid {
+				hs = append(hs, h)
+			}
+		}
+	}
+	return hs, t.err
+}
+
+func (t *testPlanningStorage) AddSpentTime(ctx context.Context, history entities.SpentTimeHistory) error {
+	if locked, _ := t.IsPeriodLocked(ctx, 0, history.StartedAt, history.EndedAt); locked {
+		return errors.Wrap(entities.ErrPeriodLocked, "locked")
+	}
 	t.histories = append(t.histories, history)
 	return t.err
 }
@@ -1066,12 +1576,40 @@ func (t *testPlanningStorage) SpentTimeByUserIDTimeRange(_ context.Context, uid
 
 }
 
+func (t *testPlanningStorage) IsPeriodLocked(_ context.Context, uid ctxtg.UserID, from, to int64) (bool, error) {
+	for _, ts := range t.timesheets {
+		if ts.To > from && ts.From < to && (ts.Status == entities.Submitted || ts.Status == entities.Approved) {
+			return true, t.err
+		}
+	}
+	return false, t.err
+}
+
+func (t *testPlanningStorage) SubmitTimesheet(_ context.Context, ts entities.Timesheet) (entities.TimesheetID, error) {
+	ts.ID = entities.TimesheetID(len(t.timesheets) + 1)
+	ts.Status = entities.Submitted
+	t.timesheets = append(t.timesheets, ts)
+	return ts.ID, t.err
+}
+
+func (t *testPlanningStorage) Rates(_ context.Context) ([]entities.Rate, error) {
+	return t.rates, t.err
+}
+
+func (t *testPlanningStorage) BillableWork(_ context.Context, q entities.BillingQuery) ([]entities.BillableWork, error) {
+	t.billing = q
+	return t.billableWork, t.err
+}
+
 func newSpentTimeStorage() *testSpentTimeStorage {
 	return &testSpentTimeStorage{
 		spentTime: make(map[ctxtg.UserID]*entities.SpentTime),
 	}
 }
 
+// modifyKey marks context given to ModifySpentTimeFunc with id of locked user
+type modifyKey struct{}
+
 type testSpentTimeStorage struct {
 	spentTime map[ctxtg.UserID]*entities.SpentTime
 
@@ -1083,15 +1621,29 @@ func (t *testSpentTimeStorage) NewSpentTime(_ context.Context, spentTime entitie
 	return t.err
 }
 
-func (t *testSpentTimeStorage) Modify(_ context.Context, userID ctxtg.UserID, f entities.ModifySpentTimeFunc) error {
+func (t *testSpentTimeStorage) Modify(ctx context.Context, userID ctxtg.UserID, f entities.ModifySpentTimeFunc) error {
 	origS := t.spentTime[userID]
 	if origS != nil {
 		origS.UserID = userID
 	}
-	s, err := f(origS)
+	s, err := f(context.WithValue(ctx, modifyKey{}, userID), origS)
 	t.spentTime[userID] = s
 	if err != nil {
 		return err
 	}
 	return t.err
 }
+
+func (t *testSpentTimeStorage) SpentTime(_ context.Context, userID ctxtg.UserID) (*entities.SpentTime, error) {
+	return t.spentTime[userID], t.err
+}
+
+func (t *testSpentTimeStorage) UserIDs(_ context.Context) ([]ctxtg.UserID, error) {
+	var uids []ctxtg.UserID
+	for uid, st := range t.spentTime {
+		if st != nil {
+			uids = append(uids, uid)
+		}
+	}
+	return uids, t.err
+}
@@ -0,0 +1,21 @@
+package postgresdb
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/qarea/planningms/cfg"
+)
+
+// New creates new database connection for postgres database
+func New() *sqlx.DB {
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.Postgres.Login, cfg.Postgres.Password),
+		Host:     fmt.Sprintf("%s:%d", cfg.Postgres.Host, cfg.Postgres.Port),
+		Path:     cfg.Postgres.DB,
+		RawQuery: url.Values{"sslmode": {cfg.Postgres.SSLMode}}.Encode(),
+	}
+	return sqlx.MustConnect("postgres", dsn.String())
+}
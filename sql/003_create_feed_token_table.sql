@@ -0,0 +1,7 @@
+CREATE TABLE FeedToken (
+  PRIMARY KEY (user_id),
+  UNIQUE KEY (token),
+  user_id           BIGINT        NOT NULL,
+  token             VARCHAR(64)   NOT NULL,
+  created_at        BIGINT        NOT NULL
+);
@@ -0,0 +1,14 @@
+CREATE TABLE Team (
+  PRIMARY KEY (id),
+  id                BIGINT        NOT NULL AUTO_INCREMENT,
+  name              VARCHAR(255)  NOT NULL,
+  created_at        BIGINT        NOT NULL
+);
+
+CREATE TABLE TeamMember (
+  PRIMARY KEY (team_id, user_id),
+  team_id           BIGINT                 NOT NULL,
+  user_id           BIGINT                 NOT NULL,
+  role              ENUM("LEAD","MEMBER")  NOT NULL,
+  FOREIGN KEY (team_id) REFERENCES Team(id)
+);
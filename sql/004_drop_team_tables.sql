@@ -0,0 +1,2 @@
+DROP TABLE TeamMember;
+DROP TABLE Team;
@@ -0,0 +1,12 @@
+CREATE TABLE Timesheet (
+  PRIMARY KEY (id),
+  id                BIGINT                                             NOT NULL AUTO_INCREMENT,
+  user_id           BIGINT                                             NOT NULL,
+  period_from       BIGINT                                             NOT NULL,
+  period_to         BIGINT                                             NOT NULL,
+  status            ENUM("SUBMITTED","APPROVED","REJECTED","UNLOCKED") NOT NULL,
+  approver_id       BIGINT                                             NOT NULL DEFAULT 0,
+  comment           TEXT                                               NOT NULL,
+  updated_at        BIGINT                                             NOT NULL,
+  INDEX (user_id, period_from)
+);
@@ -0,0 +1,18 @@
+ALTER TABLE Planning
+  ADD billable BOOL NOT NULL DEFAULT TRUE;
+
+CREATE TABLE ProjectBilling (
+  PRIMARY KEY (project_id),
+  project_id        BIGINT  NOT NULL,
+  billable          BOOL    NOT NULL
+);
+
+CREATE TABLE Rate (
+  PRIMARY KEY (id),
+  id                BIGINT  NOT NULL AUTO_INCREMENT,
+  user_id           BIGINT  NOT NULL DEFAULT 0,
+  project_id        BIGINT  NOT NULL DEFAULT 0,
+  activity_id       BIGINT  NOT NULL DEFAULT 0,
+  rate              BIGINT  NOT NULL,
+  effective_from    BIGINT  NOT NULL
+);
@@ -0,0 +1,5 @@
+DROP TABLE Rate;
+DROP TABLE ProjectBilling;
+
+ALTER TABLE Planning
+ DROP billable;
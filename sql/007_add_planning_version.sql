@@ -0,0 +1,2 @@
+ALTER TABLE Planning
+  ADD version BIGINT NOT NULL DEFAULT 0;
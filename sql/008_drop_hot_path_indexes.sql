@@ -0,0 +1,7 @@
+DROP INDEX spent_time_history_planning_id_ended_at ON SpentTimeHistory;
+-- InnoDB dropped index of foreign key PlannedTime.planning_id when covering index was created,
+-- so foreign key needs own index before covering one can be dropped
+CREATE INDEX planned_time_planning_id ON PlannedTime (planning_id);
+DROP INDEX planned_time_planning_id_created_at ON PlannedTime;
+DROP INDEX planning_user_id_created_at ON Planning;
+DROP INDEX planning_user_id_status_created_at ON Planning;
@@ -0,0 +1,5 @@
+DROP TABLE UserActivity;
+
+ALTER TABLE Planning
+ DROP last_activity_at,
+ DROP current_estimation;
@@ -0,0 +1,9 @@
+CREATE TABLE PrivacyRequest (
+  PRIMARY KEY (id),
+  id                BIGINT                                  NOT NULL AUTO_INCREMENT,
+  user_id           BIGINT                                  NOT NULL,
+  admin_id          BIGINT                                  NOT NULL,
+  action            ENUM("EXPORT","ERASE","PSEUDONYMISE")   NOT NULL,
+  created_at        BIGINT                                  NOT NULL,
+  INDEX (user_id, created_at)
+);
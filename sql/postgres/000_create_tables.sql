@@ -0,0 +1,90 @@
+CREATE TABLE Planning (
+  PRIMARY KEY (id),
+  id                BIGSERIAL     NOT NULL,
+  user_id           BIGINT        NOT NULL,
+  status            VARCHAR(6)    NOT NULL CHECK (status IN ('OPEN','CLOSED')),
+  project_id        INT           NOT NULL,
+  tracker_id        INT           NOT NULL,
+  issue_id          INT           NOT NULL,
+  issue_title       VARCHAR(255)  NOT NULL,
+  issue_url         VARCHAR(255)  NOT NULL,
+  activity_id       INT           NOT NULL,
+  spent_online      INT           NOT NULL,
+  spent_offline     INT           NOT NULL,
+  reported          INT           NOT NULL,
+  created_at        BIGINT        NOT NULL,
+  issue_estim       INT           NOT NULL DEFAULT 0,
+  issue_due_date    BIGINT        NOT NULL DEFAULT 0,
+  issue_done        INT           NOT NULL,
+  billable          BOOLEAN       NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE PlannedTime (
+  PRIMARY KEY (id),
+  id                BIGSERIAL     NOT NULL,
+  planning_id       BIGINT        NOT NULL REFERENCES Planning(id),
+  estimation        INT           NOT NULL,
+  reason            VARCHAR(255)  NOT NULL,
+  created_at        BIGINT        NOT NULL
+);
+
+CREATE TABLE SpentTimeHistory (
+  PRIMARY KEY (planning_id, started_at, status),
+  planning_id       BIGINT        NOT NULL REFERENCES Planning(id),
+  spent             INT           NOT NULL,
+  started_at        BIGINT        NOT NULL,
+  ended_at          BIGINT        NOT NULL,
+  status            VARCHAR(7)    NOT NULL CHECK (status IN ('ONLINE','OFFLINE'))
+);
+
+CREATE TABLE FeedToken (
+  PRIMARY KEY (user_id),
+  UNIQUE (token),
+  user_id           BIGINT        NOT NULL,
+  token             VARCHAR(64)   NOT NULL,
+  created_at        BIGINT        NOT NULL
+);
+
+CREATE TABLE Team (
+  PRIMARY KEY (id),
+  id                BIGSERIAL     NOT NULL,
+  name              VARCHAR(255)  NOT NULL,
+  created_at        BIGINT        NOT NULL
+);
+
+CREATE TABLE TeamMember (
+  PRIMARY KEY (team_id, user_id),
+  team_id           BIGINT        NOT NULL REFERENCES Team(id),
+  user_id           BIGINT        NOT NULL,
+  role              VARCHAR(6)    NOT NULL CHECK (role IN ('LEAD','MEMBER'))
+);
+
+CREATE TABLE Timesheet (
+  PRIMARY KEY (id),
+  id                BIGSERIAL     NOT NULL,
+  user_id           BIGINT        NOT NULL,
+  period_from       BIGINT        NOT NULL,
+  period_to         BIGINT        NOT NULL,
+  status            VARCHAR(9)    NOT NULL CHECK (status IN ('SUBMITTED','APPROVED','REJECTED','UNLOCKED')),
+  approver_id       BIGINT        NOT NULL DEFAULT 0,
+  comment           TEXT          NOT NULL,
+  updated_at        BIGINT        NOT NULL
+);
+
+CREATE INDEX timesheet_user_id_period_from ON Timesheet (user_id, period_from);
+
+CREATE TABLE ProjectBilling (
+  PRIMARY KEY (project_id),
+  project_id        BIGINT        NOT NULL,
+  billable          BOOLEAN       NOT NULL
+);
+
+CREATE TABLE Rate (
+  PRIMARY KEY (id),
+  id                BIGSERIAL     NOT NULL,
+  user_id           BIGINT        NOT NULL DEFAULT 0,
+  project_id        BIGINT        NOT NULL DEFAULT 0,
+  activity_id       BIGINT        NOT NULL DEFAULT 0,
+  rate              BIGINT        NOT NULL,
+  effective_from    BIGINT        NOT NULL
+);
@@ -0,0 +1,4 @@
+CREATE INDEX planning_user_id_status_created_at ON Planning (user_id, status, created_at);
+CREATE INDEX planning_user_id_created_at ON Planning (user_id, created_at);
+CREATE INDEX planned_time_planning_id_created_at ON PlannedTime (planning_id, created_at);
+CREATE INDEX spent_time_history_planning_id_ended_at ON SpentTimeHistory (planning_id, ended_at);
@@ -0,0 +1,5 @@
+DROP TABLE UserActivity;
+
+ALTER TABLE Planning
+ DROP COLUMN last_activity_at,
+ DROP COLUMN current_estimation;
@@ -0,0 +1,45 @@
+CREATE TABLE PlanningArchive (
+  PRIMARY KEY (id),
+  id                 BIGINT        NOT NULL,
+  user_id            BIGINT        NOT NULL,
+  status             VARCHAR(6)    NOT NULL CHECK (status IN ('OPEN','CLOSED')),
+  project_id         INT           NOT NULL,
+  tracker_id         INT           NOT NULL,
+  issue_id           INT           NOT NULL,
+  issue_title        VARCHAR(255)  NOT NULL,
+  issue_url          VARCHAR(255)  NOT NULL,
+  issue_estim        INT           NOT NULL,
+  issue_due_date     BIGINT        NOT NULL,
+  issue_done         INT           NOT NULL,
+  activity_id        INT           NOT NULL,
+  spent_online       INT           NOT NULL,
+  spent_offline      INT           NOT NULL,
+  reported           INT           NOT NULL,
+  created_at         BIGINT        NOT NULL,
+  billable           BOOLEAN       NOT NULL,
+  version            BIGINT        NOT NULL,
+  last_activity_at   BIGINT        NOT NULL,
+  current_estimation BIGINT        NOT NULL
+);
+
+CREATE INDEX planning_archive_user_id_created_at ON PlanningArchive (user_id, created_at);
+
+CREATE TABLE PlannedTimeArchive (
+  PRIMARY KEY (id),
+  id                BIGINT        NOT NULL,
+  planning_id       BIGINT        NOT NULL,
+  estimation        INT           NOT NULL,
+  reason            VARCHAR(255)  NOT NULL,
+  created_at        BIGINT        NOT NULL
+);
+
+CREATE INDEX planned_time_archive_planning_id_created_at ON PlannedTimeArchive (planning_id, created_at);
+
+CREATE TABLE SpentTimeHistoryArchive (
+  PRIMARY KEY (planning_id, started_at, status),
+  planning_id       BIGINT        NOT NULL,
+  spent             INT           NOT NULL,
+  started_at        BIGINT        NOT NULL,
+  ended_at          BIGINT        NOT NULL,
+  status            VARCHAR(7)    NOT NULL CHECK (status IN ('ONLINE','OFFLINE'))
+);
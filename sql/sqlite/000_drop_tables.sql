@@ -0,0 +1,9 @@
+DROP TABLE Rate;
+DROP TABLE ProjectBilling;
+DROP TABLE Timesheet;
+DROP TABLE TeamMember;
+DROP TABLE Team;
+DROP TABLE FeedToken;
+DROP TABLE SpentTimeHistory;
+DROP TABLE PlannedTime;
+DROP TABLE Planning;
@@ -0,0 +1,2 @@
+ALTER TABLE Planning
+ DROP COLUMN version;
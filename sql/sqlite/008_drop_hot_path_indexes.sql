@@ -0,0 +1,4 @@
+DROP INDEX spent_time_history_planning_id_ended_at;
+DROP INDEX planned_time_planning_id_created_at;
+DROP INDEX planning_user_id_created_at;
+DROP INDEX planning_user_id_status_created_at;
@@ -0,0 +1,26 @@
+ALTER TABLE Planning
+  ADD last_activity_at   BIGINT        NOT NULL DEFAULT 0;
+
+ALTER TABLE Planning
+  ADD current_estimation BIGINT        NOT NULL DEFAULT 0;
+
+CREATE TABLE UserActivity (
+  user_id           BIGINT        NOT NULL,
+  last_activity_at  BIGINT        NOT NULL,
+  PRIMARY KEY (user_id)
+);
+
+UPDATE Planning
+   SET last_activity_at   = COALESCE((SELECT MAX(ended_at)
+                                        FROM SpentTimeHistory
+                                       WHERE planning_id = Planning.id), 0),
+       current_estimation = COALESCE((SELECT estimation
+                                        FROM PlannedTime
+                                       WHERE planning_id = Planning.id
+                                       ORDER BY created_at DESC, id DESC
+                                       LIMIT 1), 0);
+
+INSERT INTO UserActivity (user_id, last_activity_at)
+SELECT user_id, MAX(last_activity_at)
+  FROM Planning
+ GROUP BY user_id;
@@ -0,0 +1,7 @@
+DROP TABLE UserActivity;
+
+ALTER TABLE Planning
+ DROP COLUMN last_activity_at;
+
+ALTER TABLE Planning
+ DROP COLUMN current_estimation;
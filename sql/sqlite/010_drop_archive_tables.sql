@@ -0,0 +1,3 @@
+DROP TABLE SpentTimeHistoryArchive;
+DROP TABLE PlannedTimeArchive;
+DROP TABLE PlanningArchive;
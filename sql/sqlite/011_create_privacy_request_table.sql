@@ -0,0 +1,10 @@
+CREATE TABLE PrivacyRequest (
+  id                INTEGER       NOT NULL,
+  user_id           BIGINT        NOT NULL,
+  admin_id          BIGINT        NOT NULL,
+  action            VARCHAR(12)   NOT NULL CHECK (action IN ('EXPORT','ERASE','PSEUDONYMISE')),
+  created_at        BIGINT        NOT NULL,
+  PRIMARY KEY (id)
+);
+
+CREATE INDEX privacy_request_user_id_created_at ON PrivacyRequest (user_id, created_at);
@@ -0,0 +1,14 @@
+CREATE TABLE ActiveSpentTime (
+  user_id               BIGINT      NOT NULL,
+  planning_id           BIGINT      NOT NULL,
+  planning_created_at   BIGINT      NOT NULL,
+  started_at            BIGINT      NOT NULL,
+  last_at               BIGINT      NOT NULL,
+  spent_online          INT         NOT NULL,
+  PRIMARY KEY (user_id)
+);
+
+CREATE TABLE ActiveSpentTimeLock (
+  user_id               BIGINT      NOT NULL,
+  PRIMARY KEY (user_id)
+);
@@ -0,0 +1,2 @@
+DROP TABLE ActiveSpentTimeLock;
+DROP TABLE ActiveSpentTime;
@@ -0,0 +1,14 @@
+package sqlitedb
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/qarea/planningms/cfg"
+)
+
+// New opens sqlite database file, tables are created by migrations.
+// Connections are limited to one because sqlite allows single writer.
+func New() *sqlx.DB {
+	db := sqlx.MustConnect("sqlite3", "file:"+cfg.SQLite.Path+"?_foreign_keys=1")
+	db.SetMaxOpenConns(1)
+	return db
+}
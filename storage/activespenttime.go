@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+const (
+	saveActiveSpentTimeStmt = `
+		INSERT INTO ActiveSpentTime (user_id,
+									 planning_id,
+									 planning_created_at,
+									 started_at,
+									 last_at,
+									 spent_online)
+		VALUES						(:user_id,
+									 :planning_id,
+									 :planning_created_at,
+									 :started_at,
+									 :last_at,
+									 :spent_online)
+	`
+	findActiveSpentTimeStmt = `
+		SELECT *
+		  FROM ActiveSpentTime
+		 WHERE user_id = ?
+	`
+	findActiveSpentTimeUserIDsStmt = `
+		SELECT user_id
+		  FROM ActiveSpentTime
+		 ORDER BY user_id ASC
+	`
+	lockActiveSpentTimeStmt = `
+		INSERT INTO ActiveSpentTimeLock (user_id)
+		VALUES						    (?)
+	`
+	deleteActiveSpentTimeLockStmt = `
+		DELETE FROM ActiveSpentTimeLock
+		 WHERE user_id = ?
+	`
+	deleteActiveSpentTimeStmt = `
+		DELETE FROM ActiveSpentTime
+		 WHERE user_id = ?
+	`
+)
+
+// NewSpentTimeStorage creates SpentTimeStorage in database of p, p joins transactions of
+// SpentTimeStorage.Modify when it's called with context given to ModifySpentTimeFunc
+func NewSpentTimeStorage(p *PlanningStorage) *SpentTimeStorage {
+	return &SpentTimeStorage{
+		p: p,
+	}
+}
+
+// SpentTimeStorage keeps running timers of users in ActiveSpentTime table
+type SpentTimeStorage struct {
+	p *PlanningStorage
+}
+
+// NewSpentTime saves st replacing running timer of st.UserID
+func (s *SpentTimeStorage) NewSpentTime(ctx context.Context, st entities.SpentTime) error {
+	return s.p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+		return saveActiveSpentTime(ctx, tx, st)
+	})
+}
+
+// Modify locks user's timer and passes it to f, timer is saved if f returns not nil SpentTime
+// or removed otherwise. PlanningStorage called by f with given context makes its changes in
+// same transaction, so if f returns error neither timer nor these changes are saved.
+// User is locked by row of ActiveSpentTimeLock because user without timer has no row to lock
+func (s *SpentTimeStorage) Modify(ctx context.Context, uid ctxtg.UserID, f entities.ModifySpentTimeFunc) error {
+	return s.p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+		if err := lockActiveSpentTime(ctx, tx, uid); err != nil {
+			return errors.Wrap(err, "failed to lock active spent time")
+		}
+		st, err := findActiveSpentTime(ctx, tx, uid, true)
+		if err != nil {
+			return errors.Wrap(err, "failed to load active spent time")
+		}
+		st, err = f(withTransaction(ctx, tx), st)
+		if err != nil {
+			return err
+		}
+		if st == nil {
+			return deleteActiveSpentTime(ctx, tx, uid)
+		}
+		st.UserID = uid
+		return saveActiveSpentTime(ctx, tx, *st)
+	})
+}
+
+// SpentTime returns user's running timer or nil if user hasn't active planning
+func (s *SpentTimeStorage) SpentTime(ctx context.Context, uid ctxtg.UserID) (*entities.SpentTime, error) {
+	var st *entities.SpentTime
+	err := s.p.withSharedLock(ctx, func() error {
+		var err error
+		st, err = findActiveSpentTime(ctx, s.p.db, uid, false)
+		return err
+	})
+	return st, err
+}
+
+// UserIDs returns users with active planning
+func (s *SpentTimeStorage) UserIDs(ctx context.Context) ([]ctxtg.UserID, error) {
+	var uids []ctxtg.UserID
+	err := s.p.withSharedLock(ctx, func() error {
+		return sqlx.SelectContext(ctx, s.p.db, &uids, findActiveSpentTimeUserIDsStmt)
+	})
+	return uids, err
+}
+
+// Erase removes user's running timer and its lock
+func (s *SpentTimeStorage) Erase(ctx context.Context, uid ctxtg.UserID) error {
+	return s.p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+		if err := deleteActiveSpentTime(ctx, tx, uid); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, tx.Rebind(deleteActiveSpentTimeLockStmt), uid)
+		return err
+	})
+}
+
+// Backup does nothing, timers are persisted by database
+func (s *SpentTimeStorage) Backup(_ context.Context) error {
+	return nil
+}
+
+// Close does nothing, database is shared with PlanningStorage
+func (s *SpentTimeStorage) Close() error {
+	return nil
+}
+
+func saveActiveSpentTime(ctx context.Context, ex sqlx.ExtContext, st entities.SpentTime) error {
+	stmt := saveActiveSpentTimeStmt + dialectOf(ex).upsert("user_id",
+		"planning_id", "planning_created_at", "started_at", "last_at", "spent_online")
+	_, err := sqlx.NamedExecContext(ctx, ex, stmt, st)
+	return err
+}
+
+// lockActiveSpentTime creates or updates lock row of uid, it's locked until end of transaction
+func lockActiveSpentTime(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID) error {
+	stmt := lockActiveSpentTimeStmt + dialectOf(ex).upsert("user_id", "user_id")
+	_, err := ex.ExecContext(ctx, ex.Rebind(stmt), uid)
+	return err
+}
+
+// findActiveSpentTime returns nil if user hasn't running timer,
+// forUpdate locks timer until end of transaction
+func findActiveSpentTime(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID, forUpdate bool) (*entities.SpentTime, error) {
+	stmt := findActiveSpentTimeStmt
+	if forUpdate {
+		stmt += dialectOf(ex).forUpdate()
+	}
+	var st entities.SpentTime
+	err := sqlx.GetContext(ctx, ex, &st, ex.Rebind(stmt), uid)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func deleteActiveSpentTime(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID) error {
+	_, err := ex.ExecContext(ctx, ex.Rebind(deleteActiveSpentTimeStmt), uid)
+	return err
+}
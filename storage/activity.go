@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+// Planning.last_activity_at, Planning.current_estimation and UserActivity are denormalised
+// from SpentTimeHistory and PlannedTime, they are updated in same transaction as source tables
+// and may be recomputed by repairActivity.
+const (
+	saveUserActivityStmt = `
+		INSERT INTO UserActivity (user_id,
+								  last_activity_at)
+		VALUES					 ((SELECT user_id FROM Planning WHERE id = ?),
+								  ?)
+	`
+	findUserActivityStmt = `
+		SELECT last_activity_at
+		  FROM UserActivity
+		 WHERE user_id = ?
+	`
+	updateCurrentEstimationStmt = `
+		UPDATE Planning
+		   SET current_estimation = COALESCE((SELECT estimation
+		                                        FROM PlannedTime
+		                                       WHERE planning_id = Planning.id
+		                                       ORDER BY created_at DESC, id DESC
+		                                       LIMIT 1), 0)
+		 WHERE id = ?
+	`
+	repairLastActivityStmt = `
+		UPDATE Planning
+		   SET last_activity_at = COALESCE((SELECT MAX(ended_at)
+		                                      FROM SpentTimeHistory
+		                                     WHERE planning_id = Planning.id), 0)
+		 WHERE last_activity_at <> COALESCE((SELECT MAX(ended_at)
+		                                       FROM SpentTimeHistory
+		                                      WHERE planning_id = Planning.id), 0)
+	`
+	repairCurrentEstimationStmt = `
+		UPDATE Planning
+		   SET current_estimation = COALESCE((SELECT estimation
+		                                        FROM PlannedTime
+		                                       WHERE planning_id = Planning.id
+		                                       ORDER BY created_at DESC, id DESC
+		                                       LIMIT 1), 0)
+		 WHERE current_estimation <> COALESCE((SELECT estimation
+		                                         FROM PlannedTime
+		                                        WHERE planning_id = Planning.id
+		                                        ORDER BY created_at DESC, id DESC
+		                                        LIMIT 1), 0)
+	`
+	repairUserActivityStmt = `
+		UPDATE UserActivity
+		   SET last_activity_at = (SELECT MAX(last_activity_at)
+		                             FROM Planning
+		                            WHERE user_id = UserActivity.user_id)
+		 WHERE last_activity_at <> (SELECT MAX(last_activity_at)
+		                              FROM Planning
+		                             WHERE user_id = UserActivity.user_id)
+	`
+	repairMissingUserActivityStmt = `
+		INSERT INTO UserActivity (user_id,
+								  last_activity_at)
+		SELECT user_id, MAX(last_activity_at)
+		  FROM Planning
+		 WHERE user_id NOT IN (SELECT user_id FROM UserActivity)
+		 GROUP BY user_id
+	`
+)
+
+// ActivityRepair is number of rows fixed by RepairActivity
+type ActivityRepair struct {
+	LastActivities     int64
+	CurrentEstimations int64
+	UserActivities     int64
+}
+
+// saveUserActivity moves last activity of planning's user forward to endedAt
+func saveUserActivity(ctx context.Context, ex sqlx.ExtContext, pid entities.PlanningID, endedAt int64) error {
+	stmt := saveUserActivityStmt + dialectOf(ex).upsertMax("UserActivity", "user_id", "last_activity_at")
+	_, err := ex.ExecContext(ctx, ex.Rebind(stmt), pid, endedAt)
+	return err
+}
+
+func lastActivityForUser(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID) (int64, error) {
+	var lastActivity int64
+	err := sqlx.GetContext(ctx, ex, &lastActivity, ex.Rebind(findUserActivityStmt), uid)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return lastActivity, err
+}
+
+// updateCurrentEstimation sets current estimation of planning to latest planned time
+func updateCurrentEstimation(ctx context.Context, ex sqlx.ExtContext, pid entities.PlanningID) error {
+	_, err := ex.ExecContext(ctx, ex.Rebind(updateCurrentEstimationStmt), pid)
+	return err
+}
+
+// repairActivity recomputes denormalised columns from SpentTimeHistory and PlannedTime,
+// UserActivity is repaired after Planning because it's computed from last_activity_at
+func repairActivity(ctx context.Context, ex sqlx.ExtContext) (ActivityRepair, error) {
+	var r ActivityRepair
+	var err error
+	if r.LastActivities, err = execRowsAffected(ctx, ex, repairLastActivityStmt); err != nil {
+		return r, err
+	}
+	if r.CurrentEstimations, err = execRowsAffected(ctx, ex, repairCurrentEstimationStmt); err != nil {
+		return r, err
+	}
+	if r.UserActivities, err = execRowsAffected(ctx, ex, repairUserActivityStmt); err != nil {
+		return r, err
+	}
+	missing, err := execRowsAffected(ctx, ex, repairMissingUserActivityStmt)
+	r.UserActivities += missing
+	return r, err
+}
+
+func execRowsAffected(ctx context.Context, ex sqlx.ExtContext, stmt string) (int64, error) {
+	res, err := ex.ExecContext(ctx, stmt)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
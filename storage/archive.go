@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/qarea/planningms/entities"
+)
+
+// archiveBatchSize is number of plannings moved to archive in one transaction
+const archiveBatchSize = 100
+
+// Closed plannings are moved with their planned times and histories to archive tables
+// with same columns, archived plannings are read only if read through is enabled.
+const (
+	findArchivableStmt = `
+		SELECT id
+		  FROM Planning
+		 WHERE status = 'CLOSED'
+		   AND created_at < ?
+		   AND last_activity_at < ?
+		 ORDER BY id ASC
+		 LIMIT ?
+	`
+	archiveHistoriesStmt = `
+		INSERT INTO SpentTimeHistoryArchive (planning_id, status, spent, started_at, ended_at)
+		SELECT planning_id, status, spent, started_at, ended_at
+		  FROM SpentTimeHistory
+		 WHERE planning_id IN (?)
+	`
+	archivePlannedTimesStmt = `
+		INSERT INTO PlannedTimeArchive (id, planning_id, estimation, reason, created_at)
+		SELECT id, planning_id, estimation, reason, created_at
+		  FROM PlannedTime
+		 WHERE planning_id IN (?)
+	`
+	archivePlanningsStmt = `
+		INSERT INTO PlanningArchive (id, user_id, status, project_id, tracker_id, issue_id,
+									 issue_title, issue_url, issue_estim, issue_due_date, issue_done,
+									 activity_id, spent_online, spent_offline, reported, created_at,
+									 billable, version, last_activity_at, current_estimation)
+		SELECT id, user_id, status, project_id, tracker_id, issue_id,
+			   issue_title, issue_url, issue_estim, issue_due_date, issue_done,
+			   activity_id, spent_online, spent_offline, reported, created_at,
+			   billable, version, last_activity_at, current_estimation
+		  FROM Planning
+		 WHERE id IN (?)
+	`
+	deleteHistoriesStmt = `
+		DELETE FROM SpentTimeHistory
+		 WHERE planning_id IN (?)
+	`
+	deletePlannedTimesStmt = `
+		DELETE FROM PlannedTime
+		 WHERE planning_id IN (?)
+	`
+	deletePlanningsStmt = `
+		DELETE FROM Planning
+		 WHERE id IN (?)
+	`
+)
+
+var archivedTableRe = regexp.MustCompile(`\b(Planning|PlannedTime|SpentTimeHistory)\b`)
+
+// archiveReader executes queries against archive tables instead of primary ones,
+// it's used to read through to archive by same functions which read primary tables
+type archiveReader struct {
+	sqlx.ExtContext
+}
+
+func (a archiveReader) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return a.ExtContext.QueryContext(ctx, archived(query), args...)
+}
+
+func (a archiveReader) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	return a.ExtContext.QueryxContext(ctx, archived(query), args...)
+}
+
+func (a archiveReader) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	return a.ExtContext.QueryRowxContext(ctx, archived(query), args...)
+}
+
+func archived(query string) string {
+	return archivedTableRe.ReplaceAllString(query, "${1}Archive")
+}
+
+// archivePlannings moves up to archiveBatchSize closed plannings without activity since before
+// to archive tables and returns number of moved plannings
+func archivePlannings(ctx context.Context, ex sqlx.ExtContext, before int64) (int, error) {
+	var pids []entities.PlanningID
+	err := sqlx.SelectContext(ctx, ex, &pids, ex.Rebind(findArchivableStmt), before, before, archiveBatchSize)
+	if err != nil || len(pids) == 0 {
+		return 0, err
+	}
+	for _, stmt := range []string{
+		archiveHistoriesStmt,
+		archivePlannedTimesStmt,
+		archivePlanningsStmt,
+		deleteHistoriesStmt,
+		deletePlannedTimesStmt,
+		deletePlanningsStmt,
+	} {
+		q, args, err := sqlx.In(stmt, pids)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := ex.ExecContext(ctx, ex.Rebind(q), args...); err != nil {
+			return 0, err
+		}
+	}
+	return len(pids), nil
+}
+
+type planningsByCreatedAt []entities.Planning
+
+func (ps planningsByCreatedAt) Len() int           { return len(ps) }
+func (ps planningsByCreatedAt) Swap(i, j int)      { ps[i], ps[j] = ps[j], ps[i] }
+func (ps planningsByCreatedAt) Less(i, j int) bool { return ps[i].CreatedAt < ps[j].CreatedAt }
+
+type plannedTimesByCreatedAt []entities.PlannedTime
+
+func (pts plannedTimesByCreatedAt) Len() int      { return len(pts) }
+func (pts plannedTimesByCreatedAt) Swap(i, j int) { pts[i], pts[j] = pts[j], pts[i] }
+func (pts plannedTimesByCreatedAt) Less(i, j int) bool {
+	if pts[i].CreatedAt != pts[j].CreatedAt {
+		return pts[i].CreatedAt < pts[j].CreatedAt
+	}
+	return pts[i].ID < pts[j].ID
+}
+
+type historiesByStartedAt []entities.SpentTimeHistory
+
+func (hs historiesByStartedAt) Len() int           { return len(hs) }
+func (hs historiesByStartedAt) Swap(i, j int)      { hs[i], hs[j] = hs[j], hs[i] }
+func (hs historiesByStartedAt) Less(i, j int) bool { return hs[i].StartedAt < hs[j].StartedAt }
+
+type workSessionsByStartedAt []entities.WorkSession
+
+func (ws workSessionsByStartedAt) Len() int           { return len(ws) }
+func (ws workSessionsByStartedAt) Swap(i, j int)      { ws[i], ws[j] = ws[j], ws[i] }
+func (ws workSessionsByStartedAt) Less(i, j int) bool { return ws[i].StartedAt < ws[j].StartedAt }
+
+type billableWorkByStartedAt []entities.BillableWork
+
+func (ws billableWorkByStartedAt) Len() int           { return len(ws) }
+func (ws billableWorkByStartedAt) Swap(i, j int)      { ws[i], ws[j] = ws[j], ws[i] }
+func (ws billableWorkByStartedAt) Less(i, j int) bool { return ws[i].StartedAt < ws[j].StartedAt }
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"flag"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+// Hot path queries are measured on database seeded with realistic volume,
+// seeding takes minutes so it's done only by benchmarks or with -latency flag,
+// otherwise latency is checked on small database with generous threshold:
+//
+//	go test -run QueryLatency -latency ./storage
+//	go test -run NONE -bench Queries ./storage
+//	go test -run NONE -bench Queries ./storage -seed.users 1000
+var (
+	latency       = flag.Bool("latency", false, "check query latency on seeded database")
+	maxLatency    = flag.Duration("latency.max", 20*time.Millisecond, "max 95th percentile of query latency")
+	seedUsers     = flag.Int("seed.users", 10000, "users in seeded database")
+	seedDays      = flag.Int("seed.days", 730, "days of history of every user in seeded database")
+	seedPlannings = flag.Int("seed.plannings", 24, "plannings of every user in seeded database")
+	seedHistories = flag.Int("seed.histories", 4, "spent time histories of every planning in seeded database")
+)
+
+const (
+	seedBatchUsers = 100
+	latencySamples = 200
+	day            = 24 * 60 * 60
+	// smallMaxLatency is max 95th percentile of query latency on smallVolume
+	smallMaxLatency = 100 * time.Millisecond
+)
+
+// seedVolume is size of seeded database
+type seedVolume struct {
+	users     int
+	days      int
+	plannings int
+	histories int
+}
+
+// smallVolume is seeded by every test run in few seconds
+var smallVolume = seedVolume{users: 200, days: 730, plannings: 24, histories: 4}
+
+// flagVolume returns realistic volume set by flags
+func flagVolume() seedVolume {
+	return seedVolume{users: *seedUsers, days: *seedDays, plannings: *seedPlannings, histories: *seedHistories}
+}
+
+type hotQuery struct {
+	name string
+	run  func(ctxtg.UserID) error
+}
+
+// hotQueries are queries executed on every SetActive, AddSpentTime and GetOpenedPlannings
+func hotQueries(st *PlanningStorage) []hotQuery {
+	now := timeNowFunc()
+	return []hotQuery{
+		{"LastActivity", func(uid ctxtg.UserID) error {
+			_, err := st.LastActivity(ctx, uid)
+			return err
+		}},
+		{"OpenedPlannings", func(uid ctxtg.UserID) error {
+			_, err := st.OpenedPlannings(ctx, uid)
+			return err
+		}},
+		{"SpentTimeByUserIDTimeRange", func(uid ctxtg.UserID) error {
+			_, err := st.SpentTimeByUserIDTimeRange(ctx, uid, now-30*day, now)
+			return err
+		}},
+		{"IsPeriodLocked", func(uid ctxtg.UserID) error {
+			_, err := st.IsPeriodLocked(ctx, uid, now-day, now)
+			return err
+		}},
+	}
+}
+
+func TestQueryLatency(t *testing.T) {
+	vol, limit := smallVolume, smallMaxLatency
+	if *latency {
+		vol, limit = flagVolume(), *maxLatency
+	}
+	defer prepareDB()()
+	db := newTestDB()
+	uids := seed(t, db, vol)
+	for _, q := range hotQueries(NewPlanningStorage(db, second)) {
+		var ds durations
+		for i := 0; i < latencySamples; i++ {
+			start := time.Now()
+			if err := q.run(uids[i%len(uids)]); err != nil {
+				t.Fatal(q.name, err)
+			}
+			ds = append(ds, time.Since(start))
+		}
+		sort.Sort(ds)
+		p95 := ds[len(ds)*95/100]
+		t.Logf("%s: median %v, 95th percentile %v", q.name, ds[len(ds)/2], p95)
+		if p95 > limit {
+			t.Errorf("%s: 95th percentile %v exceeds %v", q.name, p95, limit)
+		}
+	}
+}
+
+func BenchmarkQueries(b *testing.B) {
+	defer prepareDB()()
+	db := newTestDB()
+	uids := seed(b, db, flagVolume())
+	for _, q := range hotQueries(NewPlanningStorage(db, second)) {
+		q := q
+		b.Run(q.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := q.run(uids[i%len(uids)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// seed fills db with plannings spread over vol.days for every user,
+// last 2 plannings of user are opened, returns user ids in random order
+func seed(tb testing.TB, db *sqlx.DB, vol seedVolume) []ctxtg.UserID {
+	start := time.Now()
+	uids := make([]ctxtg.UserID, vol.users)
+	for i, n := range rand.Perm(vol.users) {
+		uids[i] = ctxtg.UserID(n + 1)
+	}
+	for from := 0; from < vol.users; from += seedBatchUsers {
+		tx, err := db.Beginx()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		for uid := from + 1; uid <= from+seedBatchUsers && uid <= vol.users; uid++ {
+			seedUser(tb, tx, ctxtg.UserID(uid), vol)
+		}
+		if err := tx.Commit(); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	tb.Logf("seeded %d users with %d plannings and %d histories each in %v",
+		vol.users, vol.plannings, vol.plannings*vol.histories, time.Since(start))
+	return uids
+}
+
+func seedUser(tb testing.TB, tx *sqlx.Tx, uid ctxtg.UserID, vol seedVolume) {
+	now := timeNowFunc()
+	step := int64(vol.days) * day / int64(vol.plannings)
+	for i := 0; i < vol.plannings; i++ {
+		createdAt := now - int64(vol.plannings-i)*step
+		status := entities.Closed
+		if i >= vol.plannings-2 {
+			status = entities.Open
+		}
+		pid, err := savePlanning(ctx, tx, entities.Planning{
+			UserID:     uid,
+			Status:     status,
+			ProjectID:  entities.ProjectID(rand.Int31n(100) + 1),
+			TrackerID:  1,
+			IssueID:    entities.IssueID(rand.Int31()),
+			IssueTitle: randString(),
+			IssueURL:   randString(),
+			ActivityID: entities.ActivityID(rand.Int31n(10) + 1),
+			CreatedAt:  createdAt,
+			Billable:   true,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		_, err = savePlannedTime(ctx, tx, entities.PlannedTime{
+			PlanningID: pid,
+			Estimation: rand.Int63n(8 * 60 * 60),
+			CreatedAt:  createdAt,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		for j := 0; j < vol.histories; j++ {
+			startedAt := createdAt + int64(j)*step/int64(vol.histories)
+			h := entities.SpentTimeHistory{
+				PlanningID: pid,
+				Spent:      30 * 60,
+				StartedAt:  startedAt,
+				EndedAt:    startedAt + 30*60,
+				Status:     entities.Online,
+			}
+			if err := saveHistory(ctx, tx, h); err != nil {
+				tb.Fatal(err)
+			}
+			if err := addSpentTimeToPlanning(ctx, tx, h); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+}
+
+type durations []time.Duration
+
+func (ds durations) Len() int           { return len(ds) }
+func (ds durations) Swap(i, j int)      { ds[i], ds[j] = ds[j], ds[i] }
+func (ds durations) Less(i, j int) bool { return ds[i] < ds[j] }
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/qarea/planningms/entities"
+)
+
+const (
+	saveProjectBillingStmt = `
+		INSERT INTO ProjectBilling (project_id,
+									billable)
+		VALUES					   (:project_id,
+									:billable)
+	`
+	findProjectBillableStmt = `
+		SELECT billable
+		  FROM ProjectBilling
+		 WHERE project_id = ?
+	`
+	updatePlanningBillableStmt = `
+		UPDATE Planning
+		   SET billable = ?,
+		       version = version + 1
+		 WHERE id = ?
+	`
+	saveRateStmt = `
+		INSERT INTO Rate (user_id,
+						  project_id,
+						  activity_id,
+						  rate,
+						  effective_from)
+		VALUES			 (:user_id,
+						  :project_id,
+						  :activity_id,
+						  :rate,
+						  :effective_from)
+	`
+	deleteRateStmt = `
+		DELETE FROM Rate
+		 WHERE id = ?
+	`
+	findRatesStmt = `
+		SELECT *
+		  FROM Rate
+		 ORDER BY effective_from ASC, id ASC
+	`
+	findBillableWorkStmt = `
+		SELECT s.planning_id,
+			   s.spent,
+			   s.started_at,
+			   s.ended_at,
+			   s.status,
+			   p.user_id,
+			   p.project_id,
+			   p.activity_id
+		  FROM Planning AS p INNER JOIN SpentTimeHistory AS s
+			ON p.id = s.planning_id
+		 WHERE p.billable = TRUE
+		   AND s.ended_at > ?
+		   AND s.started_at < ?
+		   AND (? = 0 OR p.user_id = ?)
+		   AND (? = 0 OR p.project_id = ?)
+		 ORDER BY s.started_at ASC
+	`
+)
+
+type billableWork struct {
+	entities.BillableWork
+	Status string `db:"status"`
+}
+
+func saveProjectBilling(ctx context.Context, ex sqlx.ExtContext, pb entities.ProjectBilling) error {
+	stmt := saveProjectBillingStmt + dialectOf(ex).upsert("project_id", "billable")
+	_, err := sqlx.NamedExecContext(ctx, ex, stmt, pb)
+	return err
+}
+
+// projectBillable returns billable rule of project, projects without rule are billable
+func projectBillable(ctx context.Context, ex sqlx.ExtContext, pid entities.ProjectID) (bool, error) {
+	var billable bool
+	err := sqlx.GetContext(ctx, ex, &billable, ex.Rebind(findProjectBillableStmt), pid)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return billable, err
+}
+
+func updatePlanningBillable(ctx context.Context, ex sqlx.ExtContext, pid entities.PlanningID, billable bool) error {
+	_, err := ex.ExecContext(ctx, ex.Rebind(updatePlanningBillableStmt), billable, pid)
+	return err
+}
+
+func saveRate(ctx context.Context, ex sqlx.ExtContext, r entities.Rate) (entities.RateID, error) {
+	id, err := dialectOf(ex).insert(ctx, ex, saveRateStmt, r)
+	if err != nil {
+		return 0, err
+	}
+	return entities.RateID(id), nil
+}
+
+func deleteRate(ctx context.Context, ex sqlx.ExtContext, id entities.RateID) error {
+	res, err := ex.ExecContext(ctx, ex.Rebind(deleteRateStmt), id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return entities.ErrInvalidRateID
+	}
+	return nil
+}
+
+func findRates(ctx context.Context, ex sqlx.ExtContext) ([]entities.Rate, error) {
+	var rates []entities.Rate
+	err := sqlx.SelectContext(ctx, ex, &rates, findRatesStmt)
+	return rates, err
+}
+
+func findBillableWork(ctx context.Context, ex sqlx.ExtContext, q entities.BillingQuery) ([]entities.BillableWork, error) {
+	var work []billableWork
+	err := sqlx.SelectContext(ctx, ex, &work, ex.Rebind(findBillableWorkStmt), q.From, q.To, q.UserID, q.UserID, q.ProjectID, q.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	var ws []entities.BillableWork
+	for _, w := range work {
+		w.BillableWork.Status = entities.SpentTimeStatus(w.Status)
+		ws = append(ws, w.BillableWork)
+	}
+	return ws, nil
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Supported database drivers, db given to NewPlanningStorage should be opened with one of them
+const (
+	MySQL    = "mysql"
+	Postgres = "postgres"
+	SQLite   = "sqlite3"
+)
+
+const (
+	mysqlForeignKeyErrorCode    = 1452
+	mysqlDuplicateKeyErrorCode  = 1062
+	postgresForeignKeyErrorCode = "23503"
+	postgresUniqueErrorCode     = "23505"
+)
+
+// dialect hides differences between SQL databases,
+// statements are written with ? placeholders and should be rebound by ex.Rebind
+type dialect interface {
+	// insert executes named INSERT stmt and returns id of inserted row
+	insert(ctx context.Context, ex sqlx.ExtContext, stmt string, arg interface{}) (int64, error)
+	// upsert returns clause for INSERT which updates columns of existing row with same key
+	upsert(key string, columns ...string) string
+	// upsertMax returns clause for INSERT into table which keeps greater value of column
+	// when row with same key exists
+	upsertMax(table, key, column string) string
+	// forUpdate returns clause for SELECT which locks selected rows until end of transaction
+	forUpdate() string
+	isForeignKeyError(err error) bool
+	// isDuplicateError reports if err is violation of primary key or unique constraint
+	isDuplicateError(err error) bool
+}
+
+func dialectOf(ex sqlx.ExtContext) dialect {
+	switch ex.DriverName() {
+	case Postgres:
+		return postgresDialect{}
+	case SQLite:
+		return sqliteDialect{}
+	default:
+		return mysqlDialect{}
+	}
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) insert(ctx context.Context, ex sqlx.ExtContext, stmt string, arg interface{}) (int64, error) {
+	return insertLastInsertID(ctx, ex, stmt, arg)
+}
+
+func (mysqlDialect) upsert(_ string, columns ...string) string {
+	var sets []string
+	for _, c := range columns {
+		sets = append(sets, fmt.Sprintf("%s = VALUES(%s)", c, c))
+	}
+	return " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+func (mysqlDialect) upsertMax(_, _, column string) string {
+	return fmt.Sprintf(" ON DUPLICATE KEY UPDATE %s = GREATEST(%s, VALUES(%s))", column, column, column)
+}
+
+func (mysqlDialect) forUpdate() string {
+	return " FOR UPDATE"
+}
+
+func (mysqlDialect) isForeignKeyError(err error) bool {
+	e, ok := err.(*mysql.MySQLError)
+	return ok && e.Number == mysqlForeignKeyErrorCode
+}
+
+func (mysqlDialect) isDuplicateError(err error) bool {
+	e, ok := err.(*mysql.MySQLError)
+	return ok && e.Number == mysqlDuplicateKeyErrorCode
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) insert(ctx context.Context, ex sqlx.ExtContext, stmt string, arg interface{}) (int64, error) {
+	rows, err := sqlx.NamedQueryContext(ctx, ex, stmt+" RETURNING id", arg)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var id int64
+	if rows.Next() {
+		err = rows.Scan(&id)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return id, rows.Err()
+}
+
+func (postgresDialect) upsert(key string, columns ...string) string {
+	return onConflictUpdate(key, columns)
+}
+
+func (postgresDialect) upsertMax(table, key, column string) string {
+	return fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s = GREATEST(%s.%s, EXCLUDED.%s)", key, column, table, column, column)
+}
+
+func (postgresDialect) forUpdate() string {
+	return " FOR UPDATE"
+}
+
+func (postgresDialect) isForeignKeyError(err error) bool {
+	e, ok := err.(*pq.Error)
+	return ok && e.Code == postgresForeignKeyErrorCode
+}
+
+func (postgresDialect) isDuplicateError(err error) bool {
+	e, ok := err.(*pq.Error)
+	return ok && e.Code == postgresUniqueErrorCode
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) insert(ctx context.Context, ex sqlx.ExtContext, stmt string, arg interface{}) (int64, error) {
+	return insertLastInsertID(ctx, ex, stmt, arg)
+}
+
+func (sqliteDialect) upsert(key string, columns ...string) string {
+	return onConflictUpdate(key, columns)
+}
+
+func (sqliteDialect) upsertMax(table, key, column string) string {
+	return fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s = MAX(%s.%s, EXCLUDED.%s)", key, column, table, column, column)
+}
+
+// forUpdate isn't needed because sqlite database has single connection
+// and transactions are serialized by it
+func (sqliteDialect) forUpdate() string {
+	return ""
+}
+
+func (sqliteDialect) isForeignKeyError(err error) bool {
+	e, ok := err.(sqlite3.Error)
+	return ok && e.ExtendedCode == sqlite3.ErrConstraintForeignKey
+}
+
+func (sqliteDialect) isDuplicateError(err error) bool {
+	e, ok := err.(sqlite3.Error)
+	return ok && (e.ExtendedCode == sqlite3.ErrConstraintPrimaryKey || e.ExtendedCode == sqlite3.ErrConstraintUnique)
+}
+
+func insertLastInsertID(ctx context.Context, ex sqlx.ExtContext, stmt string, arg interface{}) (int64, error) {
+	res, err := sqlx.NamedExecContext(ctx, ex, stmt, arg)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func onConflictUpdate(key string, columns []string) string {
+	var sets []string
+	for _, c := range columns {
+		sets = append(sets, fmt.Sprintf("%s = EXCLUDED.%s", c, c))
+	}
+	return " ON CONFLICT (" + key + ") DO UPDATE SET " + strings.Join(sets, ", ")
+}
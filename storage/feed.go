@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+const feedTokenSize = 32
+
+const (
+	saveFeedTokenStmt = `
+		INSERT INTO FeedToken (user_id,
+							   token,
+							   created_at)
+		VALUES				  (?, ?, ?)
+	`
+	findFeedTokenStmt = `
+		SELECT token
+		  FROM FeedToken
+		 WHERE user_id = ?
+	`
+	findFeedUserIDStmt = `
+		SELECT user_id
+		  FROM FeedToken
+		 WHERE token = ?
+	`
+)
+
+var newFeedTokenFunc = func() (string, error) {
+	b := make([]byte, feedTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// insertFeedToken fails with duplicate error if user has token already
+func insertFeedToken(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID, token string) error {
+	_, err := ex.ExecContext(ctx, ex.Rebind(saveFeedTokenStmt), uid, token, timeNowFunc())
+	return err
+}
+
+func saveFeedToken(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID, token string) error {
+	stmt := saveFeedTokenStmt + dialectOf(ex).upsert("user_id", "token", "created_at")
+	_, err := ex.ExecContext(ctx, ex.Rebind(stmt), uid, token, timeNowFunc())
+	return err
+}
+
+func findFeedToken(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID) (string, error) {
+	var token string
+	err := sqlx.GetContext(ctx, ex, &token, ex.Rebind(findFeedTokenStmt), uid)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return token, err
+}
+
+func findFeedUserID(ctx context.Context, ex sqlx.ExtContext, token string) (ctxtg.UserID, error) {
+	var uid ctxtg.UserID
+	err := sqlx.GetContext(ctx, ex, &uid, ex.Rebind(findFeedUserIDStmt), token)
+	if err == sql.ErrNoRows {
+		return 0, entities.ErrInvalidFeedToken
+	}
+	return uid, err
+}
@@ -1,9 +1,8 @@
 package storage
 
 import (
-	"database/sql"
+	"context"
 
-	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/qarea/ctxtg"
 	"github.com/qarea/planningms/entities"
@@ -27,19 +26,26 @@ const (
 		  FROM SpentTimeHistory
 		 WHERE planning_id = ?
 	`
-	findLastActivityStmt = `
-		SELECT ended_at
+	findHistoriesByPlanningIDs = `
+		SELECT *
+		  FROM SpentTimeHistory
+		 WHERE planning_id IN (?)
+		 ORDER BY started_at ASC
+	`
+	findWorkSessionsStmt = `
+		SELECT s.planning_id,
+			   s.spent,
+			   s.started_at,
+			   s.ended_at,
+			   s.status,
+			   p.issue_title,
+			   p.issue_url
 		  FROM Planning AS p INNER JOIN SpentTimeHistory AS s
 			ON p.id = s.planning_id
 		 WHERE p.user_id = ?
-		 ORDER BY ended_at DESC
-		 LIMIT 1
-	`
-	findLastActivitiesStmt = `
-		SELECT planning_id, MAX(ended_at) AS ended_at
-		  FROM SpentTimeHistory
-		 WHERE planning_id IN (?)
-		 GROUP BY planning_id
+		   AND s.ended_at >= ?
+		   AND s.started_at <= ?
+		 ORDER BY s.started_at ASC
 	`
 )
 
@@ -48,26 +54,30 @@ type spentTimeHistory struct {
 	Status string `db:"status"`
 }
 
-func saveHistory(ex sqlx.Ext, h entities.SpentTimeHistory) (int64, error) {
+type workSession struct {
+	entities.WorkSession
+	Status string `db:"status"`
+}
+
+func saveHistory(ctx context.Context, ex sqlx.ExtContext, h entities.SpentTimeHistory) error {
 	sth := spentTimeHistory{
 		SpentTimeHistory: h,
 		Status:           string(h.Status),
 	}
-	res, err := sqlx.NamedExec(ex, saveSpentTimeHistoryStmt, sth)
-	if err, ok := err.(*mysql.MySQLError); ok {
-		if err.Number == mysqlForeignKeyErrorCode {
-			return 0, entities.ErrInvalidPlanningID
-		}
+	_, err := sqlx.NamedExecContext(ctx, ex, saveSpentTimeHistoryStmt, sth)
+	d := dialectOf(ex)
+	if d.isForeignKeyError(err) {
+		return entities.ErrInvalidPlanningID
 	}
-	if err != nil {
-		return 0, err
+	if d.isDuplicateError(err) {
+		return entities.ErrDuplicateHistory
 	}
-	return res.LastInsertId()
+	return err
 }
 
-func findHistories(ex sqlx.Ext, pid entities.PlanningID) ([]entities.SpentTimeHistory, error) {
+func findHistories(ctx context.Context, ex sqlx.ExtContext, pid entities.PlanningID) ([]entities.SpentTimeHistory, error) {
 	var histories []spentTimeHistory
-	err := sqlx.Select(ex, &histories, findHistoriesByPlanningID, pid)
+	err := sqlx.SelectContext(ctx, ex, &histories, ex.Rebind(findHistoriesByPlanningID), pid)
 	if err != nil {
 		return nil, err
 	}
@@ -79,35 +89,37 @@ func findHistories(ex sqlx.Ext, pid entities.PlanningID) ([]entities.SpentTimeHi
 	return hs, nil
 }
 
-func lastActivityForUser(ex sqlx.Ext, uid ctxtg.UserID) (int64, error) {
-	var lastActivity int64
-	err := sqlx.Get(ex, &lastActivity, findLastActivityStmt, uid)
-	if err == sql.ErrNoRows {
-		return 0, nil
-	}
-	return lastActivity, err
-}
-
-func lastActivityForPlannings(ex sqlx.Ext, ps []entities.Planning) (map[entities.PlanningID]int64, error) {
-	if len(ps) == 0 {
+func findHistoriesForPlannings(ctx context.Context, ex sqlx.ExtContext, pids []entities.PlanningID) ([]entities.SpentTimeHistory, error) {
+	if len(pids) == 0 {
 		return nil, nil
 	}
-	var ids []entities.PlanningID
-	for _, p := range ps {
-		ids = append(ids, p.ID)
+	q, args, err := sqlx.In(findHistoriesByPlanningIDs, pids)
+	if err != nil {
+		return nil, err
 	}
-	q, args, err := sqlx.In(findLastActivitiesStmt, ids)
+	var histories []spentTimeHistory
+	err = sqlx.SelectContext(ctx, ex, &histories, ex.Rebind(q), args...)
 	if err != nil {
 		return nil, err
 	}
-	var results []struct {
-		PlanningID entities.PlanningID `db:"planning_id"`
-		EndedAt    int64               `db:"ended_at"`
+	var hs []entities.SpentTimeHistory
+	for _, h := range histories {
+		h.SpentTimeHistory.Status = entities.SpentTimeStatus(h.Status)
+		hs = append(hs, h.SpentTimeHistory)
+	}
+	return hs, nil
+}
+
+func findWorkSessions(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID, from, to int64) ([]entities.WorkSession, error) {
+	var sessions []workSession
+	err := sqlx.SelectContext(ctx, ex, &sessions, ex.Rebind(findWorkSessionsStmt), uid, from, to)
+	if err != nil {
+		return nil, err
 	}
-	err = sqlx.Select(ex, &results, q, args...)
-	activities := map[entities.PlanningID]int64{}
-	for _, v := range results {
-		activities[v.PlanningID] = v.EndedAt
+	var ws []entities.WorkSession
+	for _, s := range sessions {
+		s.WorkSession.Status = entities.SpentTimeStatus(s.Status)
+		ws = append(ws, s.WorkSession)
 	}
-	return activities, err
+	return ws, nil
 }
@@ -8,9 +8,17 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/powerman/narada-go/narada/staging"
+	"github.com/qarea/planningms/cfg"
+	"github.com/qarea/planningms/migration"
+	"github.com/qarea/planningms/mysqldb"
+	"github.com/qarea/planningms/postgresdb"
+	"github.com/qarea/planningms/sqlitedb"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 func TestMain(m *testing.M) {
@@ -20,7 +28,29 @@ func TestMain(m *testing.M) {
 
 type cleanupFunc func()
 
+// newTestDB connects to database of driver configured in config/storage/driver
+func newTestDB() *sqlx.DB {
+	switch cfg.Storage.Driver {
+	case Postgres:
+		return postgresdb.New()
+	case SQLite:
+		return sqlitedb.New()
+	default:
+		return mysqldb.New()
+	}
+}
+
 func prepareDB() cleanupFunc {
+	switch cfg.Storage.Driver {
+	case Postgres:
+		resetPostgres()
+		migrateTestDB()
+		return resetPostgres
+	case SQLite:
+		removeSQLite()
+		migrateTestDB()
+		return removeSQLite
+	}
 	err := exec.Command("narada-setup-mysql").Run()
 	if err != nil {
 		log.Fatalln("narada-setup-mysql failed: ", err)
@@ -34,3 +64,31 @@ func cleanup() {
 		log.Fatalln("narada-setup-mysql --clean failed ", err)
 	}
 }
+
+// migrateTestDB creates tables of postgres or sqlite database by embedded migrations
+func migrateTestDB() {
+	db := newTestDB()
+	defer db.Close()
+	r, err := migration.NewDriverRunner(db, cfg.Storage.Driver, "", migration.NoBaseline)
+	if err != nil {
+		log.Fatalln("failed to load migrations: ", err)
+	}
+	if err := r.Up(-1); err != nil {
+		log.Fatalln("failed to apply migrations: ", err)
+	}
+}
+
+// resetPostgres drops all tables
+func resetPostgres() {
+	db := postgresdb.New()
+	defer db.Close()
+	db.MustExec("DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+}
+
+// removeSQLite removes database file
+func removeSQLite() {
+	err := os.Remove(cfg.SQLite.Path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalln("failed to remove sqlite database: ", err)
+	}
+}
@@ -1,7 +1,7 @@
 package storage
 
 import (
-	"github.com/go-sql-driver/mysql"
+	"context"
 	"github.com/jmoiron/sqlx"
 	"github.com/qarea/planningms/entities"
 )
@@ -17,52 +17,36 @@ const (
 							     :reason,     
                                  :created_at) 
 	`
-	latestEstimationStmt = `
-		SELECT pt.planning_id, pt.estimation
-          FROM PlannedTime AS pt
-         INNER JOIN (
-	                 SELECT planning_id, MAX(created_at) AS created_at
-                       FROM PlannedTime
-                      WHERE planning_id IN (?)
-	                  GROUP BY planning_id
-	     ) AS latest 
-	        ON pt.planning_id = latest.planning_id 
-	       AND pt.created_at = latest.created_at`
+	findPlannedTimesStmt = `
+		SELECT *
+		  FROM PlannedTime
+		 WHERE planning_id IN (?)
+		 ORDER BY created_at ASC, id ASC
+	`
 )
 
-func estimationsForPlannings(ex sqlx.Ext, ps []entities.Planning) (map[entities.PlanningID]int64, error) {
-	if len(ps) == 0 {
+func findPlannedTimes(ctx context.Context, ex sqlx.ExtContext, pids []entities.PlanningID) ([]entities.PlannedTime, error) {
+	if len(pids) == 0 {
 		return nil, nil
 	}
-	var ids []entities.PlanningID
-	for _, p := range ps {
-		ids = append(ids, p.ID)
-	}
-	q, args, err := sqlx.In(latestEstimationStmt, ids)
+	q, args, err := sqlx.In(findPlannedTimesStmt, pids)
 	if err != nil {
 		return nil, err
 	}
-	var results []struct {
-		PlanningID entities.PlanningID `db:"planning_id"`
-		Estimation int64               `db:"estimation"`
-	}
-	err = sqlx.Select(ex, &results, q, args...)
-	ests := map[entities.PlanningID]int64{}
-	for _, v := range results {
-		ests[v.PlanningID] = v.Estimation
-	}
-	return ests, err
+	var pts []entities.PlannedTime
+	err = sqlx.SelectContext(ctx, ex, &pts, ex.Rebind(q), args...)
+	return pts, err
 }
 
-func savePlannedTime(ex sqlx.Ext, p entities.PlannedTime) (int64, error) {
-	res, err := sqlx.NamedExec(ex, savePlannedTimeStmt, p)
-	if err, ok := err.(*mysql.MySQLError); ok {
-		if err.Number == mysqlForeignKeyErrorCode {
-			return 0, entities.ErrInvalidPlanningID
-		}
+// savePlannedTime saves planned time and updates current estimation of planning
+func savePlannedTime(ctx context.Context, ex sqlx.ExtContext, p entities.PlannedTime) (int64, error) {
+	d := dialectOf(ex)
+	id, err := d.insert(ctx, ex, savePlannedTimeStmt, p)
+	if d.isForeignKeyError(err) {
+		return 0, entities.ErrInvalidPlanningID
 	}
 	if err != nil {
 		return 0, err
 	}
-	return res.LastInsertId()
+	return id, updateCurrentEstimation(ctx, ex, p.PlanningID)
 }
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 
@@ -25,7 +26,8 @@ const (
 							  spent_online,
 							  spent_offline,
 							  reported,
-							  created_at)
+							  created_at,
+							  billable)
 		VALUES				 (:user_id,
 							  :status,
 							  :project_id,
@@ -40,7 +42,8 @@ const (
                               :spent_online,
                               :spent_offline,
                               :reported,
-                              :created_at)
+                              :created_at,
+                              :billable)
 	`
 	updatePlanningsStmt = `
 		UPDATE Planning
@@ -56,14 +59,22 @@ const (
                spent_online  = :spent_online,
                spent_offline = :spent_offline,
                reported      = :reported,
-               created_at    = :created_at
+               created_at    = :created_at,
+               version       = version + 1
          WHERE id            = :id
+           AND version       = :version
 	`
 	findPlanningByIDStmt = `
 		SELECT *
 		  FROM Planning
 		 WHERE id = ?
 	`
+	findPlanningsByIDsStmt = `
+		SELECT *
+		  FROM Planning
+		 WHERE id IN (?)
+		 ORDER BY created_at ASC
+	`
 	createdAtStmt = `
 		SELECT created_at
 		  FROM Planning
@@ -71,19 +82,23 @@ const (
 	`
 	incrementOnlineStmt = `
 		UPDATE Planning
-		   SET spent_online = spent_online + ?
+		   SET spent_online = spent_online + ?,
+		       last_activity_at = CASE WHEN last_activity_at < ? THEN ? ELSE last_activity_at END,
+		       version = version + 1
 		 WHERE id = ?
 	`
 	incrementOfflineStmt = `
 		UPDATE Planning
-		   SET spent_offline = spent_offline + ?
+		   SET spent_offline = spent_offline + ?,
+		       last_activity_at = CASE WHEN last_activity_at < ? THEN ? ELSE last_activity_at END,
+		       version = version + 1
 		 WHERE id = ?
 	`
 	openedPlanningsStmt = `
 		SELECT *
 		  FROM Planning
 		 WHERE user_id = ?
-           AND status = "OPEN"
+           AND status = 'OPEN'
          ORDER BY created_at ASC
 	`
 	spentSumStmt = `
@@ -95,57 +110,67 @@ const (
 	`
 )
 
+// planning is row of Planning table, LastActivityAt and CurrentEstimation are maintained
+// by addSpentTimeToPlanning and savePlannedTime
 type planning struct {
 	entities.Planning
-	Status string `db:"status"`
+	Status            string `db:"status"`
+	LastActivityAt    int64  `db:"last_activity_at"`
+	CurrentEstimation int64  `db:"current_estimation"`
 }
 
-func spentTime(ex sqlx.Ext, uid ctxtg.UserID, from, to int64) (int, error) {
+func spentTime(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID, from, to int64) (int, error) {
 	var spent sql.NullInt64
-	err := sqlx.Get(ex, &spent, spentSumStmt, uid, from, to)
+	err := sqlx.GetContext(ctx, ex, &spent, ex.Rebind(spentSumStmt), uid, from, to)
 	return int(spent.Int64), err
 }
 
-func openedPlannings(ex sqlx.Ext, uid ctxtg.UserID) ([]entities.Planning, error) {
+func openedPlannings(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID) ([]entities.ExtendedPlanning, error) {
 	var plannings []planning
-	err := sqlx.Select(ex, &plannings, openedPlanningsStmt, uid)
+	err := sqlx.SelectContext(ctx, ex, &plannings, ex.Rebind(openedPlanningsStmt), uid)
 	if err != nil {
 		return nil, err
 	}
-	var ps []entities.Planning
+	var ps []entities.ExtendedPlanning
 	for _, p := range plannings {
-		ps = append(ps, fromDBPlanning(p))
+		ps = append(ps, entities.ExtendedPlanning{
+			Planning:     fromDBPlanning(p),
+			Estimation:   p.CurrentEstimation,
+			LastActivity: p.LastActivityAt,
+		})
 	}
 	return ps, nil
 }
 
-func addSpentTimeToPlanning(ex sqlx.Ext, h entities.SpentTimeHistory) error {
+// addSpentTimeToPlanning increments spent time and moves last activity of planning and its user
+func addSpentTimeToPlanning(ctx context.Context, ex sqlx.ExtContext, h entities.SpentTimeHistory) error {
+	var stmt string
 	switch h.Status {
 	case entities.Online:
-		_, err := ex.Exec(incrementOnlineStmt, h.Spent, h.PlanningID)
-		return err
+		stmt = incrementOnlineStmt
 	case entities.Offline:
-		_, err := ex.Exec(incrementOfflineStmt, h.Spent, h.PlanningID)
+		stmt = incrementOfflineStmt
+	default:
+		return errors.New("invalid status")
+	}
+	_, err := ex.ExecContext(ctx, ex.Rebind(stmt), h.Spent, h.EndedAt, h.EndedAt, h.PlanningID)
+	if err != nil {
 		return err
 	}
-	return errors.New("invalid status")
+	return saveUserActivity(ctx, ex, h.PlanningID, h.EndedAt)
 }
 
-func savePlanning(ex sqlx.Ext, p entities.Planning) (entities.PlanningID, error) {
+func savePlanning(ctx context.Context, ex sqlx.ExtContext, p entities.Planning) (entities.PlanningID, error) {
 	planningDB := toDBPlanning(p)
-	res, err := sqlx.NamedExec(ex, savePlanningsStmt, planningDB)
-	if err != nil {
-		return 0, err
-	}
-	id, err := res.LastInsertId()
+	id, err := dialectOf(ex).insert(ctx, ex, savePlanningsStmt, planningDB)
 	if err != nil {
 		return 0, err
 	}
 	return entities.PlanningID(id), nil
 }
 
-func findPlanning(ex sqlx.Ext, pid entities.PlanningID) (*entities.Planning, error) {
-	row := ex.QueryRowx(findPlanningByIDStmt, pid)
+func findPlanning(ctx context.Context, ex sqlx.ExtContext, pid entities.PlanningID) (*entities.Planning, error) {
+	row := ex.QueryRowxContext(ctx, ex.Rebind(findPlanningByIDStmt), pid)
 	var p planning
 	err := row.StructScan(&p)
 	if err == sql.ErrNoRows {
@@ -155,13 +180,45 @@ func findPlanning(ex sqlx.Ext, pid entities.PlanningID) (*entities.Planning, err
 	return &planning, err
 }
 
-func updatePlanning(ex sqlx.Ext, p entities.Planning) error {
-	_, err := sqlx.NamedExec(ex, updatePlanningsStmt, toDBPlanning(p))
-	return err
+func findPlannings(ctx context.Context, ex sqlx.ExtContext, pids []entities.PlanningID) ([]entities.Planning, error) {
+	if len(pids) == 0 {
+		return nil, nil
+	}
+	q, args, err := sqlx.In(findPlanningsByIDsStmt, pids)
+	if err != nil {
+		return nil, err
+	}
+	var plannings []planning
+	err = sqlx.SelectContext(ctx, ex, &plannings, ex.Rebind(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	var ps []entities.Planning
+	for _, p := range plannings {
+		ps = append(ps, fromDBPlanning(p))
+	}
+	return ps, nil
+}
+
+// updatePlanning overwrites planning only if it wasn't changed since p was loaded,
+// returns entities.ErrPlanningConflict otherwise
+func updatePlanning(ctx context.Context, ex sqlx.ExtContext, p entities.Planning) error {
+	res, err := sqlx.NamedExecContext(ctx, ex, updatePlanningsStmt, toDBPlanning(p))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return entities.ErrPlanningConflict
+	}
+	return nil
 }
 
-func planningCreatedAt(ex sqlx.Ext, pid entities.PlanningID) (int64, error) {
-	row := ex.QueryRowx(createdAtStmt, int64(pid))
+func planningCreatedAt(ctx context.Context, ex sqlx.ExtContext, pid entities.PlanningID) (int64, error) {
+	row := ex.QueryRowxContext(ctx, ex.Rebind(createdAtStmt), int64(pid))
 	var createdAt int64
 	err := row.Scan(&createdAt)
 	if err == sql.ErrNoRows {
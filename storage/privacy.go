@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+// Erased user's rows are deleted from all tables, pseudonymised user's plannings, timesheets,
+// rates and activity are kept under pseudonym while team memberships and feed token are deleted.
+const (
+	savePrivacyRequestStmt = `
+		INSERT INTO PrivacyRequest (user_id,
+									admin_id,
+									action,
+									created_at)
+		VALUES					   (:user_id,
+									:admin_id,
+									:action,
+									:created_at)
+	`
+	findPrivacyRequestsStmt = `
+		SELECT *
+		  FROM PrivacyRequest
+		 WHERE user_id = ?
+		 ORDER BY created_at ASC, id ASC
+	`
+	findUserPlanningIDsStmt = `
+		SELECT id
+		  FROM Planning
+		 WHERE user_id = ?
+	`
+	findUserTimesheetsStmt = `
+		SELECT *
+		  FROM Timesheet
+		 WHERE user_id = ?
+		 ORDER BY period_from ASC
+	`
+	findUserTeamMembersStmt = `
+		SELECT *
+		  FROM TeamMember
+		 WHERE user_id = ?
+		 ORDER BY team_id ASC
+	`
+	findUserRatesStmt = `
+		SELECT *
+		  FROM Rate
+		 WHERE user_id = ?
+		 ORDER BY effective_from ASC, id ASC
+	`
+	deleteUserTimesheetsStmt = `
+		DELETE FROM Timesheet
+		 WHERE user_id = ?
+	`
+	deleteUserTeamMembersStmt = `
+		DELETE FROM TeamMember
+		 WHERE user_id = ?
+	`
+	deleteUserFeedTokenStmt = `
+		DELETE FROM FeedToken
+		 WHERE user_id = ?
+	`
+	deleteUserRatesStmt = `
+		DELETE FROM Rate
+		 WHERE user_id = ?
+	`
+	deleteUserActivityStmt = `
+		DELETE FROM UserActivity
+		 WHERE user_id = ?
+	`
+	replaceApproverStmt = `
+		UPDATE Timesheet
+		   SET approver_id = ?
+		 WHERE approver_id = ?
+	`
+	replacePlanningUserStmt = `
+		UPDATE Planning
+		   SET user_id = ?,
+			   version = version + 1
+		 WHERE user_id = ?
+	`
+	replaceArchivedPlanningUserStmt = `
+		UPDATE PlanningArchive
+		   SET user_id = ?
+		 WHERE user_id = ?
+	`
+	replaceTimesheetUserStmt = `
+		UPDATE Timesheet
+		   SET user_id = ?
+		 WHERE user_id = ?
+	`
+	replaceRateUserStmt = `
+		UPDATE Rate
+		   SET user_id = ?
+		 WHERE user_id = ?
+	`
+	replaceUserActivityStmt = `
+		UPDATE UserActivity
+		   SET user_id = ?
+		 WHERE user_id = ?
+	`
+)
+
+var errInvalidPrivacyAction = errors.New("invalid privacy action")
+
+type privacyRequest struct {
+	entities.PrivacyRequest
+	Action string `db:"action"`
+}
+
+func savePrivacyRequest(ctx context.Context, ex sqlx.ExtContext, r entities.PrivacyRequest) (entities.PrivacyRequestID, error) {
+	id, err := dialectOf(ex).insert(ctx, ex, savePrivacyRequestStmt, privacyRequest{
+		PrivacyRequest: r,
+		Action:         string(r.Action),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return entities.PrivacyRequestID(id), nil
+}
+
+func findPrivacyRequests(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID) ([]entities.PrivacyRequest, error) {
+	var requests []privacyRequest
+	err := sqlx.SelectContext(ctx, ex, &requests, ex.Rebind(findPrivacyRequestsStmt), uid)
+	if err != nil {
+		return nil, err
+	}
+	var rs []entities.PrivacyRequest
+	for _, r := range requests {
+		r.PrivacyRequest.Action = entities.PrivacyAction(r.Action)
+		rs = append(rs, r.PrivacyRequest)
+	}
+	return rs, nil
+}
+
+// findUserData collects rows of uid from primary and archive tables, running timer isn't stored in db
+func findUserData(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID) (*entities.UserData, error) {
+	d := &entities.UserData{UserID: uid}
+	for _, ex := range []sqlx.ExtContext{ex, archiveReader{ex}} {
+		pids, err := findUserPlanningIDs(ctx, ex, uid)
+		if err != nil {
+			return nil, err
+		}
+		ps, err := findPlannings(ctx, ex, pids)
+		if err != nil {
+			return nil, err
+		}
+		pts, err := findPlannedTimes(ctx, ex, pids)
+		if err != nil {
+			return nil, err
+		}
+		hs, err := findHistoriesForPlannings(ctx, ex, pids)
+		if err != nil {
+			return nil, err
+		}
+		d.Plannings = append(d.Plannings, ps...)
+		d.PlannedTimes = append(d.PlannedTimes, pts...)
+		d.SpentTimeHistories = append(d.SpentTimeHistories, hs...)
+	}
+	sort.Stable(planningsByCreatedAt(d.Plannings))
+	sort.Stable(plannedTimesByCreatedAt(d.PlannedTimes))
+	sort.Stable(historiesByStartedAt(d.SpentTimeHistories))
+
+	var timesheets []timesheet
+	if err := sqlx.SelectContext(ctx, ex, &timesheets, ex.Rebind(findUserTimesheetsStmt), uid); err != nil {
+		return nil, err
+	}
+	for _, t := range timesheets {
+		t.Timesheet.Status = entities.TimesheetStatus(t.Status)
+		d.Timesheets = append(d.Timesheets, t.Timesheet)
+	}
+	var members []teamMember
+	if err := sqlx.SelectContext(ctx, ex, &members, ex.Rebind(findUserTeamMembersStmt), uid); err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		m.TeamMember.Role = entities.TeamRole(m.Role)
+		d.TeamMembers = append(d.TeamMembers, m.TeamMember)
+	}
+	if err := sqlx.SelectContext(ctx, ex, &d.Rates, ex.Rebind(findUserRatesStmt), uid); err != nil {
+		return nil, err
+	}
+	token, err := findFeedToken(ctx, ex, uid)
+	if err != nil {
+		return nil, err
+	}
+	d.HasFeedToken = token != ""
+	d.LastActivity, err = lastActivityForUser(ctx, ex, uid)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func findUserPlanningIDs(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID) ([]entities.PlanningID, error) {
+	var pids []entities.PlanningID
+	err := sqlx.SelectContext(ctx, ex, &pids, ex.Rebind(findUserPlanningIDsStmt), uid)
+	return pids, err
+}
+
+// eraseUserData deletes all rows of uid from primary and archive tables,
+// uid is removed from approvers of other users' timesheets
+func eraseUserData(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID) error {
+	for _, archive := range []bool{false, true} {
+		stmts := []string{deleteHistoriesStmt, deletePlannedTimesStmt, deletePlanningsStmt}
+		var pids []entities.PlanningID
+		var err error
+		if archive {
+			pids, err = findUserPlanningIDs(ctx, archiveReader{ex}, uid)
+			for i := range stmts {
+				stmts[i] = archived(stmts[i])
+			}
+		} else {
+			pids, err = findUserPlanningIDs(ctx, ex, uid)
+		}
+		if err != nil {
+			return err
+		}
+		if len(pids) == 0 {
+			continue
+		}
+		for _, stmt := range stmts {
+			q, args, err := sqlx.In(stmt, pids)
+			if err != nil {
+				return err
+			}
+			if _, err := ex.ExecContext(ctx, ex.Rebind(q), args...); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := ex.ExecContext(ctx, ex.Rebind(replaceApproverStmt), 0, uid); err != nil {
+		return err
+	}
+	return execForUser(ctx, ex, uid,
+		deleteUserTimesheetsStmt,
+		deleteUserTeamMembersStmt,
+		deleteUserFeedTokenStmt,
+		deleteUserRatesStmt,
+		deleteUserActivityStmt,
+	)
+}
+
+// pseudonymiseUserData replaces uid with pseudonym in all tables where data is needed for reports
+// and deletes the rest
+func pseudonymiseUserData(ctx context.Context, ex sqlx.ExtContext, uid, pseudonym ctxtg.UserID) error {
+	for _, stmt := range []string{
+		replacePlanningUserStmt,
+		replaceArchivedPlanningUserStmt,
+		replaceTimesheetUserStmt,
+		replaceApproverStmt,
+		replaceRateUserStmt,
+		replaceUserActivityStmt,
+	} {
+		if _, err := ex.ExecContext(ctx, ex.Rebind(stmt), pseudonym, uid); err != nil {
+			return err
+		}
+	}
+	return execForUser(ctx, ex, uid,
+		deleteUserTeamMembersStmt,
+		deleteUserFeedTokenStmt,
+	)
+}
+
+func execForUser(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := ex.ExecContext(ctx, ex.Rebind(stmt), uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pseudonymFor returns user id which replaces pseudonymised user, it's negative
+// to never match real user and unique because it's derived from id of request
+func pseudonymFor(id entities.PrivacyRequestID) ctxtg.UserID {
+	return ctxtg.UserID(-id)
+}
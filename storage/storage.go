@@ -3,6 +3,8 @@ package storage
 
 import (
 	"context"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -12,7 +14,8 @@ import (
 	"github.com/qarea/planningms/entities"
 )
 
-const mysqlForeignKeyErrorCode = 1452
+// maxConflictRetries is how many times update is repeated after concurrent change of planning
+const maxConflictRetries = 3
 
 var timeNowFunc = func() int64 {
 	return time.Now().Unix()
@@ -30,59 +33,104 @@ func NewPlanningStorage(db *sqlx.DB, sharedLock time.Duration) *PlanningStorage
 type PlanningStorage struct {
 	sharedLockDuration time.Duration
 	db                 *sqlx.DB
+	readArchive        bool
 }
 
-// Planning return planning by pid
-func (p *PlanningStorage) Planning(_ context.Context, pid entities.PlanningID) (*entities.Planning, error) {
+// SetReadArchive enables reading through to archived plannings by Planning, Plannings,
+// PlannedTimes, SpentTimeHistories, SpentTimeByUserIDTimeRange, WorkSessions and BillableWork
+func (p *PlanningStorage) SetReadArchive(enabled bool) {
+	p.readArchive = enabled
+}
+
+// Planning return planning by pid, it joins transaction of SpentTimeStorage.Modify
+func (p *PlanningStorage) Planning(ctx context.Context, pid entities.PlanningID) (*entities.Planning, error) {
 	var planning *entities.Planning
-	err := p.withSharedLock(func() error {
+	err := p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
 		var err error
-		planning, err = findPlanning(p.db, pid)
+		planning, err = findPlanning(ctx, tx, pid)
+		if err == nil && planning == nil && p.readArchive {
+			planning, err = findPlanning(ctx, archiveReader{tx}, pid)
+		}
 		return err
 	})
 	return planning, err
 }
 
-// OpenedPlannings returned all opened plannings for uid
-func (p *PlanningStorage) OpenedPlannings(_ context.Context, uid ctxtg.UserID) ([]entities.ExtendedPlanning, error) {
-	ps, err := openedPlannings(p.db, uid)
+// Plannings return plannings by pids, unknown pids are skipped
+func (p *PlanningStorage) Plannings(ctx context.Context, pids []entities.PlanningID) ([]entities.Planning, error) {
+	var plannings []entities.Planning
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		plannings, err = findPlannings(ctx, p.db, pids)
+		if err != nil || !p.readArchive {
+			return err
+		}
+		archived, err := findPlannings(ctx, archiveReader{p.db}, pids)
+		plannings = append(plannings, archived...)
+		sort.Stable(planningsByCreatedAt(plannings))
+		return err
+	})
+	return plannings, err
+}
+
+// PlannedTimes returns all estimations of plannings sorted by creation time
+func (p *PlanningStorage) PlannedTimes(ctx context.Context, pids []entities.PlanningID) ([]entities.PlannedTime, error) {
+	var pts []entities.PlannedTime
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		pts, err = findPlannedTimes(ctx, p.db, pids)
+		if err != nil || !p.readArchive {
+			return err
+		}
+		archived, err := findPlannedTimes(ctx, archiveReader{p.db}, pids)
+		pts = append(pts, archived...)
+		sort.Stable(plannedTimesByCreatedAt(pts))
+		return err
+	})
+	return pts, err
+}
+
+// SpentTimeHistories returns all spent time histories of plannings sorted by start time
+func (p *PlanningStorage) SpentTimeHistories(ctx context.Context, pids []entities.PlanningID) ([]entities.SpentTimeHistory, error) {
+	var hs []entities.SpentTimeHistory
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		hs, err = findHistoriesForPlannings(ctx, p.db, pids)
+		if err != nil || !p.readArchive {
+			return err
+		}
+		archived, err := findHistoriesForPlannings(ctx, archiveReader{p.db}, pids)
+		hs = append(hs, archived...)
+		sort.Stable(historiesByStartedAt(hs))
+		return err
+	})
+	return hs, err
+}
+
+// OpenedPlannings returned all opened plannings for uid with current estimation and last activity
+func (p *PlanningStorage) OpenedPlannings(ctx context.Context, uid ctxtg.UserID) ([]entities.ExtendedPlanning, error) {
+	ps, err := openedPlannings(ctx, p.db, uid)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load opened plannings")
 	}
-	estimations, err := estimationsForPlannings(p.db, ps)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to load estimations")
-	}
-	lastActivities, err := lastActivityForPlannings(p.db, ps)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to load last activities")
-	}
-	var plannings []entities.ExtendedPlanning
-	for _, p := range ps {
-		plannings = append(plannings, entities.ExtendedPlanning{
-			Planning:     p,
-			Estimation:   estimations[p.ID],
-			LastActivity: lastActivities[p.ID],
-		})
-	}
-	return plannings, nil
+	return ps, nil
 }
 
 // PlanningCreatedAt returns createdAt field for pid
-func (p *PlanningStorage) PlanningCreatedAt(_ context.Context, pid entities.PlanningID) (int64, error) {
+func (p *PlanningStorage) PlanningCreatedAt(ctx context.Context, pid entities.PlanningID) (int64, error) {
 	var createdAt int64
-	err := p.withSharedLock(func() error {
+	err := p.withSharedLock(ctx, func() error {
 		var err error
-		createdAt, err = planningCreatedAt(p.db, pid)
+		createdAt, err = planningCreatedAt(ctx, p.db, pid)
 		return err
 	})
 	return createdAt, err
 }
 
 // AddExtraTime create new estimation for planning
-func (p *PlanningStorage) AddExtraTime(_ context.Context, uid ctxtg.UserID, np entities.PlannedTime) error {
-	return p.withSharedLockAndTransaction(func(tx sqlx.Ext) error {
-		p, err := findPlanning(tx, np.PlanningID)
+func (p *PlanningStorage) AddExtraTime(ctx context.Context, uid ctxtg.UserID, np entities.PlannedTime) error {
+	return p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+		p, err := findPlanning(ctx, tx, np.PlanningID)
 		if err != nil {
 			return errors.Wrap(err, "failed to load planning")
 		}
@@ -93,19 +141,35 @@ func (p *PlanningStorage) AddExtraTime(_ context.Context, uid ctxtg.UserID, np e
 			return entities.ErrInvalidUserID
 		}
 		np.CreatedAt = timeNowFunc()
-		_, err = savePlannedTime(tx, np)
+		_, err = savePlannedTime(ctx, tx, np)
 		return err
 	})
 }
 
-// AddSpentTime save new SpentTimeHistory
-func (p *PlanningStorage) AddSpentTime(_ context.Context, h entities.SpentTimeHistory) error {
-	return p.withSharedLockAndTransaction(func(tx sqlx.Ext) error {
-		_, err := saveHistory(tx, h)
+// AddSpentTime save new SpentTimeHistory,
+// returns entities.ErrPeriodLocked if history overlaps submitted or approved timesheet
+// and entities.ErrDuplicateHistory if history with same planning, start and status exists
+func (p *PlanningStorage) AddSpentTime(ctx context.Context, h entities.SpentTimeHistory) error {
+	return p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+		planning, err := findPlanning(ctx, tx, h.PlanningID)
+		if err != nil {
+			return errors.Wrap(err, "failed to find planning")
+		}
+		if planning == nil {
+			return entities.ErrInvalidPlanningID
+		}
+		locked, err := isPeriodLocked(ctx, tx, planning.UserID, h.StartedAt, h.EndedAt)
+		if err != nil {
+			return errors.Wrap(err, "failed to check timesheets")
+		}
+		if locked {
+			return entities.ErrPeriodLocked
+		}
+		err = saveHistory(ctx, tx, h)
 		if err != nil {
 			return errors.Wrap(err, "failed to save history")
 		}
-		err = addSpentTimeToPlanning(tx, h)
+		err = addSpentTimeToPlanning(ctx, tx, h)
 		if err != nil {
 			return errors.Wrap(err, "failed to add spent time to planning")
 		}
@@ -113,16 +177,27 @@ func (p *PlanningStorage) AddSpentTime(_ context.Context, h entities.SpentTimeHi
 	})
 }
 
-// CreatePlanning create new planning and new planned time
-func (p *PlanningStorage) CreatePlanning(_ context.Context, np entities.NewPlanning) (entities.PlanningID, error) {
+// CreatePlanning create new planning and new planned time,
+// planning is billable according to project's rule unless np.Billable is set
+func (p *PlanningStorage) CreatePlanning(ctx context.Context, np entities.NewPlanning) (entities.PlanningID, error) {
 	var id entities.PlanningID
-	err := p.withSharedLockAndTransaction(func(tx sqlx.Ext) error {
+	err := p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+		planning := newPlanningToPlanning(np)
+		if np.Billable != nil {
+			planning.Billable = *np.Billable
+		} else {
+			billable, err := projectBillable(ctx, tx, np.ProjectID)
+			if err != nil {
+				return errors.Wrap(err, "failed to load project billing")
+			}
+			planning.Billable = billable
+		}
 		var err error
-		id, err = savePlanning(tx, newPlanningToPlanning(np))
+		id, err = savePlanning(ctx, tx, planning)
 		if err != nil {
 			return errors.Wrap(err, "failed to save planning")
 		}
-		_, err = savePlannedTime(tx, entities.PlannedTime{
+		_, err = savePlannedTime(ctx, tx, entities.PlannedTime{
 			PlanningID: id,
 			Estimation: np.Estimation,
 			CreatedAt:  timeNowFunc(),
@@ -136,11 +211,11 @@ func (p *PlanningStorage) CreatePlanning(_ context.Context, np entities.NewPlann
 }
 
 // LastActivity for user id
-func (p *PlanningStorage) LastActivity(_ context.Context, uid ctxtg.UserID) (int64, error) {
+func (p *PlanningStorage) LastActivity(ctx context.Context, uid ctxtg.UserID) (int64, error) {
 	var last int64
-	err := p.withSharedLock(func() error {
+	err := p.withSharedLock(ctx, func() error {
 		var err error
-		last, err = lastActivityForUser(p.db, uid)
+		last, err = lastActivityForUser(ctx, p.db, uid)
 		return err
 	})
 	return last, err
@@ -148,13 +223,329 @@ func (p *PlanningStorage) LastActivity(_ context.Context, uid ctxtg.UserID) (int
 
 // SpentTimeByUserIDTimeRange return total spent time for user for time range
 func (p *PlanningStorage) SpentTimeByUserIDTimeRange(ctx context.Context, uid ctxtg.UserID, from, to int64) (int, error) {
-	return spentTime(p.db, uid, from, to)
+	spent, err := spentTime(ctx, p.db, uid, from, to)
+	if err != nil || !p.readArchive {
+		return spent, err
+	}
+	archived, err := spentTime(ctx, archiveReader{p.db}, uid, from, to)
+	return spent + archived, err
+}
+
+// WorkSessions returns user's spent time histories intersecting time range with details of planned issues
+func (p *PlanningStorage) WorkSessions(ctx context.Context, uid ctxtg.UserID, from, to int64) ([]entities.WorkSession, error) {
+	var ws []entities.WorkSession
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		ws, err = findWorkSessions(ctx, p.db, uid, from, to)
+		if err != nil || !p.readArchive {
+			return err
+		}
+		archived, err := findWorkSessions(ctx, archiveReader{p.db}, uid, from, to)
+		ws = append(ws, archived...)
+		sort.Stable(workSessionsByStartedAt(ws))
+		return err
+	})
+	return ws, err
+}
+
+// FeedToken returns user's feed token, new token is generated if user has none
+func (p *PlanningStorage) FeedToken(ctx context.Context, uid ctxtg.UserID) (string, error) {
+	var token string
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		token, err = findFeedToken(ctx, p.db, uid)
+		if err != nil {
+			return errors.Wrap(err, "failed to load feed token")
+		}
+		if token != "" {
+			return nil
+		}
+		token, err = newFeedTokenFunc()
+		if err != nil {
+			return errors.Wrap(err, "failed to generate feed token")
+		}
+		// token created concurrently is kept, so every caller gets the same one
+		err = insertFeedToken(ctx, p.db, uid, token)
+		if err != nil && !dialectOf(p.db).isDuplicateError(err) {
+			return errors.Wrap(err, "failed to save feed token")
+		}
+		token, err = findFeedToken(ctx, p.db, uid)
+		if err == nil && token == "" {
+			err = errors.New("feed token isn't saved")
+		}
+		return errors.Wrap(err, "failed to load feed token")
+	})
+	return token, err
+}
+
+// ResetFeedToken replaces user's feed token with newly generated one
+func (p *PlanningStorage) ResetFeedToken(ctx context.Context, uid ctxtg.UserID) (string, error) {
+	token, err := newFeedTokenFunc()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate feed token")
+	}
+	err = p.withSharedLock(ctx, func() error {
+		return saveFeedToken(ctx, p.db, uid, token)
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// FeedUserID returns owner of feed token
+func (p *PlanningStorage) FeedUserID(ctx context.Context, token string) (ctxtg.UserID, error) {
+	var uid ctxtg.UserID
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		uid, err = findFeedUserID(ctx, p.db, token)
+		return err
+	})
+	return uid, err
+}
+
+// CreateTeam creates new empty team
+func (p *PlanningStorage) CreateTeam(ctx context.Context, name string) (entities.TeamID, error) {
+	var id entities.TeamID
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		id, err = saveTeam(ctx, p.db, entities.Team{
+			Name:      name,
+			CreatedAt: timeNowFunc(),
+		})
+		return err
+	})
+	return id, err
+}
+
+// DeleteTeam removes team with all its members
+func (p *PlanningStorage) DeleteTeam(ctx context.Context, tid entities.TeamID) error {
+	return p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+		return deleteTeam(ctx, tx, tid)
+	})
+}
+
+// Teams returns all teams
+func (p *PlanningStorage) Teams(ctx context.Context) ([]entities.Team, error) {
+	var teams []entities.Team
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		teams, err = findTeams(ctx, p.db)
+		return err
+	})
+	return teams, err
+}
+
+// SetTeamMember adds user to team or changes role of existing member
+func (p *PlanningStorage) SetTeamMember(ctx context.Context, m entities.TeamMember) error {
+	if m.Role != entities.Lead && m.Role != entities.Member {
+		return entities.ErrInvalidTeamRole
+	}
+	return p.withSharedLock(ctx, func() error {
+		return saveTeamMember(ctx, p.db, m)
+	})
+}
+
+// RemoveTeamMember removes user from team
+func (p *PlanningStorage) RemoveTeamMember(ctx context.Context, tid entities.TeamID, uid ctxtg.UserID) error {
+	return p.withSharedLock(ctx, func() error {
+		return deleteTeamMember(ctx, p.db, tid, uid)
+	})
+}
+
+// TeamMembers returns all members of team
+func (p *PlanningStorage) TeamMembers(ctx context.Context, tid entities.TeamID) ([]entities.TeamMember, error) {
+	var ms []entities.TeamMember
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		ms, err = findTeamMembers(ctx, p.db, tid)
+		return err
+	})
+	return ms, err
+}
+
+// TeamRole returns role of user in team or empty role if user isn't member of team
+func (p *PlanningStorage) TeamRole(ctx context.Context, tid entities.TeamID, uid ctxtg.UserID) (entities.TeamRole, error) {
+	var role entities.TeamRole
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		role, err = findTeamRole(ctx, p.db, tid, uid)
+		return err
+	})
+	return role, err
+}
+
+// IsTeamLead checks if lead is lead of any team where member is member
+func (p *PlanningStorage) IsTeamLead(ctx context.Context, lead, member ctxtg.UserID) (bool, error) {
+	var ok bool
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		ok, err = isTeamLead(ctx, p.db, lead, member)
+		return err
+	})
+	return ok, err
 }
 
-// ClosePlanning check user id, save history and update planning
-func (p *PlanningStorage) ClosePlanning(_ context.Context, uid ctxtg.UserID, report entities.PlanningReport) error {
-	return p.withSharedLockAndTransaction(func(tx sqlx.Ext) error {
-		planning, err := findPlanning(tx, report.PlanningID)
+// SubmitTimesheet locks user's period t.From - t.To and registers it for approval
+func (p *PlanningStorage) SubmitTimesheet(ctx context.Context, t entities.Timesheet) (entities.TimesheetID, error) {
+	var id entities.TimesheetID
+	err := p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+		locked, err := isPeriodLocked(ctx, tx, t.UserID, t.From, t.To)
+		if err != nil {
+			return errors.Wrap(err, "failed to check timesheets")
+		}
+		if locked {
+			return entities.ErrPeriodLocked
+		}
+		t.Status = entities.Submitted
+		t.ApproverID = 0
+		t.UpdatedAt = timeNowFunc()
+		id, err = saveTimesheet(ctx, tx, t)
+		return err
+	})
+	return id, err
+}
+
+// ReviewTimesheet approves or rejects submitted timesheet or unlocks approved one
+func (p *PlanningStorage) ReviewTimesheet(ctx context.Context, r entities.TimesheetReview) error {
+	return p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+		t, err := findTimesheet(ctx, tx, r.ID)
+		if err != nil {
+			return errors.Wrap(err, "failed to find timesheet")
+		}
+		if t == nil {
+			return entities.ErrInvalidTimesheet
+		}
+		switch {
+		case (r.Status == entities.Approved || r.Status == entities.Rejected) && t.Status == entities.Submitted:
+		case r.Status == entities.Unlocked && t.Status == entities.Approved:
+		default:
+			return entities.ErrTimesheetStatus
+		}
+		t.Status = r.Status
+		t.ApproverID = r.ApproverID
+		t.Comment = r.Comment
+		t.UpdatedAt = timeNowFunc()
+		return updateTimesheet(ctx, tx, *t)
+	})
+}
+
+// Timesheet returns timesheet by id
+func (p *PlanningStorage) Timesheet(ctx context.Context, id entities.TimesheetID) (*entities.Timesheet, error) {
+	var t *entities.Timesheet
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		t, err = findTimesheet(ctx, p.db, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, entities.ErrInvalidTimesheet
+	}
+	return t, nil
+}
+
+// Timesheets returns user's timesheets overlapping from - to
+func (p *PlanningStorage) Timesheets(ctx context.Context, uid ctxtg.UserID, from, to int64) ([]entities.Timesheet, error) {
+	var ts []entities.Timesheet
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		ts, err = findTimesheets(ctx, p.db, uid, from, to)
+		return err
+	})
+	return ts, err
+}
+
+// IsPeriodLocked checks if from - to overlaps submitted or approved timesheet of user,
+// it joins transaction of SpentTimeStorage.Modify
+func (p *PlanningStorage) IsPeriodLocked(ctx context.Context, uid ctxtg.UserID, from, to int64) (bool, error) {
+	var locked bool
+	err := p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+		var err error
+		locked, err = isPeriodLocked(ctx, tx, uid, from, to)
+		return err
+	})
+	return locked, err
+}
+
+// SetProjectBilling sets billable rule for new plannings of project
+func (p *PlanningStorage) SetProjectBilling(ctx context.Context, pb entities.ProjectBilling) error {
+	return p.withSharedLock(ctx, func() error {
+		return saveProjectBilling(ctx, p.db, pb)
+	})
+}
+
+// SetPlanningBillable changes billable flag of existing planning
+func (p *PlanningStorage) SetPlanningBillable(ctx context.Context, pid entities.PlanningID, billable bool) error {
+	return p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+		planning, err := findPlanning(ctx, tx, pid)
+		if err != nil {
+			return errors.Wrap(err, "failed to find planning")
+		}
+		if planning == nil {
+			return entities.ErrInvalidPlanningID
+		}
+		return updatePlanningBillable(ctx, tx, pid, billable)
+	})
+}
+
+// AddRate saves new hourly rate
+func (p *PlanningStorage) AddRate(ctx context.Context, r entities.Rate) (entities.RateID, error) {
+	if r.Rate < 0 {
+		return 0, entities.ErrInvalidRate
+	}
+	var id entities.RateID
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		id, err = saveRate(ctx, p.db, r)
+		return err
+	})
+	return id, err
+}
+
+// DeleteRate removes hourly rate
+func (p *PlanningStorage) DeleteRate(ctx context.Context, id entities.RateID) error {
+	return p.withSharedLock(ctx, func() error {
+		return deleteRate(ctx, p.db, id)
+	})
+}
+
+// Rates returns all hourly rates sorted by effective time
+func (p *PlanningStorage) Rates(ctx context.Context) ([]entities.Rate, error) {
+	var rates []entities.Rate
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		rates, err = findRates(ctx, p.db)
+		return err
+	})
+	return rates, err
+}
+
+// BillableWork returns spent time histories of billable plannings intersecting q.From - q.To
+func (p *PlanningStorage) BillableWork(ctx context.Context, q entities.BillingQuery) ([]entities.BillableWork, error) {
+	var ws []entities.BillableWork
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		ws, err = findBillableWork(ctx, p.db, q)
+		if err != nil || !p.readArchive {
+			return err
+		}
+		archived, err := findBillableWork(ctx, archiveReader{p.db}, q)
+		ws = append(ws, archived...)
+		sort.Stable(billableWorkByStartedAt(ws))
+		return err
+	})
+	return ws, err
+}
+
+// ClosePlanning check user id, save history and update planning.
+// Planning is updated only if it wasn't changed concurrently, otherwise it's retried
+// up to maxConflictRetries times and entities.ErrPlanningConflict is returned
+func (p *PlanningStorage) ClosePlanning(ctx context.Context, uid ctxtg.UserID, report entities.PlanningReport) error {
+	return p.withConflictRetries(ctx, func(tx sqlx.ExtContext) error {
+		planning, err := findPlanning(ctx, tx, report.PlanningID)
 		if err != nil {
 			return errors.Wrap(err, "failed to find planning")
 		}
@@ -167,7 +558,7 @@ func (p *PlanningStorage) ClosePlanning(_ context.Context, uid ctxtg.UserID, rep
 		if planning.Status == entities.Closed {
 			return entities.ErrPlanningClosed
 		}
-		histories, err := findHistories(tx, report.PlanningID)
+		histories, err := findHistories(ctx, tx, report.PlanningID)
 		if err != nil {
 			return errors.Wrap(err, "failed to load histories")
 		}
@@ -176,7 +567,7 @@ func (p *PlanningStorage) ClosePlanning(_ context.Context, uid ctxtg.UserID, rep
 		planning.Reported = report.Time
 		planning.IssueDone = report.Progress
 
-		err = updatePlanning(tx, *planning)
+		err = updatePlanning(ctx, tx, *planning)
 		if err != nil {
 			return errors.Wrap(err, "failed to update planning")
 		}
@@ -184,9 +575,125 @@ func (p *PlanningStorage) ClosePlanning(_ context.Context, uid ctxtg.UserID, rep
 	})
 }
 
-func (p *PlanningStorage) withSharedLockAndTransaction(f func(tx sqlx.Ext) error) error {
-	return p.withSharedLock(func() error {
-		tx, err := p.db.Beginx()
+// Archive moves closed plannings created and last active before `before` to archive tables
+// in batches of archiveBatchSize, returns number of archived plannings. It stops when ctx
+// is done, batch in progress is rolled back then
+func (p *PlanningStorage) Archive(ctx context.Context, before int64) (int, error) {
+	var total int
+	for {
+		var n int
+		err := p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+			var err error
+			n, err = archivePlannings(ctx, tx, before)
+			return err
+		})
+		if err != nil {
+			return total, errors.Wrap(err, "failed to archive plannings")
+		}
+		total += n
+		if n < archiveBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// RepairActivity recomputes last activities and current estimations of plannings and users
+// from spent time histories and planned times
+func (p *PlanningStorage) RepairActivity(ctx context.Context) (ActivityRepair, error) {
+	var r ActivityRepair
+	err := p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+		var err error
+		r, err = repairActivity(ctx, tx)
+		return err
+	})
+	return r, err
+}
+
+// ExportUserData returns everything stored about r.UserID including archived plannings
+// and records r in audit of privacy requests
+func (p *PlanningStorage) ExportUserData(ctx context.Context, r entities.PrivacyRequest) (*entities.UserData, error) {
+	var d *entities.UserData
+	err := p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+		r.Action = entities.Export
+		r.CreatedAt = timeNowFunc()
+		if _, err := savePrivacyRequest(ctx, tx, r); err != nil {
+			return errors.Wrap(err, "failed to save privacy request")
+		}
+		var err error
+		d, err = findUserData(ctx, tx, r.UserID)
+		if err != nil {
+			return errors.Wrap(err, "failed to load user data")
+		}
+		return nil
+	})
+	return d, err
+}
+
+// EraseUserData deletes or pseudonymises everything stored about r.UserID depending on r.Action
+// and records r in audit of privacy requests
+func (p *PlanningStorage) EraseUserData(ctx context.Context, r entities.PrivacyRequest) error {
+	if r.Action != entities.Erase && r.Action != entities.Pseudonymise {
+		return errInvalidPrivacyAction
+	}
+	return p.withSharedLockAndTransaction(ctx, func(tx sqlx.ExtContext) error {
+		r.CreatedAt = timeNowFunc()
+		id, err := savePrivacyRequest(ctx, tx, r)
+		if err != nil {
+			return errors.Wrap(err, "failed to save privacy request")
+		}
+		if r.Action == entities.Pseudonymise {
+			err = pseudonymiseUserData(ctx, tx, r.UserID, pseudonymFor(id))
+		} else {
+			err = eraseUserData(ctx, tx, r.UserID)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to %s user data", strings.ToLower(string(r.Action)))
+		}
+		return nil
+	})
+}
+
+// PrivacyRequests returns audit of exports and erasures of uid's data sorted by creation time
+func (p *PlanningStorage) PrivacyRequests(ctx context.Context, uid ctxtg.UserID) ([]entities.PrivacyRequest, error) {
+	var rs []entities.PrivacyRequest
+	err := p.withSharedLock(ctx, func() error {
+		var err error
+		rs, err = findPrivacyRequests(ctx, p.db, uid)
+		if err != nil {
+			return errors.Wrap(err, "failed to load privacy requests")
+		}
+		return nil
+	})
+	return rs, err
+}
+
+// withConflictRetries runs f in new transaction again while it returns entities.ErrPlanningConflict
+func (p *PlanningStorage) withConflictRetries(ctx context.Context, f func(tx sqlx.ExtContext) error) error {
+	var err error
+	for i := 0; i <= maxConflictRetries; i++ {
+		err = p.withSharedLockAndTransaction(ctx, f)
+		if errors.Cause(err) != entities.ErrPlanningConflict {
+			return err
+		}
+	}
+	return err
+}
+
+// transactionKey is key of context value with transaction started by SpentTimeStorage.Modify
+type transactionKey struct{}
+
+func withTransaction(ctx context.Context, tx sqlx.ExtContext) context.Context {
+	return context.WithValue(ctx, transactionKey{}, tx)
+}
+
+// withSharedLockAndTransaction runs f in transaction of ctx if there is one,
+// shared lock is already held by its owner which commits or rolls it back
+func (p *PlanningStorage) withSharedLockAndTransaction(ctx context.Context, f func(tx sqlx.ExtContext) error) error {
+	if tx, ok := ctx.Value(transactionKey{}).(sqlx.ExtContext); ok {
+		return f(tx)
+	}
+	return p.withSharedLock(ctx, func() error {
+		tx, err := p.db.BeginTxx(ctx, nil)
 		if err != nil {
 			return errors.Wrap(err, "failed to start transaction")
 		}
@@ -201,7 +708,12 @@ func (p *PlanningStorage) withSharedLockAndTransaction(f func(tx sqlx.Ext) error
 	})
 }
 
-func (p *PlanningStorage) withSharedLock(f func() error) error {
+// withSharedLock doesn't wait for lock if ctx is already done,
+// f should pass ctx to all queries to stop them when ctx is done
+func (p *PlanningStorage) withSharedLock(ctx context.Context, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	l, err := narada.SharedLock(p.sharedLockDuration)
 	if err != nil {
 		return errors.Wrapf(entities.ErrMaintenance, "can't obtain lock %v", err)
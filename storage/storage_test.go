@@ -6,6 +6,10 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"reflect"
+	"regexp"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,7 +17,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/qarea/ctxtg"
 	"github.com/qarea/planningms/entities"
-	"github.com/qarea/planningms/mysqldb"
+	"github.com/qarea/planningms/plannings"
+	"github.com/qarea/planningms/storage/storagetest"
 )
 
 var (
@@ -23,7 +28,7 @@ var (
 
 func TestOpenedPlanningsEmptyTable(t *testing.T) {
 	defer prepareDB()()
-	st := NewPlanningStorage(mysqldb.New(), second)
+	st := NewPlanningStorage(newTestDB(), second)
 	ps, err := st.OpenedPlannings(ctx, 2)
 	if err != nil {
 		t.Error("Unexpected error", err)
@@ -35,7 +40,7 @@ func TestOpenedPlanningsEmptyTable(t *testing.T) {
 
 func TestOpenedPlannings(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	uid := ctxtg.UserID(rand.Int63())
 	st := NewPlanningStorage(db, second)
 	p1 := saveTestPlanningOpened(db, t, uid)
@@ -51,7 +56,7 @@ func TestOpenedPlannings(t *testing.T) {
 		p2.ID: latestEstimation(pts2),
 		p3.ID: latestEstimation(pts3),
 	}
-	_, err := saveHistory(db, entities.SpentTimeHistory{
+	err := saveHistory(ctx, db, entities.SpentTimeHistory{
 		PlanningID: p1.ID,
 		StartedAt:  10,
 		EndedAt:    20,
@@ -85,11 +90,11 @@ func TestOpenedPlannings(t *testing.T) {
 
 func TestAddSpentSpentTimeInvalidStatusDBErr(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	uid := ctxtg.UserID(rand.Int63())
 	st := NewPlanningStorage(db, second)
 	p1 := saveTestPlanningOpened(db, t, uid)
-	err := st.AddSpentTime(nil, entities.SpentTimeHistory{
+	err := st.AddSpentTime(ctx, entities.SpentTimeHistory{
 		PlanningID: p1.ID,
 		Status:     entities.SpentTimeStatus("invalid status"),
 	})
@@ -100,8 +105,8 @@ func TestAddSpentSpentTimeInvalidStatusDBErr(t *testing.T) {
 
 func TestSavePlannedTime(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
-	_, err := savePlannedTime(db, entities.PlannedTime{PlanningID: 123})
+	db := newTestDB()
+	_, err := savePlannedTime(ctx, db, entities.PlannedTime{PlanningID: 123})
 	if err != entities.ErrInvalidPlanningID {
 		t.Error("Unexpeted err", err)
 	}
@@ -109,7 +114,7 @@ func TestSavePlannedTime(t *testing.T) {
 
 func TestSpentTimeByUserIDTimeRangeNoInfo(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	st := NewPlanningStorage(db, second)
 	uid := ctxtg.UserID(rand.Int63())
 	spent, err := st.SpentTimeByUserIDTimeRange(ctx, uid, 1, 2)
@@ -123,7 +128,7 @@ func TestSpentTimeByUserIDTimeRangeNoInfo(t *testing.T) {
 
 func TestSpentTimeByUserIDTimeRange(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	uid := ctxtg.UserID(rand.Int63())
 	st := NewPlanningStorage(db, second)
 	p1 := entities.Planning{
@@ -201,7 +206,7 @@ func saveExtraTimeForPlanning(db *sqlx.DB, t *testing.T, pid entities.PlanningID
 func saveTestPlannedTime(db *sqlx.DB, t *testing.T, pid entities.PlanningID) entities.PlannedTime {
 	p := randPlannedTime()
 	p.PlanningID = pid
-	id, err := savePlannedTime(db, p)
+	id, err := savePlannedTime(ctx, db, p)
 	if err != nil {
 		t.Fail()
 	}
@@ -213,7 +218,7 @@ func saveTestPlanningOpened(db *sqlx.DB, t *testing.T, uid ctxtg.UserID) entitie
 	p := randPlanning()
 	p.Status = entities.Open
 	p.UserID = uid
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -225,7 +230,7 @@ func saveTestPlanningClosed(db *sqlx.DB, t *testing.T, uid ctxtg.UserID) entitie
 	p := randPlanning()
 	p.Status = entities.Closed
 	p.UserID = uid
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -234,7 +239,7 @@ func saveTestPlanningClosed(db *sqlx.DB, t *testing.T, uid ctxtg.UserID) entitie
 }
 
 func saveTestPlanning(db *sqlx.DB, t *testing.T, p entities.Planning) entities.PlanningID {
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -243,10 +248,10 @@ func saveTestPlanning(db *sqlx.DB, t *testing.T, p entities.Planning) entities.P
 
 func TestPlanning(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	st := NewPlanningStorage(db, second)
 	p := randPlanning()
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -262,7 +267,7 @@ func TestPlanning(t *testing.T) {
 
 func TestPlanningNoPlanning(t *testing.T) {
 	defer prepareDB()()
-	st := NewPlanningStorage(mysqldb.New(), second)
+	st := NewPlanningStorage(newTestDB(), second)
 	p, err := st.Planning(ctx, entities.PlanningID(rand.Int63()))
 	if err != nil {
 		t.Fatal(err)
@@ -275,7 +280,7 @@ func TestPlanningNoPlanning(t *testing.T) {
 func TestCreatePlanning(t *testing.T) {
 	defer prepareDB()()
 	var now int64 = 50
-	db := mysqldb.New()
+	db := newTestDB()
 	defer mockTimeNow(now)()
 	st := NewPlanningStorage(db, second)
 	p := randNewPlanning()
@@ -284,7 +289,7 @@ func TestCreatePlanning(t *testing.T) {
 		t.Fatal(err)
 	}
 	var p2 planning
-	err = db.Get(&p2, `SELECT * FROM Planning WHERE id=?`, id)
+	err = db.Get(&p2, db.Rebind(`SELECT * FROM Planning WHERE id=?`), id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -302,6 +307,7 @@ func TestCreatePlanning(t *testing.T) {
 		IssueDone:       p.IssueDone,
 		ActivityID:      p.ActivityID,
 		CreatedAt:       now,
+		Billable:        true,
 	}
 	planning := p2.Planning
 	planning.Status = entities.PlanningStatus(p2.Status)
@@ -316,7 +322,7 @@ func TestCreatePlanning(t *testing.T) {
 		CreatedAt:  now,
 	}
 	var pt entities.PlannedTime
-	err = db.Get(&pt, `SELECT * FROM PlannedTime WHERE id=?`, id)
+	err = db.Get(&pt, db.Rebind(`SELECT * FROM PlannedTime WHERE id=?`), id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -329,7 +335,7 @@ func TestCreatePlanning(t *testing.T) {
 func TestAddExtraTimeInvalidID(t *testing.T) {
 	defer prepareDB()()
 	pt := randPlannedTime()
-	st := NewPlanningStorage(mysqldb.New(), second)
+	st := NewPlanningStorage(newTestDB(), second)
 	err := st.AddExtraTime(ctx, 1, pt)
 	if err != entities.ErrInvalidPlanningID {
 		t.Error("Unexpected error", err)
@@ -340,12 +346,12 @@ func TestAddExtraTimeInvalidUserID(t *testing.T) {
 	defer prepareDB()()
 	var now int64 = 1
 	defer mockTimeNow(now)()
-	db := mysqldb.New()
+	db := newTestDB()
 
 	pt := randPlannedTime()
 	st := NewPlanningStorage(db, second)
 	p := randPlanning()
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -355,7 +361,7 @@ func TestAddExtraTimeInvalidUserID(t *testing.T) {
 		t.Error("Unexpected error", err)
 	}
 	var plannedTime entities.PlannedTime
-	err = db.Get(&plannedTime, `SELECT * FROM PlannedTime`)
+	err = db.Get(&plannedTime, db.Rebind(`SELECT * FROM PlannedTime`))
 	if err != sql.ErrNoRows {
 		t.Error("Should be empty")
 	}
@@ -365,12 +371,12 @@ func TestAddExtraTimeInvalidPlanningID(t *testing.T) {
 	defer prepareDB()()
 	var now int64 = 1
 	defer mockTimeNow(now)()
-	db := mysqldb.New()
+	db := newTestDB()
 
 	pt := randPlannedTime()
 	st := NewPlanningStorage(db, second)
 	p := randPlanning()
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -381,14 +387,14 @@ func TestAddExtraTimeInvalidPlanningID(t *testing.T) {
 	}
 
 	var plannedTime entities.PlannedTime
-	err = db.Get(&plannedTime, `SELECT * FROM PlannedTime`)
+	err = db.Get(&plannedTime, db.Rebind(`SELECT * FROM PlannedTime`))
 	if err != sql.ErrNoRows {
 		t.Error("Should be empty")
 	}
 }
 
 func TestAddSpentSpentTimeInvalidStatus(t *testing.T) {
-	err := addSpentTimeToPlanning(nil, entities.SpentTimeHistory{
+	err := addSpentTimeToPlanning(ctx, nil, entities.SpentTimeHistory{
 		Status: entities.SpentTimeStatus("invalid status"),
 	})
 	if err == nil {
@@ -398,12 +404,12 @@ func TestAddSpentSpentTimeInvalidStatus(t *testing.T) {
 
 func TestAddExtraTimeNowFunc(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 
 	pt := randPlannedTime()
 	st := NewPlanningStorage(db, second)
 	p := randPlanning()
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -426,14 +432,14 @@ func TestAddExtraTimeNowFunc(t *testing.T) {
 
 func TestAddExtraTime(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	var now int64 = 1
 	defer mockTimeNow(now)()
 
 	pt := randPlannedTime()
 	st := NewPlanningStorage(db, second)
 	p := randPlanning()
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -444,7 +450,7 @@ func TestAddExtraTime(t *testing.T) {
 	}
 
 	var plannedTime entities.PlannedTime
-	err = db.Get(&plannedTime, `SELECT * FROM PlannedTime`)
+	err = db.Get(&plannedTime, db.Rebind(`SELECT * FROM PlannedTime`))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -462,7 +468,7 @@ func TestAddExtraTime(t *testing.T) {
 
 func TestAddSpentTimeInvalid(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	st := NewPlanningStorage(db, second)
 	h := randSpentTimeHistory()
 	err := st.AddSpentTime(ctx, h)
@@ -473,12 +479,12 @@ func TestAddSpentTimeInvalid(t *testing.T) {
 
 func TestAddSpentTimeIncPlanning(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	st := NewPlanningStorage(db, second)
 	p := randPlanning()
 	p.SpentOffline = 0
 	p.SpentOnline = 0
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -513,7 +519,7 @@ func TestAddSpentTimeIncPlanning(t *testing.T) {
 		t.Error("Unexpected error", err)
 	}
 
-	planning, err := findPlanning(db, id)
+	planning, err := findPlanning(ctx, db, id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -525,7 +531,7 @@ func TestAddSpentTimeIncPlanning(t *testing.T) {
 
 func TestPlanningCreatedAtInvalidPlanningID(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	st := NewPlanningStorage(db, second)
 	_, err := st.PlanningCreatedAt(ctx, entities.PlanningID(rand.Int63()))
 	if err != entities.ErrInvalidPlanningID {
@@ -535,10 +541,10 @@ func TestPlanningCreatedAtInvalidPlanningID(t *testing.T) {
 
 func TestPlanningCreatedAt(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	st := NewPlanningStorage(db, second)
 	p := randPlanning()
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -553,11 +559,11 @@ func TestPlanningCreatedAt(t *testing.T) {
 
 func TestAddSpentTime(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	st := NewPlanningStorage(db, second)
 	h := randSpentTimeHistory()
 	p := randPlanning()
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -567,7 +573,7 @@ func TestAddSpentTime(t *testing.T) {
 		t.Error("Unexpected error", err)
 	}
 	var sth spentTimeHistory
-	err = db.Get(&sth, `SELECT * FROM SpentTimeHistory`)
+	err = db.Get(&sth, db.Rebind(`SELECT * FROM SpentTimeHistory`))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -587,16 +593,16 @@ func TestAddSpentTime(t *testing.T) {
 
 func TestClosePlanningInvalidUserID(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	st := NewPlanningStorage(db, second)
 	p := randPlanning()
 	p.Status = entities.Open
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
 	var openedPlanning planning
-	err = db.Get(&openedPlanning, "SELECT * FROM Planning WHERE id = ?", id)
+	err = db.Get(&openedPlanning, db.Rebind("SELECT * FROM Planning WHERE id = ?"), id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -606,7 +612,7 @@ func TestClosePlanningInvalidUserID(t *testing.T) {
 		t.Error("Unexpected err", err)
 	}
 	var shouldBeOpened planning
-	err = db.Get(&shouldBeOpened, "SELECT * FROM Planning WHERE id = ?", id)
+	err = db.Get(&shouldBeOpened, db.Rebind("SELECT * FROM Planning WHERE id = ?"), id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -617,16 +623,16 @@ func TestClosePlanningInvalidUserID(t *testing.T) {
 
 func TestClosePlanningInvalidPlanningID(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	st := NewPlanningStorage(db, second)
 	p := randPlanning()
 	p.Status = entities.Open
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
 	var openedPlanning planning
-	err = db.Get(&openedPlanning, "SELECT * FROM Planning WHERE id = ?", id)
+	err = db.Get(&openedPlanning, db.Rebind("SELECT * FROM Planning WHERE id = ?"), id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -636,7 +642,7 @@ func TestClosePlanningInvalidPlanningID(t *testing.T) {
 		t.Error("Unexpected err", err)
 	}
 	var shouldBeOpened planning
-	err = db.Get(&shouldBeOpened, "SELECT * FROM Planning WHERE id = ?", id)
+	err = db.Get(&shouldBeOpened, db.Rebind("SELECT * FROM Planning WHERE id = ?"), id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -647,11 +653,11 @@ func TestClosePlanningInvalidPlanningID(t *testing.T) {
 
 func TestClosePlanningClosed(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	st := NewPlanningStorage(db, second)
 	p := randPlanning()
 	p.Status = entities.Closed
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -664,23 +670,23 @@ func TestClosePlanningClosed(t *testing.T) {
 
 func TestClosePlanning(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	st := NewPlanningStorage(db, second)
 	p := randPlanning()
 	p.Status = entities.Open
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
 	var createdAt int64
-	err = db.Get(&createdAt, `SELECT created_at FROM Planning WHERE id = ?`, id)
+	err = db.Get(&createdAt, db.Rebind(`SELECT created_at FROM Planning WHERE id = ?`), id)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	p2 := randPlanning()
 	p2.Status = entities.Open
-	id2, err := savePlanning(db, p2)
+	id2, err := savePlanning(ctx, db, p2)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -727,12 +733,19 @@ func TestClosePlanning(t *testing.T) {
 	}
 
 	var closedPlanning planning
-	err = db.Get(&closedPlanning, "SELECT * FROM Planning WHERE id = ?", id)
+	err = db.Get(&closedPlanning, db.Rebind("SELECT * FROM Planning WHERE id = ?"), id)
 	if err != nil {
 		t.Fatal(err)
 	}
+	var lastActivity int64
+	for _, h := range []entities.SpentTimeHistory{h1, h2, h3} {
+		if h.EndedAt > lastActivity {
+			lastActivity = h.EndedAt
+		}
+	}
 	expectedPlanning := planning{
-		Status: string(entities.Closed),
+		Status:         string(entities.Closed),
+		LastActivityAt: lastActivity,
 		Planning: entities.Planning{
 			UserID:          p.UserID,
 			ID:              id,
@@ -749,6 +762,7 @@ func TestClosePlanning(t *testing.T) {
 			SpentOffline:    h3.Spent,
 			Reported:        report.Time,
 			CreatedAt:       createdAt,
+			Version:         4,
 		},
 	}
 	if expectedPlanning != closedPlanning {
@@ -756,12 +770,75 @@ func TestClosePlanning(t *testing.T) {
 	}
 }
 
+func TestUpdatePlanningConflict(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	p := saveTestPlanningOpened(db, t, ctxtg.UserID(rand.Int63()))
+	stale, err := findPlanning(ctx, db, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := randSpentTimeHistory()
+	h.Status = entities.Online
+	h.PlanningID = p.ID
+	if err := addSpentTimeToPlanning(ctx, db, h); err != nil {
+		t.Fatal(err)
+	}
+	stale.Status = entities.Closed
+	err = updatePlanning(ctx, db, *stale)
+	if err != entities.ErrPlanningConflict {
+		t.Errorf("unexpected error %v", err)
+	}
+	actual, err := findPlanning(ctx, db, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.Status != entities.Open || actual.SpentOnline != stale.SpentOnline+h.Spent || actual.Version != stale.Version+1 {
+		t.Errorf("stale update should be rejected, got %+v", *actual)
+	}
+	err = updatePlanning(ctx, db, *actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := findPlanning(ctx, db, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != actual.Version+1 {
+		t.Errorf("unexpected version %d, expected %d", updated.Version, actual.Version+1)
+	}
+}
+
+func TestConflictRetries(t *testing.T) {
+	defer prepareDB()()
+	st := NewPlanningStorage(newTestDB(), second)
+	var calls int
+	err := st.withConflictRetries(ctx, func(sqlx.ExtContext) error {
+		calls++
+		if calls < maxConflictRetries {
+			return errors.Wrap(entities.ErrPlanningConflict, "failed to update planning")
+		}
+		return nil
+	})
+	if err != nil || calls != maxConflictRetries {
+		t.Errorf("unexpected result %v after %d calls", err, calls)
+	}
+	calls = 0
+	err = st.withConflictRetries(ctx, func(sqlx.ExtContext) error {
+		calls++
+		return entities.ErrPlanningConflict
+	})
+	if err != entities.ErrPlanningConflict || calls != maxConflictRetries+1 {
+		t.Errorf("unexpected result %v after %d calls", err, calls)
+	}
+}
+
 func TestLastActivityZero(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	st := NewPlanningStorage(db, second)
 	p := randPlanning()
-	_, err := savePlanning(db, p)
+	_, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -777,10 +854,10 @@ func TestLastActivityZero(t *testing.T) {
 
 func TestLastActivity(t *testing.T) {
 	defer prepareDB()()
-	db := mysqldb.New()
+	db := newTestDB()
 	st := NewPlanningStorage(db, second)
 	p := randPlanning()
-	id, err := savePlanning(db, p)
+	id, err := savePlanning(ctx, db, p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -819,85 +896,1377 @@ func TestLastActivity(t *testing.T) {
 	}
 }
 
-func randSpentTimeHistory() entities.SpentTimeHistory {
-	return entities.SpentTimeHistory{
-		PlanningID: entities.PlanningID(rand.Int63()),
-		Spent:      int(rand.Int31() / 10),
-		StartedAt:  rand.Int63(),
-		EndedAt:    rand.Int63(),
-		Status:     randSpentTimeStatus(),
+func TestPlannings(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	uid := ctxtg.UserID(rand.Int63())
+	p1 := saveTestPlanningOpened(db, t, uid)
+	p2 := saveTestPlanningClosed(db, t, uid)
+	saveTestPlanningOpened(db, t, uid)
+	ps, err := st.Plannings(ctx, []entities.PlanningID{p1.ID, p2.ID, entities.PlanningID(rand.Int63())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 2 {
+		t.Fatal("Invalid amount", len(ps))
+	}
+	for _, p := range ps {
+		if p != p1 && p != p2 {
+			t.Errorf("Invalid planning %+v", p)
+		}
 	}
 }
 
-func randPlannedTime() entities.PlannedTime {
-	return entities.PlannedTime{
-		ID:         rand.Int63(),
-		Estimation: int64(math.Abs(float64(rand.Int31()))),
-		Reason:     randString(),
-		CreatedAt:  int64(math.Abs(float64(rand.Int31()))),
+func TestPlanningsEmpty(t *testing.T) {
+	defer prepareDB()()
+	st := NewPlanningStorage(newTestDB(), second)
+	ps, err := st.Plannings(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 0 {
+		t.Error("Should be empty")
 	}
 }
 
-func randNewPlanning() entities.NewPlanning {
-	return entities.NewPlanning{
-		UserID:          ctxtg.UserID(rand.Int63()),
-		ProjectID:       entities.ProjectID(rand.Int31()),
-		TrackerID:       entities.TrackerID(rand.Int31()),
-		IssueID:         entities.IssueID(rand.Int31()),
-		IssueTitle:      randString(),
-		IssueURL:        randString(),
-		IssueEstimation: int64(rand.Int31()),
-		IssueDueDate:    rand.Int63(),
-		IssueDone:       int(rand.Int31()),
-		ActivityID:      entities.ActivityID(rand.Int31()),
-		Estimation:      int64(rand.Int31()),
+func TestPlannedTimes(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	uid := ctxtg.UserID(rand.Int63())
+	p1 := saveTestPlanningOpened(db, t, uid)
+	p2 := saveTestPlanningOpened(db, t, uid)
+	p3 := saveTestPlanningOpened(db, t, uid)
+	expected := append(saveExtraTimeForPlanning(db, t, p1.ID), saveExtraTimeForPlanning(db, t, p2.ID)...)
+	saveExtraTimeForPlanning(db, t, p3.ID)
+	pts, err := st.PlannedTimes(ctx, []entities.PlanningID{p1.ID, p2.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pts) != len(expected) {
+		t.Fatal("Invalid amount", len(pts))
+	}
+	for i, pt := range pts {
+		if i > 0 && pt.CreatedAt < pts[i-1].CreatedAt {
+			t.Error("Invalid sorting")
+		}
+		found := false
+		for _, e := range expected {
+			found = found || e == pt
+		}
+		if !found {
+			t.Errorf("Unexpected planned time %+v", pt)
+		}
 	}
 }
 
-func randPlanning() entities.Planning {
-	return entities.Planning{
-		ID:              entities.PlanningID(rand.Int63()),
-		UserID:          ctxtg.UserID(rand.Int63()),
-		ProjectID:       entities.ProjectID(rand.Int31()),
-		TrackerID:       entities.TrackerID(rand.Int31()),
-		IssueID:         entities.IssueID(rand.Int31()),
-		IssueTitle:      randString(),
-		IssueURL:        randString(),
-		IssueEstimation: int64(rand.Int31()),
-		IssueDueDate:    rand.Int63(),
-		ActivityID:      entities.ActivityID(rand.Int31()),
-		SpentOnline:     int(rand.Int31() / 2),
-		SpentOffline:    int(rand.Int31() / 2),
-		Reported:        int64(rand.Int31()),
-		CreatedAt:       rand.Int63(),
-		Status:          randStatus(),
+func TestSpentTimeHistories(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	uid := ctxtg.UserID(rand.Int63())
+	p1 := saveTestPlanningOpened(db, t, uid)
+	p2 := saveTestPlanningOpened(db, t, uid)
+	histories := []entities.SpentTimeHistory{
+		{PlanningID: p1.ID, Spent: 10, StartedAt: 30, EndedAt: 40, Status: entities.Online},
+		{PlanningID: p1.ID, Spent: 10, StartedAt: 10, EndedAt: 20, Status: entities.Offline},
+		{PlanningID: p2.ID, Spent: 10, StartedAt: 10, EndedAt: 20, Status: entities.Online},
+	}
+	for _, h := range histories {
+		if err := st.AddSpentTime(ctx, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hs, err := st.SpentTimeHistories(ctx, []entities.PlanningID{p1.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []entities.SpentTimeHistory{histories[1], histories[0]}
+	if len(hs) != len(expected) {
+		t.Fatal("Invalid amount", len(hs))
+	}
+	for i := range expected {
+		if hs[i] != expected[i] {
+			t.Errorf("Invalid history %+v %+v", hs[i], expected[i])
+		}
 	}
 }
 
-func randSpentTimeStatus() entities.SpentTimeStatus {
-	if 1 == rand.Int63()%2 {
-		return entities.Online
+func TestFeedToken(t *testing.T) {
+	defer prepareDB()()
+	st := NewPlanningStorage(newTestDB(), second)
+	uid := ctxtg.UserID(rand.Int63())
+	token, err := st.FeedToken(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
 	}
-	return entities.Offline
+	if len(token) != 2*feedTokenSize {
+		t.Error("Invalid token", token)
+	}
+	token2, err := st.FeedToken(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token2 != token {
+		t.Error("Token should be reused", token2, token)
+	}
+	feedUID, err := st.FeedUserID(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feedUID != uid {
+		t.Error("Invalid user id", feedUID)
+	}
+}
 
+func TestFeedTokenCreatedConcurrently(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	uid := ctxtg.UserID(rand.Int63())
+	defer func(f func() (string, error)) { newFeedTokenFunc = f }(newFeedTokenFunc)
+	newFeedTokenFunc = func() (string, error) {
+		// other call saves its token after this one found none
+		if err := insertFeedToken(ctx, db, uid, "first"); err != nil {
+			t.Fatal(err)
+		}
+		return "second", nil
+	}
+	token, err := st.FeedToken(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "first" {
+		t.Error("Token created concurrently should be kept", token)
+	}
+	if feedUID, err := st.FeedUserID(ctx, "first"); err != nil || feedUID != uid {
+		t.Error("Token created concurrently should be valid", feedUID, err)
+	}
 }
 
-func randStatus() entities.PlanningStatus {
-	if 1 == rand.Int63()%2 {
-		return entities.Open
+func TestResetFeedToken(t *testing.T) {
+	defer prepareDB()()
+	st := NewPlanningStorage(newTestDB(), second)
+	uid := ctxtg.UserID(rand.Int63())
+	token, err := st.FeedToken(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := st.ResetFeedToken(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newToken == token {
+		t.Error("Token should be changed")
+	}
+	if _, err := st.FeedUserID(ctx, token); err != entities.ErrInvalidFeedToken {
+		t.Error("Unexpected err", err)
+	}
+	feedUID, err := st.FeedUserID(ctx, newToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feedUID != uid {
+		t.Error("Invalid user id", feedUID)
 	}
-	return entities.Closed
 }
 
-func randString() string {
-	return fmt.Sprint(rand.Int63())
+func TestFeedUserIDInvalidToken(t *testing.T) {
+	defer prepareDB()()
+	st := NewPlanningStorage(newTestDB(), second)
+	_, err := st.FeedUserID(ctx, randString())
+	if err != entities.ErrInvalidFeedToken {
+		t.Error("Unexpected err", err)
+	}
 }
 
-func mockTimeNow(timeToReturn int64) func() {
-	timeNowFunc = func() int64 { return timeToReturn }
-	return func() {
-		timeNowFunc = func() int64 {
-			return time.Now().Unix()
+func TestWorkSessions(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	uid := ctxtg.UserID(rand.Int63())
+	p1 := saveTestPlanningOpened(db, t, uid)
+	p2 := saveTestPlanningClosed(db, t, uid)
+	p3 := saveTestPlanningOpened(db, t, uid+1)
+	histories := []entities.SpentTimeHistory{
+		{PlanningID: p1.ID, Spent: 5, StartedAt: 5, EndedAt: 10, Status: entities.Online},
+		{PlanningID: p2.ID, Spent: 10, StartedAt: 20, EndedAt: 30, Status: entities.Offline},
+		{PlanningID: p1.ID, Spent: 10, StartedAt: 40, EndedAt: 50, Status: entities.Online},
+		{PlanningID: p3.ID, Spent: 10, StartedAt: 20, EndedAt: 30, Status: entities.Online},
+	}
+	for _, h := range histories {
+		if err := st.AddSpentTime(ctx, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws, err := st.WorkSessions(ctx, uid, 10, 45)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []entities.WorkSession{
+		{SpentTimeHistory: histories[0], IssueTitle: p1.IssueTitle, IssueURL: p1.IssueURL},
+		{SpentTimeHistory: histories[1], IssueTitle: p2.IssueTitle, IssueURL: p2.IssueURL},
+		{SpentTimeHistory: histories[2], IssueTitle: p1.IssueTitle, IssueURL: p1.IssueURL},
+	}
+	if len(ws) != len(expected) {
+		t.Fatalf("Invalid amount %d %+v", len(ws), ws)
+	}
+	for i := range expected {
+		if ws[i] != expected[i] {
+			t.Errorf("Invalid work session %+v %+v", ws[i], expected[i])
+		}
+	}
+}
+
+func TestTeams(t *testing.T) {
+	defer prepareDB()()
+	st := NewPlanningStorage(newTestDB(), second)
+	name := randString()
+	tid, err := st.CreateTeam(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	teams, err := st.Teams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(teams) != 1 || teams[0].ID != tid || teams[0].Name != name {
+		t.Errorf("Invalid teams %+v", teams)
+	}
+	if err := st.DeleteTeam(ctx, tid); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.DeleteTeam(ctx, tid); err != entities.ErrInvalidTeamID {
+		t.Error("Unexpected err", err)
+	}
+}
+
+func TestSetTeamMemberInvalid(t *testing.T) {
+	defer prepareDB()()
+	st := NewPlanningStorage(newTestDB(), second)
+	err := st.SetTeamMember(ctx, entities.TeamMember{
+		TeamID: entities.TeamID(rand.Int31()),
+		UserID: ctxtg.UserID(rand.Int63()),
+		Role:   entities.Lead,
+	})
+	if err != entities.ErrInvalidTeamID {
+		t.Error("Unexpected err", err)
+	}
+	tid, err := st.CreateTeam(ctx, randString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.SetTeamMember(ctx, entities.TeamMember{
+		TeamID: tid,
+		UserID: ctxtg.UserID(rand.Int63()),
+		Role:   entities.TeamRole(randString()),
+	})
+	if err != entities.ErrInvalidTeamRole {
+		t.Error("Unexpected err", err)
+	}
+}
+
+func TestTeamMembers(t *testing.T) {
+	defer prepareDB()()
+	st := NewPlanningStorage(newTestDB(), second)
+	tid, err := st.CreateTeam(ctx, randString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherTID, err := st.CreateTeam(ctx, randString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lead := ctxtg.UserID(rand.Int31())
+	members := []entities.TeamMember{
+		{TeamID: tid, UserID: lead, Role: entities.Member},
+		{TeamID: tid, UserID: lead + 1, Role: entities.Member},
+		{TeamID: tid, UserID: lead, Role: entities.Lead},
+		{TeamID: otherTID, UserID: lead + 2, Role: entities.Member},
+	}
+	for _, m := range members {
+		if err := st.SetTeamMember(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ms, err := st.TeamMembers(ctx, tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 || ms[0] != members[2] || ms[1] != members[1] {
+		t.Errorf("Invalid members %+v", ms)
+	}
+	role, err := st.TeamRole(ctx, tid, lead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != entities.Lead {
+		t.Error("Invalid role", role)
+	}
+	role, err = st.TeamRole(ctx, otherTID, lead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != "" {
+		t.Error("Invalid role", role)
+	}
+	for member, expected := range map[ctxtg.UserID]bool{
+		lead + 1: true,
+		lead + 2: false,
+	} {
+		ok, err := st.IsTeamLead(ctx, lead, member)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != expected {
+			t.Error("Invalid lead check for", member)
 		}
 	}
+	if err := st.RemoveTeamMember(ctx, tid, lead+1); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := st.IsTeamLead(ctx, lead, lead+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("Removed member shouldn't be led")
+	}
+}
+
+func TestSubmitTimesheet(t *testing.T) {
+	defer prepareDB()()
+	defer mockTimeNow(100)()
+	st := NewPlanningStorage(newTestDB(), second)
+	uid := ctxtg.UserID(rand.Int63())
+	id, err := st.SubmitTimesheet(ctx, entities.Timesheet{UserID: uid, From: 10, To: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = st.SubmitTimesheet(ctx, entities.Timesheet{UserID: uid, From: 15, To: 30})
+	if err != entities.ErrPeriodLocked {
+		t.Error("Unexpected err", err)
+	}
+	if _, err := st.SubmitTimesheet(ctx, entities.Timesheet{UserID: uid + 1, From: 15, To: 30}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.SubmitTimesheet(ctx, entities.Timesheet{UserID: uid, From: 20, To: 30}); err != nil {
+		t.Fatal(err)
+	}
+	ts, err := st.Timesheet(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := entities.Timesheet{
+		ID:        id,
+		UserID:    uid,
+		From:      10,
+		To:        20,
+		Status:    entities.Submitted,
+		UpdatedAt: 100,
+	}
+	if *ts != expected {
+		t.Errorf("Invalid timesheet %+v", ts)
+	}
+	timesheets, err := st.Timesheets(ctx, uid, 0, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timesheets) != 2 || timesheets[0] != expected {
+		t.Errorf("Invalid timesheets %+v", timesheets)
+	}
+	if _, err := st.Timesheet(ctx, id+100); err != entities.ErrInvalidTimesheet {
+		t.Error("Unexpected err", err)
+	}
+}
+
+func TestReviewTimesheet(t *testing.T) {
+	defer prepareDB()()
+	st := NewPlanningStorage(newTestDB(), second)
+	uid := ctxtg.UserID(rand.Int63())
+	approver := uid + 1
+	id, err := st.SubmitTimesheet(ctx, entities.Timesheet{UserID: uid, From: 10, To: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		status entities.TimesheetStatus
+		err    error
+		locked bool
+	}{
+		{entities.Unlocked, entities.ErrTimesheetStatus, true},
+		{entities.Approved, nil, true},
+		{entities.Rejected, entities.ErrTimesheetStatus, true},
+		{entities.Unlocked, nil, false},
+		{entities.Approved, entities.ErrTimesheetStatus, false},
+	} {
+		err := st.ReviewTimesheet(ctx, entities.TimesheetReview{
+			ID:         id,
+			ApproverID: approver,
+			Status:     tc.status,
+			Comment:    randString(),
+		})
+		if err != tc.err {
+			t.Errorf("Unexpected err %v for %s", err, tc.status)
+		}
+		locked, err := st.IsPeriodLocked(ctx, uid, 15, 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked != tc.locked {
+			t.Errorf("Invalid lock after %s", tc.status)
+		}
+	}
+	ts, err := st.Timesheet(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Status != entities.Unlocked || ts.ApproverID != approver {
+		t.Errorf("Invalid timesheet %+v", ts)
+	}
+	err = st.ReviewTimesheet(ctx, entities.TimesheetReview{ID: id + 1, Status: entities.Approved})
+	if err != entities.ErrInvalidTimesheet {
+		t.Error("Unexpected err", err)
+	}
+}
+
+func TestAddSpentTimeLockedPeriod(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	uid := ctxtg.UserID(rand.Int63())
+	p := saveTestPlanningOpened(db, t, uid)
+	if _, err := st.SubmitTimesheet(ctx, entities.Timesheet{UserID: uid, From: 10, To: 20}); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []entities.SpentTimeHistory{
+		{PlanningID: p.ID, Spent: 10, StartedAt: 5, EndedAt: 15, Status: entities.Online},
+		{PlanningID: p.ID, Spent: 5, StartedAt: 12, EndedAt: 17, Status: entities.Offline},
+	} {
+		if err := st.AddSpentTime(ctx, h); err != entities.ErrPeriodLocked {
+			t.Error("Unexpected err", err)
+		}
+	}
+	for _, h := range []entities.SpentTimeHistory{
+		{PlanningID: p.ID, Spent: 5, StartedAt: 5, EndedAt: 10, Status: entities.Online},
+		{PlanningID: p.ID, Spent: 5, StartedAt: 20, EndedAt: 25, Status: entities.Offline},
+	} {
+		if err := st.AddSpentTime(ctx, h); err != nil {
+			t.Error("Unexpected err", err)
+		}
+	}
+}
+
+func TestCreatePlanningBillable(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	p := randNewPlanning()
+	err := st.SetProjectBilling(ctx, entities.ProjectBilling{ProjectID: p.ProjectID, Billable: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := st.CreatePlanning(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planning, err := st.Planning(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if planning.Billable {
+		t.Error("Planning should use project's rule")
+	}
+	billable := true
+	p.Billable = &billable
+	id, err = st.CreatePlanning(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planning, err = st.Planning(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !planning.Billable {
+		t.Error("Planning should override project's rule")
+	}
+	if err := st.SetPlanningBillable(ctx, id, false); err != nil {
+		t.Fatal(err)
+	}
+	planning, err = st.Planning(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if planning.Billable {
+		t.Error("Planning billable flag should be changed")
+	}
+	if err := st.SetPlanningBillable(ctx, id+1, false); err != entities.ErrInvalidPlanningID {
+		t.Error("Unexpected err", err)
+	}
+}
+
+func TestRates(t *testing.T) {
+	defer prepareDB()()
+	st := NewPlanningStorage(newTestDB(), second)
+	if _, err := st.AddRate(ctx, entities.Rate{Rate: -1}); err != entities.ErrInvalidRate {
+		t.Error("Unexpected err", err)
+	}
+	rates := []entities.Rate{
+		{UserID: ctxtg.UserID(rand.Int31()), Rate: 100, EffectiveFrom: 20},
+		{ProjectID: entities.ProjectID(rand.Int31()), ActivityID: 1, Rate: 200, EffectiveFrom: 10},
+	}
+	for i := range rates {
+		id, err := st.AddRate(ctx, rates[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		rates[i].ID = id
+	}
+	if err := st.DeleteRate(ctx, rates[0].ID+rates[1].ID); err != entities.ErrInvalidRateID {
+		t.Error("Unexpected err", err)
+	}
+	saved, err := st.Rates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 2 || saved[0] != rates[1] || saved[1] != rates[0] {
+		t.Errorf("Invalid rates %+v", saved)
+	}
+	if err := st.DeleteRate(ctx, rates[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	saved, err = st.Rates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0] != rates[1] {
+		t.Errorf("Invalid rates %+v", saved)
+	}
+}
+
+func TestBillableWork(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	uid := ctxtg.UserID(rand.Int63())
+	p1 := saveTestPlanningOpened(db, t, uid)
+	p2 := saveTestPlanningOpened(db, t, uid+1)
+	p3 := saveTestPlanningOpened(db, t, uid)
+	for _, p := range []entities.Planning{p1, p2} {
+		if err := st.SetPlanningBillable(ctx, p.ID, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.SetPlanningBillable(ctx, p3.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	histories := []entities.SpentTimeHistory{
+		{PlanningID: p1.ID, Spent: 5, StartedAt: 5, EndedAt: 10, Status: entities.Online},
+		{PlanningID: p1.ID, Spent: 10, StartedAt: 20, EndedAt: 30, Status: entities.Offline},
+		{PlanningID: p2.ID, Spent: 10, StartedAt: 20, EndedAt: 30, Status: entities.Online},
+		{PlanningID: p3.ID, Spent: 10, StartedAt: 20, EndedAt: 30, Status: entities.Online},
+	}
+	for _, h := range histories {
+		if err := st.AddSpentTime(ctx, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws, err := st.BillableWork(ctx, entities.BillingQuery{UserID: uid, From: 10, To: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := entities.BillableWork{
+		SpentTimeHistory: histories[1],
+		UserID:           uid,
+		ProjectID:        p1.ProjectID,
+		ActivityID:       p1.ActivityID,
+	}
+	if len(ws) != 1 || ws[0] != expected {
+		t.Errorf("Invalid billable work %+v", ws)
+	}
+	ws, err = st.BillableWork(ctx, entities.BillingQuery{From: 0, To: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ws) != 3 {
+		t.Errorf("Invalid billable work %+v", ws)
+	}
+}
+
+func randSpentTimeHistory() entities.SpentTimeHistory {
+	return entities.SpentTimeHistory{
+		PlanningID: entities.PlanningID(rand.Int63()),
+		Spent:      int(rand.Int31() / 10),
+		StartedAt:  rand.Int63(),
+		EndedAt:    rand.Int63(),
+		Status:     randSpentTimeStatus(),
+	}
+}
+
+func randPlannedTime() entities.PlannedTime {
+	return entities.PlannedTime{
+		ID:         rand.Int63(),
+		Estimation: int64(math.Abs(float64(rand.Int31()))),
+		Reason:     randString(),
+		CreatedAt:  int64(math.Abs(float64(rand.Int31()))),
+	}
+}
+
+func randNewPlanning() entities.NewPlanning {
+	return entities.NewPlanning{
+		UserID:          ctxtg.UserID(rand.Int63()),
+		ProjectID:       entities.ProjectID(rand.Int31()),
+		TrackerID:       entities.TrackerID(rand.Int31()),
+		IssueID:         entities.IssueID(rand.Int31()),
+		IssueTitle:      randString(),
+		IssueURL:        randString(),
+		IssueEstimation: int64(rand.Int31()),
+		IssueDueDate:    rand.Int63(),
+		IssueDone:       int(rand.Int31()),
+		ActivityID:      entities.ActivityID(rand.Int31()),
+		Estimation:      int64(rand.Int31()),
+	}
+}
+
+func randPlanning() entities.Planning {
+	return entities.Planning{
+		ID:              entities.PlanningID(rand.Int63()),
+		UserID:          ctxtg.UserID(rand.Int63()),
+		ProjectID:       entities.ProjectID(rand.Int31()),
+		TrackerID:       entities.TrackerID(rand.Int31()),
+		IssueID:         entities.IssueID(rand.Int31()),
+		IssueTitle:      randString(),
+		IssueURL:        randString(),
+		IssueEstimation: int64(rand.Int31()),
+		IssueDueDate:    rand.Int63(),
+		ActivityID:      entities.ActivityID(rand.Int31()),
+		SpentOnline:     int(rand.Int31() / 2),
+		SpentOffline:    int(rand.Int31() / 2),
+		Reported:        int64(rand.Int31()),
+		CreatedAt:       rand.Int63(),
+		Status:          randStatus(),
+	}
+}
+
+func randSpentTimeStatus() entities.SpentTimeStatus {
+	if 1 == rand.Int63()%2 {
+		return entities.Online
+	}
+	return entities.Offline
+
+}
+
+func randStatus() entities.PlanningStatus {
+	if 1 == rand.Int63()%2 {
+		return entities.Open
+	}
+	return entities.Closed
+}
+
+func randString() string {
+	return fmt.Sprint(rand.Int63())
+}
+
+func mockTimeNow(timeToReturn int64) func() {
+	timeNowFunc = func() int64 { return timeToReturn }
+	return func() {
+		timeNowFunc = func() int64 {
+			return time.Now().Unix()
+		}
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	p := saveTestPlanningOpened(db, t, ctxtg.UserID(rand.Int63()))
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := st.Planning(canceled, p.ID)
+	if errors.Cause(err) != context.Canceled {
+		t.Errorf("unexpected error %v", err)
+	}
+	err = st.AddSpentTime(canceled, entities.SpentTimeHistory{PlanningID: p.ID, Spent: 1, Status: entities.Online})
+	if errors.Cause(err) != context.Canceled {
+		t.Errorf("unexpected error %v", err)
+	}
+	_, err = st.OpenedPlannings(canceled, p.UserID)
+	if errors.Cause(err) != context.Canceled {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestContract(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (storagetest.PlanningStorage, func()) {
+		cleanup := prepareDB()
+		return NewPlanningStorage(newTestDB(), second), cleanup
+	})
+}
+
+func TestRepairActivity(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	np := randNewPlanning()
+	id, err := st.CreatePlanning(ctx, np)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.AddExtraTime(ctx, np.UserID, entities.PlannedTime{PlanningID: id, Estimation: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.AddSpentTime(ctx, entities.SpentTimeHistory{PlanningID: id, Spent: 10, StartedAt: 100, EndedAt: 110, Status: entities.Online})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := st.RepairActivity(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != (ActivityRepair{}) {
+		t.Errorf("nothing should be repaired, got %+v", r)
+	}
+
+	db.MustExec(db.Rebind("UPDATE Planning SET last_activity_at = 0, current_estimation = 0 WHERE id = ?"), id)
+	db.MustExec("DELETE FROM UserActivity")
+	ps, err := st.OpenedPlannings(ctx, np.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 1 || ps[0].Estimation != 0 || ps[0].LastActivity != 0 {
+		t.Fatalf("unexpected opened plannings %+v", ps)
+	}
+	r, err = st.RepairActivity(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ActivityRepair{LastActivities: 1, CurrentEstimations: 1, UserActivities: 1}
+	if r != expected {
+		t.Errorf("unexpected repair %+v, expected %+v", r, expected)
+	}
+	ps, err = st.OpenedPlannings(ctx, np.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 1 || ps[0].Estimation != 50 || ps[0].LastActivity != 110 {
+		t.Errorf("unexpected opened plannings %+v", ps)
+	}
+	last, err := st.LastActivity(ctx, np.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != 110 {
+		t.Errorf("unexpected last activity %d", last)
+	}
+
+	db.MustExec(db.Rebind("UPDATE UserActivity SET last_activity_at = 1 WHERE user_id = ?"), np.UserID)
+	r, err = st.RepairActivity(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != (ActivityRepair{UserActivities: 1}) {
+		t.Errorf("unexpected repair %+v", r)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	np := randNewPlanning()
+	old := createClosedPlanning(t, st, np, entities.SpentTimeHistory{Spent: 10, StartedAt: 100, EndedAt: 110, Status: entities.Online})
+	recent := createClosedPlanning(t, st, np, entities.SpentTimeHistory{Spent: 20, StartedAt: 500, EndedAt: 520, Status: entities.Offline})
+	opened, err := st.CreatePlanning(ctx, np)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.MustExec(db.Rebind("UPDATE Planning SET created_at = ? WHERE id IN (?, ?)"), 50, old, opened)
+	db.MustExec(db.Rebind("UPDATE Planning SET created_at = ? WHERE id = ?"), 450, recent)
+
+	n, err := st.Archive(ctx, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("unexpected archived plannings %d", n)
+	}
+	p, err := st.Planning(ctx, old)
+	if err != nil || p != nil {
+		t.Errorf("archived planning should not be read %v, %v", p, err)
+	}
+	spent, err := st.SpentTimeByUserIDTimeRange(ctx, np.UserID, 0, 1000)
+	if err != nil || spent != 20 {
+		t.Errorf("unexpected spent time %d, %v", spent, err)
+	}
+
+	st.SetReadArchive(true)
+	p, err = st.Planning(ctx, old)
+	if err != nil || p == nil || p.Status != entities.Closed || p.SpentOnline != 10 {
+		t.Errorf("unexpected archived planning %+v, %v", p, err)
+	}
+	ps, err := st.Plannings(ctx, []entities.PlanningID{recent, old, opened})
+	if err != nil || len(ps) != 3 || ps[2].ID != recent {
+		t.Errorf("unexpected plannings %+v, %v", ps, err)
+	}
+	hs, err := st.SpentTimeHistories(ctx, []entities.PlanningID{old, recent})
+	if err != nil || len(hs) != 2 || hs[0].PlanningID != old || hs[1].PlanningID != recent {
+		t.Errorf("unexpected histories %+v, %v", hs, err)
+	}
+	pts, err := st.PlannedTimes(ctx, []entities.PlanningID{old})
+	if err != nil || len(pts) != 1 || pts[0].Estimation != np.Estimation {
+		t.Errorf("unexpected planned times %+v, %v", pts, err)
+	}
+	spent, err = st.SpentTimeByUserIDTimeRange(ctx, np.UserID, 0, 1000)
+	if err != nil || spent != 30 {
+		t.Errorf("unexpected spent time %d, %v", spent, err)
+	}
+	ws, err := st.WorkSessions(ctx, np.UserID, 0, 1000)
+	if err != nil || len(ws) != 2 || ws[0].PlanningID != old {
+		t.Errorf("unexpected work sessions %+v, %v", ws, err)
+	}
+	bw, err := st.BillableWork(ctx, entities.BillingQuery{UserID: np.UserID, From: 0, To: 1000})
+	if err != nil || len(bw) != 2 || bw[0].PlanningID != old {
+		t.Errorf("unexpected billable work %+v, %v", bw, err)
+	}
+
+	n, err = st.Archive(ctx, 300)
+	if err != nil || n != 0 {
+		t.Errorf("archived plannings should not be archived again %d, %v", n, err)
+	}
+}
+
+func TestArchiveCanceled(t *testing.T) {
+	defer prepareDB()()
+	st := NewPlanningStorage(newTestDB(), second)
+	np := randNewPlanning()
+	id := createClosedPlanning(t, st, np, entities.SpentTimeHistory{Spent: 10, StartedAt: 100, EndedAt: 110, Status: entities.Online})
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	n, err := st.Archive(canceled, timeNowFunc()+1)
+	if errors.Cause(err) != context.Canceled || n != 0 {
+		t.Errorf("archiving should be stopped %d, %v", n, err)
+	}
+	if p, err := st.Planning(ctx, id); err != nil || p == nil {
+		t.Errorf("planning should not be archived %+v, %v", p, err)
+	}
+}
+
+// queryRecorder records queries executed by read through functions
+type queryRecorder struct {
+	sqlx.ExtContext
+	queries []string
+}
+
+func (r *queryRecorder) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	r.queries = append(r.queries, query)
+	return r.ExtContext.QueryContext(ctx, query, args...)
+}
+
+func (r *queryRecorder) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	r.queries = append(r.queries, query)
+	return r.ExtContext.QueryxContext(ctx, query, args...)
+}
+
+func (r *queryRecorder) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	r.queries = append(r.queries, query)
+	return r.ExtContext.QueryRowxContext(ctx, query, args...)
+}
+
+// TestArchiveReader runs every read through query against archive tables only,
+// data left in primary tables must not be read
+func TestArchiveReader(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	np := randNewPlanning()
+	old := createClosedPlanning(t, st, np, entities.SpentTimeHistory{Spent: 10, StartedAt: 100, EndedAt: 110, Status: entities.Online})
+	opened, err := st.CreatePlanning(ctx, np)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := entities.SpentTimeHistory{PlanningID: opened, Spent: 20, StartedAt: 500, EndedAt: 520, Status: entities.Offline}
+	if err := st.AddSpentTime(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := st.Archive(ctx, timeNowFunc()+1); err != nil || n != 1 {
+		t.Fatalf("unexpected archived plannings %d, %v", n, err)
+	}
+	pids := []entities.PlanningID{old, opened}
+	rec := &queryRecorder{ExtContext: db}
+	ar := archiveReader{rec}
+
+	p, err := findPlanning(ctx, ar, old)
+	if err != nil || p == nil || p.ID != old {
+		t.Errorf("unexpected archived planning %+v, %v", p, err)
+	}
+	p, err = findPlanning(ctx, ar, opened)
+	if err != nil || p != nil {
+		t.Errorf("primary planning should not be read %+v, %v", p, err)
+	}
+	ps, err := findPlannings(ctx, ar, pids)
+	if err != nil || len(ps) != 1 || ps[0].ID != old {
+		t.Errorf("unexpected archived plannings %+v, %v", ps, err)
+	}
+	pts, err := findPlannedTimes(ctx, ar, pids)
+	if err != nil || len(pts) != 1 || pts[0].PlanningID != old {
+		t.Errorf("unexpected archived planned times %+v, %v", pts, err)
+	}
+	hs, err := findHistoriesForPlannings(ctx, ar, pids)
+	if err != nil || len(hs) != 1 || hs[0].PlanningID != old {
+		t.Errorf("unexpected archived histories %+v, %v", hs, err)
+	}
+	spent, err := spentTime(ctx, ar, np.UserID, 0, timeNowFunc()+1)
+	if err != nil || spent != 10 {
+		t.Errorf("unexpected archived spent time %d, %v", spent, err)
+	}
+	ws, err := findWorkSessions(ctx, ar, np.UserID, 0, 1000)
+	if err != nil || len(ws) != 1 || ws[0].PlanningID != old {
+		t.Errorf("unexpected archived work sessions %+v, %v", ws, err)
+	}
+	bw, err := findBillableWork(ctx, ar, entities.BillingQuery{UserID: np.UserID, From: 0, To: 1000})
+	if err != nil || len(bw) != 1 || bw[0].PlanningID != old {
+		t.Errorf("unexpected archived billable work %+v, %v", bw, err)
+	}
+	ids, err := findUserPlanningIDs(ctx, ar, np.UserID)
+	if err != nil || len(ids) != 1 || ids[0] != old {
+		t.Errorf("unexpected archived planning ids %v, %v", ids, err)
+	}
+
+	if len(rec.queries) < 9 {
+		t.Errorf("read through queries should be recorded %q", rec.queries)
+	}
+	primary := regexp.MustCompile(`\b(Planning|PlannedTime|SpentTimeHistory)\b`)
+	for _, q := range rec.queries {
+		if primary.MatchString(q) {
+			t.Errorf("query reads primary table %s", q)
+		}
+	}
+}
+
+func createClosedPlanning(t *testing.T, st *PlanningStorage, np entities.NewPlanning, h entities.SpentTimeHistory) entities.PlanningID {
+	id, err := st.CreatePlanning(ctx, np)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.PlanningID = id
+	if err := st.AddSpentTime(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.ClosePlanning(ctx, np.UserID, entities.PlanningReport{PlanningID: id}); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestExportUserData(t *testing.T) {
+	defer prepareDB()()
+	defer mockTimeNow(1000)()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	np, archived, opened := createUserData(t, st)
+	other := randNewPlanning()
+	if _, err := st.CreatePlanning(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	admin := ctxtg.UserID(rand.Int63())
+
+	d, err := st.ExportUserData(ctx, entities.PrivacyRequest{UserID: np.UserID, AdminID: admin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Plannings) != 2 || d.Plannings[0].ID != archived || d.Plannings[1].ID != opened {
+		t.Errorf("unexpected plannings %+v", d.Plannings)
+	}
+	if len(d.PlannedTimes) != 2 || len(d.SpentTimeHistories) != 2 {
+		t.Errorf("unexpected planned times %+v or histories %+v", d.PlannedTimes, d.SpentTimeHistories)
+	}
+	if len(d.Timesheets) != 1 || d.Timesheets[0].Status != entities.Submitted {
+		t.Errorf("unexpected timesheets %+v", d.Timesheets)
+	}
+	if len(d.TeamMembers) != 1 || d.TeamMembers[0].Role != entities.Lead {
+		t.Errorf("unexpected team members %+v", d.TeamMembers)
+	}
+	if len(d.Rates) != 1 || !d.HasFeedToken || d.LastActivity != 520 || d.UserID != np.UserID {
+		t.Errorf("unexpected user data %+v", d)
+	}
+
+	rs, err := st.PrivacyRequests(ctx, np.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := entities.PrivacyRequest{
+		ID:        rs[0].ID,
+		UserID:    np.UserID,
+		AdminID:   admin,
+		Action:    entities.Export,
+		CreatedAt: 1000,
+	}
+	if len(rs) != 1 || rs[0] != expected {
+		t.Errorf("unexpected privacy requests %+v", rs)
+	}
+}
+
+func TestEraseUserData(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	np, _, _ := createUserData(t, st)
+	other := randNewPlanning()
+	otherID, err := st.CreatePlanning(ctx, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approved := approvedTimesheet(t, st, other.UserID, np.UserID)
+
+	err = st.EraseUserData(ctx, entities.PrivacyRequest{UserID: np.UserID, Action: entities.Export})
+	if err == nil {
+		t.Error("export should not erase data")
+	}
+	err = st.EraseUserData(ctx, entities.PrivacyRequest{UserID: np.UserID, Action: entities.Erase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := st.ExportUserData(ctx, entities.PrivacyRequest{UserID: np.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*d, entities.UserData{UserID: np.UserID}) {
+		t.Errorf("user data should be erased %+v", d)
+	}
+	for _, table := range []string{"PlannedTimeArchive", "SpentTimeHistoryArchive", "PlannedTime", "SpentTimeHistory"} {
+		var n int
+		if err := db.Get(&n, "SELECT COUNT(*) FROM "+table+" WHERE planning_id <> ?", otherID); err != nil || n != 0 {
+			t.Errorf("%s should be erased %d, %v", table, n, err)
+		}
+	}
+	ts, err := st.Timesheet(ctx, approved)
+	if err != nil || ts.ApproverID != 0 {
+		t.Errorf("approver should be erased %+v, %v", ts, err)
+	}
+	p, err := st.Planning(ctx, otherID)
+	if err != nil || p == nil {
+		t.Errorf("other user's planning should be kept %+v, %v", p, err)
+	}
+	rs, err := st.PrivacyRequests(ctx, np.UserID)
+	if err != nil || len(rs) != 2 || rs[0].Action != entities.Erase || rs[1].Action != entities.Export {
+		t.Errorf("unexpected privacy requests %+v, %v", rs, err)
+	}
+}
+
+func TestPseudonymiseUserData(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	st := NewPlanningStorage(db, second)
+	st.SetReadArchive(true)
+	np, archived, opened := createUserData(t, st)
+	other := randNewPlanning()
+	approved := approvedTimesheet(t, st, other.UserID, np.UserID)
+
+	err := st.EraseUserData(ctx, entities.PrivacyRequest{UserID: np.UserID, Action: entities.Pseudonymise})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := st.PrivacyRequests(ctx, np.UserID)
+	if err != nil || len(rs) != 1 || rs[0].Action != entities.Pseudonymise {
+		t.Fatalf("unexpected privacy requests %+v, %v", rs, err)
+	}
+	pseudonym := pseudonymFor(rs[0].ID)
+
+	d, err := st.ExportUserData(ctx, entities.PrivacyRequest{UserID: np.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*d, entities.UserData{UserID: np.UserID}) {
+		t.Errorf("user data should be pseudonymised %+v", d)
+	}
+	d, err = st.ExportUserData(ctx, entities.PrivacyRequest{UserID: pseudonym})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Plannings) != 2 || d.Plannings[0].ID != archived || d.Plannings[1].ID != opened {
+		t.Errorf("unexpected plannings %+v", d.Plannings)
+	}
+	if len(d.SpentTimeHistories) != 2 || len(d.Timesheets) != 1 || len(d.Rates) != 1 || d.LastActivity != 520 {
+		t.Errorf("unexpected user data %+v", d)
+	}
+	if len(d.TeamMembers) != 0 || d.HasFeedToken {
+		t.Errorf("team members and feed token should be erased %+v", d)
+	}
+	spent, err := st.SpentTimeByUserIDTimeRange(ctx, pseudonym, 0, timeNowFunc())
+	if err != nil || spent != 30 {
+		t.Errorf("unexpected spent time %d, %v", spent, err)
+	}
+	ts, err := st.Timesheet(ctx, approved)
+	if err != nil || ts.ApproverID != pseudonym {
+		t.Errorf("approver should be pseudonymised %+v, %v", ts, err)
+	}
+}
+
+// createUserData creates archived and opened plannings with histories, timesheet,
+// team membership, feed token and rate of user
+func createUserData(t *testing.T, st *PlanningStorage) (np entities.NewPlanning, archived, opened entities.PlanningID) {
+	np = randNewPlanning()
+	archived = createClosedPlanning(t, st, np, entities.SpentTimeHistory{Spent: 10, StartedAt: 100, EndedAt: 110, Status: entities.Online})
+	if _, err := st.Archive(ctx, timeNowFunc()+1); err != nil {
+		t.Fatal(err)
+	}
+	opened, err := st.CreatePlanning(ctx, np)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := entities.SpentTimeHistory{PlanningID: opened, Spent: 20, StartedAt: 500, EndedAt: 520, Status: entities.Offline}
+	if err := st.AddSpentTime(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.SubmitTimesheet(ctx, entities.Timesheet{UserID: np.UserID, From: 600, To: 700}); err != nil {
+		t.Fatal(err)
+	}
+	tid, err := st.CreateTeam(ctx, randString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SetTeamMember(ctx, entities.TeamMember{TeamID: tid, UserID: np.UserID, Role: entities.Lead}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.FeedToken(ctx, np.UserID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.AddRate(ctx, entities.Rate{UserID: np.UserID, Rate: 100}); err != nil {
+		t.Fatal(err)
+	}
+	return np, archived, opened
+}
+
+func approvedTimesheet(t *testing.T, st *PlanningStorage, uid, approver ctxtg.UserID) entities.TimesheetID {
+	id, err := st.SubmitTimesheet(ctx, entities.Timesheet{UserID: uid, From: 600, To: 700})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.ReviewTimesheet(ctx, entities.TimesheetReview{ID: id, ApproverID: approver, Status: entities.Approved})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestSpentTimeStorage(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	s := NewSpentTimeStorage(NewPlanningStorage(db, second))
+	st := entities.SpentTime{
+		UserID:            ctxtg.UserID(rand.Int63()),
+		PlanningID:        entities.PlanningID(rand.Int63()),
+		PlanningCreatedAt: rand.Int63(),
+		Started:           rand.Int63(),
+		Last:              rand.Int63(),
+		SpentOnline:       int(rand.Int31()),
+	}
+	if err := s.NewSpentTime(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	st.SpentOnline++
+	if err := s.NewSpentTime(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.SpentTime(ctx, st.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil || *res != st {
+		t.Errorf("Expected %+v, got %+v", st, res)
+	}
+	uids, err := s.UserIDs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(uids, []ctxtg.UserID{st.UserID}) {
+		t.Errorf("Expected %v, got %v", st.UserID, uids)
+	}
+	if err := s.Erase(ctx, st.UserID); err != nil {
+		t.Fatal(err)
+	}
+	res, err = s.SpentTime(ctx, st.UserID)
+	if err != nil || res != nil {
+		t.Errorf("Expected erased spent time, got %+v %v", res, err)
+	}
+}
+
+func TestSpentTimeStorageConcurrentModify(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	s := NewSpentTimeStorage(NewPlanningStorage(db, second))
+	uid := ctxtg.UserID(rand.Int63())
+	const n = 10
+	var running, overlapped int32
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.Modify(ctx, uid, func(_ context.Context, st *entities.SpentTime) (*entities.SpentTime, error) {
+				if atomic.AddInt32(&running, 1) > 1 {
+					atomic.StoreInt32(&overlapped, 1)
+				}
+				defer atomic.AddInt32(&running, -1)
+				time.Sleep(10 * time.Millisecond)
+				if st == nil {
+					return &entities.SpentTime{PlanningID: 1}, nil
+				}
+				st.SpentOnline++
+				return st, nil
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if overlapped != 0 {
+		t.Error("Modify of user without timer should be serialized")
+	}
+	st, err := s.SpentTime(ctx, uid)
+	if err != nil || st == nil || st.SpentOnline != n-1 {
+		t.Errorf("Expected %d modifications, got %+v, %v", n-1, st, err)
+	}
+	if err := s.Erase(ctx, uid); err != nil {
+		t.Fatal(err)
+	}
+	var locks int
+	if err := db.Get(&locks, db.Rebind("SELECT COUNT(*) FROM ActiveSpentTimeLock WHERE user_id = ?"), uid); err != nil || locks != 0 {
+		t.Errorf("Lock of erased user should be removed %d, %v", locks, err)
+	}
+}
+
+func TestSpentTimeStorageModifyWithAddSpentTime(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	p := NewPlanningStorage(db, second)
+	s := NewSpentTimeStorage(p)
+	np := randNewPlanning()
+	pid, err := p.CreatePlanning(ctx, np)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := entities.SpentTime{
+		UserID:      np.UserID,
+		PlanningID:  pid,
+		Started:     100,
+		Last:        200,
+		SpentOnline: 100,
+	}
+	if err := s.NewSpentTime(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	toHistory := func(ctx context.Context, cur *entities.SpentTime) (*entities.SpentTime, error) {
+		return nil, p.AddSpentTime(ctx, entities.SpentTimeHistory{
+			PlanningID: cur.PlanningID,
+			Spent:      cur.SpentOnline,
+			StartedAt:  cur.Started,
+			EndedAt:    cur.Last,
+			Status:     entities.Online,
+		})
+	}
+
+	errTest := errors.New("test")
+	err = s.Modify(ctx, st.UserID, func(ctx context.Context, cur *entities.SpentTime) (*entities.SpentTime, error) {
+		if _, err := toHistory(ctx, cur); err != nil {
+			t.Fatal(err)
+		}
+		return nil, errTest
+	})
+	if err != errTest {
+		t.Errorf("Expected %v, got %v", errTest, err)
+	}
+	hs, err := p.SpentTimeHistories(ctx, []entities.PlanningID{pid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hs) != 0 {
+		t.Errorf("History should be rolled back with failed Modify, got %+v", hs)
+	}
+	res, err := s.SpentTime(ctx, st.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil || *res != st {
+		t.Errorf("Spent time should be kept after failed Modify, expected %+v, got %+v", st, res)
+	}
+
+	err = s.Modify(ctx, st.UserID, toHistory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs, err = p.SpentTimeHistories(ctx, []entities.PlanningID{pid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hs) != 1 || hs[0].Spent != st.SpentOnline {
+		t.Errorf("Expected single history of %d seconds, got %+v", st.SpentOnline, hs)
+	}
+	res, err = s.SpentTime(ctx, st.UserID)
+	if err != nil || res != nil {
+		t.Errorf("Expected flushed spent time, got %+v %v", res, err)
+	}
+}
+
+func TestSpentTimeStorageValidateTimers(t *testing.T) {
+	defer prepareDB()()
+	db := newTestDB()
+	p := NewPlanningStorage(db, second)
+	s := NewSpentTimeStorage(p)
+	now := time.Now().Unix()
+	newTimer := func(closed bool) entities.SpentTime {
+		np := randNewPlanning()
+		pid, err := p.CreatePlanning(ctx, np)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if closed {
+			if err := p.ClosePlanning(ctx, np.UserID, entities.PlanningReport{PlanningID: pid}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		st := entities.SpentTime{
+			UserID:            np.UserID,
+			PlanningID:        pid,
+			PlanningCreatedAt: now,
+			Started:           now,
+			Last:              now,
+		}
+		if err := s.NewSpentTime(ctx, st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+	valid, closed := newTimer(false), newTimer(true)
+	svc := plannings.NewService(plannings.PlanningServiceCfg{
+		SpentTimeStorage:        s,
+		PlanningStorage:         p,
+		MaxPlanningAge:          time.Hour,
+		MaxPeriodFromLastUpdate: time.Hour,
+	})
+
+	// planning is read in transaction of Modify, it would wait for single connection of sqlite
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	res, err := svc.ValidateTimers(ctx, plannings.ActionFlush)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Checked != 2 || res.Dropped != 1 || res.Failed != 0 {
+		t.Errorf("unexpected validation %+v", res)
+	}
+	if st, err := s.SpentTime(ctx, valid.UserID); err != nil || st == nil {
+		t.Errorf("valid timer should be kept %+v, %v", st, err)
+	}
+	if st, err := s.SpentTime(ctx, closed.UserID); err != nil || st != nil {
+		t.Errorf("timer of closed planning should be dropped %+v, %v", st, err)
+	}
 }
@@ -0,0 +1,471 @@
+// Package storagetest provides contract tests shared by all PlanningStorage implementations.
+package storagetest
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/api/rpcsvc"
+	"github.com/qarea/planningms/entities"
+	"github.com/qarea/planningms/plannings"
+)
+
+var ctx = context.Background()
+
+// PlanningStorage is set of methods covered by contract tests
+type PlanningStorage interface {
+	plannings.PlanningStorage
+	rpcsvc.PlanningStorage
+}
+
+// NewFunc returns empty storage and func to release it after test
+type NewFunc func(t *testing.T) (PlanningStorage, func())
+
+// Run runs all contract tests as subtests of t with new storage for each one
+func Run(t *testing.T, newStorage NewFunc) {
+	tests := []struct {
+		name string
+		test func(*testing.T, PlanningStorage)
+	}{
+		{"CreatePlanning", testCreatePlanning},
+		{"UnknownPlanning", testUnknownPlanning},
+		{"Plannings", testPlannings},
+		{"AddExtraTime", testAddExtraTime},
+		{"AddSpentTime", testAddSpentTime},
+		{"LastActivity", testLastActivity},
+		{"ClosePlanning", testClosePlanning},
+		{"ConcurrentUpdates", testConcurrentUpdates},
+		{"OpenedPlannings", testOpenedPlannings},
+		{"SpentTimeByUserIDTimeRange", testSpentTimeByUserIDTimeRange},
+		{"Timesheets", testTimesheets},
+		{"BillableWork", testBillableWork},
+	}
+	for _, tc := range tests {
+		test := tc.test
+		t.Run(tc.name, func(t *testing.T) {
+			st, release := newStorage(t)
+			defer release()
+			test(t, st)
+		})
+	}
+}
+
+func testCreatePlanning(t *testing.T, st PlanningStorage) {
+	np := randNewPlanning()
+	id := createPlanning(t, st, np)
+	p, err := st.Planning(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == nil {
+		t.Fatal("planning not found")
+	}
+	expected := entities.Planning{
+		ID:              id,
+		UserID:          np.UserID,
+		Status:          entities.Open,
+		ProjectID:       np.ProjectID,
+		TrackerID:       np.TrackerID,
+		IssueID:         np.IssueID,
+		IssueTitle:      np.IssueTitle,
+		IssueURL:        np.IssueURL,
+		IssueEstimation: np.IssueEstimation,
+		IssueDueDate:    np.IssueDueDate,
+		IssueDone:       np.IssueDone,
+		ActivityID:      np.ActivityID,
+		CreatedAt:       p.CreatedAt,
+		Billable:        true,
+	}
+	if *p != expected {
+		t.Errorf("unexpected planning %+v, expected %+v", *p, expected)
+	}
+	createdAt, err := st.PlanningCreatedAt(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if createdAt != p.CreatedAt {
+		t.Errorf("unexpected created at %d, expected %d", createdAt, p.CreatedAt)
+	}
+	pts, err := st.PlannedTimes(ctx, []entities.PlanningID{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pts) != 1 || pts[0].PlanningID != id || pts[0].Estimation != np.Estimation || pts[0].CreatedAt != createdAt {
+		t.Errorf("unexpected planned times %+v", pts)
+	}
+
+	notBillable := false
+	np.Billable = &notBillable
+	p, err = st.Planning(ctx, createPlanning(t, st, np))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Billable {
+		t.Error("planning should not be billable")
+	}
+}
+
+func testUnknownPlanning(t *testing.T, st PlanningStorage) {
+	pid := entities.PlanningID(rand.Int63n(math.MaxInt32) + 1)
+	p, err := st.Planning(ctx, pid)
+	if err != nil || p != nil {
+		t.Errorf("unexpected planning %v, %v", p, err)
+	}
+	if _, err := st.PlanningCreatedAt(ctx, pid); errors.Cause(err) != entities.ErrInvalidPlanningID {
+		t.Errorf("unexpected error %v", err)
+	}
+	err = st.AddExtraTime(ctx, 1, entities.PlannedTime{PlanningID: pid})
+	if errors.Cause(err) != entities.ErrInvalidPlanningID {
+		t.Errorf("unexpected error %v", err)
+	}
+	err = st.AddSpentTime(ctx, entities.SpentTimeHistory{PlanningID: pid, Status: entities.Online})
+	if errors.Cause(err) != entities.ErrInvalidPlanningID {
+		t.Errorf("unexpected error %v", err)
+	}
+	err = st.ClosePlanning(ctx, 1, entities.PlanningReport{PlanningID: pid})
+	if errors.Cause(err) != entities.ErrInvalidPlanningID {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func testPlannings(t *testing.T, st PlanningStorage) {
+	ps, err := st.Plannings(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 0 {
+		t.Errorf("unexpected plannings %+v", ps)
+	}
+	id1 := createPlanning(t, st, randNewPlanning())
+	id2 := createPlanning(t, st, randNewPlanning())
+	createPlanning(t, st, randNewPlanning())
+	ps, err = st.Plannings(ctx, []entities.PlanningID{id1, id2, id2 + 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[entities.PlanningID]bool{}
+	for _, p := range ps {
+		ids[p.ID] = true
+	}
+	if len(ps) != 2 || !ids[id1] || !ids[id2] {
+		t.Errorf("unexpected plannings %+v", ps)
+	}
+}
+
+func testAddExtraTime(t *testing.T, st PlanningStorage) {
+	np := randNewPlanning()
+	id := createPlanning(t, st, np)
+	err := st.AddExtraTime(ctx, np.UserID+1, entities.PlannedTime{PlanningID: id, Estimation: 10})
+	if errors.Cause(err) != entities.ErrInvalidUserID {
+		t.Errorf("unexpected error %v", err)
+	}
+	err = st.AddExtraTime(ctx, np.UserID, entities.PlannedTime{PlanningID: id, Estimation: 20, Reason: "more"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pts, err := st.PlannedTimes(ctx, []entities.PlanningID{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pts) != 2 || pts[0].Estimation != np.Estimation || pts[1].Estimation != 20 || pts[1].Reason != "more" {
+		t.Errorf("unexpected planned times %+v", pts)
+	}
+}
+
+func testAddSpentTime(t *testing.T, st PlanningStorage) {
+	id := createPlanning(t, st, randNewPlanning())
+	hs := []entities.SpentTimeHistory{
+		{PlanningID: id, Spent: 20, StartedAt: 200, EndedAt: 220, Status: entities.Offline},
+		{PlanningID: id, Spent: 10, StartedAt: 100, EndedAt: 110, Status: entities.Online},
+	}
+	for _, h := range hs {
+		if err := st.AddSpentTime(ctx, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.AddSpentTime(ctx, hs[0]); errors.Cause(err) != entities.ErrDuplicateHistory {
+		t.Errorf("duplicated history should not be saved, got %v", err)
+	}
+	saved, err := st.SpentTimeHistories(ctx, []entities.PlanningID{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []entities.SpentTimeHistory{hs[1], hs[0]}
+	if !reflect.DeepEqual(saved, expected) {
+		t.Errorf("unexpected histories %+v, expected %+v", saved, expected)
+	}
+	p, err := st.Planning(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SpentOnline != 10 || p.SpentOffline != 20 {
+		t.Errorf("unexpected spent time %d, %d", p.SpentOnline, p.SpentOffline)
+	}
+	if p.Version != 2 {
+		t.Errorf("unexpected version %d", p.Version)
+	}
+}
+
+func testLastActivity(t *testing.T, st PlanningStorage) {
+	np := randNewPlanning()
+	last, err := st.LastActivity(ctx, np.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != 0 {
+		t.Errorf("unexpected last activity %d", last)
+	}
+	id1 := createPlanning(t, st, np)
+	id2 := createPlanning(t, st, np)
+	addSpentTime(t, st, entities.SpentTimeHistory{PlanningID: id1, Spent: 10, StartedAt: 290, EndedAt: 300, Status: entities.Online})
+	addSpentTime(t, st, entities.SpentTimeHistory{PlanningID: id2, Spent: 10, StartedAt: 190, EndedAt: 200, Status: entities.Online})
+	last, err = st.LastActivity(ctx, np.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != 300 {
+		t.Errorf("unexpected last activity %d", last)
+	}
+}
+
+func testClosePlanning(t *testing.T, st PlanningStorage) {
+	np := randNewPlanning()
+	id := createPlanning(t, st, np)
+	addSpentTime(t, st, entities.SpentTimeHistory{PlanningID: id, Spent: 10, StartedAt: 100, EndedAt: 110, Status: entities.Online})
+	addSpentTime(t, st, entities.SpentTimeHistory{PlanningID: id, Spent: 5, StartedAt: 200, EndedAt: 205, Status: entities.Offline})
+	report := entities.PlanningReport{PlanningID: id, Progress: 70, Time: 1000}
+	err := st.ClosePlanning(ctx, np.UserID+1, report)
+	if errors.Cause(err) != entities.ErrInvalidUserID {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := st.ClosePlanning(ctx, np.UserID, report); err != nil {
+		t.Fatal(err)
+	}
+	p, err := st.Planning(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != entities.Closed || p.IssueDone != 70 || p.Reported != 1000 || p.SpentOnline != 10 || p.SpentOffline != 5 {
+		t.Errorf("unexpected closed planning %+v", *p)
+	}
+	if p.Version != 3 {
+		t.Errorf("unexpected version %d", p.Version)
+	}
+	err = st.ClosePlanning(ctx, np.UserID, report)
+	if errors.Cause(err) != entities.ErrPlanningClosed {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// testConcurrentUpdates checks that every accepted update of planning is counted by version
+// and no spent time is lost when planning is closed during AddSpentTime calls
+func testConcurrentUpdates(t *testing.T, st PlanningStorage) {
+	const adds = 20
+	np := randNewPlanning()
+	id := createPlanning(t, st, np)
+	var wg sync.WaitGroup
+	addErrs := make(chan error, adds)
+	for i := 0; i < adds; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			status := entities.Online
+			if i%2 == 0 {
+				status = entities.Offline
+			}
+			started := int64(i * 100)
+			addErrs <- st.AddSpentTime(ctx, entities.SpentTimeHistory{
+				PlanningID: id,
+				Spent:      i + 1,
+				StartedAt:  started,
+				EndedAt:    started + int64(i+1),
+				Status:     status,
+			})
+		}(i)
+	}
+	closeErr := st.ClosePlanning(ctx, np.UserID, entities.PlanningReport{PlanningID: id, Progress: 50, Time: 100})
+	wg.Wait()
+	close(addErrs)
+	for err := range addErrs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	closed := int64(1)
+	if errors.Cause(closeErr) == entities.ErrPlanningConflict {
+		closed = 0
+	} else if closeErr != nil {
+		t.Fatal(closeErr)
+	}
+	p, err := st.Planning(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs, err := st.SpentTimeHistories(ctx, []entities.PlanningID{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var online, offline int
+	for _, h := range hs {
+		if h.Status == entities.Online {
+			online += h.Spent
+		} else {
+			offline += h.Spent
+		}
+	}
+	if len(hs) != adds || p.SpentOnline != online || p.SpentOffline != offline {
+		t.Errorf("spent time %d, %d doesn't match %d histories %d, %d", p.SpentOnline, p.SpentOffline, len(hs), online, offline)
+	}
+	if p.Version != adds+closed {
+		t.Errorf("unexpected version %d, expected %d", p.Version, adds+closed)
+	}
+	if (p.Status == entities.Closed) != (closed == 1) {
+		t.Errorf("unexpected status %v, close error %v", p.Status, closeErr)
+	}
+}
+
+func testOpenedPlannings(t *testing.T, st PlanningStorage) {
+	np := randNewPlanning()
+	opened := createPlanning(t, st, np)
+	closed := createPlanning(t, st, np)
+	createPlanning(t, st, randNewPlanning())
+	addSpentTime(t, st, entities.SpentTimeHistory{PlanningID: opened, Spent: 10, StartedAt: 100, EndedAt: 110, Status: entities.Online})
+	if err := st.ClosePlanning(ctx, np.UserID, entities.PlanningReport{PlanningID: closed}); err != nil {
+		t.Fatal(err)
+	}
+	ps, err := st.OpenedPlannings(ctx, np.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 1 {
+		t.Fatalf("unexpected opened plannings %+v", ps)
+	}
+	if ps[0].ID != opened || ps[0].Estimation != np.Estimation || ps[0].LastActivity != 110 {
+		t.Errorf("unexpected opened planning %+v", ps[0])
+	}
+}
+
+func testSpentTimeByUserIDTimeRange(t *testing.T, st PlanningStorage) {
+	np := randNewPlanning()
+	id1 := createPlanning(t, st, np)
+	id2 := createPlanning(t, st, np)
+	addSpentTime(t, st, entities.SpentTimeHistory{PlanningID: id1, Spent: 10, StartedAt: 100, EndedAt: 110, Status: entities.Online})
+	addSpentTime(t, st, entities.SpentTimeHistory{PlanningID: id2, Spent: 5, StartedAt: 200, EndedAt: 205, Status: entities.Offline})
+	spent, err := st.SpentTimeByUserIDTimeRange(ctx, np.UserID, 0, math.MaxInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spent != 15 {
+		t.Errorf("unexpected spent time %d", spent)
+	}
+	spent, err = st.SpentTimeByUserIDTimeRange(ctx, np.UserID+1, 0, math.MaxInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spent != 0 {
+		t.Errorf("unexpected spent time of other user %d", spent)
+	}
+}
+
+func testTimesheets(t *testing.T, st PlanningStorage) {
+	np := randNewPlanning()
+	id := createPlanning(t, st, np)
+	_, err := st.SubmitTimesheet(ctx, entities.Timesheet{UserID: np.UserID, From: 1000, To: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		uid      ctxtg.UserID
+		from, to int64
+		locked   bool
+	}{
+		{np.UserID, 1500, 1600, true},
+		{np.UserID, 500, 1001, true},
+		{np.UserID, 500, 1000, false},
+		{np.UserID, 2000, 3000, false},
+		{np.UserID + 1, 1500, 1600, false},
+	}
+	for _, v := range tests {
+		locked, err := st.IsPeriodLocked(ctx, v.uid, v.from, v.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked != v.locked {
+			t.Errorf("IsPeriodLocked(%d, %d, %d) = %v", v.uid, v.from, v.to, locked)
+		}
+	}
+	_, err = st.SubmitTimesheet(ctx, entities.Timesheet{UserID: np.UserID, From: 1900, To: 2100})
+	if errors.Cause(err) != entities.ErrPeriodLocked {
+		t.Errorf("unexpected error %v", err)
+	}
+	err = st.AddSpentTime(ctx, entities.SpentTimeHistory{PlanningID: id, Spent: 10, StartedAt: 1100, EndedAt: 1110, Status: entities.Online})
+	if errors.Cause(err) != entities.ErrPeriodLocked {
+		t.Errorf("unexpected error %v", err)
+	}
+	addSpentTime(t, st, entities.SpentTimeHistory{PlanningID: id, Spent: 10, StartedAt: 2100, EndedAt: 2110, Status: entities.Online})
+}
+
+func testBillableWork(t *testing.T, st PlanningStorage) {
+	np := randNewPlanning()
+	billable := createPlanning(t, st, np)
+	notBillable := false
+	np.Billable = &notBillable
+	free := createPlanning(t, st, np)
+	np.Billable = nil
+	np.UserID++
+	other := createPlanning(t, st, np)
+	h := entities.SpentTimeHistory{PlanningID: billable, Spent: 10, StartedAt: 100, EndedAt: 110, Status: entities.Online}
+	addSpentTime(t, st, h)
+	addSpentTime(t, st, entities.SpentTimeHistory{PlanningID: billable, Spent: 10, StartedAt: 500, EndedAt: 510, Status: entities.Online})
+	addSpentTime(t, st, entities.SpentTimeHistory{PlanningID: free, Spent: 10, StartedAt: 100, EndedAt: 110, Status: entities.Online})
+	addSpentTime(t, st, entities.SpentTimeHistory{PlanningID: other, Spent: 10, StartedAt: 100, EndedAt: 110, Status: entities.Online})
+	ws, err := st.BillableWork(ctx, entities.BillingQuery{UserID: np.UserID - 1, From: 105, To: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []entities.BillableWork{{
+		SpentTimeHistory: h,
+		UserID:           np.UserID - 1,
+		ProjectID:        np.ProjectID,
+		ActivityID:       np.ActivityID,
+	}}
+	if !reflect.DeepEqual(ws, expected) {
+		t.Errorf("unexpected billable work %+v, expected %+v", ws, expected)
+	}
+}
+
+func createPlanning(t *testing.T, st PlanningStorage, np entities.NewPlanning) entities.PlanningID {
+	id, err := st.CreatePlanning(ctx, np)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func addSpentTime(t *testing.T, st PlanningStorage, h entities.SpentTimeHistory) {
+	if err := st.AddSpentTime(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func randNewPlanning() entities.NewPlanning {
+	return entities.NewPlanning{
+		UserID:          ctxtg.UserID(rand.Int63n(math.MaxInt32) + 1),
+		ProjectID:       entities.ProjectID(rand.Int31()),
+		TrackerID:       entities.TrackerID(rand.Int31()),
+		IssueID:         entities.IssueID(rand.Int31()),
+		IssueTitle:      "title",
+		IssueURL:        "url",
+		IssueEstimation: rand.Int63n(math.MaxInt32),
+		IssueDueDate:    rand.Int63(),
+		IssueDone:       rand.Intn(100),
+		ActivityID:      entities.ActivityID(rand.Int31()),
+		Estimation:      rand.Int63n(math.MaxInt32),
+	}
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+const (
+	saveTeamStmt = `
+		INSERT INTO Team (name,
+						  created_at)
+		VALUES			 (:name,
+						  :created_at)
+	`
+	deleteTeamStmt = `
+		DELETE FROM Team
+		 WHERE id = ?
+	`
+	findTeamsStmt = `
+		SELECT *
+		  FROM Team
+		 ORDER BY id ASC
+	`
+	saveTeamMemberStmt = `
+		INSERT INTO TeamMember (team_id,
+								user_id,
+								role)
+		VALUES				   (:team_id,
+								:user_id,
+								:role)
+	`
+	deleteTeamMemberStmt = `
+		DELETE FROM TeamMember
+		 WHERE team_id = ?
+		   AND user_id = ?
+	`
+	deleteTeamMembersStmt = `
+		DELETE FROM TeamMember
+		 WHERE team_id = ?
+	`
+	findTeamMembersStmt = `
+		SELECT *
+		  FROM TeamMember
+		 WHERE team_id = ?
+		 ORDER BY user_id ASC
+	`
+	findTeamRoleStmt = `
+		SELECT role
+		  FROM TeamMember
+		 WHERE team_id = ?
+		   AND user_id = ?
+	`
+	countLeadMembershipsStmt = `
+		SELECT COUNT(*)
+		  FROM TeamMember AS l INNER JOIN TeamMember AS m
+			ON l.team_id = m.team_id
+		 WHERE l.user_id = ?
+		   AND l.role = 'LEAD'
+		   AND m.user_id = ?
+	`
+)
+
+type teamMember struct {
+	entities.TeamMember
+	Role string `db:"role"`
+}
+
+func saveTeam(ctx context.Context, ex sqlx.ExtContext, t entities.Team) (entities.TeamID, error) {
+	id, err := dialectOf(ex).insert(ctx, ex, saveTeamStmt, t)
+	if err != nil {
+		return 0, err
+	}
+	return entities.TeamID(id), nil
+}
+
+func deleteTeam(ctx context.Context, ex sqlx.ExtContext, tid entities.TeamID) error {
+	_, err := ex.ExecContext(ctx, ex.Rebind(deleteTeamMembersStmt), tid)
+	if err != nil {
+		return err
+	}
+	res, err := ex.ExecContext(ctx, ex.Rebind(deleteTeamStmt), tid)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return entities.ErrInvalidTeamID
+	}
+	return nil
+}
+
+func findTeams(ctx context.Context, ex sqlx.ExtContext) ([]entities.Team, error) {
+	var teams []entities.Team
+	err := sqlx.SelectContext(ctx, ex, &teams, findTeamsStmt)
+	return teams, err
+}
+
+func saveTeamMember(ctx context.Context, ex sqlx.ExtContext, m entities.TeamMember) error {
+	d := dialectOf(ex)
+	_, err := sqlx.NamedExecContext(ctx, ex, saveTeamMemberStmt+d.upsert("team_id, user_id", "role"), teamMember{
+		TeamMember: m,
+		Role:       string(m.Role),
+	})
+	if d.isForeignKeyError(err) {
+		return entities.ErrInvalidTeamID
+	}
+	return err
+}
+
+func deleteTeamMember(ctx context.Context, ex sqlx.ExtContext, tid entities.TeamID, uid ctxtg.UserID) error {
+	_, err := ex.ExecContext(ctx, ex.Rebind(deleteTeamMemberStmt), tid, uid)
+	return err
+}
+
+func findTeamMembers(ctx context.Context, ex sqlx.ExtContext, tid entities.TeamID) ([]entities.TeamMember, error) {
+	var members []teamMember
+	err := sqlx.SelectContext(ctx, ex, &members, ex.Rebind(findTeamMembersStmt), tid)
+	if err != nil {
+		return nil, err
+	}
+	var ms []entities.TeamMember
+	for _, m := range members {
+		m.TeamMember.Role = entities.TeamRole(m.Role)
+		ms = append(ms, m.TeamMember)
+	}
+	return ms, nil
+}
+
+func findTeamRole(ctx context.Context, ex sqlx.ExtContext, tid entities.TeamID, uid ctxtg.UserID) (entities.TeamRole, error) {
+	var role string
+	err := sqlx.GetContext(ctx, ex, &role, ex.Rebind(findTeamRoleStmt), tid, uid)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return entities.TeamRole(role), err
+}
+
+func isTeamLead(ctx context.Context, ex sqlx.ExtContext, lead, member ctxtg.UserID) (bool, error) {
+	var n int
+	err := sqlx.GetContext(ctx, ex, &n, ex.Rebind(countLeadMembershipsStmt), lead, member)
+	return n > 0, err
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/qarea/ctxtg"
+	"github.com/qarea/planningms/entities"
+)
+
+const (
+	saveTimesheetStmt = `
+		INSERT INTO Timesheet (user_id,
+							   period_from,
+							   period_to,
+							   status,
+							   approver_id,
+							   comment,
+							   updated_at)
+		VALUES				  (:user_id,
+							   :period_from,
+							   :period_to,
+							   :status,
+							   :approver_id,
+							   :comment,
+							   :updated_at)
+	`
+	updateTimesheetStmt = `
+		UPDATE Timesheet
+		   SET status      = :status,
+			   approver_id = :approver_id,
+			   comment     = :comment,
+			   updated_at  = :updated_at
+		 WHERE id          = :id
+	`
+	findTimesheetStmt = `
+		SELECT *
+		  FROM Timesheet
+		 WHERE id = ?
+	`
+	findTimesheetsStmt = `
+		SELECT *
+		  FROM Timesheet
+		 WHERE user_id = ?
+		   AND period_to > ?
+		   AND period_from < ?
+		 ORDER BY period_from ASC
+	`
+	countLockedTimesheetsStmt = `
+		SELECT COUNT(*)
+		  FROM Timesheet
+		 WHERE user_id = ?
+		   AND period_to > ?
+		   AND period_from < ?
+		   AND status IN ('SUBMITTED', 'APPROVED')
+	`
+)
+
+type timesheet struct {
+	entities.Timesheet
+	Status string `db:"status"`
+}
+
+func saveTimesheet(ctx context.Context, ex sqlx.ExtContext, t entities.Timesheet) (entities.TimesheetID, error) {
+	id, err := dialectOf(ex).insert(ctx, ex, saveTimesheetStmt, timesheet{
+		Timesheet: t,
+		Status:    string(t.Status),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return entities.TimesheetID(id), nil
+}
+
+func updateTimesheet(ctx context.Context, ex sqlx.ExtContext, t entities.Timesheet) error {
+	_, err := sqlx.NamedExecContext(ctx, ex, updateTimesheetStmt, timesheet{
+		Timesheet: t,
+		Status:    string(t.Status),
+	})
+	return err
+}
+
+func findTimesheet(ctx context.Context, ex sqlx.ExtContext, id entities.TimesheetID) (*entities.Timesheet, error) {
+	var t timesheet
+	err := sqlx.GetContext(ctx, ex, &t, ex.Rebind(findTimesheetStmt), id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.Timesheet.Status = entities.TimesheetStatus(t.Status)
+	return &t.Timesheet, nil
+}
+
+func findTimesheets(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID, from, to int64) ([]entities.Timesheet, error) {
+	var timesheets []timesheet
+	err := sqlx.SelectContext(ctx, ex, &timesheets, ex.Rebind(findTimesheetsStmt), uid, from, to)
+	if err != nil {
+		return nil, err
+	}
+	var ts []entities.Timesheet
+	for _, t := range timesheets {
+		t.Timesheet.Status = entities.TimesheetStatus(t.Status)
+		ts = append(ts, t.Timesheet)
+	}
+	return ts, nil
+}
+
+// isPeriodLocked checks if [from, to] overlaps submitted or approved timesheet of user
+func isPeriodLocked(ctx context.Context, ex sqlx.ExtContext, uid ctxtg.UserID, from, to int64) (bool, error) {
+	var n int
+	err := sqlx.GetContext(ctx, ex, &n, ex.Rebind(countLockedTimesheetsStmt), uid, from, to)
+	return n > 0, err
+}